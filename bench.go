@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/blastd/internal/db"
+	"github.com/taigrr/blastd/internal/socket"
+)
+
+func newBenchCmd() *cobra.Command {
+	var (
+		clients  int
+		duration time.Duration
+		dbPath   string
+	)
+
+	cmd := &cobra.Command{
+		Use:    "bench",
+		Short:  "Measure activity ingest throughput over the socket",
+		Hidden: true,
+		Long: "bench starts a throwaway socket server and has --clients concurrent clients send activities " +
+			"for --duration, then reports throughput and latency percentiles. It uses a temporary database " +
+			"unless --db points it at one.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if clients < 1 {
+				return fmt.Errorf("--clients must be at least 1")
+			}
+
+			dir, err := os.MkdirTemp("", "blastd-bench-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+
+			if dbPath == "" {
+				dbPath = filepath.Join(dir, "bench.db")
+			}
+			database, err := db.Open(dbPath)
+			if err != nil {
+				return err
+			}
+			defer database.Close()
+
+			server := socket.NewServer(filepath.Join(dir, "bench.sock"), database, "bench")
+			if err := server.Start(); err != nil {
+				return err
+			}
+			defer server.Stop()
+
+			result, err := runBench(filepath.Join(dir, "bench.sock"), clients, duration)
+			if err != nil {
+				return err
+			}
+			result.print(cmd.OutOrStdout())
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVar(&clients, "clients", 8, "number of concurrent socket clients")
+	cmd.Flags().DurationVar(&duration, "duration", 10*time.Second, "how long to send activities")
+	cmd.Flags().StringVar(&dbPath, "db", "", "database to write to (default: a temporary database)")
+	return cmd
+}
+
+type benchResult struct {
+	elapsed   time.Duration
+	latencies []time.Duration
+	errors    int
+	firstErr  string
+}
+
+// runBench drives clients connections against the socket at path until
+// duration elapses, recording the round-trip latency of each activity.
+func runBench(path string, clients int, duration time.Duration) (*benchResult, error) {
+	line, err := benchActivity()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu     sync.Mutex
+		result benchResult
+		wg     sync.WaitGroup
+	)
+	deadline := time.Now().Add(duration)
+	start := time.Now()
+
+	errCh := make(chan error, clients)
+	for range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			latencies, failures, firstErr, err := benchClient(path, line, deadline)
+			if err != nil {
+				errCh <- err
+			}
+			mu.Lock()
+			result.latencies = append(result.latencies, latencies...)
+			result.errors += failures
+			if result.firstErr == "" {
+				result.firstErr = firstErr
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	result.elapsed = time.Since(start)
+	close(errCh)
+
+	if err := <-errCh; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// benchClient sends activities over one connection until deadline. It
+// returns the latencies, the number of rejected activities and the first
+// rejection reason.
+func benchClient(path string, line []byte, deadline time.Time) ([]time.Duration, int, string, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("dial %s: %w", path, err)
+	}
+	defer conn.Close()
+
+	var (
+		latencies []time.Duration
+		failures  int
+		firstErr  string
+	)
+	reader := bufio.NewReader(conn)
+	for time.Now().Before(deadline) {
+		sent := time.Now()
+		if _, err := conn.Write(line); err != nil {
+			return latencies, failures, firstErr, fmt.Errorf("send activity: %w", err)
+		}
+		reply, err := reader.ReadBytes('\n')
+		if err != nil {
+			return latencies, failures, firstErr, fmt.Errorf("read response: %w", err)
+		}
+		latencies = append(latencies, time.Since(sent))
+
+		var resp socket.Response
+		if err := json.Unmarshal(reply, &resp); err != nil {
+			resp.Error = err.Error()
+		}
+		if !resp.OK {
+			failures++
+			if firstErr == "" {
+				firstErr = resp.Error
+			}
+		}
+	}
+	return latencies, failures, firstErr, nil
+}
+
+func benchActivity() ([]byte, error) {
+	now := time.Now().UTC()
+	data, err := json.Marshal(socket.ActivityData{
+		Project:      "blastd-bench",
+		StartedAt:    now.Add(-time.Minute).Format(time.RFC3339),
+		EndedAt:      now.Format(time.RFC3339),
+		Filename:     "bench.go",
+		Filetype:     "go",
+		LinesAdded:   1,
+		LinesRemoved: 1,
+		Editor:       "bench",
+	})
+	if err != nil {
+		return nil, err
+	}
+	line, err := json.Marshal(socket.Request{Type: "activity", Data: data})
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+func (r *benchResult) print(w io.Writer) {
+	n := len(r.latencies)
+	fmt.Fprintf(w, "activities: %d (%d errors) in %s\n", n, r.errors, r.elapsed.Round(time.Millisecond))
+	if r.firstErr != "" {
+		fmt.Fprintf(w, "first error: %s\n", r.firstErr)
+	}
+	if n == 0 {
+		return
+	}
+	fmt.Fprintf(w, "throughput: %.1f stored activities/sec\n", float64(n-r.errors)/r.elapsed.Seconds())
+
+	slices.Sort(r.latencies)
+	percentile := func(p float64) time.Duration {
+		return r.latencies[min(n-1, int(float64(n)*p))]
+	}
+	fmt.Fprintf(w, "latency:    p50 %s  p90 %s  p99 %s  max %s\n",
+		percentile(0.50), percentile(0.90), percentile(0.99), r.latencies[n-1])
+}
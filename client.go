@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/taigrr/blastd/internal/socket"
+)
+
+// requestTimeout bounds a one-shot socket request from a CLI command.
+const requestTimeout = 5 * time.Second
+
+// socketRequest sends req to the daemon at path and reads one response.
+func socketRequest(path string, req socket.Request) (socket.Response, error) {
+	conn, err := net.DialTimeout("unix", path, requestTimeout)
+	if err != nil {
+		return socket.Response{}, fmt.Errorf("connect to blastd at %s: %w", path, err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(requestTimeout)); err != nil {
+		return socket.Response{}, err
+	}
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		return socket.Response{}, err
+	}
+	if _, err := conn.Write(append(line, '\n')); err != nil {
+		return socket.Response{}, fmt.Errorf("%s: %w", req.Type, err)
+	}
+	line, err = bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return socket.Response{}, fmt.Errorf("%s: %w", req.Type, err)
+	}
+
+	var resp socket.Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return socket.Response{}, fmt.Errorf("%s: %w", req.Type, err)
+	}
+	return resp, nil
+}
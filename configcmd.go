@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/blastd/internal/config"
+)
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Create, inspect, and edit the config file",
+	}
+	cmd.AddCommand(newConfigInitCmd(), newConfigGetCmd(), newConfigSetCmd())
+	return cmd
+}
+
+func newConfigInitCmd() *cobra.Command {
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Write a commented config file with the defaults",
+		Long: "init writes a config.toml listing every setting at its default, commented out, to --config " +
+			"or the default config path. It refuses to overwrite an existing file unless --force is given.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			path := configPath
+			if path == "" {
+				var err error
+				if path, err = config.DefaultPath(); err != nil {
+					return err
+				}
+			}
+
+			if _, err := os.Stat(path); err == nil && !force {
+				return fmt.Errorf("%s already exists; re-run with --force to overwrite it", path)
+			} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+
+			if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+				return fmt.Errorf("create config directory: %w", err)
+			}
+			// The file will hold the API token once filled in.
+			if err := os.WriteFile(path, []byte(config.Template), 0o600); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "wrote %s\n", path)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&force, "force", false, "overwrite an existing config file")
+	return cmd
+}
+
+func newConfigGetCmd() *cobra.Command {
+	var reveal bool
+
+	cmd := &cobra.Command{
+		Use:   "get <key>",
+		Short: "Print the effective value of a config key",
+		Long: "get prints the value blastd would use for key after merging defaults, the config file, " +
+			"and env vars. auth_token, oauth_client_secret and encryption_key are redacted unless --reveal is given.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if !reveal {
+				redacted := cfg.Redacted()
+				cfg = &redacted
+			}
+			value, err := cfg.Get(args[0])
+			if err != nil {
+				return fmt.Errorf("%w (known keys: %s)", err, strings.Join(config.Keys(), ", "))
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), value)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&reveal, "reveal", false, "print secrets instead of redacting them")
+	return cmd
+}
+
+func newConfigSetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set <key> <value>",
+		Short: "Set a key in the config file",
+		Long: "set writes key = value to --config or the default config file, creating it if needed and " +
+			"keeping every other line. The value is validated before the file is replaced.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := configPath
+			if path == "" {
+				var err error
+				if path, err = config.DefaultPath(); err != nil {
+					return err
+				}
+			}
+			if err := config.SetInFile(path, args[0], args[1]); err != nil {
+				if errors.Is(err, config.ErrUnknownKey) {
+					return fmt.Errorf("%w (known keys: %s)", err, strings.Join(config.Keys(), ", "))
+				}
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "set %s in %s\n", args[0], path)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// daemonizedEnv marks the re-executed background child so it doesn't try
+// to detach again.
+const daemonizedEnv = "BLASTD_DAEMONIZED"
+
+func isDaemonChild() bool {
+	return os.Getenv(daemonizedEnv) == "1"
+}
+
+func writePIDFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create pid file directory: %w", err)
+	}
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644)
+}
@@ -0,0 +1,54 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// detach re-executes blastd in a new session with stdio redirected to
+// logPath, and returns the child's PID. The parent is expected to exit.
+func detach(logPath string) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("find executable: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(logPath), 0o755); err != nil {
+		return 0, fmt.Errorf("create log directory: %w", err)
+	}
+	logOut, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("open log file: %w", err)
+	}
+	defer logOut.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	// The child reopens the log itself via BLAST_LOG_FILE so SIGUSR1
+	// rotation keeps working; stdio only catches panics and early output.
+	cmd.Env = append(os.Environ(), daemonizedEnv+"=1", "BLAST_LOG_FILE="+logPath)
+	cmd.Stdout = logOut
+	cmd.Stderr = logOut
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("start background process: %w", err)
+	}
+	pid := cmd.Process.Pid
+	if err := cmd.Process.Release(); err != nil {
+		return 0, fmt.Errorf("release background process: %w", err)
+	}
+	return pid, nil
+}
+
+// processRunning reports whether a process with pid exists. EPERM means
+// it does but belongs to another user.
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
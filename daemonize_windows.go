@@ -0,0 +1,23 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func detach(string) (int, error) {
+	return 0, fmt.Errorf("--daemonize is not supported on Windows; run blastd as a service instead")
+}
+
+// processRunning reports whether a process with pid exists; FindProcess
+// fails on Windows when it doesn't.
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
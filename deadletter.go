@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func newDeadLetterCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "deadletter",
+		Short: "Inspect and retry activities the server repeatedly rejected",
+		Long: "Activities the server rejects dead_letter_after times in a row are moved out of the sync " +
+			"backlog so they can't block it. Use these commands to inspect them and put them back once the cause is fixed.",
+	}
+	cmd.AddCommand(newDeadLetterListCmd(), newDeadLetterRetryCmd())
+	return cmd
+}
+
+func newDeadLetterListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List dead-lettered activities",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			database, err := openDB(cfg)
+			if err != nil {
+				return err
+			}
+			defer database.Close()
+
+			letters, err := database.DeadLetters()
+			if err != nil {
+				return err
+			}
+			if len(letters) == 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "no dead-lettered activities")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tPROJECT\tSTARTED\tFAILURES\tLAST ERROR")
+			for _, dl := range letters {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n",
+					dl.ID, dl.Activity.Project, dl.Activity.StartedAt.Format("2006-01-02 15:04"), dl.Failures, dl.LastError)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func newDeadLetterRetryCmd() *cobra.Command {
+	var all bool
+
+	cmd := &cobra.Command{
+		Use:   "retry [id...]",
+		Short: "Move dead-lettered activities back into the sync backlog",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if all == (len(args) > 0) {
+				return fmt.Errorf("specify dead letter ids or --all")
+			}
+
+			var ids []int64
+			for _, arg := range args {
+				id, err := strconv.ParseInt(arg, 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid id %q", arg)
+				}
+				ids = append(ids, id)
+			}
+
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			database, err := openDB(cfg)
+			if err != nil {
+				return err
+			}
+			defer database.Close()
+
+			if all {
+				letters, err := database.DeadLetters()
+				if err != nil {
+					return err
+				}
+				for _, dl := range letters {
+					ids = append(ids, dl.ID)
+				}
+			}
+
+			for _, id := range ids {
+				if err := database.RetryDeadLetter(id); err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "re-queued %d activities\n", len(ids))
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", false, "retry every dead-lettered activity")
+	return cmd
+}
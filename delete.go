@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+func newDeleteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete ID...",
+		Short: "Delete activities locally and from the server",
+		Long: "delete removes activities by id (as shown by blastd tail --json). Activities that were never " +
+			"synced are removed immediately; synced ones are hidden and deleted from the server on the next sync.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ids := make([]int64, len(args))
+			for i, arg := range args {
+				id, err := strconv.ParseInt(arg, 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid activity id %q", arg)
+				}
+				ids[i] = id
+			}
+
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			database, err := openDB(cfg)
+			if err != nil {
+				return err
+			}
+			defer database.Close()
+
+			removed, tombstoned, err := database.DeleteActivities(ids)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "deleted %d unsynced activities\n", removed)
+			if tombstoned > 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "%d synced activities will be deleted from the server on the next sync\n", tombstoned)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
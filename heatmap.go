@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/blastd/internal/config"
+	"github.com/taigrr/blastd/internal/db"
+	"github.com/taigrr/blastd/internal/output"
+	"github.com/taigrr/blastd/internal/socket"
+)
+
+func newHeatmapCmd() *cobra.Command {
+	var (
+		raw   bool
+		weeks int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "heatmap",
+		Short: "Show daily coding time over the last year as a calendar heatmap",
+		Long: "heatmap asks the running daemon for per-day totals (in the configured timezone) and draws one " +
+			"cell per day, one column per week, shaded by time tracked. If the daemon isn't running it reads the " +
+			"database directly, read-only.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if weeks < 1 || weeks > 520 {
+				return fmt.Errorf("--weeks must be between 1 and 520, got %d", weeks)
+			}
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			loc := cfg.Location()
+			y, m, d := time.Now().In(loc).Date()
+			today := time.Date(y, m, d, 0, 0, 0, 0, loc)
+			// Whole weeks, so the first column is full.
+			thisMonday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+			start := thisMonday.AddDate(0, 0, -7*(weeks-1))
+			end := today.AddDate(0, 0, 1)
+
+			days, err := daysFromDaemon(cfg.SocketPath, start, end)
+			if daemonNotRunning(err) {
+				fmt.Fprintf(cmd.ErrOrStderr(), "blastd is not running; reading %s directly\n", cfg.DBPath)
+				days, err = daysFromDB(cfg, start, end)
+			}
+			if err != nil {
+				return err
+			}
+
+			if raw {
+				if days == nil {
+					days = []db.DayStats{}
+				}
+				return json.NewEncoder(cmd.OutOrStdout()).Encode(days)
+			}
+			values := make(map[string]float64, len(days))
+			var total float64
+			for _, day := range days {
+				values[day.Date] = day.Seconds
+				total += day.Seconds
+			}
+			w := output.NewWriter(cmd.OutOrStdout(), os.Environ())
+			fmt.Fprintln(w, output.Heatmap(values, start, today))
+			fmt.Fprintf(w, "\n%s on %d of the last %d days\n", output.Bold.Render(formatTracked(total)), len(days), int(end.Sub(start).Hours()/24+0.5))
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&raw, "json", false, "print the per-day totals as a JSON array")
+	cmd.Flags().IntVar(&weeks, "weeks", 53, "number of weeks to show, ending with the current one")
+	return cmd
+}
+
+// daysFromDaemon asks the daemon for per-day totals over [start, end).
+func daysFromDaemon(socketPath string, start, end time.Time) ([]db.DayStats, error) {
+	data, err := json.Marshal(socket.StatsData{Since: start.Format(time.RFC3339), Until: end.Format(time.RFC3339), ByDay: true})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := socketRequest(socketPath, socket.Request{Type: "stats", Data: data})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, fmt.Errorf("stats: %s", resp.Error)
+	}
+	return resp.Days, nil
+}
+
+// daysFromDB reads per-day totals over [start, end) without the daemon.
+func daysFromDB(cfg *config.Config, start, end time.Time) ([]db.DayStats, error) {
+	database, err := openReadOnlyDB(cfg)
+	if database == nil || err != nil {
+		return nil, err
+	}
+	defer database.Close()
+	return database.StatsByDay(start, end, cfg.Location())
+}
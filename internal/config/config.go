@@ -1,26 +1,142 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/taigrr/jety"
 )
 
+// Config is the effective daemon configuration. JSON tags match the config
+// file keys so the running config can be reported back to clients.
 type Config struct {
-	ServerURL           string
-	APIToken            string
-	SyncIntervalMinutes int
-	SyncBatchSize       int
-	SocketPath          string
-	DBPath              string
-	Machine             string
-	MetricsOnly         bool
+	ServerURL               string  `json:"server_url"`
+	APIToken                string  `json:"auth_token"`
+	OAuthTokenURL           string  `json:"oauth_token_url"`
+	OAuthClientID           string  `json:"oauth_client_id"`
+	OAuthClientSecret       string  `json:"oauth_client_secret"`
+	SyncEnabled             bool    `json:"sync_enabled"`
+	SyncIntervalMinutes     int     `json:"sync_interval_minutes"`
+	SyncBatchSize           int     `json:"sync_batch_size"`
+	AdaptiveBatch           bool    `json:"adaptive_batch"`
+	SyncBatchMax            int     `json:"sync_batch_max"`
+	SyncConcurrency         int     `json:"sync_concurrency"`
+	SyncMaxIdleConns        int     `json:"sync_max_idle_conns"`
+	SyncIdleTimeoutSeconds  int     `json:"sync_idle_timeout_seconds"`
+	CoalesceGapSeconds      int     `json:"coalesce_gap_seconds"`
+	MaxSyncBytes            int     `json:"max_sync_bytes"`
+	SyncDebounceSeconds     int     `json:"sync_debounce_seconds"`
+	SyncStartupDelaySeconds int     `json:"sync_startup_delay_seconds"`
+	SyncOrder               string  `json:"sync_order"`
+	SyncStream              bool    `json:"sync_stream"`
+	SyncReachabilityCheck   bool    `json:"sync_reachability_check"`
+	SyncGateCommand         string  `json:"sync_gate_command"`
+	ClockSkewWarnSeconds    int     `json:"clock_skew_warn_seconds"`
+	DeadLetterAfter         int     `json:"dead_letter_after"`
+	DataDir                 string  `json:"data_dir"`
+	SocketPath              string  `json:"socket_path"`
+	SocketMode              string  `json:"socket_mode"`
+	SocketGroup             string  `json:"socket_group"`
+	DBPath                  string  `json:"db_path"`
+	Machine                 string  `json:"machine"`
+	Timezone                string  `json:"timezone"`
+	AllowMachineOverride    bool    `json:"allow_machine_override"`
+	MetricsOnly             bool    `json:"metrics_only"`
+	TLSClientCert           string  `json:"tls_client_cert"`
+	TLSClientKey            string  `json:"tls_client_key"`
+	TLSCACert               string  `json:"tls_ca_cert"`
+	InsecureSkipVerify      bool    `json:"insecure_skip_verify"`
+	LogFile                 string  `json:"log_file"`
+	WebhookURL              string  `json:"webhook_url"`
+	VacuumIntervalHours     int     `json:"vacuum_interval_hours"`
+	SyncedRetentionDays     int     `json:"synced_retention_days"`
+	ArchiveDBPath           string  `json:"archive_db_path"`
+	ActivityRateLimit       int     `json:"activity_rate_limit"`
+	ActivityRateBurst       int     `json:"activity_rate_burst"`
+	DropZeroDuration        bool    `json:"drop_zero_duration"`
+	SyncBackoffMinSeconds   int     `json:"sync_backoff_min_seconds"`
+	SyncBackoffMaxSeconds   int     `json:"sync_backoff_max_seconds"`
+	SyncBackoffFactor       float64 `json:"sync_backoff_factor"`
+	NotifyOnFailure         bool    `json:"notify_on_failure"`
+	NotifyAfterFailures     int     `json:"notify_after_failures"`
+	FlushIntervalMs         int     `json:"flush_interval_ms"`
+	FlushCount              int     `json:"flush_count"`
+	MergeWindowMs           int     `json:"merge_window_ms"`
+	RecoverCorruptDB        bool    `json:"recover_corrupt_db"`
+	MigrationBackups        int     `json:"migration_backups"`
+	DurableWrites           bool    `json:"durable_writes"`
+	EncryptionKey           string  `json:"encryption_key"`
+	EncryptionKeyFile       string  `json:"encryption_key_file"`
+	MaxUnsyncedRows         int     `json:"max_unsynced_rows"`
+	UnsyncedOverflow        string  `json:"unsynced_overflow"`
 }
 
+// Redacted returns a copy of c that is safe to show to users, with the API
+// token, OAuth client secret and encryption key masked.
+func (c Config) Redacted() Config {
+	if c.APIToken != "" {
+		c.APIToken = "REDACTED"
+	}
+	if c.OAuthClientSecret != "" {
+		c.OAuthClientSecret = "REDACTED"
+	}
+	if c.EncryptionKey != "" {
+		c.EncryptionKey = "REDACTED"
+	}
+	return c
+}
+
+// Location returns the timezone used to bucket stats by day and week,
+// defaulting to the system's local zone.
+func (c Config) Location() *time.Location {
+	if c.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// Load reads config from the default XDG/HOME search paths.
 func Load() (*Config, error) {
+	return LoadFrom("")
+}
+
+// ErrParse marks a config file that exists but isn't valid TOML.
+var ErrParse = errors.New("invalid config file")
+
+// LoadFrom reads config from path, or from the default search paths when
+// path is empty. Unlike the search paths, an explicit path must exist.
+func LoadFrom(path string) (*Config, error) {
+	return loadFrom(path, true)
+}
+
+// LoadFromFallback is LoadFrom, except that a config file with a syntax
+// error doesn't fail the load: parseErr (wrapping ErrParse, with the path
+// and line) reports it, and cfg is built from defaults and env vars alone,
+// so a typo doesn't stop background recording.
+func LoadFromFallback(path string) (cfg *Config, parseErr error, err error) {
+	cfg, err = LoadFrom(path)
+	if !errors.Is(err, ErrParse) {
+		return cfg, nil, err
+	}
+	cfg, fallbackErr := loadFrom(path, false)
+	return cfg, err, fallbackErr
+}
+
+// loadFrom builds the config from defaults, env vars and, when readFile is
+// set, the config file.
+func loadFrom(path string, readFile bool) (*Config, error) {
 	homeDir, _ := os.UserHomeDir()
-	dataDir := filepath.Join(homeDir, ".local", "share", "blastd")
 	hostname, _ := os.Hostname()
 
 	cm := jety.NewConfigManager().WithEnvPrefix("BLAST_")
@@ -30,40 +146,312 @@ func Load() (*Config, error) {
 
 	cm.SetDefault("server_url", "https://nvimblast.com")
 	cm.SetDefault("auth_token", "")
+	cm.SetDefault("oauth_token_url", "")
+	cm.SetDefault("oauth_client_id", "")
+	cm.SetDefault("oauth_client_secret", "")
+	cm.SetDefault("sync_enabled", true)
 	cm.SetDefault("sync_interval_minutes", 10)
 	cm.SetDefault("sync_batch_size", 100)
-	cm.SetDefault("socket_path", filepath.Join(dataDir, "blastd.sock"))
-	cm.SetDefault("db_path", filepath.Join(dataDir, "blast.db"))
+	cm.SetDefault("adaptive_batch", false)
+	cm.SetDefault("sync_batch_max", 1000)
+	cm.SetDefault("sync_concurrency", 1)
+	cm.SetDefault("sync_max_idle_conns", 2)
+	cm.SetDefault("sync_idle_timeout_seconds", 90)
+	cm.SetDefault("coalesce_gap_seconds", 0)
+	cm.SetDefault("max_sync_bytes", 4194304)
+	cm.SetDefault("sync_order", "oldest")
+	cm.SetDefault("sync_debounce_seconds", 60)
+	cm.SetDefault("sync_startup_delay_seconds", 0)
+	cm.SetDefault("sync_stream", false)
+	cm.SetDefault("sync_reachability_check", false)
+	cm.SetDefault("sync_gate_command", "")
+	cm.SetDefault("clock_skew_warn_seconds", 300)
+	cm.SetDefault("dead_letter_after", 3)
+	cm.SetDefault("data_dir", filepath.Join(homeDir, ".local", "share", "blastd"))
+	// socket_path and db_path default relative to data_dir, which is only
+	// known once the config file and env have been read.
+	cm.SetDefault("socket_path", "")
+	cm.SetDefault("socket_mode", "0600")
+	cm.SetDefault("socket_group", "")
+	cm.SetDefault("db_path", "")
 	cm.SetDefault("machine", hostname)
+	cm.SetDefault("timezone", "")
+	cm.SetDefault("allow_machine_override", false)
 	cm.SetDefault("metrics_only", false)
+	cm.SetDefault("tls_client_cert", "")
+	cm.SetDefault("tls_client_key", "")
+	cm.SetDefault("tls_ca_cert", "")
+	cm.SetDefault("insecure_skip_verify", false)
+	cm.SetDefault("log_file", "")
+	cm.SetDefault("webhook_url", "")
+	cm.SetDefault("vacuum_interval_hours", 0)
+	cm.SetDefault("synced_retention_days", 0)
+	cm.SetDefault("archive_db_path", "")
+	cm.SetDefault("activity_rate_limit", 100)
+	cm.SetDefault("activity_rate_burst", 1000)
+	cm.SetDefault("drop_zero_duration", false)
+	cm.SetDefault("sync_backoff_min_seconds", 30)
+	cm.SetDefault("sync_backoff_max_seconds", 1800)
+	cm.SetDefault("sync_backoff_factor", 2.0)
+	cm.SetDefault("notify_on_failure", false)
+	cm.SetDefault("notify_after_failures", 3)
+	cm.SetDefault("flush_interval_ms", 0)
+	cm.SetDefault("flush_count", 100)
+	cm.SetDefault("merge_window_ms", 0)
+	cm.SetDefault("recover_corrupt_db", false)
+	cm.SetDefault("migration_backups", 3)
+	cm.SetDefault("durable_writes", false)
+	cm.SetDefault("encryption_key", "")
+	cm.SetDefault("encryption_key_file", "")
+	cm.SetDefault("max_unsynced_rows", 0)
+	cm.SetDefault("unsynced_overflow", "drop-oldest")
 
-	var configPaths []string
-	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
-		configPaths = append(configPaths, filepath.Join(xdg, "blastd", "config.toml"))
+	switch {
+	case !readFile:
+		// Defaults and env vars only.
+	case path != "":
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+		cm.SetConfigFile(path)
+		if err := cm.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("%w %s: %w", ErrParse, path, err)
+		}
+	default:
+		for _, p := range searchPaths() {
+			if _, err := os.Stat(p); err == nil {
+				cm.SetConfigFile(p)
+				if err := cm.ReadInConfig(); err != nil {
+					return nil, fmt.Errorf("%w %s: %w", ErrParse, p, err)
+				}
+				break
+			}
+		}
 	}
-	if home := os.Getenv("HOME"); home != "" {
-		configPaths = append(configPaths, filepath.Join(home, ".config", "blastd", "config.toml"))
+
+	cfg := &Config{
+		ServerURL:               cm.GetString("server_url"),
+		APIToken:                cm.GetString("auth_token"),
+		OAuthTokenURL:           cm.GetString("oauth_token_url"),
+		OAuthClientID:           cm.GetString("oauth_client_id"),
+		OAuthClientSecret:       cm.GetString("oauth_client_secret"),
+		SyncEnabled:             cm.GetBool("sync_enabled"),
+		SyncIntervalMinutes:     cm.GetInt("sync_interval_minutes"),
+		SyncBatchSize:           cm.GetInt("sync_batch_size"),
+		AdaptiveBatch:           cm.GetBool("adaptive_batch"),
+		SyncBatchMax:            cm.GetInt("sync_batch_max"),
+		SyncConcurrency:         cm.GetInt("sync_concurrency"),
+		SyncMaxIdleConns:        cm.GetInt("sync_max_idle_conns"),
+		SyncIdleTimeoutSeconds:  cm.GetInt("sync_idle_timeout_seconds"),
+		CoalesceGapSeconds:      cm.GetInt("coalesce_gap_seconds"),
+		MaxSyncBytes:            cm.GetInt("max_sync_bytes"),
+		SyncOrder:               cm.GetString("sync_order"),
+		SyncDebounceSeconds:     cm.GetInt("sync_debounce_seconds"),
+		SyncStartupDelaySeconds: cm.GetInt("sync_startup_delay_seconds"),
+		SyncStream:              cm.GetBool("sync_stream"),
+		SyncReachabilityCheck:   cm.GetBool("sync_reachability_check"),
+		SyncGateCommand:         cm.GetString("sync_gate_command"),
+		ClockSkewWarnSeconds:    cm.GetInt("clock_skew_warn_seconds"),
+		DeadLetterAfter:         cm.GetInt("dead_letter_after"),
+		DataDir:                 cm.GetString("data_dir"),
+		SocketPath:              cm.GetString("socket_path"),
+		SocketMode:              cm.GetString("socket_mode"),
+		SocketGroup:             cm.GetString("socket_group"),
+		DBPath:                  cm.GetString("db_path"),
+		Machine:                 cm.GetString("machine"),
+		Timezone:                cm.GetString("timezone"),
+		AllowMachineOverride:    cm.GetBool("allow_machine_override"),
+		MetricsOnly:             cm.GetBool("metrics_only"),
+		TLSClientCert:           cm.GetString("tls_client_cert"),
+		TLSClientKey:            cm.GetString("tls_client_key"),
+		TLSCACert:               cm.GetString("tls_ca_cert"),
+		InsecureSkipVerify:      cm.GetBool("insecure_skip_verify"),
+		LogFile:                 cm.GetString("log_file"),
+		WebhookURL:              cm.GetString("webhook_url"),
+		VacuumIntervalHours:     cm.GetInt("vacuum_interval_hours"),
+		SyncedRetentionDays:     cm.GetInt("synced_retention_days"),
+		ArchiveDBPath:           cm.GetString("archive_db_path"),
+		ActivityRateLimit:       cm.GetInt("activity_rate_limit"),
+		ActivityRateBurst:       cm.GetInt("activity_rate_burst"),
+		DropZeroDuration:        cm.GetBool("drop_zero_duration"),
+		SyncBackoffMinSeconds:   cm.GetInt("sync_backoff_min_seconds"),
+		SyncBackoffMaxSeconds:   cm.GetInt("sync_backoff_max_seconds"),
+		SyncBackoffFactor:       cm.GetFloat64("sync_backoff_factor"),
+		NotifyOnFailure:         cm.GetBool("notify_on_failure"),
+		NotifyAfterFailures:     cm.GetInt("notify_after_failures"),
+		FlushIntervalMs:         cm.GetInt("flush_interval_ms"),
+		FlushCount:              cm.GetInt("flush_count"),
+		MergeWindowMs:           cm.GetInt("merge_window_ms"),
+		RecoverCorruptDB:        cm.GetBool("recover_corrupt_db"),
+		MigrationBackups:        cm.GetInt("migration_backups"),
+		DurableWrites:           cm.GetBool("durable_writes"),
+		EncryptionKey:           cm.GetString("encryption_key"),
+		EncryptionKeyFile:       cm.GetString("encryption_key_file"),
+		MaxUnsyncedRows:         cm.GetInt("max_unsynced_rows"),
+		UnsyncedOverflow:        cm.GetString("unsynced_overflow"),
 	}
 
-	for _, path := range configPaths {
-		if _, err := os.Stat(path); err == nil {
-			cm.SetConfigFile(path)
-			if err := cm.ReadInConfig(); err != nil {
-				return nil, err
-			}
-			break
+	// Expand before filling in the defaults: only values a user wrote can
+	// hold tokens. machine goes first so the paths can use {machine}.
+	vars := map[string]string{"hostname": hostname, "user": currentUser()}
+	var err error
+	if cfg.Machine, err = expandTokens("machine", cfg.Machine, vars); err != nil {
+		return nil, err
+	}
+	vars["machine"] = cfg.Machine
+	if cfg.SocketPath, err = expandTokens("socket_path", cfg.SocketPath, vars); err != nil {
+		return nil, err
+	}
+	if cfg.DBPath, err = expandTokens("db_path", cfg.DBPath, vars); err != nil {
+		return nil, err
+	}
+	if cfg.ArchiveDBPath, err = expandTokens("archive_db_path", cfg.ArchiveDBPath, vars); err != nil {
+		return nil, err
+	}
+
+	if cfg.SocketPath == "" {
+		cfg.SocketPath = defaultSocketPath(cfg.DataDir)
+	}
+	if cfg.DBPath == "" {
+		cfg.DBPath = filepath.Join(cfg.DataDir, "blast.db")
+	}
+
+	if cfg.SyncOrder != "oldest" && cfg.SyncOrder != "newest" {
+		return nil, fmt.Errorf("sync_order must be \"oldest\" or \"newest\", got %q", cfg.SyncOrder)
+	}
+
+	if cfg.UnsyncedOverflow != "drop-oldest" && cfg.UnsyncedOverflow != "reject-new" {
+		return nil, fmt.Errorf("unsynced_overflow must be \"drop-oldest\" or \"reject-new\", got %q", cfg.UnsyncedOverflow)
+	}
+
+	if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+		return nil, fmt.Errorf("timezone must be an IANA name like \"Europe/Berlin\", got %q: %w", cfg.Timezone, err)
+	}
+
+	if _, err := ParseSocketMode(cfg.SocketMode); err != nil {
+		return nil, err
+	}
+
+	if IsAbstractSocket(cfg.SocketPath) && runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("socket_path %q is a Linux abstract socket, which %s does not support", cfg.SocketPath, runtime.GOOS)
+	}
+
+	if cfg.SyncConcurrency < 1 {
+		return nil, fmt.Errorf("sync_concurrency must be at least 1, got %d", cfg.SyncConcurrency)
+	}
+
+	if cfg.AdaptiveBatch && cfg.SyncBatchMax < cfg.SyncBatchSize {
+		return nil, fmt.Errorf("sync_batch_max (%d) must be at least sync_batch_size (%d) with adaptive_batch", cfg.SyncBatchMax, cfg.SyncBatchSize)
+	}
+
+	if cfg.SyncMaxIdleConns < 1 {
+		return nil, fmt.Errorf("sync_max_idle_conns must be at least 1, got %d", cfg.SyncMaxIdleConns)
+	}
+
+	if cfg.SyncIdleTimeoutSeconds < 0 {
+		return nil, fmt.Errorf("sync_idle_timeout_seconds must not be negative, got %d", cfg.SyncIdleTimeoutSeconds)
+	}
+
+	if cfg.EncryptionKey != "" && cfg.EncryptionKeyFile != "" {
+		return nil, fmt.Errorf("set only one of encryption_key and encryption_key_file")
+	}
+
+	if cfg.MaxSyncBytes < 0 {
+		return nil, fmt.Errorf("max_sync_bytes must not be negative, got %d", cfg.MaxSyncBytes)
+	}
+
+	if cfg.CoalesceGapSeconds < 0 {
+		return nil, fmt.Errorf("coalesce_gap_seconds must not be negative, got %d", cfg.CoalesceGapSeconds)
+	}
+
+	if cfg.ClockSkewWarnSeconds < 0 {
+		return nil, fmt.Errorf("clock_skew_warn_seconds must not be negative, got %d", cfg.ClockSkewWarnSeconds)
+	}
+
+	if cfg.SyncStartupDelaySeconds < 0 {
+		return nil, fmt.Errorf("sync_startup_delay_seconds must not be negative, got %d", cfg.SyncStartupDelaySeconds)
+	}
+
+	if cfg.DeadLetterAfter < 0 {
+		return nil, fmt.Errorf("dead_letter_after must not be negative, got %d", cfg.DeadLetterAfter)
+	}
+
+	if cfg.MigrationBackups < 0 {
+		return nil, fmt.Errorf("migration_backups must not be negative, got %d", cfg.MigrationBackups)
+	}
+
+	if cfg.VacuumIntervalHours < 0 {
+		return nil, fmt.Errorf("vacuum_interval_hours must not be negative, got %d", cfg.VacuumIntervalHours)
+	}
+
+	if cfg.SyncedRetentionDays < 0 {
+		return nil, fmt.Errorf("synced_retention_days must not be negative, got %d", cfg.SyncedRetentionDays)
+	}
+
+	if cfg.ArchiveDBPath != "" {
+		if cfg.SyncedRetentionDays == 0 {
+			return nil, fmt.Errorf("archive_db_path requires synced_retention_days")
+		}
+		if filepath.Clean(cfg.ArchiveDBPath) == filepath.Clean(cfg.DBPath) {
+			return nil, fmt.Errorf("archive_db_path must differ from db_path")
 		}
 	}
 
-	cfg := &Config{
-		ServerURL:           cm.GetString("server_url"),
-		APIToken:            cm.GetString("auth_token"),
-		SyncIntervalMinutes: cm.GetInt("sync_interval_minutes"),
-		SyncBatchSize:       cm.GetInt("sync_batch_size"),
-		SocketPath:          cm.GetString("socket_path"),
-		DBPath:              cm.GetString("db_path"),
-		Machine:             cm.GetString("machine"),
-		MetricsOnly:         cm.GetBool("metrics_only"),
+	if cfg.ActivityRateLimit < 0 {
+		return nil, fmt.Errorf("activity_rate_limit must not be negative, got %d", cfg.ActivityRateLimit)
+	}
+
+	if cfg.ActivityRateLimit > 0 && cfg.ActivityRateBurst < 1 {
+		return nil, fmt.Errorf("activity_rate_burst must be at least 1, got %d", cfg.ActivityRateBurst)
+	}
+
+	if cfg.SyncBackoffMinSeconds < 1 {
+		return nil, fmt.Errorf("sync_backoff_min_seconds must be at least 1, got %d", cfg.SyncBackoffMinSeconds)
+	}
+
+	if cfg.SyncBackoffMaxSeconds < cfg.SyncBackoffMinSeconds {
+		return nil, fmt.Errorf("sync_backoff_max_seconds (%d) must not be less than sync_backoff_min_seconds (%d)", cfg.SyncBackoffMaxSeconds, cfg.SyncBackoffMinSeconds)
+	}
+
+	if cfg.SyncBackoffFactor <= 1 {
+		return nil, fmt.Errorf("sync_backoff_factor must be greater than 1, got %g", cfg.SyncBackoffFactor)
+	}
+
+	if cfg.NotifyAfterFailures < 1 {
+		return nil, fmt.Errorf("notify_after_failures must be at least 1, got %d", cfg.NotifyAfterFailures)
+	}
+
+	if cfg.FlushIntervalMs < 0 {
+		return nil, fmt.Errorf("flush_interval_ms must not be negative, got %d", cfg.FlushIntervalMs)
+	}
+
+	if cfg.FlushCount < 1 {
+		return nil, fmt.Errorf("flush_count must be at least 1, got %d", cfg.FlushCount)
+	}
+
+	if cfg.MergeWindowMs < 0 {
+		return nil, fmt.Errorf("merge_window_ms must not be negative, got %d", cfg.MergeWindowMs)
+	}
+
+	if cfg.OAuthTokenURL != "" {
+		if u, err := url.Parse(cfg.OAuthTokenURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("oauth_token_url must be an http or https URL, got %q", cfg.OAuthTokenURL)
+		}
+		if cfg.OAuthClientID == "" {
+			return nil, fmt.Errorf("oauth_client_id is required with oauth_token_url")
+		}
+	} else if cfg.OAuthClientID != "" || cfg.OAuthClientSecret != "" {
+		return nil, fmt.Errorf("oauth_client_id and oauth_client_secret require oauth_token_url")
+	}
+
+	if cfg.WebhookURL != "" {
+		if u, err := url.Parse(cfg.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook_url must be an http or https URL, got %q", cfg.WebhookURL)
+		}
+	}
+
+	if (cfg.TLSClientCert == "") != (cfg.TLSClientKey == "") {
+		return nil, fmt.Errorf("tls_client_cert and tls_client_key must be set together")
 	}
 
 	dbDir := filepath.Dir(cfg.DBPath)
@@ -73,3 +461,55 @@ func Load() (*Config, error) {
 
 	return cfg, nil
 }
+
+// searchPaths lists the default config file locations in priority order.
+func searchPaths() []string {
+	var paths []string
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		paths = append(paths, filepath.Join(xdg, "blastd", "config.toml"))
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		paths = append(paths, filepath.Join(home, ".config", "blastd", "config.toml"))
+	}
+	return paths
+}
+
+// DefaultPath returns the config file Load would read: the first existing
+// search path, or the preferred one when none exists yet.
+func DefaultPath() (string, error) {
+	paths := searchPaths()
+	if len(paths) == 0 {
+		return "", fmt.Errorf("neither XDG_CONFIG_HOME nor HOME is set")
+	}
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return paths[0], nil
+}
+
+// IsAbstractSocket reports whether path names a socket in the Linux
+// abstract namespace ("@name"), which has no file on disk.
+func IsAbstractSocket(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
+// ParseSocketMode parses an octal permission string such as "0660".
+func ParseSocketMode(mode string) (os.FileMode, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0o777 {
+		return 0, fmt.Errorf("socket_mode must be octal permissions like \"0600\", got %q", mode)
+	}
+	return os.FileMode(perm), nil
+}
+
+// defaultSocketPath prefers $XDG_RUNTIME_DIR, which is the correct home for
+// Unix sockets (per-user, tmpfs, cleaned on logout), falling back to the
+// data directory when it isn't set.
+func defaultSocketPath(dataDir string) string {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "blastd.sock")
+	}
+	return filepath.Join(dataDir, "blastd.sock")
+}
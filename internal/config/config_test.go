@@ -1,13 +1,17 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadDefaults(t *testing.T) {
 	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_RUNTIME_DIR", "")
 	tmpDir := t.TempDir()
 	t.Setenv("HOME", tmpDir)
 
@@ -25,14 +29,70 @@ func TestLoadDefaults(t *testing.T) {
 	if cfg.SyncBatchSize != 100 {
 		t.Errorf("SyncBatchSize = %d, want 100", cfg.SyncBatchSize)
 	}
+	if !cfg.SyncEnabled {
+		t.Error("SyncEnabled = false, want true")
+	}
 	if cfg.Machine == "" {
 		t.Error("Machine should default to hostname, got empty string")
 	}
-	if cfg.SocketPath == "" {
-		t.Error("SocketPath should not be empty")
+	wantDataDir := filepath.Join(tmpDir, ".local", "share", "blastd")
+	if cfg.DataDir != wantDataDir {
+		t.Errorf("DataDir = %q, want %q", cfg.DataDir, wantDataDir)
+	}
+	if cfg.SocketPath != filepath.Join(wantDataDir, "blastd.sock") {
+		t.Errorf("SocketPath = %q, want under data dir", cfg.SocketPath)
+	}
+	if cfg.DBPath != filepath.Join(wantDataDir, "blast.db") {
+		t.Errorf("DBPath = %q, want under data dir", cfg.DBPath)
+	}
+}
+
+func TestLoadDataDir(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("HOME", t.TempDir())
+	dataDir := t.TempDir()
+	t.Setenv("BLAST_DATA_DIR", dataDir)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+
+	if cfg.DBPath != filepath.Join(dataDir, "blast.db") {
+		t.Errorf("DBPath = %q, want under %q", cfg.DBPath, dataDir)
+	}
+	if cfg.SocketPath != filepath.Join(dataDir, "blastd.sock") {
+		t.Errorf("SocketPath = %q, want under %q", cfg.SocketPath, dataDir)
+	}
+
+	explicit := filepath.Join(t.TempDir(), "other.db")
+	t.Setenv("BLAST_DB_PATH", explicit)
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.DBPath != explicit {
+		t.Errorf("DBPath = %q, want explicit %q", cfg.DBPath, explicit)
+	}
+}
+
+func TestLoadRuntimeDirSocket(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", t.TempDir())
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+
+	if cfg.SocketPath != filepath.Join(runtimeDir, "blastd.sock") {
+		t.Errorf("SocketPath = %q, want under XDG_RUNTIME_DIR %q", cfg.SocketPath, runtimeDir)
 	}
-	if cfg.DBPath == "" {
-		t.Error("DBPath should not be empty")
+	if filepath.Dir(cfg.DBPath) != cfg.DataDir {
+		t.Errorf("DBPath = %q, want under data dir %q", cfg.DBPath, cfg.DataDir)
 	}
 }
 
@@ -153,3 +213,316 @@ func TestLoadNoHOME(t *testing.T) {
 		t.Fatalf("Load() should not error with missing HOME, got: %v", err)
 	}
 }
+
+func TestLoadTLSCertWithoutKey(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("BLAST_TLS_CLIENT_CERT", "/etc/blastd/client.crt")
+
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error when tls_client_cert is set without tls_client_key")
+	}
+
+	t.Setenv("BLAST_TLS_CLIENT_KEY", "/etc/blastd/client.key")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.TLSClientCert != "/etc/blastd/client.crt" || cfg.TLSClientKey != "/etc/blastd/client.key" {
+		t.Errorf("TLS paths = %q/%q, want env values", cfg.TLSClientCert, cfg.TLSClientKey)
+	}
+}
+
+func TestLoadFromExplicitPath(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", tmpDir)
+	t.Setenv("HOME", tmpDir)
+
+	// A config in the default search path that LoadFrom should ignore.
+	defaultDir := filepath.Join(tmpDir, "blastd")
+	if err := os.MkdirAll(defaultDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(defaultDir, "config.toml"), []byte(`machine = "default"`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "profile.toml")
+	content := `
+machine = "profile"
+server_url = "https://profile.example.com"
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BLAST_SERVER_URL", "https://env.example.com")
+
+	cfg, err := LoadFrom(path)
+	if err != nil {
+		t.Fatalf("LoadFrom() error: %v", err)
+	}
+	if cfg.Machine != "profile" {
+		t.Errorf("Machine = %q, want %q", cfg.Machine, "profile")
+	}
+	if cfg.ServerURL != "https://env.example.com" {
+		t.Errorf("ServerURL = %q, want env override", cfg.ServerURL)
+	}
+}
+
+func TestLoadFromMissingPath(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", t.TempDir())
+
+	if _, err := LoadFrom(filepath.Join(t.TempDir(), "missing.toml")); err == nil {
+		t.Fatal("expected error for missing explicit config file")
+	}
+}
+
+func TestLoadFromBadFile(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("BLAST_MACHINE", "from-env")
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	content := "sync_batch_size = 50\nsync_interval_minutes = = 3\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadFrom(path)
+	if !errors.Is(err, ErrParse) {
+		t.Fatalf("LoadFrom() error = %v, want ErrParse", err)
+	}
+	if !strings.Contains(err.Error(), path) || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("error %q should name the file and line", err)
+	}
+
+	cfg, parseErr, err := LoadFromFallback(path)
+	if err != nil {
+		t.Fatalf("LoadFromFallback() error: %v", err)
+	}
+	if !errors.Is(parseErr, ErrParse) {
+		t.Errorf("parseErr = %v, want ErrParse", parseErr)
+	}
+	// Nothing from the broken file is used; env vars still apply.
+	if cfg.SyncBatchSize != 100 || cfg.Machine != "from-env" {
+		t.Errorf("fallback config: batch size %d, machine %q; want defaults plus env", cfg.SyncBatchSize, cfg.Machine)
+	}
+
+	if err := os.WriteFile(path, []byte("sync_batch_size = 50\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, parseErr, err = LoadFromFallback(path)
+	if err != nil || parseErr != nil || cfg.SyncBatchSize != 50 {
+		t.Errorf("LoadFromFallback() on a valid file = %+v, %v, %v", cfg, parseErr, err)
+	}
+}
+
+func TestLoadSyncOrder(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", t.TempDir())
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.SyncOrder != "oldest" {
+		t.Errorf("SyncOrder = %q, want %q", cfg.SyncOrder, "oldest")
+	}
+
+	t.Setenv("BLAST_SYNC_ORDER", "newest")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.SyncOrder != "newest" {
+		t.Errorf("SyncOrder = %q, want %q", cfg.SyncOrder, "newest")
+	}
+
+	t.Setenv("BLAST_SYNC_ORDER", "random")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for invalid sync_order")
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Config{ServerURL: "https://example.com", APIToken: "blast_secret"}
+
+	redacted := cfg.Redacted()
+	if redacted.APIToken != "REDACTED" {
+		t.Errorf("APIToken = %q, want REDACTED", redacted.APIToken)
+	}
+	if redacted.ServerURL != cfg.ServerURL {
+		t.Errorf("ServerURL = %q, want %q", redacted.ServerURL, cfg.ServerURL)
+	}
+	if cfg.APIToken != "blast_secret" {
+		t.Error("Redacted() modified the original config")
+	}
+	if (Config{OAuthClientSecret: "secret"}).Redacted().OAuthClientSecret != "REDACTED" {
+		t.Error("OAuthClientSecret should be redacted")
+	}
+	if (Config{EncryptionKey: "secret"}).Redacted().EncryptionKey != "REDACTED" {
+		t.Error("EncryptionKey should be redacted")
+	}
+	if (Config{}).Redacted().APIToken != "" {
+		t.Error("empty token should stay empty")
+	}
+}
+
+func TestLoadUnsyncedOverflow(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("BLAST_MAX_UNSYNCED_ROWS", "5000")
+	t.Setenv("BLAST_UNSYNCED_OVERFLOW", "reject-new")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.MaxUnsyncedRows != 5000 || cfg.UnsyncedOverflow != "reject-new" {
+		t.Errorf("got max %d policy %q", cfg.MaxUnsyncedRows, cfg.UnsyncedOverflow)
+	}
+
+	t.Setenv("BLAST_UNSYNCED_OVERFLOW", "drop-newest")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for invalid unsynced_overflow")
+	}
+}
+
+func TestLoadArchiveDBPath(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "")
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	archive := filepath.Join(home, "archive.db")
+	t.Setenv("BLAST_ARCHIVE_DB_PATH", archive)
+
+	if _, err := Load(); err == nil {
+		t.Error("expected error for archive_db_path without synced_retention_days")
+	}
+
+	t.Setenv("BLAST_SYNCED_RETENTION_DAYS", "90")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.ArchiveDBPath != archive {
+		t.Errorf("ArchiveDBPath = %q, want %q", cfg.ArchiveDBPath, archive)
+	}
+
+	t.Setenv("BLAST_ARCHIVE_DB_PATH", cfg.DBPath)
+	if _, err := Load(); err == nil {
+		t.Error("expected error for archive_db_path equal to db_path")
+	}
+}
+
+func TestLoadAdaptiveBatch(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("BLAST_ADAPTIVE_BATCH", "true")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !cfg.AdaptiveBatch || cfg.SyncBatchMax != 1000 {
+		t.Errorf("got adaptive %v max %d", cfg.AdaptiveBatch, cfg.SyncBatchMax)
+	}
+
+	t.Setenv("BLAST_SYNC_BATCH_MAX", "50")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for sync_batch_max below sync_batch_size")
+	}
+}
+
+func TestLoadWebhookURL(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("BLAST_WEBHOOK_URL", "http://localhost:9000/blast")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.WebhookURL != "http://localhost:9000/blast" {
+		t.Errorf("WebhookURL = %q", cfg.WebhookURL)
+	}
+
+	for _, bad := range []string{"localhost:9000", "ftp://example.com", "http://"} {
+		t.Setenv("BLAST_WEBHOOK_URL", bad)
+		if _, err := Load(); err == nil {
+			t.Errorf("expected error for webhook_url %q", bad)
+		}
+	}
+}
+
+func TestLoadOAuth(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("BLAST_OAUTH_TOKEN_URL", "https://auth.example.com/token")
+	t.Setenv("BLAST_OAUTH_CLIENT_ID", "blastd")
+	t.Setenv("BLAST_OAUTH_CLIENT_SECRET", "s3cret")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.OAuthTokenURL != "https://auth.example.com/token" || cfg.OAuthClientID != "blastd" || cfg.OAuthClientSecret != "s3cret" {
+		t.Errorf("got url %q id %q secret %q", cfg.OAuthTokenURL, cfg.OAuthClientID, cfg.OAuthClientSecret)
+	}
+
+	t.Setenv("BLAST_OAUTH_CLIENT_ID", "")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for oauth_token_url without oauth_client_id")
+	}
+
+	t.Setenv("BLAST_OAUTH_CLIENT_ID", "blastd")
+	t.Setenv("BLAST_OAUTH_TOKEN_URL", "auth.example.com/token")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for oauth_token_url without a scheme")
+	}
+
+	t.Setenv("BLAST_OAUTH_TOKEN_URL", "")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for oauth_client_id without oauth_token_url")
+	}
+}
+
+func TestLoadTimezone(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", t.TempDir())
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if cfg.Location() != time.Local {
+		t.Errorf("default Location() = %v, want Local", cfg.Location())
+	}
+
+	t.Setenv("BLAST_TIMEZONE", "America/New_York")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if got := cfg.Location().String(); got != "America/New_York" {
+		t.Errorf("Location() = %q, want America/New_York", got)
+	}
+
+	t.Setenv("BLAST_TIMEZONE", "Mars/Olympus_Mons")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for invalid timezone")
+	}
+}
+
+func TestParseSocketMode(t *testing.T) {
+	for mode, want := range map[string]os.FileMode{"0600": 0o600, "660": 0o660, "0770": 0o770} {
+		got, err := ParseSocketMode(mode)
+		if err != nil || got != want {
+			t.Errorf("ParseSocketMode(%q) = %o, %v; want %o", mode, got, err, want)
+		}
+	}
+	for _, mode := range []string{"", "rw-rw----", "0800", "1777"} {
+		if _, err := ParseSocketMode(mode); err == nil {
+			t.Errorf("ParseSocketMode(%q) succeeded, want error", mode)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrUnknownKey is returned for keys that aren't config settings.
+var ErrUnknownKey = errors.New("unknown config key")
+
+// Keys returns every config key in declaration order.
+func Keys() []string {
+	typ := reflect.TypeOf(Config{})
+	keys := make([]string, 0, typ.NumField())
+	for i := range typ.NumField() {
+		keys = append(keys, typ.Field(i).Tag.Get("json"))
+	}
+	return keys
+}
+
+// field returns the struct field index for key.
+func field(key string) (int, error) {
+	typ := reflect.TypeOf(Config{})
+	for i := range typ.NumField() {
+		if typ.Field(i).Tag.Get("json") == key {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q", ErrUnknownKey, key)
+}
+
+// Get returns the value of key as text.
+func (c Config) Get(key string) (string, error) {
+	i, err := field(key)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(reflect.ValueOf(c).Field(i).Interface()), nil
+}
+
+// SetInFile sets key to value in the TOML file at path, keeping the rest of
+// the file, comments included. A commented-out line for the key (as written
+// by `config init`) is uncommented in place; otherwise the key is appended.
+// A missing file is created from Template. The result must load cleanly or
+// the file is left untouched.
+func SetInFile(path, key, value string) error {
+	i, err := field(key)
+	if err != nil {
+		return err
+	}
+	encoded, err := tomlValue(reflect.TypeOf(Config{}).Field(i).Type.Kind(), value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return fmt.Errorf("create config directory: %w", err)
+		}
+		content = []byte(Template)
+	} else if err != nil {
+		return err
+	}
+
+	line := key + " = " + encoded
+	set := regexp.MustCompile(`(?m)^[ \t]*` + regexp.QuoteMeta(key) + `[ \t]*=.*$`)
+	commented := regexp.MustCompile(`(?m)^[ \t]*#[ \t]*` + regexp.QuoteMeta(key) + `[ \t]*=.*$`)
+	switch {
+	case set.Match(content):
+		content = replaceFirst(set, content, line)
+	case commented.Match(content):
+		content = replaceFirst(commented, content, line)
+	default:
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			content = append(content, '\n')
+		}
+		content = append(content, line+"\n"...)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.toml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if _, err := LoadFrom(tmp.Name()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func replaceFirst(re *regexp.Regexp, content []byte, line string) []byte {
+	loc := re.FindIndex(content)
+	out := append([]byte{}, content[:loc[0]]...)
+	out = append(out, line...)
+	return append(out, content[loc[1]:]...)
+}
+
+// tomlValue validates raw for a field of the given kind and encodes it as a
+// TOML value.
+func tomlValue(kind reflect.Kind, raw string) (string, error) {
+	switch kind {
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return "", fmt.Errorf("want an integer, got %q", raw)
+		}
+		return strconv.Itoa(n), nil
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return "", fmt.Errorf("want a number, got %q", raw)
+		}
+		// A TOML float needs a fraction or exponent; "2" would be an integer.
+		v := strconv.FormatFloat(f, 'g', -1, 64)
+		if !strings.ContainsAny(v, ".e") {
+			v += ".0"
+		}
+		return v, nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "", fmt.Errorf("want true or false, got %q", raw)
+		}
+		return strconv.FormatBool(b), nil
+	default:
+		if strings.ContainsFunc(raw, func(r rune) bool { return r < ' ' || r == 0x7f }) {
+			return "", fmt.Errorf("value must not contain control characters")
+		}
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(raw) + `"`, nil
+	}
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetInFile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "blastd", "config.toml")
+
+	// A missing file is created from the template, uncommenting the key.
+	if err := SetInFile(path, "auth_token", `blast_"x"`); err != nil {
+		t.Fatalf("SetInFile() error: %v", err)
+	}
+	if err := SetInFile(path, "sync_batch_size", "250"); err != nil {
+		t.Fatalf("SetInFile() error: %v", err)
+	}
+	if err := SetInFile(path, "sync_batch_size", "50"); err != nil {
+		t.Fatalf("SetInFile() error: %v", err)
+	}
+	if err := SetInFile(path, "metrics_only", "true"); err != nil {
+		t.Fatalf("SetInFile() error: %v", err)
+	}
+	if err := SetInFile(path, "sync_backoff_factor", "1.5"); err != nil {
+		t.Fatalf("SetInFile() error: %v", err)
+	}
+
+	cfg, err := LoadFrom(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.APIToken != `blast_"x"` || cfg.SyncBatchSize != 50 || !cfg.MetricsOnly {
+		t.Errorf("got token %q batch %d metrics_only %v", cfg.APIToken, cfg.SyncBatchSize, cfg.MetricsOnly)
+	}
+	if cfg.SyncBackoffFactor != 1.5 {
+		t.Errorf("sync_backoff_factor = %v, want 1.5", cfg.SyncBackoffFactor)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "# Syncing.") {
+		t.Error("comments from the template were not preserved")
+	}
+	if n := strings.Count(string(content), "sync_batch_size ="); n != 1 {
+		t.Errorf("sync_batch_size appears %d times, want 1", n)
+	}
+	if !strings.Contains(string(content), "sync_backoff_factor = 1.5\n") {
+		t.Error("sync_backoff_factor was not written as an unquoted float")
+	}
+}
+
+func TestSetInFileRejectsInvalid(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("machine = \"a\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetInFile(path, "no_such_key", "1"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown key: got %v, want ErrUnknownKey", err)
+	}
+	if err := SetInFile(path, "sync_batch_size", "lots"); err == nil {
+		t.Error("expected error for non-integer value")
+	}
+	if err := SetInFile(path, "sync_backoff_factor", "fast"); err == nil {
+		t.Error("expected error for non-numeric float value")
+	}
+	// Well-typed but rejected by LoadFrom's validation.
+	if err := SetInFile(path, "socket_mode", "999"); err == nil {
+		t.Error("expected error for invalid socket_mode")
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "machine = \"a\"\n" {
+		t.Errorf("file changed after failed sets: %q", content)
+	}
+}
+
+func TestGet(t *testing.T) {
+	cfg := Config{SyncBatchSize: 7, MetricsOnly: true}
+	for key, want := range map[string]string{"sync_batch_size": "7", "metrics_only": "true"} {
+		if got, err := cfg.Get(key); err != nil || got != want {
+			t.Errorf("Get(%q) = %q, %v; want %q", key, got, err, want)
+		}
+	}
+	if _, err := cfg.Get("nope"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Get(nope) error = %v, want ErrUnknownKey", err)
+	}
+}
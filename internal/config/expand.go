@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"strings"
+)
+
+// tokenPattern matches a template token: {hostname}, {user}, {machine}
+// or {env:NAME}. Braces around anything else are left alone.
+var tokenPattern = regexp.MustCompile(`\{([a-z]+)(?::([^{}]*))?\}`)
+
+// expandTokens replaces the template tokens in the value of key. vars maps
+// the plain token names allowed for key to their values; {env:NAME} is
+// always allowed. An unknown token or an unset variable is an error, so a
+// typo can't quietly produce a path shared by every machine.
+func expandTokens(key, value string, vars map[string]string) (string, error) {
+	var err error
+	expanded := tokenPattern.ReplaceAllStringFunc(value, func(token string) string {
+		if err != nil {
+			return token
+		}
+		m := tokenPattern.FindStringSubmatch(token)
+		name, arg := m[1], m[2]
+		if name == "env" {
+			v, ok := os.LookupEnv(arg)
+			if arg == "" || !ok {
+				err = fmt.Errorf("%s: environment variable %q in %s is not set", key, arg, token)
+			}
+			return v
+		}
+		v, ok := vars[name]
+		if !ok || arg != "" {
+			err = fmt.Errorf("%s: unknown token %s", key, token)
+		} else if v == "" {
+			err = fmt.Errorf("%s: %s is empty on this machine", key, token)
+		}
+		return v
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+// ExpandTokens expands the template tokens in value the way the path keys
+// of the config file are expanded, with {machine} taken from c. Callers use
+// it for command-line overrides such as --socket; key names the source in
+// errors.
+func (c *Config) ExpandTokens(key, value string) (string, error) {
+	hostname, _ := os.Hostname()
+	return expandTokens(key, value, map[string]string{
+		"hostname": hostname,
+		"user":     currentUser(),
+		"machine":  c.Machine,
+	})
+}
+
+// currentUser returns the login name for {user}, without a Windows
+// domain prefix.
+func currentUser() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if i := strings.LastIndex(name, `\`); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandTokens(t *testing.T) {
+	t.Setenv("BLAST_TEST_DIR", "/srv/blast")
+	vars := map[string]string{"hostname": "laptop", "user": "ana", "machine": "work"}
+
+	tests := []struct {
+		value, want string
+	}{
+		{"/data/blast.db", "/data/blast.db"},
+		{"/data/{hostname}/blast.db", "/data/laptop/blast.db"},
+		{"/data/{user}-{machine}.db", "/data/ana-work.db"},
+		{"{env:BLAST_TEST_DIR}/{hostname}.db", "/srv/blast/laptop.db"},
+		{"/data/{Hostname}/{}/{a b}.db", "/data/{Hostname}/{}/{a b}.db"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := expandTokens("db_path", tt.value, vars)
+		if err != nil {
+			t.Errorf("expandTokens(%q) error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expandTokens(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestExpandTokensErrors(t *testing.T) {
+	vars := map[string]string{"hostname": "", "user": "ana"}
+
+	tests := []struct {
+		value, want string
+	}{
+		{"/data/{hostnme}.db", "unknown token {hostnme}"},
+		{"/data/{machine}.db", "unknown token {machine}"},
+		{"/data/{user:x}.db", "unknown token {user:x}"},
+		{"/data/{env:BLAST_TEST_UNSET}.db", `"BLAST_TEST_UNSET" in {env:BLAST_TEST_UNSET} is not set`},
+		{"/data/{env:}.db", "is not set"},
+		{"/data/{hostname}.db", "{hostname} is empty"},
+	}
+	for _, tt := range tests {
+		_, err := expandTokens("db_path", tt.value, vars)
+		if err == nil {
+			t.Errorf("expandTokens(%q) succeeded", tt.value)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) || !strings.HasPrefix(err.Error(), "db_path: ") {
+			t.Errorf("expandTokens(%q) error = %q, want it to contain %q", tt.value, err, tt.want)
+		}
+	}
+}
+
+func TestLoadExpandsTokens(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", tmpDir)
+	t.Setenv("BLAST_TEST_ROOT", tmpDir)
+	t.Setenv("BLAST_MACHINE", "{user}@{hostname}")
+	t.Setenv("BLAST_DB_PATH", "{env:BLAST_TEST_ROOT}/{machine}/blast.db")
+	t.Setenv("BLAST_SOCKET_PATH", "{env:BLAST_TEST_ROOT}/{hostname}.sock")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	hostname, _ := os.Hostname()
+	machine := currentUser() + "@" + hostname
+	if cfg.Machine != machine {
+		t.Errorf("Machine = %q, want %q", cfg.Machine, machine)
+	}
+	if want := filepath.Join(tmpDir, machine, "blast.db"); cfg.DBPath != want {
+		t.Errorf("DBPath = %q, want %q", cfg.DBPath, want)
+	}
+	if want := filepath.Join(tmpDir, hostname+".sock"); cfg.SocketPath != want {
+		t.Errorf("SocketPath = %q, want %q", cfg.SocketPath, want)
+	}
+
+	t.Setenv("BLAST_DB_PATH", "{env:BLAST_TEST_ROOT}/{host}/blast.db")
+	if _, err := Load(); err == nil {
+		t.Error("expected error for an unknown token in db_path")
+	}
+}
+
+func TestConfigExpandTokens(t *testing.T) {
+	t.Setenv("BLAST_TEST_DIR", "/run/blast")
+	cfg := &Config{Machine: "work"}
+
+	got, err := cfg.ExpandTokens("--socket", "{env:BLAST_TEST_DIR}/{machine}-{user}.sock")
+	if err != nil {
+		t.Fatalf("ExpandTokens() error: %v", err)
+	}
+	if want := "/run/blast/work-" + currentUser() + ".sock"; got != want {
+		t.Errorf("ExpandTokens() = %q, want %q", got, want)
+	}
+
+	_, err = cfg.ExpandTokens("--socket", "/run/{host}.sock")
+	if err == nil || !strings.HasPrefix(err.Error(), "--socket: unknown token {host}") {
+		t.Errorf("ExpandTokens() error = %v, want an unknown token error for --socket", err)
+	}
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// MachineIDFile is the name of the file in data_dir holding the machine ID.
+const MachineIDFile = "machine_id"
+
+// MachineID returns this installation's stable identifier, creating
+// <dataDir>/machine_id with a random UUID on first use. Unlike the machine
+// name it survives hostname changes, so the server can group activity by
+// it while still displaying the name.
+func MachineID(dataDir string) (string, error) {
+	path := filepath.Join(dataDir, MachineIDFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id, nil
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("read machine id: %w", err)
+	}
+
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return "", fmt.Errorf("create data directory: %w", err)
+	}
+	id := uuid.NewString()
+	if err := os.WriteFile(path, []byte(id+"\n"), 0o644); err != nil {
+		return "", fmt.Errorf("write machine id: %w", err)
+	}
+	return id, nil
+}
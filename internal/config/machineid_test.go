@@ -0,0 +1,44 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMachineID(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "blastd")
+
+	id, err := MachineID(dataDir)
+	if err != nil {
+		t.Fatalf("MachineID() error: %v", err)
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		t.Errorf("MachineID() = %q, want a UUID", id)
+	}
+
+	again, err := MachineID(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != id {
+		t.Errorf("second MachineID() = %q, want the persisted %q", again, id)
+	}
+
+	// A hand-written ID is kept as is; an empty file gets a new one.
+	path := filepath.Join(dataDir, MachineIDFile)
+	if err := os.WriteFile(path, []byte("workstation-1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := MachineID(dataDir); got != "workstation-1" {
+		t.Errorf("MachineID() = %q, want %q", got, "workstation-1")
+	}
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := MachineID(dataDir); got == "" || got == "workstation-1" {
+		t.Errorf("MachineID() for an empty file = %q, want a fresh ID", got)
+	}
+}
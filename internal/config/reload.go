@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// RestartRequiredError is returned by a reload when keys changed that only
+// take effect when the daemon restarts, e.g. socket_path or db_path.
+type RestartRequiredError struct {
+	Keys []string
+}
+
+func (e *RestartRequiredError) Error() string {
+	return fmt.Sprintf("restart required to apply: %s", strings.Join(e.Keys, ", "))
+}
+
+// Changed returns the keys whose values differ between a and b, in
+// declaration order.
+func Changed(a, b *Config) []string {
+	va, vb := reflect.ValueOf(*a), reflect.ValueOf(*b)
+	typ := va.Type()
+	var keys []string
+	for i := range typ.NumField() {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			keys = append(keys, typ.Field(i).Tag.Get("json"))
+		}
+	}
+	return keys
+}
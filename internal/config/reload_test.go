@@ -0,0 +1,32 @@
+package config
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestChanged(t *testing.T) {
+	a := &Config{SocketPath: "/tmp/a.sock", DropZeroDuration: false, MaxUnsyncedRows: 10}
+	b := *a
+	if got := Changed(a, &b); len(got) != 0 {
+		t.Errorf("Changed(identical) = %v, want none", got)
+	}
+
+	b.SocketPath = "/tmp/b.sock"
+	b.DropZeroDuration = true
+	if got, want := Changed(a, &b), []string{"socket_path", "drop_zero_duration"}; !slices.Equal(got, want) {
+		t.Errorf("Changed() = %v, want %v", got, want)
+	}
+}
+
+func TestRestartRequiredError(t *testing.T) {
+	var err error = &RestartRequiredError{Keys: []string{"socket_path", "db_path"}}
+	if got, want := err.Error(), "restart required to apply: socket_path, db_path"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	var restart *RestartRequiredError
+	if !errors.As(err, &restart) || len(restart.Keys) != 2 {
+		t.Errorf("errors.As() = %v", restart)
+	}
+}
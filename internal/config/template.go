@@ -0,0 +1,137 @@
+package config
+
+// Template is a commented config.toml for `blastd config init`. Every key
+// is commented out at its default, so env vars still apply until a value is
+// uncommented.
+const Template = `# blastd configuration. Uncomment a key to override its default.
+# Each key can also be set with a BLAST_-prefixed env var, e.g. BLAST_AUTH_TOKEN.
+
+# Blast server and the API token from your account settings.
+# server_url = "https://nvimblast.com"
+# auth_token = "blast_xxxxxxxxxxxxxxxx"
+
+# Fetch short-lived tokens from an OAuth 2.0 client credentials endpoint
+# instead of using auth_token, which is then ignored. Tokens are cached
+# until they expire and refetched when the server answers 401.
+# oauth_token_url = "https://auth.example.com/oauth/token"
+# oauth_client_id = ""
+# oauth_client_secret = ""
+
+# Syncing. sync_enabled = false records locally but never contacts the
+# server.
+# sync_enabled = true
+# sync_interval_minutes = 10
+# sync_batch_size = 100
+# adaptive_batch = false           # grow batches while a backlog drains, shrink on failures
+# sync_batch_max = 1000            # largest batch adaptive_batch grows to
+# sync_concurrency = 1
+# sync_max_idle_conns = 2          # idle connections kept open for the next sync
+# sync_idle_timeout_seconds = 90   # how long they stay open; 0 = until the server closes them
+# coalesce_gap_seconds = 0         # merge adjacent activities before syncing; 0 = off
+# max_sync_bytes = 4194304         # split uploads larger than this; 0 = no limit
+# sync_order = "oldest"             # or "newest"
+# sync_debounce_seconds = 60
+# sync_startup_delay_seconds = 0
+# sync_stream = false
+# sync_reachability_check = false  # HEAD the server before syncing; wait while offline
+# sync_gate_command = ""           # run before each sync; non-zero exit skips it
+# clock_skew_warn_seconds = 300    # log when the server's clock differs by more; 0 = off
+# dead_letter_after = 3
+
+# Local storage and the socket editors connect to. socket_path and db_path
+# default to locations derived from data_dir. They, and machine, may use the
+# tokens {hostname}, {user}, {env:NAME} and (paths only) {machine}, so one
+# config can give each machine its own database, e.g.
+# db_path = "{env:HOME}/sync/blastd/{machine}.db".
+# data_dir = "~/.local/share/blastd"
+# socket_path = "$XDG_RUNTIME_DIR/blastd.sock"
+# socket_mode = "0600"
+# socket_group = ""
+# db_path = "<data_dir>/blast.db"
+# max_unsynced_rows = 0             # 0 = unlimited
+# unsynced_overflow = "drop-oldest" # or "reject-new"
+# recover_corrupt_db = false
+# migration_backups = 3
+# The database runs in WAL mode. With durable_writes = false (synchronous
+# NORMAL) inserts skip the per-commit fsync, which saves battery and SSD
+# wear; a power loss can drop the last few activities but can't corrupt
+# the file. true (synchronous FULL) fsyncs every commit.
+# durable_writes = false
+
+# Encrypt project, git_remote and filename in the database (AES-256-GCM).
+# The key is base64 of 32 random bytes (openssl rand -base64 32). If it is
+# lost, those fields can't be recovered. Set at most one of the two.
+# encryption_key = ""
+# encryption_key_file = ""
+
+# Identity and privacy. machine defaults to the hostname, timezone to the
+# system zone.
+# machine = "my-laptop"
+# timezone = "Europe/Berlin"
+# allow_machine_override = false
+# metrics_only = false
+
+# TLS for the sync client.
+# tls_client_cert = ""
+# tls_client_key = ""
+# tls_ca_cert = ""
+# insecure_skip_verify = false
+
+# Log to a file instead of stderr.
+# log_file = ""
+
+# Also POST each stored activity as JSON to this URL, e.g. a local
+# dashboard. Best effort: failures never block editors or syncing.
+# webhook_url = ""
+
+# Every this many hours, VACUUM the database to return space freed by
+# deleted rows to the filesystem. 0 = never.
+# vacuum_interval_hours = 0
+
+# Delete synced activities that started more than this many days ago,
+# checked at startup and daily. Unsynced activities are never deleted by
+# age. 0 = keep everything.
+# synced_retention_days = 0
+
+# With synced_retention_days set, move those activities into this separate
+# SQLite file instead of deleting them, keeping the full history offline
+# while the main database stays small. Same schema and encryption key.
+# archive_db_path = ""
+
+# Safety valve against a runaway plugin: each socket connection may store
+# this many activities per second on average, in bursts of up to
+# activity_rate_burst. Excess activities get ERR_RATE_LIMITED. 0 = no limit.
+# activity_rate_limit = 100
+# activity_rate_burst = 1000
+
+# Acknowledge but don't store activities whose ended_at isn't after
+# started_at (instantaneous events). When false they are stored with no
+# duration and carry no weight in averaged rates.
+# drop_zero_duration = false
+
+# After a failed sync, wait sync_backoff_min_seconds before retrying, then
+# multiply the wait by sync_backoff_factor after each further failure, up
+# to sync_backoff_max_seconds.
+# sync_backoff_min_seconds = 30
+# sync_backoff_max_seconds = 1800
+# sync_backoff_factor = 2.0
+
+# Show a desktop notification (notify-send on Linux and the BSDs, osascript
+# on macOS) once notify_after_failures sync passes in a row have failed, and
+# another when sync works again. Ignored where no notifier is available.
+# notify_on_failure = false
+# notify_after_failures = 3
+
+# Acknowledge activities before writing them and store them in batches of
+# up to flush_count, at most flush_interval_ms after the first arrived.
+# Faster under heavy load, but a crash loses what hasn't been written yet.
+# 0 writes every activity before acknowledging it.
+# flush_interval_ms = 0
+# flush_count = 100
+
+# Hold each activity this long before storing it and extend it with
+# activities that continue it (same project, branch and filetype, starting
+# within the window), so rapid file switches become one row. Held
+# activities are lost if the daemon crashes. 0 stores each as it arrives.
+# merge_window_ms = 0
+`
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplateCoversEveryKey(t *testing.T) {
+	for _, key := range Keys() {
+		if !strings.Contains(Template, "# "+key+" = ") {
+			t.Errorf("Template is missing %q", key)
+		}
+	}
+}
+
+func TestTemplateLoads(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(Template), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadFrom(path)
+	if err != nil {
+		t.Fatalf("LoadFrom(template) error: %v", err)
+	}
+	if cfg.ServerURL != "https://nvimblast.com" || cfg.APIToken != "" {
+		t.Errorf("template changed defaults: %+v", cfg.Redacted())
+	}
+}
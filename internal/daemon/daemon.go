@@ -1,49 +1,306 @@
 package daemon
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	gosync "sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/taigrr/blastd/internal/config"
 	"github.com/taigrr/blastd/internal/db"
+	"github.com/taigrr/blastd/internal/notify"
 	"github.com/taigrr/blastd/internal/socket"
 	"github.com/taigrr/blastd/internal/sync"
+	"github.com/taigrr/blastd/internal/tap"
+	"github.com/taigrr/blastd/internal/webhook"
 )
 
 type Daemon struct {
-	cfg    *config.Config
-	db     *db.DB
-	socket *socket.Server
-	syncer *sync.Syncer
+	cfg      *config.Config
+	db       *db.DB
+	socket   *socket.Server
+	syncer   *sync.Syncer
+	webhook  *webhook.Forwarder
+	tap      *tap.Tap
+	done     chan struct{}
+	stopOnce gosync.Once
+	pruned   chan struct{} // a prune deleted rows; vacuumLoop runs early
+
+	load     func() (*config.Config, error)
+	reloadMu gosync.Mutex
+	applied  *config.Config // cfg plus changes applied by Reload; guarded by reloadMu
+}
+
+// oneshotRetries bounds how many consecutive failed batches Oneshot retries
+// before giving up.
+const oneshotRetries = 3
+
+// pruneInterval is how often synced_retention_days is enforced.
+const pruneInterval = 24 * time.Hour
+
+// DBOptions returns the database options selected by cfg, reading the
+// encryption key from encryption_key_file if set.
+func DBOptions(cfg *config.Config) (db.Options, error) {
+	opts := db.Options{Backups: cfg.MigrationBackups, RelaxedSync: !cfg.DurableWrites}
+
+	secret := cfg.EncryptionKey
+	if cfg.EncryptionKeyFile != "" {
+		data, err := os.ReadFile(cfg.EncryptionKeyFile)
+		if err != nil {
+			return db.Options{}, fmt.Errorf("read encryption_key_file: %w", err)
+		}
+		secret = string(data)
+	}
+	if secret != "" {
+		key, err := db.ParseEncryptionKey(secret)
+		if err != nil {
+			return db.Options{}, err
+		}
+		opts.EncryptionKey = key
+	}
+	return opts, nil
 }
 
-func New(cfg *config.Config) (*Daemon, error) {
-	database, err := db.Open(cfg.DBPath)
+// New wires up the database, socket server, and syncer. version is the
+// blastd build version reported to the server.
+func New(cfg *config.Config, version string) (*Daemon, error) {
+	opts, err := DBOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	machineID, err := config.MachineID(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	database, err := db.OpenWithOptions(cfg.DBPath, opts)
+	if errors.Is(err, db.ErrCorrupt) {
+		if !cfg.RecoverCorruptDB {
+			return nil, fmt.Errorf("%w (set recover_corrupt_db = true to move it aside and start fresh)", err)
+		}
+		moved, qErr := db.Quarantine(cfg.DBPath)
+		if qErr != nil {
+			return nil, fmt.Errorf("%w (quarantine failed: %v)", err, qErr)
+		}
+		log.Printf("WARNING: %v; moved it to %s and starting with an empty database", err, moved)
+		database, err = db.OpenWithOptions(cfg.DBPath, opts)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if cfg.ArchiveDBPath != "" {
+		if err := prepareArchive(cfg.ArchiveDBPath, opts); err != nil {
+			if closeErr := database.Close(); closeErr != nil {
+				return nil, fmt.Errorf("%w (close db: %v)", err, closeErr)
+			}
+			return nil, err
+		}
+	}
 
 	socketServer := socket.NewServer(cfg.SocketPath, database, cfg.Machine)
+	socketServer.SetConfig(cfg)
+	socketServer.SetLocation(cfg.Location())
+	if err := configureSocketPermissions(socketServer, cfg); err != nil {
+		if closeErr := database.Close(); closeErr != nil {
+			return nil, fmt.Errorf("%w (close db: %v)", err, closeErr)
+		}
+		return nil, err
+	}
+	socketServer.SetAllowMachineOverride(cfg.AllowMachineOverride)
+	if cfg.FlushIntervalMs > 0 {
+		socketServer.SetWriteBuffer(time.Duration(cfg.FlushIntervalMs)*time.Millisecond, cfg.FlushCount)
+	}
+	if cfg.MergeWindowMs > 0 {
+		socketServer.SetMergeWindow(time.Duration(cfg.MergeWindowMs) * time.Millisecond)
+	}
+	// Clients that don't identify themselves share one instance per run.
+	socketServer.SetClientUUID(uuid.NewString())
+	socketServer.SetUnsyncedCap(int64(cfg.MaxUnsyncedRows), socket.OverflowPolicy(cfg.UnsyncedOverflow))
+	socketServer.SetInsertRateLimit(float64(cfg.ActivityRateLimit), cfg.ActivityRateBurst)
+	socketServer.SetDropZeroDuration(cfg.DropZeroDuration)
 	syncer := sync.NewSyncer(database, cfg.ServerURL, cfg.APIToken, cfg.SyncIntervalMinutes, cfg.SyncBatchSize, cfg.MetricsOnly)
-	socketServer.SetSyncFunc(syncer.SyncNow)
+	syncer.SetOAuth(cfg.OAuthTokenURL, cfg.OAuthClientID, cfg.OAuthClientSecret)
+	syncer.SetOrder(db.SyncOrder(cfg.SyncOrder))
+	syncer.SetClientInfo(sync.ClientInfo{
+		Version:   version,
+		Machine:   cfg.Machine,
+		MachineID: machineID,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	})
+	syncer.SetDebounce(time.Duration(cfg.SyncDebounceSeconds) * time.Second)
+	syncer.SetReachabilityCheck(cfg.SyncReachabilityCheck)
+	syncer.SetGate(cfg.SyncGateCommand)
+	syncer.SetLocation(cfg.Location())
+	syncer.SetClockSkewThreshold(time.Duration(cfg.ClockSkewWarnSeconds) * time.Second)
+	syncer.SetStartupDelay(time.Duration(cfg.SyncStartupDelaySeconds) * time.Second)
+	syncer.SetStream(cfg.SyncStream)
+	syncer.SetConcurrency(cfg.SyncConcurrency)
+	if cfg.AdaptiveBatch {
+		syncer.SetAdaptiveBatch(cfg.SyncBatchMax)
+	}
+	syncer.SetKeepAlive(cfg.SyncMaxIdleConns, time.Duration(cfg.SyncIdleTimeoutSeconds)*time.Second)
+	syncer.SetMaxBytes(cfg.MaxSyncBytes)
+	syncer.SetCoalesceGap(time.Duration(cfg.CoalesceGapSeconds) * time.Second)
+	syncer.SetDeadLetterAfter(cfg.DeadLetterAfter)
+	syncer.SetBackoff(time.Duration(cfg.SyncBackoffMinSeconds)*time.Second, time.Duration(cfg.SyncBackoffMaxSeconds)*time.Second, cfg.SyncBackoffFactor)
+	if err := syncer.SetTLS(sync.TLSOptions{
+		ClientCert:         cfg.TLSClientCert,
+		ClientKey:          cfg.TLSClientKey,
+		CACert:             cfg.TLSCACert,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}); err != nil {
+		if closeErr := database.Close(); closeErr != nil {
+			return nil, fmt.Errorf("configure sync tls: %w (close db: %v)", err, closeErr)
+		}
+		return nil, fmt.Errorf("configure sync tls: %w", err)
+	}
+	if cfg.SyncEnabled {
+		socketServer.SetActivityFunc(syncer.Notify)
+		socketServer.SetSyncFunc(syncer.SyncNow)
+		socketServer.SetSyncHistoryFunc(syncer.History)
+		socketServer.SetSyncWarningFunc(syncer.LastWarning)
+	}
+	if cfg.NotifyOnFailure {
+		if notifier := notify.New(); notifier != nil {
+			syncer.SetFailureNotifier(cfg.NotifyAfterFailures, func(title, message string) {
+				if err := notifier.Send(title, message); err != nil {
+					log.Printf("notify: %v", err)
+				}
+			})
+		} else {
+			log.Println("notify_on_failure is set but no desktop notifier is available; ignoring it")
+		}
+	}
+
+	var forwarder *webhook.Forwarder
+	if cfg.WebhookURL != "" {
+		forwarder = webhook.New(cfg.WebhookURL)
+	}
+
+	d := &Daemon{
+		cfg:     cfg,
+		db:      database,
+		socket:  socketServer,
+		syncer:  syncer,
+		webhook: forwarder,
+		done:    make(chan struct{}),
+		pruned:  make(chan struct{}, 1),
+		applied: cfg,
+	}
+	socketServer.SetReloadFunc(d.Reload)
+	if forwarder != nil {
+		socketServer.SetEventFunc(d.sendEvent)
+	}
+	return d, nil
+}
 
-	return &Daemon{
-		cfg:    cfg,
-		db:     database,
-		socket: socketServer,
-		syncer: syncer,
-	}, nil
+// SetEventOutput makes the daemon write every stored activity to w as a
+// JSON line, the same event the subscribe request streams. A slow reader
+// misses events rather than delaying inserts. Must be called before Run.
+func (d *Daemon) SetEventOutput(w io.Writer) {
+	d.tap = tap.New(w)
+	d.socket.SetEventFunc(d.sendEvent)
+}
+
+// sendEvent hands a stored activity to the webhook and event output.
+func (d *Daemon) sendEvent(ev socket.Event) {
+	if d.webhook != nil {
+		d.webhook.Send(ev)
+	}
+	if d.tap != nil {
+		d.tap.Send(ev)
+	}
+}
+
+// configureSocketPermissions applies socket_mode and resolves socket_group
+// to a gid, failing clearly if the group doesn't exist.
+func configureSocketPermissions(server *socket.Server, cfg *config.Config) error {
+	mode, err := config.ParseSocketMode(cfg.SocketMode)
+	if err != nil {
+		return err
+	}
+
+	gid := -1
+	if cfg.SocketGroup != "" {
+		group, err := user.LookupGroup(cfg.SocketGroup)
+		if err != nil {
+			return fmt.Errorf("socket_group %q: %w", cfg.SocketGroup, err)
+		}
+		if gid, err = strconv.Atoi(group.Gid); err != nil {
+			return fmt.Errorf("socket_group %q: unsupported gid %q", cfg.SocketGroup, group.Gid)
+		}
+	}
+
+	server.SetPermissions(mode, gid)
+	return nil
 }
 
 func (d *Daemon) Run() error {
 	log.Printf("starting blastd daemon")
 	log.Printf("  socket: %s", d.cfg.SocketPath)
 	log.Printf("  database: %s", d.cfg.DBPath)
-	log.Printf("  server: %s", d.cfg.ServerURL)
-	log.Printf("  sync interval: %d minutes", d.cfg.SyncIntervalMinutes)
+	if d.cfg.SyncEnabled {
+		log.Printf("  server: %s", d.cfg.ServerURL)
+		if d.cfg.OAuthTokenURL != "" {
+			log.Printf("  auth: oauth client credentials from %s", d.cfg.OAuthTokenURL)
+			if d.cfg.APIToken != "" {
+				log.Println("  auth_token is ignored while oauth_token_url is set")
+			}
+		}
+		log.Printf("  sync interval: %d minutes", d.cfg.SyncIntervalMinutes)
+		if d.cfg.AdaptiveBatch {
+			log.Printf("  sync batch size: adaptive, %d to %d", d.cfg.SyncBatchSize, d.cfg.SyncBatchMax)
+		}
+	} else {
+		log.Printf("  sync: disabled, recording locally only")
+	}
+	if d.cfg.WebhookURL != "" {
+		log.Printf("  webhook: %s", d.cfg.WebhookURL)
+	}
+	if d.tap != nil {
+		log.Printf("  event output: JSON lines")
+	}
+	if d.cfg.VacuumIntervalHours > 0 {
+		log.Printf("  vacuum interval: %d hours", d.cfg.VacuumIntervalHours)
+	}
+	if d.cfg.SyncedRetentionDays > 0 {
+		log.Printf("  synced retention: %d days", d.cfg.SyncedRetentionDays)
+	}
+	if d.cfg.ArchiveDBPath != "" {
+		log.Printf("  archive: %s", d.cfg.ArchiveDBPath)
+	}
+	if d.cfg.InsecureSkipVerify {
+		log.Printf("WARNING: insecure_skip_verify is enabled — sync will NOT verify the server's TLS certificate. Never use this in production.")
+	}
 
 	if err := d.socket.Start(); err != nil {
 		return err
 	}
+	if d.webhook != nil {
+		go d.webhook.Start()
+	}
+	if d.tap != nil {
+		go d.tap.Start()
+	}
+	if d.cfg.VacuumIntervalHours > 0 {
+		go d.vacuumLoop(time.Duration(d.cfg.VacuumIntervalHours) * time.Hour)
+	}
+	if d.cfg.SyncedRetentionDays > 0 {
+		go d.pruneLoop(d.cfg.SyncedRetentionDays)
+	}
+
+	if !d.cfg.SyncEnabled {
+		<-d.done
+		return nil
+	}
 
 	// Run syncer (blocks until stopped)
 	d.syncer.Start()
@@ -51,11 +308,122 @@ func (d *Daemon) Run() error {
 	return nil
 }
 
-func (d *Daemon) Stop() {
-	log.Println("stopping daemon...")
-	d.syncer.Stop()
-	d.socket.Stop()
-	if err := d.db.Close(); err != nil {
-		log.Printf("close database: %v", err)
+// vacuumLoop compacts the database every interval, and right after a
+// prune that deleted rows, until Stop.
+func (d *Daemon) vacuumLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.vacuum()
+		case <-d.pruned:
+			d.vacuum()
+			ticker.Reset(interval)
+		}
+	}
+}
+
+func (d *Daemon) vacuum() {
+	before := fileSize(d.cfg.DBPath)
+	start := time.Now()
+	if err := d.db.Vacuum(); err != nil {
+		log.Printf("vacuum: %v", err)
+		return
 	}
+	log.Printf("vacuum: %s %d -> %d bytes in %s", d.cfg.DBPath, before, fileSize(d.cfg.DBPath), time.Since(start).Round(time.Millisecond))
+}
+
+// pruneLoop deletes synced activities older than days at startup and then
+// daily until Stop, moving them to the archive database when one is set.
+// When that deletes anything and vacuuming is enabled, it has vacuumLoop
+// reclaim the space right away.
+func (d *Daemon) pruneLoop(days int) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		cutoff := time.Now().AddDate(0, 0, -days)
+		var n int64
+		var err error
+		if archive := d.cfg.ArchiveDBPath; archive != "" {
+			n, err = d.db.ArchiveSynced(cutoff, archive)
+			if err != nil {
+				log.Printf("archive synced activities: %v", err)
+			} else if n > 0 {
+				log.Printf("archived %d synced activities older than %d days to %s", n, days, archive)
+			}
+		} else {
+			n, err = d.db.PruneSynced(cutoff)
+			if err != nil {
+				log.Printf("prune synced activities: %v", err)
+			} else if n > 0 {
+				log.Printf("pruned %d synced activities older than %d days", n, days)
+			}
+		}
+		if n > 0 && d.cfg.VacuumIntervalHours > 0 {
+			select {
+			case d.pruned <- struct{}{}:
+			default:
+			}
+		}
+
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prepareArchive creates the archive database if needed and migrates it
+// to the current schema so ArchiveSynced can copy rows into it.
+func prepareArchive(path string, opts db.Options) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create archive directory: %w", err)
+	}
+	archive, err := db.OpenWithOptions(path, opts)
+	if err != nil {
+		return fmt.Errorf("open archive: %w", err)
+	}
+	return archive.Close()
+}
+
+// fileSize returns the size of path, or -1 if it can't be read.
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
+
+// Oneshot drains the unsynced backlog once and returns, without starting
+// the socket server or the sync ticker. The caller must still call Stop.
+func (d *Daemon) Oneshot() error {
+	if !d.cfg.SyncEnabled {
+		return fmt.Errorf("sync is disabled (sync_enabled = false)")
+	}
+	n, err := d.syncer.Drain(oneshotRetries)
+	log.Printf("oneshot: synced %d activities", n)
+	return err
+}
+
+func (d *Daemon) Stop() {
+	d.stopOnce.Do(func() {
+		log.Println("stopping daemon...")
+		close(d.done)
+		d.syncer.Stop()
+		d.socket.Stop()
+		if d.webhook != nil {
+			d.webhook.Stop()
+		}
+		if d.tap != nil {
+			d.tap.Stop()
+		}
+		if err := d.db.Close(); err != nil {
+			log.Printf("close database: %v", err)
+		}
+	})
 }
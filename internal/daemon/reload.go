@@ -0,0 +1,77 @@
+package daemon
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/taigrr/blastd/internal/config"
+	"github.com/taigrr/blastd/internal/socket"
+)
+
+// reloadable lists the config keys Reload applies to the running daemon.
+// Any other changed key is reported as needing a restart.
+var reloadable = map[string]bool{
+	"allow_machine_override": true,
+	"activity_rate_limit":    true,
+	"activity_rate_burst":    true,
+	"drop_zero_duration":     true,
+	"max_unsynced_rows":      true,
+	"unsynced_overflow":      true,
+}
+
+// SetConfigLoader sets how Reload re-reads the config: normally the same
+// function that loaded it at startup, so command-line overrides still
+// apply.
+func (d *Daemon) SetConfigLoader(load func() (*config.Config, error)) {
+	d.load = load
+}
+
+// Reload re-reads the config and applies the keys in reloadable, returning
+// the ones that changed. Other changed keys are left as they were and
+// reported in a *config.RestartRequiredError. It backs both SIGHUP and the
+// socket's "reload" request.
+func (d *Daemon) Reload() ([]string, error) {
+	if d.load == nil {
+		return nil, fmt.Errorf("config reload not configured")
+	}
+	d.reloadMu.Lock()
+	defer d.reloadMu.Unlock()
+
+	next, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied, restart []string
+	for _, key := range config.Changed(d.applied, next) {
+		if reloadable[key] {
+			applied = append(applied, key)
+		} else {
+			restart = append(restart, key)
+		}
+	}
+
+	if len(applied) > 0 {
+		cfg := *d.applied
+		cfg.AllowMachineOverride = next.AllowMachineOverride
+		cfg.ActivityRateLimit = next.ActivityRateLimit
+		cfg.ActivityRateBurst = next.ActivityRateBurst
+		cfg.DropZeroDuration = next.DropZeroDuration
+		cfg.MaxUnsyncedRows = next.MaxUnsyncedRows
+		cfg.UnsyncedOverflow = next.UnsyncedOverflow
+
+		d.socket.SetAllowMachineOverride(cfg.AllowMachineOverride)
+		d.socket.SetInsertRateLimit(float64(cfg.ActivityRateLimit), cfg.ActivityRateBurst)
+		d.socket.SetDropZeroDuration(cfg.DropZeroDuration)
+		d.socket.SetUnsyncedCap(int64(cfg.MaxUnsyncedRows), socket.OverflowPolicy(cfg.UnsyncedOverflow))
+		d.socket.SetConfig(&cfg)
+		d.applied = &cfg
+		log.Printf("reloaded config: %s", strings.Join(applied, ", "))
+	}
+
+	if len(restart) > 0 {
+		return applied, &config.RestartRequiredError{Keys: restart}
+	}
+	return applied, nil
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ArchiveSynced moves the activities PruneSynced would delete into the
+// archive database at archivePath: in one transaction they are copied
+// there and deleted here. It returns how many moved. The archive must
+// already have the current schema, e.g. from opening it once with
+// OpenWithOptions. Archived rows get new ids; client_id still identifies
+// them. Encrypted fields are copied sealed, so reading the archive needs
+// the same key.
+func (db *DB) ArchiveSynced(cutoff time.Time, archivePath string) (n int64, err error) {
+	ctx := context.Background()
+	// ATTACH is per connection, so everything runs on one.
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS archive`, archivePath); err != nil {
+		return 0, fmt.Errorf("attach archive: %w", err)
+	}
+	defer func() {
+		if _, detachErr := conn.ExecContext(ctx, `DETACH DATABASE archive`); detachErr != nil && err == nil {
+			err = fmt.Errorf("detach archive: %w", detachErr)
+		}
+	}()
+
+	columns, err := archiveColumns(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	const where = `WHERE synced = TRUE AND deleted_at IS NULL AND started_at < ?`
+	list := strings.Join(columns, ", ")
+	copied, err := tx.ExecContext(ctx, `INSERT INTO archive.activities (`+list+`) SELECT `+list+` FROM main.activities `+where, cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("copy to archive: %w", err)
+	}
+	deleted, err := tx.ExecContext(ctx, `DELETE FROM main.activities `+where, cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("delete archived: %w", err)
+	}
+
+	nCopied, err := copied.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n, err = deleted.RowsAffected(); err != nil {
+		return 0, err
+	}
+	if n != nCopied {
+		return 0, fmt.Errorf("copied %d activities to the archive but matched %d to delete", nCopied, n)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// archiveColumns lists the activity columns to copy: every column but id.
+// It fails if the archive lacks one, i.e. its schema is older.
+func archiveColumns(ctx context.Context, conn *sql.Conn) ([]string, error) {
+	main, err := tableColumns(ctx, conn, "main")
+	if err != nil {
+		return nil, err
+	}
+	archive, err := tableColumns(ctx, conn, "archive")
+	if err != nil {
+		return nil, err
+	}
+	if len(archive) == 0 {
+		return nil, fmt.Errorf("archive has no activities table; open it with OpenWithOptions first")
+	}
+
+	var columns []string
+	for _, c := range main {
+		if c == "id" {
+			continue
+		}
+		if !slices.Contains(archive, c) {
+			return nil, fmt.Errorf("archive is missing column %s; open it with OpenWithOptions to migrate it", c)
+		}
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+func tableColumns(ctx context.Context, conn *sql.Conn, schema string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT name FROM pragma_table_info('activities', ?)`, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveSynced(t *testing.T) {
+	database := setupTestDB(t)
+	archivePath := filepath.Join(t.TempDir(), "archive.db")
+	archive, err := Open(archivePath)
+	if err != nil {
+		t.Fatalf("Open(archive) error: %v", err)
+	}
+	t.Cleanup(func() { archive.Close() })
+
+	now := time.Now().UTC()
+	old := now.AddDate(0, 0, -60)
+	var ids []int64
+	for i, start := range []time.Time{old, old.Add(time.Hour), old.Add(2 * time.Hour), now} {
+		a := &Activity{Project: "blast", Filetype: "go", StartedAt: start, EndedAt: start.Add(time.Minute), LinesAdded: i + 1, Tags: []string{"work"}}
+		if err := database.InsertActivity(a); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, a.ID)
+	}
+	// As in TestPruneSynced: only ids[0] is old, synced and not a tombstone.
+	if err := database.MarkSynced([]int64{ids[0], ids[2], ids[3]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := database.DeleteActivities([]int64{ids[2]}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := database.ArchiveSynced(now.AddDate(0, 0, -30), archivePath)
+	if err != nil {
+		t.Fatalf("ArchiveSynced() error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("ArchiveSynced() = %d, want 1", n)
+	}
+
+	stats, err := database.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 2 || stats.Unsynced != 1 {
+		t.Errorf("primary stats = %+v, want the old unsynced and the recent synced activity", stats)
+	}
+
+	archived, err := archive.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archived.Total != 1 || archived.Unsynced != 0 {
+		t.Errorf("archive stats = %+v, want one synced activity", archived)
+	}
+	days, err := archive.StatsByDay(time.Time{}, time.Time{}, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 1 || days[0].LinesAdded != 1 || days[0].Seconds != 60 {
+		t.Errorf("archived activity = %+v, want the first one's lines and duration", days)
+	}
+
+	// Nothing left to move; a second run is a no-op.
+	if n, err := database.ArchiveSynced(now.AddDate(0, 0, -30), archivePath); err != nil || n != 0 {
+		t.Errorf("second ArchiveSynced() = %d, %v; want 0, nil", n, err)
+	}
+}
+
+func TestArchiveSyncedNeedsSchema(t *testing.T) {
+	database := setupTestDB(t)
+	a := &Activity{Project: "blast", StartedAt: time.Now().AddDate(0, 0, -60), EndedAt: time.Now().AddDate(0, 0, -60)}
+	if err := database.InsertActivity(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.MarkSynced([]int64{a.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := database.ArchiveSynced(time.Now(), filepath.Join(t.TempDir(), "empty.db"))
+	if err == nil || !strings.Contains(err.Error(), "no activities table") {
+		t.Fatalf("ArchiveSynced() into an empty file error = %v, want a missing schema error", err)
+	}
+	if stats, err := database.GetStats(); err != nil || stats.Total != 1 {
+		t.Errorf("primary stats = %+v, %v; want the activity kept", stats, err)
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pressly/goose/v3"
+)
+
+// Go migrations are registered by name; goose orders them with the embedded
+// SQL migrations by version.
+func init() {
+	goose.AddNamedMigrationContext("20250215000007_backfill_duration_seconds.go", backfillDurationSeconds, nil)
+	goose.AddNamedMigrationContext("20250215000009_normalize_timestamps_utc.go", normalizeTimestampsUTC, nil)
+}
+
+// backfillDurationSeconds fills duration_seconds for rows written before the
+// column existed. The timestamps are parsed by the driver, which understands
+// the stored Go time format (including zone offsets).
+func backfillDurationSeconds(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, started_at, ended_at FROM activities WHERE duration_seconds IS NULL`)
+	if err != nil {
+		return err
+	}
+
+	durations := make(map[int64]float64)
+	for rows.Next() {
+		var id int64
+		var startedAt, endedAt time.Time
+		if err := rows.Scan(&id, &startedAt, &endedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		durations[id] = durationSeconds(startedAt, endedAt)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `UPDATE activities SET duration_seconds = ? WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for id, d := range durations {
+		if _, err := stmt.ExecContext(ctx, d, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeTimestampsUTC rewrites started_at/ended_at stored with a local
+// offset as UTC, so that text comparisons on started_at order correctly.
+func normalizeTimestampsUTC(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, started_at, ended_at FROM activities`)
+	if err != nil {
+		return err
+	}
+
+	type span struct{ startedAt, endedAt time.Time }
+	local := make(map[int64]span)
+	for rows.Next() {
+		var id int64
+		var startedAt, endedAt time.Time
+		if err := rows.Scan(&id, &startedAt, &endedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		if startedAt.Location() != time.UTC || endedAt.Location() != time.UTC {
+			local[id] = span{startedAt.UTC(), endedAt.UTC()}
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `UPDATE activities SET started_at = ?, ended_at = ? WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for id, s := range local {
+		if _, err := stmt.ExecContext(ctx, s.startedAt, s.endedAt, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// durationSeconds returns ended - started in seconds, clamped at zero for
+// activities whose clocks ran backwards.
+func durationSeconds(startedAt, endedAt time.Time) float64 {
+	if d := endedAt.Sub(startedAt); d > 0 {
+		return d.Seconds()
+	}
+	return 0
+}
@@ -0,0 +1,192 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// InsertActivities inserts activities in a single transaction: either all
+// of them are stored or none are.
+func (db *DB) InsertActivities(activities []*Activity) (err error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && rollbackErr != sql.ErrTxDone {
+			err = rollbackErr
+		}
+	}()
+
+	for _, a := range activities {
+		if err := db.insertActivity(tx, a); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Buffer holds activities in memory and writes them with InsertActivities
+// once count are pending or interval has passed since the first of them
+// arrived, trading a short window in which a crash loses activities for
+// one transaction per batch instead of one per activity. Once closed it
+// writes each activity straight through.
+//
+// Its activities were already acknowledged, so a failed write doesn't
+// drop the batch: on a transient error (a busy database, a full or
+// read-only disk) the activities stay queued for the next flush, and on
+// any other error they are written one by one so only the rows the
+// database rejects are lost.
+type Buffer struct {
+	db       *DB
+	interval time.Duration
+	count    int
+	// flushed is called after every write with the activities it stored
+	// and the write's error, if any.
+	flushed func([]*Activity, error)
+
+	mu      sync.Mutex
+	pending []*Activity
+	timer   *time.Timer
+	closed  bool
+
+	// writeMu serializes writes, so Flush doesn't return while an earlier
+	// flush is still writing activities added before it.
+	writeMu sync.Mutex
+}
+
+func NewBuffer(db *DB, interval time.Duration, count int, flushed func([]*Activity, error)) *Buffer {
+	return &Buffer{
+		db:       db,
+		interval: interval,
+		count:    max(count, 1),
+		flushed:  flushed,
+	}
+}
+
+// Add queues a. When that fills the buffer, the caller writes the batch.
+func (b *Buffer) Add(a *Activity) {
+	b.mu.Lock()
+	b.pending = append(b.pending, a)
+	full := b.closed || len(b.pending) >= b.count
+	if !full && b.timer == nil {
+		b.timer = time.AfterFunc(b.interval, func() { _ = b.Flush() })
+	}
+	b.mu.Unlock()
+
+	if full {
+		_ = b.Flush()
+	}
+}
+
+// Pending returns how many activities are waiting to be written,
+// including any kept after a failed flush.
+func (b *Buffer) Pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending)
+}
+
+// Flush writes everything added so far and returns the write's error.
+// Activities a transient error kept from being written stay pending.
+func (b *Buffer) Flush() error {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	stored, err := b.write(batch)
+	if b.flushed != nil {
+		b.flushed(stored, err)
+	}
+	return err
+}
+
+// write stores batch in one transaction and returns the activities that
+// were stored. If that fails for a reason other than a transient one, it
+// retries the activities one at a time and drops those that still fail.
+func (b *Buffer) write(batch []*Activity) ([]*Activity, error) {
+	err := b.db.InsertActivities(batch)
+	if err == nil {
+		return batch, nil
+	}
+	if isTransient(err) {
+		b.requeue(batch)
+		return nil, fmt.Errorf("kept %d activities for the next flush: %w", len(batch), err)
+	}
+
+	var stored []*Activity
+	var dropped int
+	var dropErr error
+	for i, a := range batch {
+		err := b.db.InsertActivity(a)
+		switch {
+		case err == nil:
+			stored = append(stored, a)
+		case isTransient(err):
+			b.requeue(batch[i:])
+			return stored, fmt.Errorf("kept %d activities for the next flush: %w", len(batch)-i, err)
+		default:
+			dropped++
+			dropErr = errors.Join(dropErr, err)
+		}
+	}
+	if dropped > 0 {
+		return stored, fmt.Errorf("dropped %d activities the database rejected: %w", dropped, dropErr)
+	}
+	return stored, nil
+}
+
+// requeue puts activities back at the front of the buffer and arms the
+// flush timer, so they are retried before anything added since.
+func (b *Buffer) requeue(activities []*Activity) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(slices.Clip(activities), b.pending...)
+	if !b.closed && b.timer == nil {
+		b.timer = time.AfterFunc(b.interval, func() { _ = b.Flush() })
+	}
+}
+
+// isTransient reports whether err may go away on its own: the database
+// is busy or locked, or the disk is full, read-only or failing.
+func isTransient(err error) bool {
+	if IsStorageError(err) {
+		return true
+	}
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	switch sqliteErr.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return true
+	}
+	return false
+}
+
+// Close flushes the buffer and makes later Adds write immediately, so
+// nothing is left in memory once the database is about to close.
+func (b *Buffer) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	return b.Flush()
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBufferFlushesOnCount(t *testing.T) {
+	database := setupTestDB(t)
+	var batches []int
+	buf := NewBuffer(database, time.Hour, 3, func(batch []*Activity, err error) {
+		if err != nil {
+			t.Errorf("flush error: %v", err)
+		}
+		batches = append(batches, len(batch))
+	})
+
+	now := time.Now().UTC()
+	for i := range 4 {
+		buf.Add(&Activity{Project: "blast", StartedAt: now.Add(time.Duration(i) * time.Minute), EndedAt: now.Add(time.Duration(i+1) * time.Minute)})
+	}
+	if stats, err := database.GetStats(); err != nil || stats.Total != 3 {
+		t.Fatalf("stored after 4 adds = %+v, %v; want 3", stats, err)
+	}
+	if buf.Pending() != 1 {
+		t.Errorf("Pending() = %d, want 1", buf.Pending())
+	}
+
+	if err := buf.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	buf.Add(&Activity{Project: "blast", StartedAt: now, EndedAt: now.Add(time.Minute)})
+	if stats, err := database.GetStats(); err != nil || stats.Total != 5 {
+		t.Errorf("stored after Close = %+v, %v; want all 5", stats, err)
+	}
+	if want := []int{3, 1, 1}; !slices.Equal(batches, want) {
+		t.Errorf("batches = %v, want %v", batches, want)
+	}
+}
+
+func TestBufferFlushesOnInterval(t *testing.T) {
+	database := setupTestDB(t)
+	done := make(chan []*Activity, 1)
+	buf := NewBuffer(database, 20*time.Millisecond, 100, func(batch []*Activity, err error) {
+		if err != nil {
+			t.Errorf("flush error: %v", err)
+		}
+		done <- batch
+	})
+
+	now := time.Now().UTC()
+	a := &Activity{Project: "blast", StartedAt: now, EndedAt: now.Add(time.Minute)}
+	buf.Add(a)
+	select {
+	case batch := <-done:
+		if len(batch) != 1 || batch[0].ID == 0 {
+			t.Errorf("flushed %+v, want the one activity with its ID set", batch)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("buffer never flushed")
+	}
+}
+
+func TestBufferKeepsBatchOnTransientError(t *testing.T) {
+	database := setupTestDB(t)
+	// One connection, so query_only applies to every write.
+	database.conn.SetMaxOpenConns(1)
+	var stored []int
+	buf := NewBuffer(database, time.Hour, 100, func(batch []*Activity, err error) {
+		stored = append(stored, len(batch))
+	})
+
+	now := time.Now().UTC()
+	for i := range 3 {
+		buf.Add(&Activity{Project: "blast", StartedAt: now.Add(time.Duration(i) * time.Minute), EndedAt: now.Add(time.Duration(i+1) * time.Minute)})
+	}
+	if _, err := database.conn.Exec("PRAGMA query_only = ON"); err != nil {
+		t.Fatal(err)
+	}
+	if err := buf.Flush(); err == nil {
+		t.Fatal("Flush() on a read-only database succeeded")
+	}
+	if buf.Pending() != 3 {
+		t.Fatalf("Pending() after a failed flush = %d, want 3", buf.Pending())
+	}
+
+	if _, err := database.conn.Exec("PRAGMA query_only = OFF"); err != nil {
+		t.Fatal(err)
+	}
+	if err := buf.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+	if stats, err := database.GetStats(); err != nil || stats.Total != 3 {
+		t.Errorf("stored after retry = %+v, %v; want 3", stats, err)
+	}
+	if want := []int{0, 3}; !slices.Equal(stored, want) {
+		t.Errorf("stored per flush = %v, want %v", stored, want)
+	}
+}
+
+func TestBufferDropsOnlyRejectedRows(t *testing.T) {
+	database := setupTestDB(t)
+	if _, err := database.conn.Exec(`
+		CREATE TRIGGER reject_bad BEFORE INSERT ON activities WHEN NEW.project = 'bad'
+		BEGIN SELECT RAISE(ABORT, 'bad row'); END
+	`); err != nil {
+		t.Fatal(err)
+	}
+	var stored []*Activity
+	buf := NewBuffer(database, time.Hour, 100, func(batch []*Activity, err error) {
+		stored = append(stored, batch...)
+	})
+
+	now := time.Now().UTC()
+	for i, project := range []string{"a", "bad", "c"} {
+		buf.Add(&Activity{Project: project, StartedAt: now.Add(time.Duration(i) * time.Minute), EndedAt: now.Add(time.Duration(i+1) * time.Minute)})
+	}
+	if err := buf.Flush(); err == nil || !strings.Contains(err.Error(), "dropped 1 activities") {
+		t.Errorf("Flush() error = %v, want one dropped activity", err)
+	}
+	if buf.Pending() != 0 {
+		t.Errorf("Pending() = %d, want 0", buf.Pending())
+	}
+	if len(stored) != 2 || stored[0].Project != "a" || stored[1].Project != "c" {
+		t.Errorf("stored %d activities, want a and c", len(stored))
+	}
+	if stats, err := database.GetStats(); err != nil || stats.Total != 2 {
+		t.Errorf("stats = %+v, %v; want 2 stored", stats, err)
+	}
+}
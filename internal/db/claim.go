@@ -0,0 +1,74 @@
+package db
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// ClaimUnsynced atomically claims up to limit unsynced activities for
+// upload and returns them in the given order. Activities claimed by another
+// syncer are skipped until that claim is released or older than lease, so
+// a crashed syncer can't strand rows.
+func (db *DB) ClaimUnsynced(limit int, order SyncOrder, lease time.Duration) (activities []*Activity, err error) {
+	direction := "ASC"
+	if order == NewestFirst {
+		direction = "DESC"
+	}
+
+	now := time.Now()
+	// A single UPDATE ... RETURNING is atomic, so two syncers can never
+	// claim the same row.
+	rows, err := db.conn.Query(`
+		UPDATE activities SET syncing_at = ?
+		WHERE id IN (
+			SELECT id FROM activities
+			WHERE synced = FALSE AND (syncing_at IS NULL OR syncing_at <= ?)
+			ORDER BY started_at `+direction+`
+			LIMIT ?
+		)
+		RETURNING `+activityColumns, now.Unix(), now.Add(-lease).Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	for rows.Next() {
+		a, err := db.scanActivity(rows)
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING doesn't preserve the subquery's order.
+	slices.SortFunc(activities, func(a, b *Activity) int {
+		if order == NewestFirst {
+			return b.StartedAt.Compare(a.StartedAt)
+		}
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+	return activities, nil
+}
+
+// ReleaseClaims returns claimed activities that weren't synced to the
+// backlog, e.g. after a failed upload.
+func (db *DB) ReleaseClaims(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.Repeat("?, ", len(ids)-1) + "?"
+	_, err := db.conn.Exec(`UPDATE activities SET syncing_at = NULL WHERE id IN (`+placeholders+`)`, args...)
+	return err
+}
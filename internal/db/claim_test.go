@@ -0,0 +1,108 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClaimUnsynced(t *testing.T) {
+	database := setupTestDB(t)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 5 {
+		start := base.Add(time.Duration(i) * time.Minute)
+		if err := database.InsertActivity(&Activity{Project: "p", StartedAt: start, EndedAt: start.Add(time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, err := database.ClaimUnsynced(3, OldestFirst, time.Hour)
+	if err != nil {
+		t.Fatalf("ClaimUnsynced() error: %v", err)
+	}
+	if len(first) != 3 || !first[0].StartedAt.Equal(base) || !first[2].StartedAt.After(first[1].StartedAt) {
+		t.Fatalf("first claim = %d activities, want the 3 oldest in order", len(first))
+	}
+
+	// A second syncer only gets what the first didn't claim.
+	second, err := database.ClaimUnsynced(10, OldestFirst, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != 2 {
+		t.Fatalf("second claim got %d activities, want 2", len(second))
+	}
+
+	// Synced rows stay out; released ones come back.
+	if err := database.MarkSynced([]int64{first[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.ReleaseClaims([]int64{first[0].ID, first[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+	again, err := database.ClaimUnsynced(10, OldestFirst, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 1 || again[0].ID != first[1].ID {
+		t.Fatalf("claim after release = %+v, want only activity %d", again, first[1].ID)
+	}
+
+	// Claims older than the lease are treated as abandoned.
+	stale, err := database.ClaimUnsynced(10, NewestFirst, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 4 || !stale[0].StartedAt.After(stale[3].StartedAt) {
+		t.Errorf("claim with expired lease got %d activities, want 4 newest first", len(stale))
+	}
+}
+
+// BenchmarkDrainBacklog claims and marks synced a 100k-row backlog, queued
+// behind 100k synced rows, in passes of 1000 the way a sync drain does.
+func BenchmarkDrainBacklog(b *testing.B) {
+	const backlog, pass = 100_000, 1000
+	database, err := Open(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer database.Close()
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	activities := make([]*Activity, 2*backlog)
+	for i := range activities {
+		start := base.Add(time.Duration(i) * time.Minute)
+		activities[i] = &Activity{Project: "p", StartedAt: start, EndedAt: start.Add(time.Minute)}
+	}
+	if err := database.InsertActivities(activities); err != nil {
+		b.Fatal(err)
+	}
+	lastSynced := activities[backlog-1].ID
+
+	b.ResetTimer()
+	for range b.N {
+		b.StopTimer()
+		if _, err := database.conn.Exec(`UPDATE activities SET synced = (id <= ?), syncing_at = NULL`, lastSynced); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+
+		for {
+			batch, err := database.ClaimUnsynced(pass, OldestFirst, time.Hour)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(batch) == 0 {
+				break
+			}
+			ids := make([]int64, len(batch))
+			for i, a := range batch {
+				ids[i] = a.ID
+			}
+			if err := database.MarkSynced(ids); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Coalesce merges runs of consecutive unsynced activities that share a
+// project, remote, filetype, branch, editor, source, editor instance,
+// machine, tags and metadata and are at most gap apart into one row per
+// run. The merged row spans the whole run, sums line, keystroke and edit
+// counts and durations (gaps don't count as time spent), keeps the latest
+// commit, and averages the per-minute rates by duration. Filenames that
+// differ within a run are dropped. Synced and claimed rows are never
+// touched, so nothing already sent, or being sent, changes. One between
+// two unsynced rows ends the run, so a merged row never spans time the
+// server already has. It returns how many rows were merged away.
+func (db *DB) Coalesce(gap time.Duration) (merged int, err error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && rollbackErr != sql.ErrTxDone {
+			err = rollbackErr
+		}
+	}()
+
+	rows, err := tx.Query(`SELECT ` + activityColumns + `
+		FROM activities
+		WHERE synced = FALSE AND syncing_at IS NULL
+		ORDER BY started_at ASC, id ASC`)
+	if err != nil {
+		return 0, err
+	}
+	var activities []*Activity
+	for rows.Next() {
+		a, err := db.scanActivity(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		activities = append(activities, a)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	breaks, err := runBreaks(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		run     *Activity
+		dropped []int64
+		changed bool
+	)
+	flush := func() error {
+		if run == nil || !changed {
+			return nil
+		}
+		filename, err := db.seal(run.Filename)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE activities SET started_at = ?, ended_at = ?, duration_seconds = ?, filename = ?,
+				lines_added = ?, lines_removed = ?, git_commit = ?, actions_per_minute = ?, words_per_minute = ?,
+				keystrokes = ?, edits = ?
+			WHERE id = ?`,
+			run.StartedAt, run.EndedAt, run.DurationSeconds, filename,
+			run.LinesAdded, run.LinesRemoved, run.GitCommit, run.ActionsPerMinute, run.WordsPerMinute,
+			run.Keystrokes, run.Edits, run.ID)
+		return err
+	}
+	for _, a := range activities {
+		if run != nil && !breaks[a.ID] && canCoalesce(run, a, gap) {
+			mergeInto(run, a)
+			dropped = append(dropped, a.ID)
+			changed = true
+			continue
+		}
+		if err := flush(); err != nil {
+			return 0, err
+		}
+		run, changed = a, false
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+
+	if len(dropped) > 0 {
+		args := make([]any, len(dropped))
+		for i, id := range dropped {
+			args[i] = id
+		}
+		placeholders := strings.Repeat("?, ", len(dropped)-1) + "?"
+		if _, err := tx.Exec(`DELETE FROM activities WHERE id IN (`+placeholders+`)`, args...); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(dropped), nil
+}
+
+// runBreaks returns the IDs of the unsynced, unclaimed activities that
+// directly follow a synced or claimed one in started_at order.
+func runBreaks(tx *sql.Tx) (breaks map[int64]bool, err error) {
+	rows, err := tx.Query(`
+		SELECT id, synced = TRUE OR syncing_at IS NOT NULL
+		FROM activities
+		WHERE started_at >= (SELECT MIN(started_at) FROM activities WHERE synced = FALSE AND syncing_at IS NULL)
+		ORDER BY started_at ASC, id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	breaks = make(map[int64]bool)
+	boundary := false
+	for rows.Next() {
+		var id int64
+		var sent bool
+		if err := rows.Scan(&id, &sent); err != nil {
+			return nil, err
+		}
+		if sent {
+			boundary = true
+		} else if boundary {
+			breaks[id] = true
+			boundary = false
+		}
+	}
+	return breaks, rows.Err()
+}
+
+// canCoalesce reports whether next continues run: same identifying fields
+// and starting no more than gap after run ends.
+func canCoalesce(run, next *Activity, gap time.Duration) bool {
+	return run.Project == next.Project &&
+		run.GitRemote == next.GitRemote &&
+		run.Filetype == next.Filetype &&
+		run.GitBranch == next.GitBranch &&
+		run.Editor == next.Editor &&
+		run.Source == next.Source &&
+		run.InstanceUUID == next.InstanceUUID &&
+		run.Machine == next.Machine &&
+		slices.Equal(run.Tags, next.Tags) &&
+		bytes.Equal(run.Metadata, next.Metadata) &&
+		next.StartedAt.Sub(run.EndedAt) <= gap
+}
+
+// MergeUnsaved folds next into run, both not yet inserted, if Coalesce
+// would merge them with at most gap between them, and reports whether it
+// did. It lets activities be merged as they arrive rather than after they
+// are stored.
+func MergeUnsaved(run, next *Activity, gap time.Duration) bool {
+	if !canCoalesce(run, next, gap) {
+		return false
+	}
+	// Durations are only derived on insert; mergeInto weighs rates by them.
+	if run.DurationSeconds == 0 {
+		run.DurationSeconds = durationSeconds(run.StartedAt, run.EndedAt)
+	}
+	next.DurationSeconds = durationSeconds(next.StartedAt, next.EndedAt)
+	mergeInto(run, next)
+	return true
+}
+
+// mergeInto folds next into run.
+func mergeInto(run, next *Activity) {
+	total := run.DurationSeconds + next.DurationSeconds
+	if total > 0 {
+		run.ActionsPerMinute = (run.ActionsPerMinute*run.DurationSeconds + next.ActionsPerMinute*next.DurationSeconds) / total
+		run.WordsPerMinute = (run.WordsPerMinute*run.DurationSeconds + next.WordsPerMinute*next.DurationSeconds) / total
+	}
+	run.DurationSeconds = total
+	if next.EndedAt.After(run.EndedAt) {
+		run.EndedAt = next.EndedAt
+	}
+	if run.Filename != next.Filename {
+		run.Filename = ""
+	}
+	run.LinesAdded += next.LinesAdded
+	run.LinesRemoved += next.LinesRemoved
+	run.Keystrokes += next.Keystrokes
+	run.Edits += next.Edits
+	if next.GitCommit != "" {
+		run.GitCommit = next.GitCommit
+	}
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	database := setupTestDB(t)
+	base := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	insert := func(project, filename string, start, end time.Duration, added int) *Activity {
+		t.Helper()
+		a := &Activity{
+			Project:    project,
+			Filename:   filename,
+			Filetype:   "go",
+			GitBranch:  "main",
+			StartedAt:  base.Add(start),
+			EndedAt:    base.Add(end),
+			LinesAdded: added,
+			Keystrokes: added * 10,
+			Edits:      added,
+			Editor:     "neovim",
+		}
+		if err := database.InsertActivity(a); err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+
+	first := insert("blast", "a.go", 0, 5*time.Minute, 1)
+	insert("blast", "b.go", 5*time.Minute+10*time.Second, 10*time.Minute, 2)
+	insert("blast", "c.go", 10*time.Minute+20*time.Second, 12*time.Minute, 3)
+	// Too far after the run to join it.
+	late := insert("blast", "d.go", 20*time.Minute, 21*time.Minute, 4)
+	// A different project interrupts; the run after it stays separate.
+	insert("other", "x.go", 21*time.Minute, 22*time.Minute, 5)
+	insert("blast", "d.go", 22*time.Minute, 23*time.Minute, 6)
+
+	// Already synced rows never join a run.
+	synced := insert("blast", "d.go", 23*time.Minute, 24*time.Minute, 7)
+	if err := database.MarkSynced([]int64{synced.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := database.Coalesce(time.Minute)
+	if err != nil {
+		t.Fatalf("Coalesce() error: %v", err)
+	}
+	if merged != 2 {
+		t.Errorf("Coalesce() merged %d rows, want 2", merged)
+	}
+
+	activities, err := database.GetUnsyncedActivities(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 4 {
+		t.Fatalf("got %d unsynced activities, want 4", len(activities))
+	}
+
+	run := activities[0]
+	if run.ID != first.ID || run.ClientID != first.ClientID {
+		t.Errorf("merged row = %d/%s, want the first row of the run", run.ID, run.ClientID)
+	}
+	if !run.StartedAt.Equal(base) || !run.EndedAt.Equal(base.Add(12*time.Minute)) {
+		t.Errorf("merged span = %v..%v, want 09:00..09:12", run.StartedAt, run.EndedAt)
+	}
+	if run.LinesAdded != 6 || run.Keystrokes != 60 || run.Edits != 6 {
+		t.Errorf("LinesAdded, Keystrokes, Edits = %d, %d, %d, want 6, 60, 6", run.LinesAdded, run.Keystrokes, run.Edits)
+	}
+	if want := (12*time.Minute - 30*time.Second).Seconds(); run.DurationSeconds != want {
+		t.Errorf("DurationSeconds = %v, want %v (gaps excluded)", run.DurationSeconds, want)
+	}
+	if run.Filename != "" {
+		t.Errorf("Filename = %q, want empty for a run across files", run.Filename)
+	}
+	if activities[1].ID != late.ID || activities[1].LinesAdded != 4 {
+		t.Errorf("activity after the gap = %+v, want it unmerged", activities[1])
+	}
+
+	// A second pass finds nothing left to merge.
+	if merged, err := database.Coalesce(time.Minute); err != nil || merged != 0 {
+		t.Errorf("second Coalesce() = %d, %v; want 0", merged, err)
+	}
+}
+
+func TestCoalesceStopsAtSyncedRows(t *testing.T) {
+	database := setupTestDB(t)
+	base := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	var ids []int64
+	for i := range 5 {
+		a := &Activity{Project: "blast", Editor: "neovim", StartedAt: base.Add(time.Duration(i) * time.Minute), EndedAt: base.Add(time.Duration(i+1) * time.Minute)}
+		if err := database.InsertActivity(a); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, a.ID)
+	}
+	// The server already has 09:01-09:02; 09:00 and 09:02 must not be
+	// merged across it. 09:03 is claimed by an upload in flight.
+	if err := database.MarkSynced([]int64{ids[1]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.conn.Exec(`UPDATE activities SET syncing_at = ? WHERE id = ?`, base.Unix(), ids[3]); err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := database.Coalesce(time.Minute)
+	if err != nil {
+		t.Fatalf("Coalesce() error: %v", err)
+	}
+	if merged != 0 {
+		t.Errorf("Coalesce() merged %d rows across synced and claimed ones, want 0", merged)
+	}
+	stats, err := database.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 5 {
+		t.Errorf("total = %d, want 5", stats.Total)
+	}
+}
+
+func TestCoalesceIgnoresZeroDurationRates(t *testing.T) {
+	database := setupTestDB(t)
+	base := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	for _, a := range []*Activity{
+		// An instantaneous event with an implausible rate carries no weight.
+		{Project: "blast", StartedAt: base, EndedAt: base, WordsPerMinute: 500, ActionsPerMinute: 900},
+		{Project: "blast", StartedAt: base, EndedAt: base.Add(5 * time.Minute), WordsPerMinute: 60, ActionsPerMinute: 30},
+	} {
+		if err := database.InsertActivity(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if merged, err := database.Coalesce(time.Minute); err != nil || merged != 1 {
+		t.Fatalf("Coalesce() = %d, %v; want 1", merged, err)
+	}
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 {
+		t.Fatalf("got %d activities, want 1", len(activities))
+	}
+	if a := activities[0]; a.WordsPerMinute != 60 || a.ActionsPerMinute != 30 {
+		t.Errorf("rates = %v wpm, %v apm; want 60, 30", a.WordsPerMinute, a.ActionsPerMinute)
+	}
+}
+
+func TestMergeUnsaved(t *testing.T) {
+	base := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	run := &Activity{Project: "blast", StartedAt: base, EndedAt: base.Add(time.Minute), ActionsPerMinute: 30}
+	next := &Activity{Project: "blast", StartedAt: base.Add(time.Minute), EndedAt: base.Add(4 * time.Minute), ActionsPerMinute: 70}
+
+	if !MergeUnsaved(run, next, time.Second) {
+		t.Fatal("MergeUnsaved() = false for contiguous activities")
+	}
+	if !run.EndedAt.Equal(next.EndedAt) || run.DurationSeconds != 240 {
+		t.Errorf("merged EndedAt, DurationSeconds = %v, %v, want 09:04 and 240", run.EndedAt, run.DurationSeconds)
+	}
+	// Weighted by duration: (30*1 + 70*3) / 4.
+	if run.ActionsPerMinute != 60 {
+		t.Errorf("ActionsPerMinute = %v, want 60", run.ActionsPerMinute)
+	}
+
+	other := &Activity{Project: "other", StartedAt: run.EndedAt, EndedAt: run.EndedAt.Add(time.Minute)}
+	if MergeUnsaved(run, other, time.Second) {
+		t.Error("MergeUnsaved() merged a different project")
+	}
+}
@@ -1,15 +1,29 @@
 package db
 
 import (
+	"crypto/cipher"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/pressly/goose/v3"
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
+// DefaultMigrationBackups is how many pre-migration backups Open keeps.
+const DefaultMigrationBackups = 3
+
+// ErrCorrupt is returned by Open when the database fails its integrity check.
+var ErrCorrupt = errors.New("database is corrupt")
+
 type Activity struct {
 	ID               int64
 	ClientID         string
@@ -17,29 +31,98 @@ type Activity struct {
 	GitRemote        string
 	StartedAt        time.Time
 	EndedAt          time.Time
+	DurationSeconds  float64
 	Filename         string
 	Filetype         string
 	LinesAdded       int
 	LinesRemoved     int
 	GitBranch        string
+	GitCommit        string
+	Tags             []string
+	Metadata         json.RawMessage
 	ActionsPerMinute float64
 	WordsPerMinute   float64
+	Keystrokes       int
+	Edits            int
 	Editor           string
+	Source           string
+	InstanceUUID     string
 	Machine          string
 	Synced           bool
 	CreatedAt        time.Time
 }
 
+// Activity sources: how an activity was captured.
+const (
+	SourceEditor = "editor" // tracked automatically by an editor plugin
+	SourceManual = "manual" // entered by hand with `blastd log`
+	SourceCLI    = "cli"    // submitted by another command-line tool
+)
+
 type DB struct {
 	conn *sql.DB
+	// aead encrypts project, git_remote and filename at rest when an
+	// encryption key is configured.
+	aead cipher.AEAD
 }
 
+// Options tunes how Open sets up the database.
+type Options struct {
+	// Backups is how many pre-migration backups to keep; <= 0 disables
+	// them.
+	Backups int
+	// RelaxedSync runs with PRAGMA synchronous=NORMAL instead of FULL.
+	// The database is in WAL mode, so NORMAL can't corrupt it, but a power
+	// loss or OS crash may roll back the last few commits.
+	RelaxedSync bool
+	// EncryptionKey, when set, encrypts the project, git_remote and
+	// filename columns with AES-256-GCM. See ParseEncryptionKey.
+	EncryptionKey []byte
+	// ReadOnly opens an existing database without writing to it: no
+	// integrity check, backups or migrations. Inserts fail. It is for
+	// reading while the daemon may be stopped or mid-write.
+	ReadOnly bool
+}
+
+// Open opens the database at path, keeping DefaultMigrationBackups backups.
 func Open(path string) (*DB, error) {
-	conn, err := sql.Open("sqlite", path)
+	return OpenWithBackups(path, DefaultMigrationBackups)
+}
+
+// OpenWithBackups opens the database at path, keeping keep pre-migration
+// backups, with default options otherwise.
+func OpenWithBackups(path string, keep int) (*DB, error) {
+	return OpenWithOptions(path, Options{Backups: keep})
+}
+
+// OpenWithOptions opens the database at path and applies pending
+// migrations. Before migrating an existing database it snapshots it to
+// <path>.bak-<timestamp> and prunes all but the newest opts.Backups backups.
+func OpenWithOptions(path string, opts Options) (*DB, error) {
+	var aead cipher.AEAD
+	if opts.EncryptionKey != nil {
+		var err error
+		if aead, err = newAEAD(opts.EncryptionKey); err != nil {
+			return nil, fmt.Errorf("encryption key: %w", err)
+		}
+	}
+
+	if opts.ReadOnly {
+		return openReadOnly(path, aead)
+	}
+
+	conn, err := sql.Open("sqlite", dsn(path, opts.RelaxedSync))
 	if err != nil {
 		return nil, err
 	}
 
+	if err := checkIntegrity(conn); err != nil {
+		if closeErr := conn.Close(); closeErr != nil {
+			return nil, fmt.Errorf("%w (close db: %v)", err, closeErr)
+		}
+		return nil, err
+	}
+
 	goose.SetBaseFS(FS)
 
 	if err := goose.SetDialect("sqlite3"); err != nil {
@@ -49,6 +132,15 @@ func Open(path string) (*DB, error) {
 		return nil, fmt.Errorf("failed to set dialect: %w", err)
 	}
 
+	if opts.Backups > 0 {
+		if err := backupBeforeMigrate(conn, path, opts.Backups); err != nil {
+			if closeErr := conn.Close(); closeErr != nil {
+				return nil, fmt.Errorf("failed to back up database: %w (close db: %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("failed to back up database: %w", err)
+		}
+	}
+
 	if err := goose.Up(conn, "migrations"); err != nil {
 		if closeErr := conn.Close(); closeErr != nil {
 			return nil, fmt.Errorf("failed to apply migrations: %w (close db: %v)", err, closeErr)
@@ -56,7 +148,166 @@ func Open(path string) (*DB, error) {
 		return nil, fmt.Errorf("failed to apply migrations: %w", err)
 	}
 
-	return &DB{conn: conn}, nil
+	return &DB{conn: conn, aead: aead}, nil
+}
+
+// openReadOnly opens the database at path with mode=ro. Unlike a normal
+// open it fails if the file doesn't exist rather than creating it.
+func openReadOnly(path string, aead cipher.AEAD) (*DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	conn, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro&_pragma=busy_timeout(%d)", path, busyTimeout.Milliseconds()))
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Ping(); err != nil {
+		if closeErr := conn.Close(); closeErr != nil {
+			return nil, fmt.Errorf("%w (close db: %v)", err, closeErr)
+		}
+		return nil, err
+	}
+	return &DB{conn: conn, aead: aead}, nil
+}
+
+// busyTimeout is how long a write waits for another connection's write
+// to finish before failing with SQLITE_BUSY.
+const busyTimeout = 5 * time.Second
+
+// dsn adds the connection pragmas every pooled connection needs. The
+// database runs in WAL mode. synchronous=FULL syncs the WAL on every
+// commit; relaxed (NORMAL) only syncs it at checkpoints, which is cheaper
+// but lets a power loss roll back the latest commits. Neither corrupts it.
+func dsn(path string, relaxed bool) string {
+	synchronous := "FULL"
+	if relaxed {
+		synchronous = "NORMAL"
+	}
+	return fmt.Sprintf("%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_pragma=synchronous(%s)", path, busyTimeout.Milliseconds(), synchronous)
+}
+
+// backupBeforeMigrate snapshots an existing database when migrations are
+// pending. A brand-new database (version 0) has no history worth saving.
+func backupBeforeMigrate(conn *sql.DB, path string, keep int) error {
+	current, err := goose.GetDBVersion(conn)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return nil
+	}
+	// CollectMigrations reports "nothing newer than current" as ErrNoMigrationFiles.
+	pending, err := goose.CollectMigrations("migrations", current, goose.MaxVersion)
+	if err != nil && !errors.Is(err, goose.ErrNoMigrationFiles) {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// VACUUM INTO writes a consistent snapshot even if another process has
+	// the database open, unlike a plain file copy.
+	dest := path + ".bak-" + time.Now().Format("20060102T150405")
+	if _, err := conn.Exec("VACUUM INTO ?", dest); err != nil {
+		return err
+	}
+	return pruneBackups(path, keep)
+}
+
+// pruneBackups removes all but the newest keep <path>.bak-* files. The
+// timestamp suffix sorts chronologically.
+func pruneBackups(path string, keep int) error {
+	backups, err := filepath.Glob(path + ".bak-*")
+	if err != nil {
+		return err
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+	sort.Strings(backups)
+	for _, old := range backups[:len(backups)-keep] {
+		if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkIntegrity runs PRAGMA integrity_check and reports failures as
+// ErrCorrupt so callers can tell corruption apart from other open errors.
+func checkIntegrity(conn *sql.DB) (err error) {
+	rows, err := conn.Query("PRAGMA integrity_check")
+	if err != nil {
+		return classifyIntegrityErr(err)
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return classifyIntegrityErr(err)
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return classifyIntegrityErr(err)
+	}
+
+	if len(problems) > 0 {
+		if len(problems) > 3 {
+			problems = append(problems[:3], fmt.Sprintf("and %d more", len(problems)-3))
+		}
+		return fmt.Errorf("%w: %s", ErrCorrupt, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func classifyIntegrityErr(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() & 0xff {
+		case sqlite3.SQLITE_CORRUPT, sqlite3.SQLITE_NOTADB:
+			return fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+	}
+	return fmt.Errorf("integrity check: %w", err)
+}
+
+// IsStorageError reports whether err means the database can't be written
+// because the disk is full, read-only, or failing, as opposed to a bad
+// query or constraint violation.
+func IsStorageError(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	switch sqliteErr.Code() & 0xff {
+	case sqlite3.SQLITE_FULL, sqlite3.SQLITE_IOERR, sqlite3.SQLITE_READONLY:
+		return true
+	}
+	return false
+}
+
+// Quarantine moves a (corrupt) database file and its WAL/SHM sidecars aside
+// to <path>.corrupt-<timestamp> and returns the new path of the main file.
+func Quarantine(path string) (string, error) {
+	dest := path + ".corrupt-" + time.Now().Format("20060102T150405")
+	if err := os.Rename(path, dest); err != nil {
+		return "", err
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Rename(path+suffix, dest+suffix); err != nil && !os.IsNotExist(err) {
+			return dest, err
+		}
+	}
+	return dest, nil
 }
 
 func (db *DB) Close() error {
@@ -64,20 +315,45 @@ func (db *DB) Close() error {
 }
 
 func (db *DB) InsertActivity(a *Activity) error {
+	return db.insertActivity(db.conn, a)
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func (db *DB) insertActivity(conn execer, a *Activity) error {
 	if a.ClientID == "" {
 		a.ClientID = uuid.NewString()
 	}
 
-	result, err := db.conn.Exec(`
+	tags, err := encodeTags(a.Tags)
+	if err != nil {
+		return err
+	}
+	// Timestamps are stored in UTC so that range filters and day
+	// bucketing don't depend on the offset each client sent.
+	a.StartedAt = a.StartedAt.UTC()
+	a.EndedAt = a.EndedAt.UTC()
+	a.DurationSeconds = durationSeconds(a.StartedAt, a.EndedAt)
+	if a.Source == "" {
+		a.Source = SourceEditor
+	}
+	project, gitRemote, filename, err := db.sealedFields(a)
+	if err != nil {
+		return err
+	}
+
+	result, err := conn.Exec(`
 		INSERT INTO activities (
-			client_id, project, git_remote, started_at, ended_at, filename, filetype,
-			lines_added, lines_removed, git_branch,
-			actions_per_minute, words_per_minute, editor, machine
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			client_id, project, git_remote, started_at, ended_at, duration_seconds, filename, filetype,
+			lines_added, lines_removed, git_branch, git_commit, tags, metadata,
+			actions_per_minute, words_per_minute, keystrokes, edits, editor, source, instance_uuid, machine
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
-		a.ClientID, a.Project, a.GitRemote, a.StartedAt, a.EndedAt, a.Filename, a.Filetype,
-		a.LinesAdded, a.LinesRemoved, a.GitBranch,
-		a.ActionsPerMinute, a.WordsPerMinute, a.Editor, a.Machine,
+		a.ClientID, project, gitRemote, a.StartedAt, a.EndedAt, a.DurationSeconds, filename, a.Filetype,
+		a.LinesAdded, a.LinesRemoved, a.GitBranch, a.GitCommit, tags, encodeMetadata(a.Metadata),
+		a.ActionsPerMinute, a.WordsPerMinute, a.Keystrokes, a.Edits, a.Editor, a.Source, a.InstanceUUID, a.Machine,
 	)
 	if err != nil {
 		return err
@@ -91,19 +367,31 @@ func (db *DB) InsertActivity(a *Activity) error {
 	return nil
 }
 
+// SyncOrder selects which end of the unsynced backlog is returned first.
+type SyncOrder string
+
+const (
+	OldestFirst SyncOrder = "oldest"
+	NewestFirst SyncOrder = "newest"
+)
+
 func (db *DB) GetUnsyncedActivities(limit int) ([]*Activity, error) {
+	return db.GetUnsyncedActivitiesOrdered(limit, OldestFirst)
+}
+
+// GetUnsyncedActivitiesOrdered returns up to limit unsynced activities,
+// ordered by started_at according to order.
+func (db *DB) GetUnsyncedActivitiesOrdered(limit int, order SyncOrder) ([]*Activity, error) {
+	direction := "ASC"
+	if order == NewestFirst {
+		direction = "DESC"
+	}
+
 	rows, err := db.conn.Query(`
-		SELECT id, client_id,
-			   COALESCE(project, ''), COALESCE(git_remote, ''),
-			   started_at, ended_at,
-			   COALESCE(filename, ''), COALESCE(filetype, ''),
-			   COALESCE(lines_added, 0), COALESCE(lines_removed, 0),
-			   COALESCE(git_branch, ''),
-			   COALESCE(actions_per_minute, 0), COALESCE(words_per_minute, 0),
-			   COALESCE(editor, 'neovim'), COALESCE(machine, ''), created_at
+		SELECT `+activityColumns+`
 		FROM activities
 		WHERE synced = FALSE
-		ORDER BY started_at ASC
+		ORDER BY started_at `+direction+`
 		LIMIT ?
 	`, limit)
 	if err != nil {
@@ -117,12 +405,7 @@ func (db *DB) GetUnsyncedActivities(limit int) ([]*Activity, error) {
 
 	var activities []*Activity
 	for rows.Next() {
-		a := &Activity{}
-		err := rows.Scan(
-			&a.ID, &a.ClientID, &a.Project, &a.GitRemote, &a.StartedAt, &a.EndedAt, &a.Filename, &a.Filetype,
-			&a.LinesAdded, &a.LinesRemoved, &a.GitBranch,
-			&a.ActionsPerMinute, &a.WordsPerMinute, &a.Editor, &a.Machine, &a.CreatedAt,
-		)
+		a, err := db.scanActivity(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -131,6 +414,45 @@ func (db *DB) GetUnsyncedActivities(limit int) ([]*Activity, error) {
 	return activities, rows.Err()
 }
 
+// activityColumns is the SELECT list read by scanActivity.
+const activityColumns = `id, client_id,
+			   COALESCE(project, ''), COALESCE(git_remote, ''),
+			   started_at, ended_at, COALESCE(duration_seconds, 0),
+			   COALESCE(filename, ''), COALESCE(filetype, ''),
+			   COALESCE(lines_added, 0), COALESCE(lines_removed, 0),
+			   COALESCE(git_branch, ''), COALESCE(git_commit, ''), COALESCE(tags, ''), COALESCE(metadata, ''),
+			   COALESCE(actions_per_minute, 0), COALESCE(words_per_minute, 0), COALESCE(keystrokes, 0), COALESCE(edits, 0),
+			   COALESCE(editor, 'neovim'), COALESCE(source, 'editor'), COALESCE(instance_uuid, ''), COALESCE(machine, ''), synced, created_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func (db *DB) scanActivity(row rowScanner) (*Activity, error) {
+	a := &Activity{}
+	var tags, metadata string
+	err := row.Scan(
+		&a.ID, &a.ClientID, &a.Project, &a.GitRemote, &a.StartedAt, &a.EndedAt, &a.DurationSeconds, &a.Filename, &a.Filetype,
+		&a.LinesAdded, &a.LinesRemoved, &a.GitBranch, &a.GitCommit, &tags, &metadata,
+		&a.ActionsPerMinute, &a.WordsPerMinute, &a.Keystrokes, &a.Edits, &a.Editor, &a.Source, &a.InstanceUUID, &a.Machine, &a.Synced, &a.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if a.Tags, err = decodeTags(tags); err != nil {
+		return nil, fmt.Errorf("activity %d: decode tags: %w", a.ID, err)
+	}
+	if metadata != "" {
+		a.Metadata = json.RawMessage(metadata)
+	}
+	a.StartedAt = a.StartedAt.UTC()
+	a.EndedAt = a.EndedAt.UTC()
+	if err := db.unsealFields(a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
 // Stats holds aggregate counts from the activities table.
 type Stats struct {
 	Total    int64
@@ -145,6 +467,7 @@ func (db *DB) GetStats() (*Stats, error) {
 			COUNT(*) AS total,
 			COUNT(*) FILTER (WHERE synced = FALSE) AS unsynced
 		FROM activities
+		WHERE deleted_at IS NULL
 	`).Scan(&s.Total, &s.Unsynced)
 	if err != nil {
 		return nil, err
@@ -152,7 +475,162 @@ func (db *DB) GetStats() (*Stats, error) {
 	return &s, nil
 }
 
+// GetStatsByTag returns total and unsynced counts for activities carrying tag.
+func (db *DB) GetStatsByTag(tag string) (*Stats, error) {
+	var s Stats
+	err := db.conn.QueryRow(`
+		SELECT
+			COUNT(*) AS total,
+			COUNT(*) FILTER (WHERE synced = FALSE) AS unsynced
+		FROM activities
+		WHERE deleted_at IS NULL
+			AND EXISTS (SELECT 1 FROM json_each(activities.tags) WHERE json_each.value = ?)
+	`, tag).Scan(&s.Total, &s.Unsynced)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// EditorStats summarizes activity recorded by one editor.
+type EditorStats struct {
+	Activities   int64   `json:"activities"`
+	Seconds      float64 `json:"seconds"`
+	LinesAdded   int64   `json:"lines_added"`
+	LinesRemoved int64   `json:"lines_removed"`
+	Keystrokes   int64   `json:"keystrokes"`
+	Edits        int64   `json:"edits"`
+}
+
+// UnknownEditor labels activities stored without an editor.
+const UnknownEditor = "unknown"
+
+// StatsByEditor summarizes activities that started within [start, end) per
+// editor. A zero start or end leaves that side unbounded.
+func (db *DB) StatsByEditor(start, end time.Time) (map[string]EditorStats, error) {
+	where, args := rangeClause(start, end)
+	rows, err := db.conn.Query(`
+		SELECT COALESCE(NULLIF(editor, ''), ?) AS editor_name,
+			COUNT(*), COALESCE(SUM(duration_seconds), 0),
+			COALESCE(SUM(lines_added), 0), COALESCE(SUM(lines_removed), 0),
+			COALESCE(SUM(keystrokes), 0), COALESCE(SUM(edits), 0)
+		FROM activities
+		WHERE deleted_at IS NULL`+where+`
+		GROUP BY editor_name
+	`, append([]any{UnknownEditor}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]EditorStats)
+	for rows.Next() {
+		var editor string
+		var es EditorStats
+		if err := rows.Scan(&editor, &es.Activities, &es.Seconds, &es.LinesAdded, &es.LinesRemoved, &es.Keystrokes, &es.Edits); err != nil {
+			return nil, err
+		}
+		stats[editor] = es
+	}
+	return stats, rows.Err()
+}
+
+// LineStats totals the lines changed in one filetype.
+type LineStats struct {
+	LinesAdded   int64 `json:"lines_added"`
+	LinesRemoved int64 `json:"lines_removed"`
+}
+
+// UnknownFiletype labels activities stored without a filetype.
+const UnknownFiletype = "unknown"
+
+// LinesByFiletype sums lines added and removed per filetype for activities
+// that started within [start, end). A zero start or end leaves that side
+// unbounded.
+func (db *DB) LinesByFiletype(start, end time.Time) (map[string]LineStats, error) {
+	where, args := rangeClause(start, end)
+	rows, err := db.conn.Query(`
+		SELECT COALESCE(NULLIF(filetype, ''), ?) AS filetype_name,
+			COALESCE(SUM(lines_added), 0), COALESCE(SUM(lines_removed), 0)
+		FROM activities
+		WHERE deleted_at IS NULL`+where+`
+		GROUP BY filetype_name
+	`, append([]any{UnknownFiletype}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]LineStats)
+	for rows.Next() {
+		var filetype string
+		var ls LineStats
+		if err := rows.Scan(&filetype, &ls.LinesAdded, &ls.LinesRemoved); err != nil {
+			return nil, err
+		}
+		stats[filetype] = ls
+	}
+	return stats, rows.Err()
+}
+
+// DayStats totals the activities that started on one calendar day.
+type DayStats struct {
+	Date         string  `json:"date"` // YYYY-MM-DD
+	Activities   int64   `json:"activities"`
+	Seconds      float64 `json:"seconds"`
+	LinesAdded   int64   `json:"lines_added"`
+	LinesRemoved int64   `json:"lines_removed"`
+}
+
+// StatsByDay totals activities that started within [start, end) per
+// calendar day in loc, oldest first. Days without activity are left out.
+// Days are bucketed here rather than in SQL so they follow loc's DST
+// rules. A zero start or end leaves that side unbounded.
+func (db *DB) StatsByDay(start, end time.Time, loc *time.Location) ([]DayStats, error) {
+	where, args := rangeClause(start, end)
+	rows, err := db.conn.Query(`
+		SELECT started_at, duration_seconds, lines_added, lines_removed
+		FROM activities
+		WHERE deleted_at IS NULL`+where+`
+		ORDER BY started_at
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var days []DayStats
+	for rows.Next() {
+		var startedAt time.Time
+		var seconds float64
+		var added, removed int64
+		if err := rows.Scan(&startedAt, &seconds, &added, &removed); err != nil {
+			return nil, err
+		}
+		date := startedAt.In(loc).Format(time.DateOnly)
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, DayStats{Date: date})
+		}
+		day := &days[len(days)-1]
+		day.Activities++
+		day.Seconds += seconds
+		day.LinesAdded += added
+		day.LinesRemoved += removed
+	}
+	return days, rows.Err()
+}
+
 func (db *DB) MarkSynced(ids []int64) error {
+	return db.setSynced(ids, true)
+}
+
+// MarkUnsynced flips activities back to unsynced so the next drain
+// re-uploads them.
+func (db *DB) MarkUnsynced(ids []int64) error {
+	return db.setSynced(ids, false)
+}
+
+func (db *DB) setSynced(ids []int64, synced bool) error {
 	if len(ids) == 0 {
 		return nil
 	}
@@ -167,7 +645,7 @@ func (db *DB) MarkSynced(ids []int64) error {
 		}
 	}()
 
-	stmt, err := tx.Prepare("UPDATE activities SET synced = TRUE WHERE id = ?")
+	stmt, err := tx.Prepare("UPDATE activities SET synced = ?, syncing_at = NULL WHERE id = ? AND deleted_at IS NULL")
 	if err != nil {
 		return err
 	}
@@ -178,10 +656,107 @@ func (db *DB) MarkSynced(ids []int64) error {
 	}()
 
 	for _, id := range ids {
-		if _, err := stmt.Exec(id); err != nil {
+		if _, err := stmt.Exec(synced, id); err != nil {
 			return err
 		}
 	}
 
 	return tx.Commit()
 }
+
+// CountSynced returns how many synced activities started within
+// [start, end). A zero start or end leaves that side unbounded.
+func (db *DB) CountSynced(start, end time.Time) (int64, error) {
+	where, args := rangeClause(start, end)
+	var n int64
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM activities WHERE synced = TRUE AND deleted_at IS NULL`+where, args...).Scan(&n)
+	return n, err
+}
+
+// MarkAllUnsynced flips every synced activity that started within
+// [start, end) back to unsynced and returns how many rows changed.
+func (db *DB) MarkAllUnsynced(start, end time.Time) (int64, error) {
+	where, args := rangeClause(start, end)
+	result, err := db.conn.Exec(`UPDATE activities SET synced = FALSE WHERE synced = TRUE AND deleted_at IS NULL`+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// UnsyncedCount returns how many activities are waiting to be uploaded,
+// including rows a syncer has claimed but not yet marked synced. It is
+// answered from idx_activities_synced, so it is cheap enough to run on every
+// insert.
+func (db *DB) UnsyncedCount() (int64, error) {
+	var n int64
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM activities WHERE synced = FALSE`).Scan(&n)
+	return n, err
+}
+
+// DropOldestUnsynced deletes the oldest unsynced activities (by started_at)
+// so that at most keep remain, and returns how many were deleted.
+func (db *DB) DropOldestUnsynced(keep int64) (int64, error) {
+	n, err := db.UnsyncedCount()
+	if err != nil || n <= keep {
+		return 0, err
+	}
+	result, err := db.conn.Exec(`
+		DELETE FROM activities WHERE id IN (
+			SELECT id FROM activities
+			WHERE synced = FALSE
+			ORDER BY started_at ASC, id ASC
+			LIMIT ?
+		)
+	`, n-keep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// rangeClause builds an " AND ..." filter on started_at for [start, end).
+func rangeClause(start, end time.Time) (string, []any) {
+	var where string
+	var args []any
+	if !start.IsZero() {
+		where += " AND started_at >= ?"
+		args = append(args, start.UTC())
+	}
+	if !end.IsZero() {
+		where += " AND started_at < ?"
+		args = append(args, end.UTC())
+	}
+	return where, args
+}
+
+// encodeTags stores tags as a JSON array, or NULL when there are none.
+func encodeTags(tags []string) (any, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// encodeMetadata stores metadata verbatim, or NULL when there is none.
+func encodeMetadata(metadata json.RawMessage) any {
+	if len(metadata) == 0 || string(metadata) == "null" {
+		return nil
+	}
+	return string(metadata)
+}
+
+func decodeTags(raw string) ([]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var tags []string
+	if err := json.Unmarshal([]byte(raw), &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
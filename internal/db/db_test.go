@@ -1,9 +1,18 @@
 package db
 
 import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/pressly/goose/v3"
 )
 
 func setupTestDB(t *testing.T) *DB {
@@ -28,6 +37,98 @@ func TestOpenAndMigrate(t *testing.T) {
 	}
 }
 
+func TestRelaxedSync(t *testing.T) {
+	for relaxed, want := range map[bool]int{false: 2, true: 1} {
+		database, err := OpenWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{RelaxedSync: relaxed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got int
+		if err := database.conn.QueryRow("PRAGMA synchronous").Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("relaxed=%v: synchronous = %d, want %d", relaxed, got, want)
+		}
+		var mode string
+		if err := database.conn.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+			t.Fatal(err)
+		}
+		if mode != "wal" {
+			t.Errorf("relaxed=%v: journal_mode = %q, want wal", relaxed, mode)
+		}
+		if err := database.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	database, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	now := time.Now()
+	if err := database.InsertActivity(&Activity{StartedAt: now.Add(-time.Minute), EndedAt: now, Editor: "neovim"}); err != nil {
+		t.Fatalf("InsertActivity() error: %v", err)
+	}
+
+	// The writer stays open, as it would in a running daemon.
+	readOnly, err := OpenWithOptions(path, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("OpenWithOptions(ReadOnly) error: %v", err)
+	}
+	stats, err := readOnly.StatsByEditor(time.Time{}, time.Time{})
+	if err != nil || stats["neovim"].Activities != 1 {
+		t.Errorf("StatsByEditor() = %v, %v; want one neovim activity", stats, err)
+	}
+	if err := readOnly.InsertActivity(&Activity{StartedAt: now, EndedAt: now}); err == nil {
+		t.Error("InsertActivity() on a read-only database succeeded")
+	}
+	if err := readOnly.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.db")
+	if _, err := OpenWithOptions(missing, Options{ReadOnly: true}); err == nil {
+		t.Error("read-only open of a missing database succeeded")
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("read-only open created %s", missing)
+	}
+}
+
+func TestIsStorageError(t *testing.T) {
+	database := setupTestDB(t)
+
+	// Cap the file at its current size so inserts soon can't grow it. The
+	// pragma is per connection, so pin the pool to one.
+	database.conn.SetMaxOpenConns(1)
+	var pages int
+	if err := database.conn.QueryRow("PRAGMA page_count").Scan(&pages); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.conn.Exec(fmt.Sprintf("PRAGMA max_page_count = %d", pages)); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	now := time.Now()
+	for i := 0; err == nil && i < 1000; i++ {
+		err = database.InsertActivity(&Activity{Project: strings.Repeat("x", 1000), StartedAt: now, EndedAt: now})
+	}
+	if !IsStorageError(err) {
+		t.Errorf("IsStorageError(%v) = false, want true for a full database", err)
+	}
+	if IsStorageError(errors.New("some other failure")) {
+		t.Error("IsStorageError() = true for a non-sqlite error")
+	}
+}
+
 func TestInsertActivity(t *testing.T) {
 	database := setupTestDB(t)
 
@@ -53,6 +154,34 @@ func TestInsertActivity(t *testing.T) {
 	}
 }
 
+func TestActivitySource(t *testing.T) {
+	database := setupTestDB(t)
+
+	now := time.Now()
+	for i, source := range []string{"", SourceManual} {
+		start := now.Add(time.Duration(i) * time.Minute)
+		if err := database.InsertActivity(&Activity{StartedAt: start, EndedAt: start.Add(time.Minute), Source: source}); err != nil {
+			t.Fatalf("InsertActivity() error: %v", err)
+		}
+	}
+
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatalf("GetUnsyncedActivities() error: %v", err)
+	}
+	if len(activities) != 2 || activities[0].Source != SourceEditor || activities[1].Source != SourceManual {
+		t.Errorf("sources = %v, want [%s %s]", sources(activities), SourceEditor, SourceManual)
+	}
+}
+
+func sources(activities []*Activity) []string {
+	var out []string
+	for _, a := range activities {
+		out = append(out, a.Source)
+	}
+	return out
+}
+
 func TestGetUnsyncedActivities(t *testing.T) {
 	database := setupTestDB(t)
 
@@ -85,6 +214,46 @@ func TestGetUnsyncedActivities(t *testing.T) {
 	}
 }
 
+func TestGetUnsyncedActivitiesNewestFirst(t *testing.T) {
+	database := setupTestDB(t)
+
+	now := time.Now()
+	for i := range 3 {
+		a := &Activity{
+			Project:   "blast",
+			StartedAt: now.Add(time.Duration(i) * time.Minute),
+			EndedAt:   now.Add(time.Duration(i+1) * time.Minute),
+			Editor:    "neovim",
+		}
+		if err := database.InsertActivity(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	activities, err := database.GetUnsyncedActivitiesOrdered(2, NewestFirst)
+	if err != nil {
+		t.Fatalf("GetUnsyncedActivitiesOrdered() error: %v", err)
+	}
+	if len(activities) != 2 {
+		t.Fatalf("got %d activities, want 2", len(activities))
+	}
+	if !activities[0].StartedAt.After(activities[1].StartedAt) {
+		t.Error("activities should be ordered by started_at DESC")
+	}
+
+	ids := []int64{activities[0].ID, activities[1].ID}
+	if err := database.MarkSynced(ids); err != nil {
+		t.Fatal(err)
+	}
+	remaining, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0].StartedAt.After(activities[1].StartedAt) {
+		t.Errorf("expected only the oldest activity to remain unsynced")
+	}
+}
+
 func TestGetUnsyncedActivitiesLimit(t *testing.T) {
 	database := setupTestDB(t)
 
@@ -197,3 +366,587 @@ func TestMarkSyncedEmpty(t *testing.T) {
 		t.Fatalf("MarkSynced(nil) error: %v", err)
 	}
 }
+
+func TestTagsRoundTripAndStats(t *testing.T) {
+	database := setupTestDB(t)
+
+	now := time.Now()
+	for i, tags := range [][]string{{"review"}, {"refactor", "review"}, nil} {
+		a := &Activity{
+			Project:   "blast",
+			StartedAt: now.Add(time.Duration(i) * time.Minute),
+			EndedAt:   now.Add(time.Duration(i+1) * time.Minute),
+			Tags:      tags,
+			Editor:    "neovim",
+		}
+		if err := database.InsertActivity(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 3 {
+		t.Fatalf("got %d activities, want 3", len(activities))
+	}
+	if got := activities[1].Tags; len(got) != 2 || got[0] != "refactor" || got[1] != "review" {
+		t.Errorf("Tags = %v, want [refactor review]", got)
+	}
+	if activities[2].Tags != nil {
+		t.Errorf("Tags = %v, want nil", activities[2].Tags)
+	}
+
+	stats, err := database.GetStatsByTag("review")
+	if err != nil {
+		t.Fatalf("GetStatsByTag() error: %v", err)
+	}
+	if stats.Total != 2 || stats.Unsynced != 2 {
+		t.Errorf("review: total=%d unsynced=%d, want 2/2", stats.Total, stats.Unsynced)
+	}
+
+	stats, err = database.GetStatsByTag("meeting")
+	if err != nil {
+		t.Fatalf("GetStatsByTag() error: %v", err)
+	}
+	if stats.Total != 0 {
+		t.Errorf("meeting: total=%d, want 0", stats.Total)
+	}
+}
+
+func TestMarkUnsynced(t *testing.T) {
+	database := setupTestDB(t)
+
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var ids []int64
+	for i := range 4 {
+		a := &Activity{
+			Project:   "blast",
+			StartedAt: base.Add(time.Duration(i) * 24 * time.Hour),
+			EndedAt:   base.Add(time.Duration(i)*24*time.Hour + time.Minute),
+			Editor:    "neovim",
+		}
+		if err := database.InsertActivity(a); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, a.ID)
+	}
+	if err := database.MarkSynced(ids); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.MarkUnsynced(ids[:1]); err != nil {
+		t.Fatalf("MarkUnsynced() error: %v", err)
+	}
+	stats, err := database.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Unsynced != 1 {
+		t.Errorf("after MarkUnsynced: unsynced=%d, want 1", stats.Unsynced)
+	}
+
+	// Window covers days 1 and 2 only.
+	start, end := base.Add(24*time.Hour), base.Add(3*24*time.Hour)
+	n, err := database.CountSynced(start, end)
+	if err != nil {
+		t.Fatalf("CountSynced() error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("CountSynced(window) = %d, want 2", n)
+	}
+	n, err = database.MarkAllUnsynced(start, end)
+	if err != nil {
+		t.Fatalf("MarkAllUnsynced() error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("MarkAllUnsynced(window) = %d, want 2", n)
+	}
+
+	n, err = database.MarkAllUnsynced(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("MarkAllUnsynced() error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("MarkAllUnsynced(all) = %d, want 1", n)
+	}
+	stats, err = database.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Unsynced != 4 {
+		t.Errorf("unsynced=%d, want 4", stats.Unsynced)
+	}
+}
+
+func TestOpenCorruptAndQuarantine(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "corrupt.db")
+	if err := os.WriteFile(dbPath, bytes.Repeat([]byte("not sqlite "), 512), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Open(dbPath)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Open() error = %v, want ErrCorrupt", err)
+	}
+
+	moved, err := Quarantine(dbPath)
+	if err != nil {
+		t.Fatalf("Quarantine() error: %v", err)
+	}
+	if _, err := os.Stat(moved); err != nil {
+		t.Errorf("quarantined file missing: %v", err)
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Errorf("original path still exists after quarantine")
+	}
+
+	database, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("Open() after quarantine error: %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupBeforeMigrate(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	database, err := OpenWithBackups(dbPath, 2)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	if backups, _ := filepath.Glob(dbPath + ".bak-*"); len(backups) != 0 {
+		t.Errorf("fresh database produced backups: %v", backups)
+	}
+
+	// Roll back the newest migration so the next open has one pending.
+	if err := goose.Down(database.conn, "migrations"); err != nil {
+		t.Fatalf("goose.Down() error: %v", err)
+	}
+	preMigration, err := goose.GetDBVersion(database.conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, old := range []string{".bak-20000101T000000", ".bak-20000102T000000"} {
+		if err := os.WriteFile(dbPath+old, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	database, err = OpenWithBackups(dbPath, 2)
+	if err != nil {
+		t.Fatalf("Open() with pending migration error: %v", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, _ := filepath.Glob(dbPath + ".bak-*")
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups after pruning, got %v", backups)
+	}
+	if backups[0] != dbPath+".bak-20000102T000000" {
+		t.Errorf("oldest backup not pruned: %v", backups)
+	}
+	snapshot, err := sql.Open("sqlite", backups[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snapshot.Close()
+	if version, err := goose.GetDBVersion(snapshot); err != nil || version != preMigration {
+		t.Errorf("backup version = %d, %v; want pre-migration version %d", version, err, preMigration)
+	}
+
+	// Nothing pending: no new backup.
+	database, err = OpenWithBackups(dbPath, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := filepath.Glob(dbPath + ".bak-*"); len(after) != 2 {
+		t.Errorf("expected no new backup without pending migrations, got %v", after)
+	}
+}
+
+func TestStatsByEditor(t *testing.T) {
+	database := setupTestDB(t)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, a := range []*Activity{
+		{Editor: "neovim", StartedAt: base, EndedAt: base.Add(5 * time.Minute), LinesAdded: 10, LinesRemoved: 2, Keystrokes: 300, Edits: 12},
+		{Editor: "neovim", StartedAt: base.Add(time.Hour), EndedAt: base.Add(time.Hour + time.Minute), LinesAdded: 1, Keystrokes: 20, Edits: 1},
+		{Editor: "vscode", StartedAt: base.Add(2 * time.Hour), EndedAt: base.Add(2*time.Hour + 30*time.Second)},
+		{Editor: "", StartedAt: base.Add(3 * time.Hour), EndedAt: base.Add(3*time.Hour + time.Minute)},
+		{Editor: "vscode", StartedAt: base.Add(48 * time.Hour), EndedAt: base.Add(49 * time.Hour)},
+	} {
+		if err := database.InsertActivity(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := database.StatsByEditor(base, base.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("StatsByEditor() error: %v", err)
+	}
+
+	want := map[string]EditorStats{
+		"neovim":      {Activities: 2, Seconds: 360, LinesAdded: 11, LinesRemoved: 2, Keystrokes: 320, Edits: 13},
+		"vscode":      {Activities: 1, Seconds: 30},
+		UnknownEditor: {Activities: 1, Seconds: 60},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("got %d editors, want %d: %+v", len(stats), len(want), stats)
+	}
+	for editor, w := range want {
+		if stats[editor] != w {
+			t.Errorf("%s: got %+v, want %+v", editor, stats[editor], w)
+		}
+	}
+
+	all, err := database.StatsByEditor(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all["vscode"].Activities != 2 {
+		t.Errorf("unbounded vscode activities = %d, want 2", all["vscode"].Activities)
+	}
+}
+
+func TestLinesByFiletype(t *testing.T) {
+	database := setupTestDB(t)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, a := range []*Activity{
+		{Filetype: "go", StartedAt: base, EndedAt: base.Add(time.Minute), LinesAdded: 10, LinesRemoved: 2},
+		{Filetype: "go", StartedAt: base.Add(time.Hour), EndedAt: base.Add(time.Hour + time.Minute), LinesAdded: 5, LinesRemoved: 1},
+		{Filetype: "lua", StartedAt: base.Add(2 * time.Hour), EndedAt: base.Add(2*time.Hour + time.Minute), LinesAdded: 3},
+		{Filetype: "", StartedAt: base.Add(3 * time.Hour), EndedAt: base.Add(3*time.Hour + time.Minute), LinesRemoved: 4},
+		{Filetype: "lua", StartedAt: base.Add(48 * time.Hour), EndedAt: base.Add(49 * time.Hour), LinesAdded: 100},
+	} {
+		if err := database.InsertActivity(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := database.LinesByFiletype(base, base.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("LinesByFiletype() error: %v", err)
+	}
+	want := map[string]LineStats{
+		"go":            {LinesAdded: 15, LinesRemoved: 3},
+		"lua":           {LinesAdded: 3},
+		UnknownFiletype: {LinesRemoved: 4},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("got %d filetypes, want %d: %+v", len(stats), len(want), stats)
+	}
+	for filetype, w := range want {
+		if stats[filetype] != w {
+			t.Errorf("%s: got %+v, want %+v", filetype, stats[filetype], w)
+		}
+	}
+
+	all, err := database.LinesByFiletype(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all["lua"].LinesAdded != 103 {
+		t.Errorf("unbounded lua lines added = %d, want 103", all["lua"].LinesAdded)
+	}
+}
+
+func TestDropOldestUnsynced(t *testing.T) {
+	database := setupTestDB(t)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 5 {
+		a := &Activity{Project: "p", StartedAt: base.Add(time.Duration(i) * time.Minute), EndedAt: base.Add(time.Duration(i+1) * time.Minute)}
+		if err := database.InsertActivity(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A synced row never counts toward or gets dropped by the cap.
+	if err := database.MarkSynced([]int64{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	dropped, err := database.DropOldestUnsynced(2)
+	if err != nil {
+		t.Fatalf("DropOldestUnsynced() error: %v", err)
+	}
+	if dropped != 2 {
+		t.Errorf("dropped %d, want 2", dropped)
+	}
+
+	remaining, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 2 || remaining[0].ID != 4 || remaining[1].ID != 5 {
+		t.Errorf("expected the newest two unsynced rows to remain, got %d rows", len(remaining))
+	}
+	stats, err := database.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 3 {
+		t.Errorf("total = %d, want 3 (synced row kept)", stats.Total)
+	}
+
+	if dropped, err := database.DropOldestUnsynced(10); err != nil || dropped != 0 {
+		t.Errorf("DropOldestUnsynced under cap = %d, %v; want 0, nil", dropped, err)
+	}
+}
+
+func TestUnsyncedCountIncludesClaimed(t *testing.T) {
+	database := setupTestDB(t)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 4 {
+		a := &Activity{Project: "p", StartedAt: base.Add(time.Duration(i) * time.Minute), EndedAt: base.Add(time.Duration(i+1) * time.Minute)}
+		if err := database.InsertActivity(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.MarkSynced([]int64{1}); err != nil {
+		t.Fatal(err)
+	}
+	// Rows claimed by an in-flight upload are still waiting on the server.
+	if _, err := database.ClaimUnsynced(2, OldestFirst, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := database.UnsyncedCount()
+	if err != nil {
+		t.Fatalf("UnsyncedCount() error: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("UnsyncedCount() = %d, want 3", n)
+	}
+
+	dropped, err := database.DropOldestUnsynced(1)
+	if err != nil {
+		t.Fatalf("DropOldestUnsynced() error: %v", err)
+	}
+	if dropped != 2 {
+		t.Errorf("dropped %d, want 2", dropped)
+	}
+}
+
+func TestDurationSeconds(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	database, err := Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Recreate a pre-duration schema holding a row in a non-UTC zone.
+	if err := goose.DownTo(database.conn, "migrations", 20250215000005); err != nil {
+		t.Fatalf("goose.DownTo() error: %v", err)
+	}
+	zone := time.FixedZone("EST", -5*3600)
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, zone)
+	if _, err := database.conn.Exec(
+		`INSERT INTO activities (client_id, project, started_at, ended_at) VALUES (?, ?, ?, ?)`,
+		"legacy", "p", start, start.Add(90*time.Second),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	database, err = OpenWithBackups(dbPath, 0)
+	if err != nil {
+		t.Fatalf("Open() after downgrade error: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := database.Close(); err != nil {
+			t.Fatalf("Close() error: %v", err)
+		}
+	})
+
+	fresh := &Activity{Project: "p", StartedAt: start, EndedAt: start.Add(5 * time.Minute)}
+	if err := database.InsertActivity(fresh); err != nil {
+		t.Fatal(err)
+	}
+	backwards := &Activity{Project: "p", StartedAt: start, EndedAt: start.Add(-time.Minute)}
+	if err := database.InsertActivity(backwards); err != nil {
+		t.Fatal(err)
+	}
+
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[int64]float64)
+	for _, a := range activities {
+		got[a.ID] = a.DurationSeconds
+	}
+	want := map[int64]float64{1: 90, fresh.ID: 300, backwards.ID: 0}
+	for id, w := range want {
+		if got[id] != w {
+			t.Errorf("activity %d: DurationSeconds = %v, want %v", id, got[id], w)
+		}
+	}
+}
+
+func TestTimestampsStoredInUTC(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	database, err := Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A row written before normalization, still carrying its local offset.
+	if err := goose.DownTo(database.conn, "migrations", 20250215000008); err != nil {
+		t.Fatalf("goose.DownTo() error: %v", err)
+	}
+	zone := time.FixedZone("IST", 5*3600+1800)
+	start := time.Date(2024, 1, 1, 1, 0, 0, 0, zone)
+	if _, err := database.conn.Exec(
+		`INSERT INTO activities (client_id, project, started_at, ended_at) VALUES (?, ?, ?, ?)`,
+		"legacy", "p", start, start.Add(time.Minute),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	database, err = OpenWithBackups(dbPath, 0)
+	if err != nil {
+		t.Fatalf("Open() after downgrade error: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := database.Close(); err != nil {
+			t.Fatalf("Close() error: %v", err)
+		}
+	})
+
+	fresh := &Activity{Project: "p", StartedAt: start, EndedAt: start.Add(time.Minute)}
+	if err := database.InsertActivity(fresh); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := database.conn.Query(`SELECT started_at, ended_at FROM activities`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var startedAt, endedAt time.Time
+		if err := rows.Scan(&startedAt, &endedAt); err != nil {
+			t.Fatal(err)
+		}
+		if startedAt.Location() != time.UTC || endedAt.Location() != time.UTC {
+			t.Errorf("stored %v - %v, want UTC", startedAt, endedAt)
+		}
+		if !startedAt.Equal(start) {
+			t.Errorf("started_at = %v, want %v", startedAt, start)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range activities {
+		if a.StartedAt.Location() != time.UTC || a.EndedAt.Location() != time.UTC {
+			t.Errorf("activity %d: got %v - %v, want UTC", a.ID, a.StartedAt, a.EndedAt)
+		}
+	}
+}
+
+func TestMachineStartedIndex(t *testing.T) {
+	database := setupTestDB(t)
+
+	// The shape of a per-machine stats query over a time range.
+	where, args := rangeClause(time.Now().Add(-time.Hour), time.Now())
+	rows, err := database.conn.Query(`
+		EXPLAIN QUERY PLAN
+		SELECT editor, COUNT(*), SUM(duration_seconds)
+		FROM activities
+		WHERE machine = ?`+where+`
+		GROUP BY editor
+	`, append([]any{"laptop"}, args...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			t.Fatal(err)
+		}
+		plan = append(plan, detail)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range plan {
+		if strings.Contains(step, "idx_activities_machine_started") {
+			return
+		}
+	}
+	t.Errorf("query plan doesn't use idx_activities_machine_started: %q", plan)
+}
+
+func TestStatsByDay(t *testing.T) {
+	database := setupTestDB(t)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+
+	base := time.Date(2024, 3, 30, 12, 0, 0, 0, time.UTC)
+	for _, a := range []*Activity{
+		{StartedAt: base, EndedAt: base.Add(5 * time.Minute), LinesAdded: 10, LinesRemoved: 2},
+		{StartedAt: base.Add(time.Hour), EndedAt: base.Add(time.Hour + time.Minute), LinesAdded: 1},
+		// 23:30 UTC is already March 31 in Berlin.
+		{StartedAt: base.Add(11*time.Hour + 30*time.Minute), EndedAt: base.Add(12 * time.Hour)},
+		// Three days later, after the switch to summer time.
+		{StartedAt: base.Add(72 * time.Hour), EndedAt: base.Add(73 * time.Hour), LinesRemoved: 4},
+	} {
+		if err := database.InsertActivity(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	days, err := database.StatsByDay(time.Time{}, time.Time{}, berlin)
+	if err != nil {
+		t.Fatalf("StatsByDay() error: %v", err)
+	}
+	want := []DayStats{
+		{Date: "2024-03-30", Activities: 2, Seconds: 360, LinesAdded: 11, LinesRemoved: 2},
+		{Date: "2024-03-31", Activities: 1, Seconds: 1800},
+		{Date: "2024-04-02", Activities: 1, Seconds: 3600, LinesRemoved: 4},
+	}
+	if !slices.Equal(days, want) {
+		t.Errorf("StatsByDay() = %+v, want %+v", days, want)
+	}
+
+	days, err = database.StatsByDay(base.Add(24*time.Hour), time.Time{}, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 1 || days[0].Date != "2024-04-02" {
+		t.Errorf("StatsByDay() from March 31 = %+v, want only 2024-04-02", days)
+	}
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DeadLetter is an activity the server rejected too many times to keep
+// retrying automatically.
+type DeadLetter struct {
+	ID         int64
+	ActivityID int64
+	Activity   *Activity
+	Failures   int
+	LastError  string
+	CreatedAt  time.Time
+}
+
+// RecordSyncFailure counts a rejection of activity id. Once it has been
+// rejected maxFailures times, the activity is moved to the dead letter
+// table and RecordSyncFailure reports true.
+func (db *DB) RecordSyncFailure(id int64, reason string, maxFailures int) (dead bool, err error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && rollbackErr != sql.ErrTxDone {
+			err = rollbackErr
+		}
+	}()
+
+	var failures int
+	if err := tx.QueryRow(
+		`UPDATE activities SET sync_failures = sync_failures + 1 WHERE id = ? RETURNING sync_failures`, id,
+	).Scan(&failures); err != nil {
+		return false, err
+	}
+
+	if failures < maxFailures {
+		return false, tx.Commit()
+	}
+
+	a, err := db.scanActivity(tx.QueryRow(`SELECT `+activityColumns+` FROM activities WHERE id = ?`, id))
+	if err != nil {
+		return false, err
+	}
+	// The dead letter copy keeps sensitive fields encrypted, like the row.
+	if a.Project, a.GitRemote, a.Filename, err = db.sealedFields(a); err != nil {
+		return false, err
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO dead_letter (activity_id, client_id, activity, failures, last_error) VALUES (?, ?, ?, ?, ?)`,
+		a.ID, a.ClientID, string(data), failures, reason,
+	); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`DELETE FROM activities WHERE id = ?`, id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// DeadLetters returns all dead-lettered activities, oldest first.
+func (db *DB) DeadLetters() (letters []*DeadLetter, err error) {
+	rows, err := db.conn.Query(`
+		SELECT id, activity_id, activity, failures, COALESCE(last_error, ''), created_at
+		FROM dead_letter
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	for rows.Next() {
+		dl := &DeadLetter{}
+		var data string
+		if err := rows.Scan(&dl.ID, &dl.ActivityID, &data, &dl.Failures, &dl.LastError, &dl.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(data), &dl.Activity); err != nil {
+			return nil, fmt.Errorf("dead letter %d: decode activity: %w", dl.ID, err)
+		}
+		if err := db.unsealFields(dl.Activity); err != nil {
+			return nil, fmt.Errorf("dead letter %d: %w", dl.ID, err)
+		}
+		letters = append(letters, dl)
+	}
+	return letters, rows.Err()
+}
+
+// ErrDeadLetterNotFound is returned by RetryDeadLetter for an unknown id.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// RetryDeadLetter moves a dead-lettered activity back into the unsynced
+// backlog with a fresh failure count. The activity keeps its client ID so
+// the server can still deduplicate it.
+func (db *DB) RetryDeadLetter(id int64) (err error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && rollbackErr != sql.ErrTxDone {
+			err = rollbackErr
+		}
+	}()
+
+	var data string
+	if err := tx.QueryRow(`SELECT activity FROM dead_letter WHERE id = ?`, id).Scan(&data); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrDeadLetterNotFound, id)
+		}
+		return err
+	}
+
+	var a Activity
+	if err := json.Unmarshal([]byte(data), &a); err != nil {
+		return fmt.Errorf("dead letter %d: decode activity: %w", id, err)
+	}
+	if err := db.unsealFields(&a); err != nil {
+		return fmt.Errorf("dead letter %d: %w", id, err)
+	}
+	a.ID = 0
+	a.Synced = false
+	if err := db.insertActivity(tx, &a); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM dead_letter WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
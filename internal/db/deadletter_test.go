@@ -0,0 +1,73 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterLifecycle(t *testing.T) {
+	database := setupTestDB(t)
+
+	now := time.Now().UTC()
+	bad := &Activity{Project: "bad", StartedAt: now, EndedAt: now.Add(time.Minute), Tags: []string{"x"}}
+	if err := database.InsertActivity(bad); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		dead, err := database.RecordSyncFailure(bad.ID, "status 422", 3)
+		if err != nil {
+			t.Fatalf("RecordSyncFailure() error: %v", err)
+		}
+		if dead {
+			t.Fatalf("dead-lettered after %d failures, want 3", i)
+		}
+	}
+	dead, err := database.RecordSyncFailure(bad.ID, "status 422", 3)
+	if err != nil || !dead {
+		t.Fatalf("RecordSyncFailure() = %v, %v; want dead", dead, err)
+	}
+
+	stats, err := database.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 0 {
+		t.Errorf("activity still in backlog after dead-lettering (total %d)", stats.Total)
+	}
+
+	letters, err := database.DeadLetters()
+	if err != nil {
+		t.Fatalf("DeadLetters() error: %v", err)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(letters))
+	}
+	dl := letters[0]
+	if dl.ActivityID != bad.ID || dl.Failures != 3 || dl.LastError != "status 422" {
+		t.Errorf("unexpected dead letter: %+v", dl)
+	}
+	if dl.Activity.Project != "bad" || dl.Activity.ClientID != bad.ClientID || len(dl.Activity.Tags) != 1 {
+		t.Errorf("activity not preserved: %+v", dl.Activity)
+	}
+
+	if err := database.RetryDeadLetter(dl.ID); err != nil {
+		t.Fatalf("RetryDeadLetter() error: %v", err)
+	}
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 || activities[0].ClientID != bad.ClientID {
+		t.Fatalf("retried activity not back in backlog: %+v", activities)
+	}
+	// The retried row starts with a clean failure count.
+	if dead, err := database.RecordSyncFailure(activities[0].ID, "again", 2); err != nil || dead {
+		t.Errorf("RecordSyncFailure() after retry = %v, %v; want not dead", dead, err)
+	}
+
+	if err := database.RetryDeadLetter(dl.ID); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Errorf("RetryDeadLetter() of retried id error = %v, want ErrDeadLetterNotFound", err)
+	}
+}
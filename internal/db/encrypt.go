@@ -0,0 +1,106 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// EncryptionKeySize is the length of the AES-256 key for field encryption.
+const EncryptionKeySize = 32
+
+// encryptedPrefix marks a column value sealed with the field encryption
+// key. Values without it are plaintext, e.g. rows stored before
+// encryption was turned on.
+const encryptedPrefix = "enc1:"
+
+// ErrNoEncryptionKey is returned when reading an encrypted field from a
+// database opened without a key.
+var ErrNoEncryptionKey = errors.New("database has encrypted fields but no encryption key is configured")
+
+// ParseEncryptionKey decodes a base64-encoded 32-byte key, as generated by
+// `openssl rand -base64 32`.
+func ParseEncryptionKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key must be base64: %w", err)
+	}
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", EncryptionKeySize, len(key))
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts a sensitive column value. Without a key, or for an empty
+// value, it is stored as is.
+func (db *DB) seal(s string) (string, error) {
+	if db.aead == nil || s == "" {
+		return s, nil
+	}
+	nonce := make([]byte, db.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := db.aead.Seal(nonce, nonce, []byte(s), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// unseal reverses seal, passing plaintext values through.
+func (db *DB) unseal(s string) (string, error) {
+	data, ok := strings.CutPrefix(s, encryptedPrefix)
+	if !ok {
+		return s, nil
+	}
+	if db.aead == nil {
+		return "", ErrNoEncryptionKey
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(raw) < db.aead.NonceSize() {
+		return "", errors.New("malformed encrypted field")
+	}
+	nonce, sealed := raw[:db.aead.NonceSize()], raw[db.aead.NonceSize():]
+	plain, err := db.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", errors.New("decrypt field: wrong encryption key or corrupted data")
+	}
+	return string(plain), nil
+}
+
+// sealedFields returns the project, git remote and filename of a as they
+// are stored.
+func (db *DB) sealedFields(a *Activity) (project, gitRemote, filename string, err error) {
+	if project, err = db.seal(a.Project); err != nil {
+		return "", "", "", err
+	}
+	if gitRemote, err = db.seal(a.GitRemote); err != nil {
+		return "", "", "", err
+	}
+	if filename, err = db.seal(a.Filename); err != nil {
+		return "", "", "", err
+	}
+	return project, gitRemote, filename, nil
+}
+
+// unsealFields decrypts a's sensitive fields in place after a read.
+func (db *DB) unsealFields(a *Activity) error {
+	for _, field := range []*string{&a.Project, &a.GitRemote, &a.Filename} {
+		plain, err := db.unseal(*field)
+		if err != nil {
+			return fmt.Errorf("activity %d: %w", a.ID, err)
+		}
+		*field = plain
+	}
+	return nil
+}
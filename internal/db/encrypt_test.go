@@ -0,0 +1,128 @@
+package db
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFieldEncryption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	key := bytes.Repeat([]byte{7}, EncryptionKeySize)
+
+	// A row stored before encryption was enabled stays readable.
+	plain, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := plain.InsertActivity(&Activity{Project: "old", StartedAt: now.Add(-time.Minute), EndedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	database, err := OpenWithOptions(path, Options{EncryptionKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Activity{Project: "secret-project", GitRemote: "git@example.com:secret.git", Filename: "plan.go", Filetype: "go", StartedAt: now.Add(-time.Minute), EndedAt: now}
+	if err := database.InsertActivity(a); err != nil {
+		t.Fatal(err)
+	}
+
+	var project, filetype string
+	if err := database.conn.QueryRow(`SELECT project, filetype FROM activities WHERE id = ?`, a.ID).Scan(&project, &filetype); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(project, encryptedPrefix) || strings.Contains(project, "secret") {
+		t.Errorf("stored project = %q, want it encrypted", project)
+	}
+	if filetype != "go" {
+		t.Errorf("stored filetype = %q, want plaintext", filetype)
+	}
+
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 2 || activities[0].Project != "old" || activities[1].Project != "secret-project" ||
+		activities[1].GitRemote != a.GitRemote || activities[1].Filename != "plan.go" {
+		t.Fatalf("read back %+v, want plaintext fields", activities)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the key, or with the wrong one, encrypted rows can't be read.
+	keyless, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keyless.Close()
+	if _, err := keyless.GetUnsyncedActivities(10); !errors.Is(err, ErrNoEncryptionKey) {
+		t.Errorf("read without key = %v, want ErrNoEncryptionKey", err)
+	}
+	wrong, err := OpenWithOptions(path, Options{EncryptionKey: bytes.Repeat([]byte{8}, EncryptionKeySize)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wrong.Close()
+	if _, err := wrong.GetUnsyncedActivities(10); err == nil {
+		t.Error("read with the wrong key succeeded")
+	}
+}
+
+func TestParseEncryptionKey(t *testing.T) {
+	good := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, EncryptionKeySize))
+	if key, err := ParseEncryptionKey(good + "\n"); err != nil || len(key) != EncryptionKeySize {
+		t.Errorf("ParseEncryptionKey(valid) = %d bytes, %v", len(key), err)
+	}
+	for _, bad := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := ParseEncryptionKey(bad); err == nil {
+			t.Errorf("ParseEncryptionKey(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestDeadLetterStaysEncrypted(t *testing.T) {
+	database, err := OpenWithOptions(filepath.Join(t.TempDir(), "test.db"), Options{EncryptionKey: bytes.Repeat([]byte{7}, EncryptionKeySize)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	now := time.Now()
+	a := &Activity{Project: "secret-project", StartedAt: now.Add(-time.Minute), EndedAt: now}
+	if err := database.InsertActivity(a); err != nil {
+		t.Fatal(err)
+	}
+	if dead, err := database.RecordSyncFailure(a.ID, "status 422", 1); err != nil || !dead {
+		t.Fatalf("RecordSyncFailure() = %v, %v", dead, err)
+	}
+
+	var stored string
+	if err := database.conn.QueryRow(`SELECT activity FROM dead_letter`).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored, "secret-project") {
+		t.Errorf("dead letter stores the project in plaintext: %s", stored)
+	}
+
+	letters, err := database.DeadLetters()
+	if err != nil || len(letters) != 1 || letters[0].Activity.Project != "secret-project" {
+		t.Fatalf("DeadLetters() = %+v, %v", letters, err)
+	}
+	if err := database.RetryDeadLetter(letters[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil || len(activities) != 1 || activities[0].Project != "secret-project" {
+		t.Errorf("after retry = %+v, %v; want the decrypted activity back", activities, err)
+	}
+}
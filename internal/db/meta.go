@@ -0,0 +1,55 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// metaLastSyncAt is the meta key recording when activities last reached
+// the server.
+const metaLastSyncAt = "last_sync_at"
+
+// GetMeta returns the value stored under key, or "" when it is unset.
+func (db *DB) GetMeta(key string) (string, error) {
+	var value string
+	err := db.conn.QueryRow(`SELECT value FROM meta WHERE key = ?`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return value, err
+}
+
+// SetMeta stores value under key, replacing any previous value.
+func (db *DB) SetMeta(key, value string) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO meta (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP
+	`, key, value)
+	return err
+}
+
+// DeleteMeta removes keys; missing keys are ignored.
+func (db *DB) DeleteMeta(keys ...string) error {
+	for _, key := range keys {
+		if _, err := db.conn.Exec(`DELETE FROM meta WHERE key = ?`, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LastSyncAt returns when activities were last synced to the server, or
+// the zero time if they never were.
+func (db *DB) LastSyncAt() (time.Time, error) {
+	value, err := db.GetMeta(metaLastSyncAt)
+	if err != nil || value == "" {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// SetLastSyncAt records t as the time activities last reached the server.
+func (db *DB) SetLastSyncAt(t time.Time) error {
+	return db.SetMeta(metaLastSyncAt, t.UTC().Format(time.RFC3339Nano))
+}
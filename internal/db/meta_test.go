@@ -0,0 +1,50 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMeta(t *testing.T) {
+	database := setupTestDB(t)
+
+	if v, err := database.GetMeta("missing"); err != nil || v != "" {
+		t.Fatalf("GetMeta(missing) = %q, %v; want empty", v, err)
+	}
+
+	for _, want := range []string{"1", "2"} {
+		if err := database.SetMeta("k", want); err != nil {
+			t.Fatalf("SetMeta() error: %v", err)
+		}
+		if v, err := database.GetMeta("k"); err != nil || v != want {
+			t.Errorf("GetMeta(k) = %q, %v; want %q", v, err, want)
+		}
+	}
+
+	if err := database.DeleteMeta("k", "missing"); err != nil {
+		t.Fatalf("DeleteMeta() error: %v", err)
+	}
+	if v, err := database.GetMeta("k"); err != nil || v != "" {
+		t.Errorf("GetMeta(k) after delete = %q, %v; want empty", v, err)
+	}
+}
+
+func TestLastSyncAt(t *testing.T) {
+	database := setupTestDB(t)
+
+	if got, err := database.LastSyncAt(); err != nil || !got.IsZero() {
+		t.Fatalf("LastSyncAt() = %v, %v; want zero before any sync", got, err)
+	}
+
+	want := time.Date(2026, 3, 1, 12, 0, 0, 500, time.FixedZone("UTC+2", 2*3600))
+	if err := database.SetLastSyncAt(want); err != nil {
+		t.Fatalf("SetLastSyncAt() error: %v", err)
+	}
+	got, err := database.LastSyncAt()
+	if err != nil {
+		t.Fatalf("LastSyncAt() error: %v", err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("LastSyncAt() = %v, want %v", got, want)
+	}
+}
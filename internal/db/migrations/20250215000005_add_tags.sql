@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Tags are stored as a JSON array of strings (e.g. '["review","refactor"]')
+-- so they can be filtered with json_each().
+ALTER TABLE activities ADD COLUMN tags TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE activities DROP COLUMN tags;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Derived from ended_at - started_at at insert time. Existing rows are
+-- backfilled by the Go migration 20250215000007, since SQLite's date
+-- functions can't parse the stored Go time strings.
+ALTER TABLE activities ADD COLUMN duration_seconds REAL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE activities DROP COLUMN duration_seconds;
+-- +goose StatementEnd
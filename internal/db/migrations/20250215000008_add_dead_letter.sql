@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+-- sync_failures counts how often the server rejected this activity on its
+-- own; after dead_letter_after rejections it moves to dead_letter.
+ALTER TABLE activities ADD COLUMN sync_failures INTEGER NOT NULL DEFAULT 0;
+
+-- The activity is stored as JSON so this table doesn't have to track
+-- every column added to activities.
+CREATE TABLE IF NOT EXISTS dead_letter (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    activity_id INTEGER NOT NULL,
+    client_id TEXT,
+    activity TEXT NOT NULL,
+    failures INTEGER NOT NULL,
+    last_error TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS dead_letter;
+ALTER TABLE activities DROP COLUMN sync_failures;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- meta holds small pieces of daemon state that must survive restarts,
+-- keyed by name.
+CREATE TABLE IF NOT EXISTS meta (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS meta;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- metadata is an opaque JSON object supplied by editor plugins.
+ALTER TABLE activities ADD COLUMN metadata TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE activities DROP COLUMN metadata;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Covers per-machine queries over a time range, which idx_activities_started_at
+-- alone would answer by scanning every machine's rows.
+CREATE INDEX IF NOT EXISTS idx_activities_machine_started ON activities(machine, started_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_activities_machine_started;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- syncing_at is set (Unix seconds) while a syncer has claimed the row for
+-- upload, so a concurrent syncer skips it until the claim is released or
+-- its lease runs out.
+ALTER TABLE activities ADD COLUMN syncing_at INTEGER;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE activities DROP COLUMN syncing_at;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- source records how an activity was captured: editor, manual or cli.
+-- Rows from before this migration were all tracked by an editor.
+ALTER TABLE activities ADD COLUMN source TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE activities DROP COLUMN source;
+-- +goose StatementEnd
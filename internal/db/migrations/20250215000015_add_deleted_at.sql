@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- deleted_at marks a synced activity deleted locally whose delete hasn't
+-- reached the server yet (a tombstone). The row is removed once it has.
+ALTER TABLE activities ADD COLUMN deleted_at DATETIME;
+CREATE INDEX idx_activities_deleted_at ON activities(deleted_at) WHERE deleted_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_activities_deleted_at;
+ALTER TABLE activities DROP COLUMN deleted_at;
+-- +goose StatementEnd
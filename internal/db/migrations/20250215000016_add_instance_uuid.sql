@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- instance_uuid identifies the editor or plugin instance that produced an
+-- activity (the socket's client_uuid), unlike client_id, which is unique
+-- per activity. Older rows have none.
+ALTER TABLE activities ADD COLUMN instance_uuid TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE activities DROP COLUMN instance_uuid;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Lets claims walk the unsynced backlog in started_at order straight from
+-- the index, instead of sorting every unsynced row on each pass.
+CREATE INDEX IF NOT EXISTS idx_activities_synced_started ON activities(synced, started_at, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_activities_synced_started;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Raw per-activity totals behind actions_per_minute and words_per_minute,
+-- which sum cleanly where rates don't. Older rows read as 0.
+ALTER TABLE activities ADD COLUMN keystrokes INTEGER;
+ALTER TABLE activities ADD COLUMN edits INTEGER;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE activities DROP COLUMN edits;
+ALTER TABLE activities DROP COLUMN keystrokes;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+package db
+
+import "time"
+
+// PruneSynced deletes synced activities that started before cutoff and
+// returns how many were removed. Unsynced activities are never touched,
+// whatever their age, and neither are tombstones still waiting for the
+// server to apply their delete.
+func (db *DB) PruneSynced(cutoff time.Time) (int64, error) {
+	result, err := db.conn.Exec(`
+		DELETE FROM activities
+		WHERE synced = TRUE AND deleted_at IS NULL AND started_at < ?
+	`, cutoff.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPruneSynced(t *testing.T) {
+	database := setupTestDB(t)
+
+	now := time.Now().UTC()
+	old := now.AddDate(0, 0, -60)
+	var ids []int64
+	for _, start := range []time.Time{old, old.Add(time.Hour), old.Add(2 * time.Hour), now} {
+		a := &Activity{Project: "blast", StartedAt: start, EndedAt: start.Add(time.Minute)}
+		if err := database.InsertActivity(a); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, a.ID)
+	}
+	// ids[0] is old and synced, ids[1] is old but never synced, ids[2] is
+	// an old tombstone, ids[3] is recent and synced.
+	if err := database.MarkSynced([]int64{ids[0], ids[2], ids[3]}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := database.DeleteActivities([]int64{ids[2]}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := database.PruneSynced(now.AddDate(0, 0, -30))
+	if err != nil {
+		t.Fatalf("PruneSynced() error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("PruneSynced() = %d, want 1", n)
+	}
+
+	stats, err := database.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 2 || stats.Unsynced != 1 {
+		t.Errorf("stats after prune = %+v, want the old unsynced and the recent synced activity", stats)
+	}
+	if tombstones, err := database.Tombstones(10); err != nil || len(tombstones) != 1 {
+		t.Errorf("Tombstones() = %v, %v; want the pending delete kept", tombstones, err)
+	}
+}
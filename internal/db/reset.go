@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// DataFiles lists the files that hold the database at path and exist:
+// the database itself, its WAL, SHM and rollback journal sidecars, the
+// backups taken before migrations (<path>.bak-*) and quarantined copies
+// (<path>.corrupt-*, with their sidecars). Deleting all of them removes
+// every activity blastd has stored under path.
+func DataFiles(path string) ([]string, error) {
+	var files []string
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if _, err := os.Lstat(path + suffix); err == nil {
+			files = append(files, path+suffix)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	for _, pattern := range []string{path + ".bak-*", path + ".corrupt-*"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// ErrInUse reports that another connection, such as a running daemon, has
+// the database open.
+var ErrInUse = errors.New("database is in use")
+
+// lockTimeout is how long CheckNotInUse waits for the exclusive lock.
+const lockTimeout = 200 * time.Millisecond
+
+// CheckNotInUse takes an exclusive lock on the database at path and
+// releases it, failing with ErrInUse if another connection holds the
+// database open. In WAL mode every open connection keeps a shared lock on
+// the file, so this finds a daemon whatever socket or pid file it uses. A
+// missing database is not in use.
+func CheckNotInUse(path string) (err error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	conn, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=locking_mode(EXCLUSIVE)", path, lockTimeout.Milliseconds()))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := conn.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	// BEGIN and ROLLBACK must run on the same connection.
+	ctx := context.Background()
+	c, err := conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := c.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+	if _, err := c.ExecContext(ctx, "BEGIN EXCLUSIVE"); err != nil {
+		if isTransient(err) {
+			return fmt.Errorf("%w: %s", ErrInUse, path)
+		}
+		return err
+	}
+	_, err = c.ExecContext(ctx, "ROLLBACK")
+	return err
+}
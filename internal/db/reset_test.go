@@ -0,0 +1,72 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDataFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "blast.db")
+
+	files, err := DataFiles(path)
+	if err != nil {
+		t.Fatalf("DataFiles() error: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("DataFiles() with no database = %v, want none", files)
+	}
+
+	want := []string{
+		path,
+		path + "-wal",
+		path + "-shm",
+		path + ".bak-20250101T000000",
+		path + ".corrupt-20250102T000000",
+		path + ".corrupt-20250102T000000-wal",
+	}
+	for _, name := range append(slices.Clone(want), filepath.Join(dir, "other.db"), path+"x") {
+		if err := os.WriteFile(name, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err = DataFiles(path)
+	if err != nil {
+		t.Fatalf("DataFiles() error: %v", err)
+	}
+	if !slices.Equal(files, want) {
+		t.Errorf("DataFiles() = %v, want %v", files, want)
+	}
+}
+
+func TestCheckNotInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blast.db")
+	if err := CheckNotInUse(path); err != nil {
+		t.Errorf("CheckNotInUse() on a missing database: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("CheckNotInUse() created %s", path)
+	}
+
+	database, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.GetStats(); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckNotInUse(path); !errors.Is(err, ErrInUse) {
+		t.Errorf("CheckNotInUse() with the database open = %v, want ErrInUse", err)
+	}
+
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckNotInUse(path); err != nil {
+		t.Errorf("CheckNotInUse() after Close: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Tombstone is a synced activity deleted locally whose delete still has to
+// be sent to the server.
+type Tombstone struct {
+	ID       int64
+	ClientID string
+}
+
+// DeleteActivities deletes the given activities. Rows that were never
+// synced are removed outright; the server has never seen them. Synced rows
+// become tombstones: hidden from stats and resync, and kept until
+// PurgeTombstones once the server has deleted its copy. An upload already
+// in flight for an unsynced row may still reach the server.
+func (db *DB) DeleteActivities(ids []int64) (removed, tombstoned int64, err error) {
+	if len(ids) == 0 {
+		return 0, 0, nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.Repeat("?, ", len(ids)-1) + "?"
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && rollbackErr != sql.ErrTxDone {
+			err = rollbackErr
+		}
+	}()
+
+	result, err := tx.Exec(`DELETE FROM activities WHERE synced = FALSE AND id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return 0, 0, err
+	}
+	if removed, err = result.RowsAffected(); err != nil {
+		return 0, 0, err
+	}
+
+	result, err = tx.Exec(`UPDATE activities SET deleted_at = ? WHERE deleted_at IS NULL AND id IN (`+placeholders+`)`,
+		append([]any{time.Now().UTC()}, args...)...)
+	if err != nil {
+		return 0, 0, err
+	}
+	if tombstoned, err = result.RowsAffected(); err != nil {
+		return 0, 0, err
+	}
+	return removed, tombstoned, tx.Commit()
+}
+
+// Tombstones returns up to limit activities whose delete hasn't been sent
+// to the server, oldest delete first.
+func (db *DB) Tombstones(limit int) (tombstones []Tombstone, err error) {
+	rows, err := db.conn.Query(`
+		SELECT id, client_id FROM activities
+		WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at ASC, id ASC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	for rows.Next() {
+		var t Tombstone
+		if err := rows.Scan(&t.ID, &t.ClientID); err != nil {
+			return nil, err
+		}
+		tombstones = append(tombstones, t)
+	}
+	return tombstones, rows.Err()
+}
+
+// PurgeTombstones removes tombstones whose delete the server has applied.
+// Rows that aren't tombstones are left alone.
+func (db *DB) PurgeTombstones(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.Repeat("?, ", len(ids)-1) + "?"
+	_, err := db.conn.Exec(`DELETE FROM activities WHERE deleted_at IS NOT NULL AND id IN (`+placeholders+`)`, args...)
+	return err
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeleteActivities(t *testing.T) {
+	database := setupTestDB(t)
+
+	base := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	var ids []int64
+	for i := range 3 {
+		a := &Activity{Project: "blast", StartedAt: base.Add(time.Duration(i) * time.Hour), EndedAt: base.Add(time.Duration(i)*time.Hour + time.Minute)}
+		if err := database.InsertActivity(a); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, a.ID)
+	}
+	// ids[0] and ids[1] reached the server; ids[2] never did.
+	if err := database.MarkSynced(ids[:2]); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, tombstoned, err := database.DeleteActivities([]int64{ids[1], ids[2]})
+	if err != nil {
+		t.Fatalf("DeleteActivities() error: %v", err)
+	}
+	if removed != 1 || tombstoned != 1 {
+		t.Errorf("DeleteActivities() = %d removed, %d tombstoned; want 1, 1", removed, tombstoned)
+	}
+
+	stats, err := database.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 1 || stats.Unsynced != 0 {
+		t.Errorf("stats after delete = %+v, want 1 total, 0 unsynced", stats)
+	}
+	if n, err := database.CountSynced(time.Time{}, time.Time{}); err != nil || n != 1 {
+		t.Errorf("CountSynced() = %d, %v; want the tombstone excluded", n, err)
+	}
+	if n, err := database.MarkAllUnsynced(time.Time{}, time.Time{}); err != nil || n != 1 {
+		t.Errorf("MarkAllUnsynced() = %d, %v; want the tombstone left synced", n, err)
+	}
+
+	tombstones, err := database.Tombstones(10)
+	if err != nil {
+		t.Fatalf("Tombstones() error: %v", err)
+	}
+	if len(tombstones) != 1 || tombstones[0].ID != ids[1] || tombstones[0].ClientID == "" {
+		t.Fatalf("Tombstones() = %+v, want activity %d", tombstones, ids[1])
+	}
+
+	// Purging ignores live rows.
+	if err := database.PurgeTombstones([]int64{ids[0], ids[1]}); err != nil {
+		t.Fatalf("PurgeTombstones() error: %v", err)
+	}
+	if tombstones, err := database.Tombstones(10); err != nil || len(tombstones) != 0 {
+		t.Errorf("Tombstones() after purge = %+v, %v; want none", tombstones, err)
+	}
+	if stats, err := database.GetStats(); err != nil || stats.Total != 1 {
+		t.Errorf("stats after purge = %+v, %v; want the live row kept", stats, err)
+	}
+}
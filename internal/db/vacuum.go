@@ -0,0 +1,15 @@
+package db
+
+// Vacuum rebuilds the database file so pages freed by deleted rows are
+// returned to the filesystem. It runs in place: VACUUM INTO plus a rename
+// would swap the file out from under the pool's other open connections.
+// In WAL mode the rebuilt pages land in the WAL first, so it checkpoints
+// and truncates the WAL afterwards for the main file to shrink. Writers
+// wait up to busyTimeout while it runs.
+func (db *DB) Vacuum() error {
+	if _, err := db.conn.Exec("VACUUM"); err != nil {
+		return err
+	}
+	_, err := db.conn.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	return err
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVacuumShrinksFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	database, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer database.Close()
+
+	now := time.Now()
+	for i := range 2000 {
+		a := &Activity{
+			StartedAt: now.Add(time.Duration(i) * time.Minute),
+			EndedAt:   now.Add(time.Duration(i+1) * time.Minute),
+			Project:   "project-with-a-reasonably-long-name",
+			Filetype:  "go",
+			Editor:    "neovim",
+		}
+		if err := database.InsertActivity(a); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	if _, err := database.conn.Exec(`DELETE FROM activities`); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Vacuum(); err != nil {
+		t.Fatalf("vacuum: %v", err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() >= before.Size() {
+		t.Errorf("size after vacuum = %d, want < %d", after.Size(), before.Size())
+	}
+
+	if err := database.InsertActivity(&Activity{StartedAt: now, EndedAt: now.Add(time.Minute), Editor: "neovim"}); err != nil {
+		t.Errorf("insert after vacuum: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package logfile
+
+import (
+	"os"
+	"sync"
+)
+
+// File is an io.Writer backed by a log file that can be reopened in place,
+// so the daemon keeps writing to the new file after logrotate moves the old
+// one aside.
+type File struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open opens (or creates) path for appending.
+func Open(path string) (*File, error) {
+	f := &File{path: path}
+	if err := f.Reopen(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Path returns the path the file was opened with.
+func (f *File) Path() string {
+	return f.path
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Write(p)
+}
+
+// Reopen closes the current handle and opens path again, picking up a
+// freshly rotated file.
+func (f *File) Reopen() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	old := f.file
+	f.file = file
+	f.mu.Unlock()
+
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
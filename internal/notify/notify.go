@@ -0,0 +1,71 @@
+// Package notify shows desktop notifications through the platform's
+// command-line notifier: notify-send on Linux and the BSDs, osascript on
+// macOS. Other platforms have no notifier.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// sendTimeout bounds one notifier run, so a hung notification daemon
+// can't pile up processes.
+const sendTimeout = 5 * time.Second
+
+// Notifier runs a desktop notification command.
+type Notifier struct {
+	path string
+	args func(title, message string) []string
+}
+
+// New returns the notifier for this platform, or nil if there is none
+// (Windows, or notify-send not installed).
+func New() *Notifier {
+	return newFor(runtime.GOOS, exec.LookPath)
+}
+
+func newFor(goos string, lookPath func(string) (string, error)) *Notifier {
+	name, args := "notify-send", notifySendArgs
+	switch goos {
+	case "darwin":
+		name, args = "osascript", osascriptArgs
+	case "windows", "plan9", "js", "wasip1":
+		return nil
+	}
+	path, err := lookPath(name)
+	if err != nil {
+		return nil
+	}
+	return &Notifier{path: path, args: args}
+}
+
+// Send shows one notification and waits for the notifier to exit.
+func (n *Notifier) Send(title, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, n.path, n.args(title, message)...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+func notifySendArgs(title, message string) []string {
+	return []string{"--app-name=blastd", "--", title, message}
+}
+
+func osascriptArgs(title, message string) []string {
+	return []string{"-e", fmt.Sprintf("display notification %s with title %s", appleScriptString(message), appleScriptString(title))}
+}
+
+// appleScriptString quotes s as an AppleScript string literal.
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
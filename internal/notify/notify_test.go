@@ -0,0 +1,36 @@
+package notify
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestNewFor(t *testing.T) {
+	found := func(name string) (string, error) { return "/usr/bin/" + name, nil }
+	missing := func(string) (string, error) { return "", errors.New("not found") }
+
+	if n := newFor("linux", found); n == nil || n.path != "/usr/bin/notify-send" {
+		t.Errorf("linux notifier = %+v, want notify-send", n)
+	}
+	if n := newFor("darwin", found); n == nil || n.path != "/usr/bin/osascript" {
+		t.Errorf("darwin notifier = %+v, want osascript", n)
+	}
+	if n := newFor("windows", found); n != nil {
+		t.Errorf("windows notifier = %+v, want none", n)
+	}
+	if n := newFor("linux", missing); n != nil {
+		t.Errorf("notifier without notify-send = %+v, want none", n)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	if got, want := notifySendArgs("blastd", "-x"), []string{"--app-name=blastd", "--", "blastd", "-x"}; !slices.Equal(got, want) {
+		t.Errorf("notifySendArgs() = %q, want %q", got, want)
+	}
+	got := osascriptArgs("blastd", `token "expired" \ 401`)
+	want := []string{"-e", `display notification "token \"expired\" \\ 401" with title "blastd"`}
+	if !slices.Equal(got, want) {
+		t.Errorf("osascriptArgs() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,90 @@
+package output
+
+import (
+	"strings"
+	"time"
+
+	"charm.land/lipgloss/v2"
+)
+
+// heatGlyphs are the cells for levels 0 (nothing) to 4 (busiest). They
+// differ in shape as well as color so the map still reads with NO_COLOR.
+var heatGlyphs = []string{"·", "░", "▒", "▓", "█"}
+
+var heatStyles = []lipgloss.Style{
+	Dim,
+	lipgloss.NewStyle().Foreground(lipgloss.Color("#0e4429")),
+	lipgloss.NewStyle().Foreground(lipgloss.Color("#006d32")),
+	lipgloss.NewStyle().Foreground(lipgloss.Color("#26a641")),
+	lipgloss.NewStyle().Foreground(lipgloss.Color("#39d353")),
+}
+
+// heatLabelWidth is the width of the weekday labels left of the grid.
+const heatLabelWidth = 4
+
+// Heatmap renders values, keyed by YYYY-MM-DD, as a calendar with one
+// column per week (starting Monday) and one row per weekday, covering the
+// weeks from the one containing first to the one containing last. Days
+// without a value are empty cells; days after last are left blank. Shading
+// is relative to the busiest day. A legend follows the grid.
+func Heatmap(values map[string]float64, first, last time.Time) string {
+	first = time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, first.Location())
+	last = time.Date(last.Year(), last.Month(), last.Day(), 0, 0, 0, 0, first.Location())
+	monday := first.AddDate(0, 0, -(int(first.Weekday())+6)%7)
+
+	var peak float64
+	var weeks int
+	for d := monday; !d.After(last); d = d.AddDate(0, 0, 1) {
+		if d.Weekday() == time.Monday {
+			weeks++
+		}
+		peak = max(peak, values[d.Format(time.DateOnly)])
+	}
+
+	// One extra column so a month starting in the last week fits.
+	months := []rune(strings.Repeat(" ", heatLabelWidth+2*weeks+1))
+	for week, prev := 0, time.Month(0); week < weeks; week++ {
+		d := monday.AddDate(0, 0, 7*week)
+		// Label a month at the first week that starts in it, if the label
+		// fits before the line ends.
+		if d.Month() != prev && heatLabelWidth+2*week+3 <= len(months) {
+			copy(months[heatLabelWidth+2*week:], []rune(d.Format("Jan")))
+		}
+		prev = d.Month()
+	}
+
+	lines := []string{strings.TrimRight(string(months), " ")}
+	for weekday := range 7 {
+		var b strings.Builder
+		label := ""
+		if weekday%2 == 0 && weekday < 6 {
+			label = monday.AddDate(0, 0, weekday).Format("Mon")
+		}
+		b.WriteString(label + strings.Repeat(" ", heatLabelWidth-len(label)))
+		for week := range weeks {
+			d := monday.AddDate(0, 0, 7*week+weekday)
+			if d.After(last) {
+				break
+			}
+			level := heatLevel(values[d.Format(time.DateOnly)], peak)
+			b.WriteString(heatStyles[level].Render(heatGlyphs[level]) + " ")
+		}
+		lines = append(lines, strings.TrimRight(b.String(), " "))
+	}
+
+	legend := make([]string, len(heatGlyphs))
+	for level, glyph := range heatGlyphs {
+		legend[level] = heatStyles[level].Render(glyph)
+	}
+	lines = append(lines, "", strings.Repeat(" ", heatLabelWidth)+Dim.Render("less")+" "+strings.Join(legend, " ")+" "+Dim.Render("more"))
+	return strings.Join(lines, "\n")
+}
+
+// heatLevel maps v to 0 when there was nothing, else to 1-4 in proportion
+// to peak.
+func heatLevel(v, peak float64) int {
+	if v <= 0 || peak <= 0 {
+		return 0
+	}
+	return min(1+int(3*v/peak), len(heatGlyphs)-1)
+}
@@ -0,0 +1,79 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeatmap(t *testing.T) {
+	// Wednesday 2024-01-03 to Sunday 2024-01-14: the grid starts on Monday
+	// January 1 and covers two full weeks.
+	first := time.Date(2024, 1, 3, 15, 0, 0, 0, time.UTC)
+	last := time.Date(2024, 1, 14, 9, 0, 0, 0, time.UTC)
+	values := map[string]float64{
+		"2024-01-01": 7200, // before first, but in its week
+		"2024-01-03": 3600,
+		"2024-01-10": 60,
+		"2024-01-14": 7200,
+		"2023-12-31": 9999, // outside the grid; must not set the peak
+	}
+
+	var buf bytes.Buffer
+	if _, err := fmt.Fprint(NewWriter(&buf, []string{"NO_COLOR=1"}), Heatmap(values, first, last)); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"    Jan",
+		"Mon █ ·",
+		"    · ·",
+		"Wed ▒ ░",
+		"    · ·",
+		"Fri · ·",
+		"    · ·",
+		"    · █",
+		"",
+		"    less · ░ ▒ ▓ █ more",
+	}, "\n")
+	if got := buf.String(); got != want {
+		t.Errorf("Heatmap() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHeatmapEmptyAndPartialWeek(t *testing.T) {
+	// Monday to Wednesday: later weekdays of the only week stay blank.
+	first := time.Date(2024, 1, 29, 0, 0, 0, 0, time.UTC)
+	last := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	if _, err := fmt.Fprint(NewWriter(&buf, []string{"NO_COLOR=1"}), Heatmap(nil, first, last)); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	want := []string{"    Jan", "Mon ·", "    ·", "Wed ·", "", "Fri", "", "", ""}
+	for i, w := range want {
+		if lines[i] != w {
+			t.Errorf("line %d = %q, want %q", i, lines[i], w)
+		}
+	}
+}
+
+func TestHeatLevel(t *testing.T) {
+	for _, tc := range []struct {
+		v, peak float64
+		want    int
+	}{
+		{0, 100, 0},
+		{0, 0, 0},
+		{1, 100, 1},
+		{34, 100, 2},
+		{67, 100, 3},
+		{100, 100, 4},
+	} {
+		if got := heatLevel(tc.v, tc.peak); got != tc.want {
+			t.Errorf("heatLevel(%g, %g) = %d, want %d", tc.v, tc.peak, got, tc.want)
+		}
+	}
+}
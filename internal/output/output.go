@@ -0,0 +1,42 @@
+// Package output renders the human-readable output of client subcommands
+// such as `blastd tail`. Styles are plain lipgloss styles; writing through
+// NewWriter downgrades them to what the destination supports, so piped
+// output and NO_COLOR get plain text.
+package output
+
+import (
+	"io"
+	"strings"
+
+	"charm.land/lipgloss/v2"
+	"github.com/charmbracelet/colorprofile"
+)
+
+var (
+	Dim     = lipgloss.NewStyle().Faint(true)
+	Bold    = lipgloss.NewStyle().Bold(true)
+	Added   = lipgloss.NewStyle().Foreground(lipgloss.Green)
+	Removed = lipgloss.NewStyle().Foreground(lipgloss.Red)
+)
+
+// NewWriter wraps w so styled text matches its color support: ANSI codes
+// are stripped when w isn't a terminal, unless CLICOLOR_FORCE is set.
+// NO_COLOR in environ always wins and yields plain text.
+func NewWriter(w io.Writer, environ []string) io.Writer {
+	cw := colorprofile.NewWriter(w, environ)
+	if noColor(environ) {
+		cw.Profile = colorprofile.NoTTY
+	}
+	return cw
+}
+
+// noColor reports whether NO_COLOR is set to a non-empty value, per
+// https://no-color.org.
+func noColor(environ []string) bool {
+	for _, kv := range environ {
+		if value, ok := strings.CutPrefix(kv, "NO_COLOR="); ok && value != "" {
+			return true
+		}
+	}
+	return false
+}
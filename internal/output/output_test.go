@@ -0,0 +1,37 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNewWriter(t *testing.T) {
+	styled := Bold.Render("blast") + " " + Added.Render("+3") + " " + Removed.Render("-1")
+
+	for _, tc := range []struct {
+		name     string
+		environ  []string
+		wantANSI bool
+	}{
+		// A bytes.Buffer isn't a terminal, like stdout piped to a file.
+		{name: "piped", environ: []string{"TERM=xterm-256color"}},
+		{name: "NO_COLOR", environ: []string{"TERM=xterm-256color", "NO_COLOR=1", "CLICOLOR_FORCE=1"}},
+		{name: "forced", environ: []string{"TERM=xterm-256color", "CLICOLOR_FORCE=1"}, wantANSI: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if _, err := fmt.Fprintln(NewWriter(&buf, tc.environ), styled); err != nil {
+				t.Fatal(err)
+			}
+			hasANSI := strings.Contains(buf.String(), "\x1b[")
+			if hasANSI != tc.wantANSI {
+				t.Errorf("output %q: contains escape codes = %v, want %v", buf.String(), hasANSI, tc.wantANSI)
+			}
+			if !strings.Contains(buf.String(), "blast") || !strings.Contains(buf.String(), "+3") {
+				t.Errorf("output %q lost its text", buf.String())
+			}
+		})
+	}
+}
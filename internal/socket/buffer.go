@@ -0,0 +1,60 @@
+package socket
+
+import (
+	"log"
+	"time"
+
+	"github.com/taigrr/blastd/internal/db"
+)
+
+// SetWriteBuffer acknowledges activities before they are written and
+// stores them in batches: once count are pending, or interval after the
+// first of them arrived. Activities still in memory when the daemon
+// crashes are lost, and so are those a transient error kept in memory at
+// shutdown. The buffer is flushed on Stop, before a sync request and on a
+// flush request. Must be called before Start.
+func (s *Server) SetWriteBuffer(interval time.Duration, count int) {
+	s.buffer = db.NewBuffer(s.db, interval, count, s.flushed)
+}
+
+// flushed finishes a buffered write: the same bookkeeping store does for
+// an unbuffered insert, for the activities that were stored. The buffer
+// keeps activities a transient error held back and drops only rows the
+// database rejected.
+func (s *Server) flushed(stored []*db.Activity, err error) {
+	if err != nil {
+		log.Printf("write buffer: %v", err)
+	}
+	if db.IsStorageError(err) {
+		s.markStorageDown(err)
+	} else {
+		s.markStorageUp()
+	}
+	if len(stored) > 0 {
+		s.afterInsert(stored...)
+	}
+}
+
+// flushBuffer writes any activities held by the merge window or the write
+// buffer; it is a no-op without either.
+func (s *Server) flushBuffer() error {
+	if s.merger != nil {
+		s.merger.flush()
+	}
+	if s.buffer == nil {
+		return nil
+	}
+	return s.buffer.Flush()
+}
+
+// handleFlush writes buffered activities before replying, so a client can
+// be sure what it sent is on disk.
+func (s *Server) handleFlush() Response {
+	if err := s.flushBuffer(); err != nil {
+		if db.IsStorageError(err) {
+			return Response{OK: false, Error: "local storage is full or read-only", Code: ErrStorageFull}
+		}
+		return Response{OK: false, Error: err.Error(), Code: ErrInternal}
+	}
+	return Response{OK: true}
+}
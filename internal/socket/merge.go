@@ -0,0 +1,98 @@
+package socket
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/taigrr/blastd/internal/db"
+)
+
+// merger holds the latest activity from each editor instance for a short
+// window. An activity that arrives within the window and continues the
+// held one (db.MergeUnsaved) extends it instead of becoming its own row;
+// anything else, or the window passing quietly, writes the held activity.
+type merger struct {
+	window time.Duration
+	write  func(*db.Activity)
+
+	mu   sync.Mutex
+	held map[string]*heldActivity // by InstanceUUID
+}
+
+type heldActivity struct {
+	activity *db.Activity
+	timer    *time.Timer
+}
+
+// SetMergeWindow holds each activity for window before storing it, merging
+// in contiguous activities for the same project, branch and filetype that
+// arrive meanwhile, e.g. rapid file switches. Held activities are written
+// on Stop, before a sync request and on a flush request, but are lost if
+// the daemon crashes. Must be called before Start.
+func (s *Server) SetMergeWindow(window time.Duration) {
+	s.merger = &merger{window: window, write: s.writeHeld, held: make(map[string]*heldActivity)}
+}
+
+// writeHeld stores an activity released by the merger. Its client was
+// told it was stored, so a failure can only be logged.
+func (s *Server) writeHeld(activity *db.Activity) {
+	if resp := s.insert(activity); !resp.OK {
+		log.Printf("merge window: lost activity: %s", resp.Error)
+	}
+}
+
+// add holds activity, merging it into the one already held for its
+// instance when it continues it.
+func (m *merger) add(activity *db.Activity) {
+	key := activity.InstanceUUID
+	m.mu.Lock()
+	prev := m.held[key]
+	if prev != nil && db.MergeUnsaved(prev.activity, activity, m.window) {
+		prev.timer.Reset(m.window)
+		m.mu.Unlock()
+		return
+	}
+	if prev != nil {
+		prev.timer.Stop()
+	}
+	h := &heldActivity{activity: activity}
+	h.timer = time.AfterFunc(m.window, func() { m.expire(key, h) })
+	m.held[key] = h
+	m.mu.Unlock()
+
+	if prev != nil {
+		m.write(prev.activity)
+	}
+}
+
+// expire writes h once its window passes, unless it was already replaced
+// or flushed.
+func (m *merger) expire(key string, h *heldActivity) {
+	m.mu.Lock()
+	if m.held[key] != h {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.held, key)
+	m.mu.Unlock()
+	m.write(h.activity)
+}
+
+// flush writes every held activity, oldest first.
+func (m *merger) flush() {
+	m.mu.Lock()
+	activities := make([]*db.Activity, 0, len(m.held))
+	for key, h := range m.held {
+		h.timer.Stop()
+		activities = append(activities, h.activity)
+		delete(m.held, key)
+	}
+	m.mu.Unlock()
+
+	slices.SortFunc(activities, func(a, b *db.Activity) int { return a.StartedAt.Compare(b.StartedAt) })
+	for _, a := range activities {
+		m.write(a)
+	}
+}
@@ -0,0 +1,145 @@
+package socket
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/taigrr/blastd/internal/db"
+)
+
+func TestMergeWindow(t *testing.T) {
+	server, database := setupTestSocket(t)
+	server.SetMergeWindow(time.Hour)
+	conn := dial(t, server)
+
+	base := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	activity := func(project, filename string, start, end time.Duration, added int) {
+		t.Helper()
+		resp := sendAndRecv(t, conn, map[string]any{
+			"type": "activity",
+			"data": map[string]any{
+				"project":     project,
+				"filename":    filename,
+				"filetype":    "go",
+				"started_at":  base.Add(start).Format(time.RFC3339),
+				"ended_at":    base.Add(end).Format(time.RFC3339),
+				"lines_added": added,
+			},
+		})
+		if !resp.OK {
+			t.Fatalf("activity: %+v", resp)
+		}
+	}
+	stored := func() int {
+		t.Helper()
+		activities, err := database.GetUnsyncedActivities(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(activities)
+	}
+
+	activity("blast", "a.go", 0, 5*time.Second, 1)
+	activity("blast", "b.go", 5*time.Second, 8*time.Second, 2)
+	activity("blast", "a.go", 8*time.Second, 20*time.Second, 3)
+	if n := stored(); n != 0 {
+		t.Fatalf("%d activities stored inside the merge window, want 0", n)
+	}
+
+	// Another project can't continue the held activity, so it is written.
+	activity("other", "x.go", 20*time.Second, 30*time.Second, 4)
+	if n := stored(); n != 1 {
+		t.Fatalf("%d activities stored after a project switch, want 1", n)
+	}
+
+	if resp := sendAndRecv(t, conn, Request{Type: "flush"}); !resp.OK {
+		t.Fatalf("flush: %+v", resp)
+	}
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 2 {
+		t.Fatalf("got %d activities after flush, want 2", len(activities))
+	}
+	run := activities[0]
+	if run.Project != "blast" || !run.StartedAt.Equal(base) || !run.EndedAt.Equal(base.Add(20*time.Second)) {
+		t.Errorf("merged activity = %s %v..%v, want blast 09:00:00..09:00:20", run.Project, run.StartedAt, run.EndedAt)
+	}
+	if run.LinesAdded != 6 || run.Filename != "" {
+		t.Errorf("merged LinesAdded, Filename = %d, %q, want 6 and no filename", run.LinesAdded, run.Filename)
+	}
+	if activities[1].Project != "other" {
+		t.Errorf("second activity project = %q, want other", activities[1].Project)
+	}
+}
+
+func TestMergeWindowExpires(t *testing.T) {
+	server, database := setupTestSocket(t)
+	server.SetMergeWindow(50 * time.Millisecond)
+	conn := dial(t, server)
+
+	now := time.Now().UTC()
+	resp := sendAndRecv(t, conn, map[string]any{
+		"type": "activity",
+		"data": map[string]any{
+			"project":    "blast",
+			"started_at": now.Add(-time.Minute).Format(time.RFC3339),
+			"ended_at":   now.Format(time.RFC3339),
+		},
+	})
+	if !resp.OK {
+		t.Fatalf("activity: %+v", resp)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		activities, err := database.GetUnsyncedActivities(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(activities) == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("held activity was not written after the merge window")
+}
+
+func TestMergeWindowFlushedOnStop(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	// Not setupTestSocket: this test stops the server itself.
+	server := NewServer(filepath.Join(t.TempDir(), "test.sock"), database, "test-machine")
+	server.SetMergeWindow(time.Hour)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	conn := dial(t, server)
+
+	now := time.Now().UTC()
+	resp := sendAndRecv(t, conn, map[string]any{
+		"type": "activity",
+		"data": map[string]any{
+			"project":    "blast",
+			"started_at": now.Add(-time.Minute).Format(time.RFC3339),
+			"ended_at":   now.Format(time.RFC3339),
+		},
+	})
+	if !resp.OK {
+		t.Fatalf("activity: %+v", resp)
+	}
+
+	server.Stop()
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 {
+		t.Errorf("got %d activities after Stop, want the held one", len(activities))
+	}
+}
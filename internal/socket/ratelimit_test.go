@@ -0,0 +1,43 @@
+package socket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(2, 3, now)
+
+	for i := range 3 {
+		if !b.allow(now) {
+			t.Fatalf("burst event %d rejected", i)
+		}
+	}
+	if b.allow(now) {
+		t.Error("event beyond the burst allowed")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if !b.allow(now) {
+		t.Error("event rejected after refilling one token")
+	}
+	if b.allow(now) {
+		t.Error("refill allowed more than rate * elapsed")
+	}
+
+	now = now.Add(time.Hour)
+	for i := range 3 {
+		if !b.allow(now) {
+			t.Fatalf("event %d after idle rejected", i)
+		}
+	}
+	if b.allow(now) {
+		t.Error("idle refill exceeded the burst")
+	}
+
+	var unlimited *tokenBucket
+	if !unlimited.allow(now) {
+		t.Error("nil bucket rejected an event")
+	}
+}
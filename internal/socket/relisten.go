@@ -0,0 +1,88 @@
+package socket
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// socketCheckInterval is how often the socket file is checked for having
+// been deleted from under the daemon, e.g. by a runtime-dir cleaner.
+const socketCheckInterval = 30 * time.Second
+
+func (s *Server) setListener(listener net.Listener) {
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+	s.listener = listener
+}
+
+func (s *Server) currentListener() net.Listener {
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+	return s.listener
+}
+
+// watchSocket re-creates the listener when the socket file disappears.
+// The old listener keeps working for clients that already have the file
+// open, but nobody new can reach it by path, so the daemon would otherwise
+// look dead until restarted.
+func (s *Server) watchSocket() {
+	ticker := time.NewTicker(s.socketCheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if _, err := os.Lstat(s.path); !os.IsNotExist(err) {
+				continue
+			}
+			if err := s.relisten(); errors.Is(err, ErrSocketInUse) {
+				// The path exists again, so later checks leave it alone.
+				log.Printf("socket %s was deleted and %v; not taking it over", s.path, err)
+				continue
+			} else if err != nil {
+				log.Printf("socket %s was deleted; re-listen failed (retrying in %s): %v", s.path, s.socketCheck, err)
+				continue
+			}
+			log.Printf("socket %s was deleted; listening on it again", s.path)
+		}
+	}
+}
+
+// relisten binds a fresh listener at s.path and retires the old one. The
+// old listener must not unlink on close, or it would delete the new file.
+// Like Start, it refuses with ErrSocketInUse if something else has bound
+// the path since our file disappeared.
+func (s *Server) relisten() error {
+	if err := ProbeSocket(s.path); err != nil {
+		return err
+	}
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+	select {
+	case <-s.done:
+		// Stop raced us and won't see this listener.
+		return listener.Close()
+	default:
+	}
+	old := s.listener
+	s.listener = listener
+	if ul, ok := old.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	if err := old.Close(); err != nil {
+		log.Printf("close old listener: %v", err)
+	}
+
+	go s.accept(listener)
+	return nil
+}
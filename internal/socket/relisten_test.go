@@ -0,0 +1,96 @@
+package socket
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/taigrr/blastd/internal/db"
+)
+
+func TestRelistenAfterSocketDeleted(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	server := NewServer(filepath.Join(t.TempDir(), "test.sock"), database, "test-machine")
+	server.socketCheck = 10 * time.Millisecond
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	t.Cleanup(server.Stop)
+
+	before := dial(t, server)
+	if err := os.Remove(server.path); err != nil {
+		t.Fatal(err)
+	}
+
+	var conn net.Conn
+	deadline := time.Now().Add(2 * time.Second)
+	for conn == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("socket was not re-created after being deleted")
+		}
+		time.Sleep(10 * time.Millisecond)
+		conn, _ = net.Dial("unix", server.path)
+	}
+	defer conn.Close()
+
+	if resp := sendAndRecv(t, conn, Request{Type: "ping"}); !resp.OK {
+		t.Errorf("ping on the new socket: %+v", resp)
+	}
+	// Clients connected before the socket was deleted keep working.
+	if resp := sendAndRecv(t, before, Request{Type: "ping"}); !resp.OK {
+		t.Errorf("ping on the old connection: %+v", resp)
+	}
+
+	info, err := os.Stat(server.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("re-created socket mode = %o, want 600", perm)
+	}
+}
+
+func TestRelistenLeavesLiveSocket(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	server := NewServer(filepath.Join(t.TempDir(), "test.sock"), database, "test-machine")
+	server.socketCheck = time.Hour
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	t.Cleanup(server.Stop)
+
+	// Our file disappears and another daemon binds the path before we
+	// notice.
+	if err := os.Remove(server.path); err != nil {
+		t.Fatal(err)
+	}
+	other := NewServer(server.path, database, "other-machine")
+	if err := other.Start(); err != nil {
+		t.Fatalf("other Start() error: %v", err)
+	}
+	t.Cleanup(other.Stop)
+
+	before := server.currentListener()
+	if err := server.relisten(); !errors.Is(err, ErrSocketInUse) {
+		t.Fatalf("relisten() = %v, want ErrSocketInUse", err)
+	}
+	if server.currentListener() != before {
+		t.Error("relisten replaced the listener despite the live socket")
+	}
+	if resp := sendAndRecv(t, dial(t, other), Request{Type: "ping"}); !resp.OK {
+		t.Errorf("ping on the path = %+v, want the other daemon still answering", resp)
+	}
+}
@@ -0,0 +1,38 @@
+package socket
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+
+	"github.com/taigrr/blastd/internal/config"
+)
+
+// SetReloadFunc registers fn to answer "reload" requests.
+func (s *Server) SetReloadFunc(fn ReloadFunc) {
+	s.reload = fn
+}
+
+// handleReload re-reads the config on behalf of a client. Where the peer's
+// uid can be read (Linux), only the daemon's own user may reload; elsewhere
+// the socket's file permissions are the only gate.
+func (s *Server) handleReload(conn net.Conn) Response {
+	if s.reload == nil {
+		return Response{OK: false, Error: "reload not available", Code: ErrInternal}
+	}
+	if uid, ok := peerUID(conn); ok && uid != os.Getuid() {
+		return Response{OK: false, Error: "reload is only allowed for the daemon's own user", Code: ErrForbidden}
+	}
+
+	changed, err := s.reload()
+	var restart *config.RestartRequiredError
+	switch {
+	case errors.As(err, &restart):
+		return Response{OK: false, Error: err.Error(), Code: ErrRestartRequired, Changed: changed}
+	case err != nil:
+		log.Printf("reload config: %v", err)
+		return Response{OK: false, Error: err.Error(), Code: ErrReloadFailed}
+	}
+	return Response{OK: true, Changed: changed}
+}
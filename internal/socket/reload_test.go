@@ -0,0 +1,54 @@
+package socket
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/taigrr/blastd/internal/config"
+)
+
+func TestReload(t *testing.T) {
+	server, _ := setupTestSocket(t)
+	conn := dial(t, server)
+
+	if resp := sendAndRecv(t, conn, Request{Type: "reload"}); resp.OK || resp.Code != ErrInternal {
+		t.Errorf("reload without a reload func = %+v, want an error", resp)
+	}
+
+	var result func() ([]string, error)
+	server.SetReloadFunc(func() ([]string, error) { return result() })
+
+	result = func() ([]string, error) {
+		server.SetDropZeroDuration(true)
+		return []string{"drop_zero_duration"}, nil
+	}
+	resp := sendAndRecv(t, conn, Request{Type: "reload"})
+	if !resp.OK || !slices.Equal(resp.Changed, []string{"drop_zero_duration"}) {
+		t.Errorf("reload = %+v, want ok with changed [drop_zero_duration]", resp)
+	}
+	zero := map[string]any{
+		"type": "activity",
+		"data": map[string]any{"project": "p", "started_at": "2024-01-01T00:00:00Z", "ended_at": "2024-01-01T00:00:00Z"},
+	}
+	if resp := sendAndRecv(t, conn, zero); !resp.OK || resp.Message == "" {
+		t.Errorf("zero-duration activity after reload = %+v, want an ok skip", resp)
+	}
+
+	result = func() ([]string, error) {
+		return []string{"max_unsynced_rows"}, &config.RestartRequiredError{Keys: []string{"socket_path", "db_path"}}
+	}
+	resp = sendAndRecv(t, conn, Request{Type: "reload"})
+	if resp.OK || resp.Code != ErrRestartRequired || resp.Error != "restart required to apply: socket_path, db_path" {
+		t.Errorf("reload with restart-only changes = %+v, want ERR_RESTART_REQUIRED", resp)
+	}
+	if !slices.Equal(resp.Changed, []string{"max_unsynced_rows"}) {
+		t.Errorf("Changed = %v, want the keys that were still applied", resp.Changed)
+	}
+
+	result = func() ([]string, error) { return nil, errors.New("parse config: bad toml") }
+	resp = sendAndRecv(t, conn, Request{Type: "reload"})
+	if resp.OK || resp.Code != ErrReloadFailed || len(resp.Changed) != 0 {
+		t.Errorf("failed reload = %+v, want ERR_RELOAD_FAILED", resp)
+	}
+}
@@ -0,0 +1,162 @@
+package socket
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"time"
+)
+
+// session is an activity whose end isn't known yet. A client opens it with
+// "session_start" and keeps it alive with "heartbeat"s; "session_end"
+// records it as ending now. If the connection drops first (the editor
+// crashed or was killed), it is recorded as ending at the last heartbeat
+// rather than lost.
+type session struct {
+	data      ActivityData
+	startedAt time.Time
+	lastSeen  time.Time
+}
+
+// HeartbeatData optionally updates an open session's running line,
+// keystroke and edit counts, so they survive a crash along with the elapsed
+// time.
+type HeartbeatData struct {
+	LinesAdded   *int `json:"lines_added"`
+	LinesRemoved *int `json:"lines_removed"`
+	Keystrokes   *int `json:"keystrokes"`
+	Edits        *int `json:"edits"`
+}
+
+// handleSessionStart opens a session on conn. started_at defaults to now
+// and ended_at is ignored. A session already open on conn is ended now, so
+// a client can simply start a new session on every file switch.
+func (s *Server) handleSessionStart(conn net.Conn, data json.RawMessage) Response {
+	var ad ActivityData
+	if err := json.Unmarshal(data, &ad); err != nil {
+		s.rejected.invalidJSON.Add(1)
+		return Response{OK: false, Error: "invalid session data", Code: ErrInvalidActivity}
+	}
+
+	now := time.Now()
+	startedAt := now
+	if ad.StartedAt != "" {
+		t, err := parseTimestamp("started_at", ad.StartedAt)
+		if err != nil {
+			s.rejected.invalidTimestamp.Add(1)
+			return Response{OK: false, Error: err.Error(), Code: ErrInvalidActivity}
+		}
+		startedAt = t
+	}
+	// Validate now rather than when the session is finalized, when there
+	// is nobody left to tell.
+	if _, err := normalizeMetadata(ad.Metadata); err != nil {
+		s.rejected.invalidJSON.Add(1)
+		return Response{OK: false, Error: err.Error(), Code: ErrInvalidActivity}
+	}
+	if _, err := normalizeClientUUID(ad.ClientUUID); err != nil {
+		s.rejected.invalidJSON.Add(1)
+		return Response{OK: false, Error: err.Error(), Code: ErrInvalidActivity}
+	}
+
+	s.sessionMu.Lock()
+	prev := s.sessions[conn]
+	s.sessions[conn] = &session{data: ad, startedAt: startedAt, lastSeen: now}
+	s.sessionMu.Unlock()
+
+	if prev != nil {
+		prev.lastSeen = now
+		if resp := s.finalizeSession(prev); !resp.OK {
+			return resp
+		}
+	}
+	return Response{OK: true}
+}
+
+// handleHeartbeat marks conn's session as still active.
+func (s *Server) handleHeartbeat(conn net.Conn, data json.RawMessage) Response {
+	var hb HeartbeatData
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &hb); err != nil {
+			return Response{OK: false, Error: "invalid heartbeat data", Code: ErrInvalidJSON}
+		}
+	}
+
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	sess := s.sessions[conn]
+	if sess == nil {
+		return Response{OK: false, Error: "no open session", Code: ErrNoSession}
+	}
+	sess.lastSeen = time.Now()
+	if hb.LinesAdded != nil {
+		sess.data.LinesAdded = *hb.LinesAdded
+	}
+	if hb.LinesRemoved != nil {
+		sess.data.LinesRemoved = *hb.LinesRemoved
+	}
+	if hb.Keystrokes != nil {
+		sess.data.Keystrokes = *hb.Keystrokes
+	}
+	if hb.Edits != nil {
+		sess.data.Edits = *hb.Edits
+	}
+	return Response{OK: true}
+}
+
+// handleSessionEnd records conn's session as ending now.
+func (s *Server) handleSessionEnd(conn net.Conn) Response {
+	s.sessionMu.Lock()
+	sess := s.sessions[conn]
+	delete(s.sessions, conn)
+	s.sessionMu.Unlock()
+
+	if sess == nil {
+		return Response{OK: false, Error: "no open session", Code: ErrNoSession}
+	}
+	sess.lastSeen = time.Now()
+	return s.finalizeSession(sess)
+}
+
+// abandonSession records conn's open session, if any, as ending at its
+// last heartbeat.
+func (s *Server) abandonSession(conn net.Conn) {
+	s.sessionMu.Lock()
+	sess := s.sessions[conn]
+	delete(s.sessions, conn)
+	s.sessionMu.Unlock()
+
+	if sess == nil {
+		return
+	}
+	if resp := s.finalizeSession(sess); !resp.OK {
+		log.Printf("record abandoned session: %s", resp.Error)
+	}
+}
+
+// abandonAllSessions finalizes every open session, for shutdown.
+func (s *Server) abandonAllSessions() {
+	s.sessionMu.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[net.Conn]*session)
+	s.sessionMu.Unlock()
+
+	for _, sess := range sessions {
+		if resp := s.finalizeSession(sess); !resp.OK {
+			log.Printf("record open session on shutdown: %s", resp.Error)
+		}
+	}
+}
+
+// finalizeSession stores sess as a normal activity ending at its last
+// heartbeat. A session that never outlived its start has nothing to record.
+func (s *Server) finalizeSession(sess *session) Response {
+	if !sess.lastSeen.After(sess.startedAt) {
+		return Response{OK: true}
+	}
+	activity, err := s.newActivity(sess.data, sess.startedAt, sess.lastSeen)
+	if err != nil {
+		return Response{OK: false, Error: err.Error(), Code: ErrInvalidActivity}
+	}
+	return s.store(activity)
+}
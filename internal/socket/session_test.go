@@ -0,0 +1,98 @@
+package socket
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/taigrr/blastd/internal/db"
+)
+
+func sessionStart(startedAt time.Time) map[string]any {
+	return map[string]any{
+		"type": "session_start",
+		"data": map[string]any{
+			"project":    "blast",
+			"filetype":   "go",
+			"started_at": startedAt.Format(time.RFC3339),
+		},
+	}
+}
+
+// waitForActivities polls until n activities are stored, since abandoned
+// sessions are recorded asynchronously when the connection closes.
+func waitForActivities(t *testing.T, database *db.DB, n int) []*db.Activity {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		activities, err := database.GetUnsyncedActivities(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(activities) >= n || time.Now().After(deadline) {
+			if len(activities) != n {
+				t.Fatalf("got %d activities, want %d", len(activities), n)
+			}
+			return activities
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionFinalizedOnDisconnect(t *testing.T) {
+	server, database := setupTestSocket(t)
+	conn, err := net.Dial("unix", server.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-10 * time.Minute)
+	if resp := sendAndRecv(t, conn, sessionStart(start)); !resp.OK {
+		t.Fatalf("session_start: %+v", resp)
+	}
+	resp := sendAndRecv(t, conn, map[string]any{"type": "heartbeat", "data": map[string]int{"lines_added": 7}})
+	if !resp.OK {
+		t.Fatalf("heartbeat: %+v", resp)
+	}
+	heartbeat := time.Now()
+
+	// The editor crashes without ending its session.
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	a := waitForActivities(t, database, 1)[0]
+	if a.Project != "blast" || a.LinesAdded != 7 {
+		t.Errorf("activity = %+v, want project blast with 7 lines added", a)
+	}
+	if !a.StartedAt.Equal(start.Truncate(time.Second)) {
+		t.Errorf("StartedAt = %v, want %v", a.StartedAt, start)
+	}
+	if a.EndedAt.After(heartbeat) || heartbeat.Sub(a.EndedAt) > time.Second {
+		t.Errorf("EndedAt = %v, want the last heartbeat (%v)", a.EndedAt, heartbeat)
+	}
+}
+
+func TestSessionEnd(t *testing.T) {
+	server, database := setupTestSocket(t)
+	conn := dial(t, server)
+
+	if resp := sendAndRecv(t, conn, sessionStart(time.Now().Add(-time.Minute))); !resp.OK {
+		t.Fatalf("session_start: %+v", resp)
+	}
+	// Starting another session ends the first.
+	if resp := sendAndRecv(t, conn, sessionStart(time.Now().Add(-time.Second))); !resp.OK {
+		t.Fatalf("second session_start: %+v", resp)
+	}
+	waitForActivities(t, database, 1)
+
+	if resp := sendAndRecv(t, conn, map[string]string{"type": "session_end"}); !resp.OK {
+		t.Fatalf("session_end: %+v", resp)
+	}
+	waitForActivities(t, database, 2)
+
+	resp := sendAndRecv(t, conn, map[string]string{"type": "heartbeat"})
+	if resp.OK || resp.Code != ErrNoSession {
+		t.Errorf("heartbeat without a session = %+v, want %s", resp, ErrNoSession)
+	}
+}
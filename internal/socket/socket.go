@@ -2,70 +2,223 @@ package socket
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/taigrr/blastd/internal/config"
 	"github.com/taigrr/blastd/internal/db"
+	blastsync "github.com/taigrr/blastd/internal/sync"
 )
 
+// Request is one line sent by a client. ID is optional and opaque: any
+// JSON value a client sets is echoed verbatim in the Response, so clients
+// pipelining requests can correlate replies. Responses are written in
+// request order either way.
 type Request struct {
+	ID   json.RawMessage `json:"id,omitempty"`
 	Type string          `json:"type"`
 	Data json.RawMessage `json:"data"`
 }
 
 type Response struct {
-	OK       bool   `json:"ok"`
-	Error    string `json:"error,omitempty"`
-	Message  string `json:"message,omitempty"`
-	Total    *int64 `json:"total,omitempty"`
-	Unsynced *int64 `json:"unsynced,omitempty"`
+	ID         json.RawMessage `json:"id,omitempty"`
+	OK         bool            `json:"ok"`
+	Error      string          `json:"error,omitempty"`
+	Code       string          `json:"code,omitempty"`
+	Message    string          `json:"message,omitempty"`
+	Total      *int64          `json:"total,omitempty"`
+	Unsynced   *int64          `json:"unsynced,omitempty"`
+	LastSyncAt string          `json:"last_sync_at,omitempty"`
+
+	SyncWarning *blastsync.Warning `json:"sync_warning,omitempty"`
+
+	Config    *config.Config            `json:"config,omitempty"`
+	Changed   []string                  `json:"changed,omitempty"`
+	Editors   map[string]db.EditorStats `json:"editors,omitempty"`
+	Filetypes map[string]db.LineStats   `json:"filetypes,omitempty"`
+	Days      []db.DayStats             `json:"days,omitempty"`
+	Rejected  map[string]int64          `json:"rejected,omitempty"`
+	History   []blastsync.Attempt       `json:"history,omitempty"`
 }
 
+// Error codes returned in Response.Code. These are stable identifiers for
+// clients to match on; Response.Error carries the human-readable detail.
+const (
+	ErrInvalidJSON     = "ERR_INVALID_JSON"
+	ErrFraming         = "ERR_FRAMING"
+	ErrUnknownType     = "ERR_UNKNOWN_TYPE"
+	ErrInvalidActivity = "ERR_INVALID_ACTIVITY"
+	ErrRateLimited     = "ERR_RATE_LIMITED"
+	ErrSyncUnavailable = "ERR_SYNC_UNAVAILABLE"
+	ErrSyncFailed      = "ERR_SYNC_FAILED"
+	ErrInternal        = "ERR_INTERNAL"
+	ErrQueueFull       = "ERR_QUEUE_FULL"
+	ErrNoSession       = "ERR_NO_SESSION"
+	ErrStorageFull     = "ERR_STORAGE_FULL"
+	ErrForbidden       = "ERR_FORBIDDEN"
+	ErrReloadFailed    = "ERR_RELOAD_FAILED"
+	ErrRestartRequired = "ERR_RESTART_REQUIRED"
+)
+
+// OverflowPolicy decides what happens when the unsynced backlog is at its cap.
+type OverflowPolicy string
+
+const (
+	DropOldest OverflowPolicy = "drop-oldest"
+	RejectNew  OverflowPolicy = "reject-new"
+)
+
 type ActivityData struct {
-	Project          string  `json:"project"`
-	GitRemote        string  `json:"git_remote"`
-	StartedAt        string  `json:"started_at"`
-	EndedAt          string  `json:"ended_at"`
-	Filename         string  `json:"filename"`
-	Filetype         string  `json:"filetype"`
-	LinesAdded       int     `json:"lines_added"`
-	LinesRemoved     int     `json:"lines_removed"`
-	GitBranch        string  `json:"git_branch"`
-	ActionsPerMinute float64 `json:"actions_per_minute"`
-	WordsPerMinute   float64 `json:"words_per_minute"`
-	Editor           string  `json:"editor"`
+	Project          string          `json:"project"`
+	GitRemote        string          `json:"git_remote"`
+	StartedAt        string          `json:"started_at"`
+	EndedAt          string          `json:"ended_at"`
+	Filename         string          `json:"filename"`
+	Filetype         string          `json:"filetype"`
+	LinesAdded       int             `json:"lines_added"`
+	LinesRemoved     int             `json:"lines_removed"`
+	GitBranch        string          `json:"git_branch"`
+	GitCommit        string          `json:"git_commit"`
+	Tags             []string        `json:"tags"`
+	Metadata         json.RawMessage `json:"metadata"`
+	ActionsPerMinute float64         `json:"actions_per_minute"`
+	WordsPerMinute   float64         `json:"words_per_minute"`
+	Keystrokes       int             `json:"keystrokes"`
+	Edits            int             `json:"edits"`
+	Editor           string          `json:"editor"`
+	Source           string          `json:"source"`
+	ClientUUID       string          `json:"client_uuid"`
+	Machine          string          `json:"machine"`
 }
 
 type SyncFunc func() error
 
+// SyncHistoryFunc returns the syncer's recent attempts, oldest first.
+type SyncHistoryFunc func() []blastsync.Attempt
+
+// SyncWarningFunc returns the server's most recent sync warning, or nil.
+type SyncWarningFunc func() *blastsync.Warning
+
+// ReloadFunc re-reads the config and applies what it can, returning the
+// keys it changed. A *config.RestartRequiredError lists keys that changed
+// but need a restart.
+type ReloadFunc func() ([]string, error)
+
+// ActivityFunc is called after an activity has been stored.
+type ActivityFunc func()
+
+// EventFunc receives an Event for each stored activity. It runs on the
+// inserting connection's goroutine, so it must not block.
+type EventFunc func(Event)
+
 type Server struct {
-	path     string
-	db       *db.DB
-	machine  string
-	syncFunc SyncFunc
-	listener net.Listener
-	done     chan struct{}
+	path        string
+	db          *db.DB
+	machine     string
+	syncFunc    SyncFunc
+	syncHistory SyncHistoryFunc
+	syncWarning SyncWarningFunc
+	reload      ReloadFunc
+	onInsert    ActivityFunc
+	onEvent     EventFunc
+	clientUUID  string
+	loc         *time.Location
+	mode        os.FileMode
+	gid         int
+	buffer      *db.Buffer
+	merger      *merger
+	socketCheck time.Duration
+	done        chan struct{}
+
+	// settingsMu guards the options a config reload can change.
+	settingsMu           sync.RWMutex
+	cfg                  *config.Config
+	allowMachineOverride bool
+	maxQueue             int64
+	overflow             OverflowPolicy
+	insertRate           float64
+	insertBurst          int
+	dropZeroDuration     bool
+
+	listenerMu sync.Mutex
+	listener   net.Listener
 
 	rateMu       sync.Mutex
 	syncRequests []time.Time
+
+	sessionMu sync.Mutex
+	sessions  map[net.Conn]*session
+
+	subMu       sync.Mutex
+	subscribers map[net.Conn]chan Event
+
+	rejected rejectCounters
+
+	// storageDownAt is when a write last failed for lack of storage (unix
+	// nanoseconds), or zero while the database is writable.
+	storageDownAt atomic.Int64
+}
+
+// rejectCounters tallies activities turned away since the daemon started,
+// so a misbehaving editor plugin shows up without reading logs.
+type rejectCounters struct {
+	invalidJSON      atomic.Int64
+	invalidTimestamp atomic.Int64
+	queueFull        atomic.Int64
+	storageFull      atomic.Int64
+	rateLimited      atomic.Int64
+}
+
+func (c *rejectCounters) snapshot() map[string]int64 {
+	return map[string]int64{
+		"invalid_json":      c.invalidJSON.Load(),
+		"invalid_timestamp": c.invalidTimestamp.Load(),
+		"queue_full":        c.queueFull.Load(),
+		"storage_full":      c.storageFull.Load(),
+		"rate_limited":      c.rateLimited.Load(),
+	}
 }
 
 const (
 	syncRateLimit  = 10
 	syncRateWindow = 10 * time.Minute
+
+	// rejectLogInterval is how often new rejections are summarized in the
+	// log.
+	rejectLogInterval = 10 * time.Minute
+
+	// maxRequestLine caps a single request line; activities are tiny, so
+	// anything near this is a client bug.
+	maxRequestLine = 1 << 20
 )
 
 func NewServer(path string, database *db.DB, machine string) *Server {
 	return &Server{
-		path:    path,
-		db:      database,
-		machine: machine,
-		done:    make(chan struct{}),
+		path:        path,
+		db:          database,
+		machine:     machine,
+		loc:         time.Local,
+		mode:        0o600,
+		gid:         -1,
+		socketCheck: socketCheckInterval,
+		sessions:    make(map[net.Conn]*session),
+		subscribers: make(map[net.Conn]chan Event),
+		done:        make(chan struct{}),
 	}
 }
 
@@ -73,52 +226,323 @@ func (s *Server) SetSyncFunc(fn SyncFunc) {
 	s.syncFunc = fn
 }
 
+// SetSyncHistoryFunc registers fn to answer "sync_history" requests.
+func (s *Server) SetSyncHistoryFunc(fn SyncHistoryFunc) {
+	s.syncHistory = fn
+}
+
+// SetSyncWarningFunc registers fn to report the latest sync warning in
+// "status" responses.
+func (s *Server) SetSyncWarningFunc(fn SyncWarningFunc) {
+	s.syncWarning = fn
+}
+
+// SetActivityFunc registers fn to be called after each successful insert.
+func (s *Server) SetActivityFunc(fn ActivityFunc) {
+	s.onInsert = fn
+}
+
+// SetEventFunc registers fn to receive every stored activity, e.g. to
+// forward it to a webhook.
+func (s *Server) SetEventFunc(fn EventFunc) {
+	s.onEvent = fn
+}
+
+// SetConfig gives the server the effective config to report for "config"
+// requests. The API token is redacted in responses.
+func (s *Server) SetConfig(cfg *config.Config) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.cfg = cfg
+}
+
+// SetLocation sets the timezone that stats periods ("day", "week") are
+// bucketed in. The default is the system's local zone.
+func (s *Server) SetLocation(loc *time.Location) {
+	s.loc = loc
+}
+
+// SetPermissions sets the socket file mode and, when gid >= 0, its group,
+// e.g. to let a shared group talk to the daemon. The default is 0600 with
+// the group left unchanged.
+func (s *Server) SetPermissions(mode os.FileMode, gid int) {
+	s.mode = mode
+	s.gid = gid
+}
+
+// SetClientUUID sets the editor instance recorded for activities whose
+// client doesn't send its own client_uuid.
+func (s *Server) SetClientUUID(id string) {
+	s.clientUUID = id
+}
+
+// SetAllowMachineOverride lets clients set ActivityData.Machine for
+// activities that originate on another host. When false (the default), the
+// daemon's own machine name is always used.
+func (s *Server) SetAllowMachineOverride(allow bool) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.allowMachineOverride = allow
+}
+
+// SetUnsyncedCap limits how many unsynced activities are kept. When the cap
+// is reached, policy either drops the oldest unsynced rows or rejects new
+// activities. max <= 0 means unlimited.
+func (s *Server) SetUnsyncedCap(max int64, policy OverflowPolicy) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.maxQueue = max
+	s.overflow = policy
+}
+
+// unsyncedCap returns the max_unsynced_rows limit and its policy.
+func (s *Server) unsyncedCap() (int64, OverflowPolicy) {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.maxQueue, s.overflow
+}
+
+// SetDropZeroDuration makes activity requests whose ended_at is not after
+// started_at succeed without being stored. By default they are stored with
+// a zero duration.
+func (s *Server) SetDropZeroDuration(drop bool) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.dropZeroDuration = drop
+}
+
+// SetInsertRateLimit caps activity requests on each connection at perSecond
+// on average, allowing bursts of up to burst, so a runaway plugin can't
+// flood the database. perSecond <= 0 means unlimited. A change applies to
+// connections opened afterwards.
+func (s *Server) SetInsertRateLimit(perSecond float64, burst int) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	s.insertRate = perSecond
+	s.insertBurst = burst
+}
+
+// newInsertLimiter returns a fresh per-connection activity limiter, or nil
+// when inserts are unlimited.
+func (s *Server) newInsertLimiter() *tokenBucket {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	if s.insertRate <= 0 {
+		return nil
+	}
+	return newTokenBucket(s.insertRate, s.insertBurst, time.Now())
+}
+
 func (s *Server) Start() error {
-	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+	if config.IsAbstractSocket(s.path) {
+		return s.startAbstract()
+	}
+
+	if err := ProbeSocket(s.path); err != nil {
+		return err
+	}
+	listener, err := s.listen()
+	if err != nil {
 		return err
 	}
+	s.setListener(listener)
+
+	go s.accept(listener)
+	go s.logRejections(rejectLogInterval)
+	go s.watchSocket()
+	return nil
+}
+
+// listen (re)creates the socket file and applies its permissions. The
+// caller has already checked that no live process owns the path.
+func (s *Server) listen() (net.Listener, error) {
+	if err := s.makeSocketDir(); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
 
 	listener, err := net.Listen("unix", s.path)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	s.listener = listener
 
-	if err := os.Chmod(s.path, 0o600); err != nil {
+	if err := os.Chmod(s.path, s.mode); err != nil {
 		if closeErr := listener.Close(); closeErr != nil {
-			return fmt.Errorf("chmod socket: %w (close listener: %v)", err, closeErr)
+			return nil, fmt.Errorf("chmod socket: %w (close listener: %v)", err, closeErr)
+		}
+		return nil, err
+	}
+
+	if s.gid >= 0 {
+		if err := os.Chown(s.path, -1, s.gid); err != nil {
+			if closeErr := listener.Close(); closeErr != nil {
+				return nil, fmt.Errorf("chown socket: %w (close listener: %v)", err, closeErr)
+			}
+			return nil, fmt.Errorf("chown socket: %w", err)
+		}
+	}
+	return listener, nil
+}
+
+// makeSocketDir creates the socket's directory if it is missing. It is
+// private to us unless the socket mode or socket_group lets others connect,
+// which also needs search permission on the directory: then the group, or
+// everyone, gets traverse (but not list) access, and the directory takes
+// the socket's group. An existing directory is left as it is.
+func (s *Server) makeSocketDir() error {
+	dir := filepath.Dir(s.path)
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	mode := os.FileMode(0o700)
+	if s.gid >= 0 || s.mode&0o070 != 0 {
+		mode |= 0o010
+	}
+	if s.mode&0o007 != 0 {
+		mode |= 0o001
+	}
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return fmt.Errorf("create socket directory %s: %w", dir, err)
+	}
+	// MkdirAll's mode is filtered through the umask.
+	if err := os.Chmod(dir, mode); err != nil {
+		return fmt.Errorf("chmod socket directory %s: %w", dir, err)
+	}
+	if s.gid >= 0 {
+		if err := os.Chown(dir, -1, s.gid); err != nil {
+			return fmt.Errorf("chown socket directory %s: %w", dir, err)
 		}
+	}
+	return nil
+}
+
+// startAbstract listens on a Linux abstract socket. There is no file to
+// create, remove or chmod; the kernel frees the name when the listener
+// closes, and access is limited only by the network namespace.
+func (s *Server) startAbstract() error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("abstract socket %s: only supported on Linux", s.path)
+	}
+	if err := ProbeSocket(s.path); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
 		return err
 	}
+	s.setListener(listener)
 
-	go s.accept()
+	go s.accept(listener)
+	go s.logRejections(rejectLogInterval)
 	return nil
 }
 
+// logRejections periodically logs how many activities were rejected since
+// the last report, staying quiet while nothing is rejected.
+func (s *Server) logRejections(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := s.rejected.snapshot()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			current := s.rejected.snapshot()
+			var parts []string
+			for _, reason := range []string{"invalid_json", "invalid_timestamp", "queue_full", "storage_full", "rate_limited"} {
+				if n := current[reason] - last[reason]; n > 0 {
+					parts = append(parts, fmt.Sprintf("%s=%d", reason, n))
+				}
+			}
+			if len(parts) > 0 {
+				log.Printf("rejected activities in the last %s: %s", interval, strings.Join(parts, " "))
+			}
+			last = current
+		}
+	}
+}
+
+// ErrSocketInUse is returned by Start when something is still listening on
+// the socket path, e.g. another blastd sharing the same socket_path.
+var ErrSocketInUse = errors.New("socket is in use")
+
+// ProbeSocket checks whether a socket file at path is stale, e.g. before
+// Start removes it. A socket that accepts connections belongs to a live
+// process and is left alone: ProbeSocket returns ErrSocketInUse. Only
+// refused or missing sockets return nil.
+func ProbeSocket(path string) error {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("probe existing socket %s: %w", path, err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(time.Second)); err != nil {
+		return fmt.Errorf("%w: %s", ErrSocketInUse, path)
+	}
+	var resp Response
+	if _, err := conn.Write([]byte(`{"type":"ping"}` + "\n")); err == nil {
+		line, err := bufio.NewReader(conn).ReadBytes('\n')
+		if err == nil && json.Unmarshal(line, &resp) == nil && resp.OK {
+			return fmt.Errorf("%w: another blastd is already listening on %s", ErrSocketInUse, path)
+		}
+	}
+	return fmt.Errorf("%w: another process is listening on %s", ErrSocketInUse, path)
+}
+
 func (s *Server) Stop() {
 	close(s.done)
-	if s.listener != nil {
-		if err := s.listener.Close(); err != nil {
-			log.Printf("close listener: %v", err)
+	// A server that never started doesn't own the path; it may be another
+	// daemon's live socket.
+	listener := s.currentListener()
+	if listener == nil {
+		return
+	}
+	if err := listener.Close(); err != nil {
+		log.Printf("close listener: %v", err)
+	}
+	s.abandonAllSessions()
+	if s.merger != nil {
+		s.merger.flush()
+	}
+	if s.buffer != nil {
+		if err := s.buffer.Close(); err != nil {
+			log.Printf("flush write buffer: %v", err)
 		}
 	}
+	if config.IsAbstractSocket(s.path) {
+		return
+	}
 	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
 		log.Printf("remove socket: %v", err)
 	}
 }
 
-func (s *Server) accept() {
+// accept serves listener until the server stops or the listener is
+// replaced by watchSocket.
+func (s *Server) accept(listener net.Listener) {
 	for {
 		select {
 		case <-s.done:
 			return
 		default:
-			conn, err := s.listener.Accept()
+			conn, err := listener.Accept()
 			if err != nil {
 				select {
 				case <-s.done:
 					return
 				default:
+					if errors.Is(err, net.ErrClosed) {
+						return
+					}
 					log.Printf("accept error: %v", err)
 					continue
 				}
@@ -130,72 +554,181 @@ func (s *Server) accept() {
 
 func (s *Server) handle(conn net.Conn) {
 	defer func() {
+		// A session the client never ended (editor crash, killed plugin)
+		// still counts up to its last heartbeat.
+		s.abandonSession(conn)
 		if err := conn.Close(); err != nil {
 			log.Printf("close connection: %v", err)
 		}
 	}()
 
 	scanner := bufio.NewScanner(conn)
-	encoder := json.NewEncoder(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRequestLine)
+	limiter := s.newInsertLimiter()
 
 	for scanner.Scan() {
-		var req Request
-		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
-			if encodeErr := encoder.Encode(Response{OK: false, Error: "invalid json"}); encodeErr != nil {
-				log.Printf("encode response: %v", encodeErr)
-				return
-			}
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
 			continue
 		}
 
-		switch req.Type {
-		case "activity":
-			s.handleActivity(req.Data, encoder)
-		case "sync":
-			s.handleSync(encoder)
-		case "status":
-			s.handleStatus(encoder)
-		case "ping":
-			if err := encoder.Encode(Response{OK: true}); err != nil {
-				log.Printf("encode response: %v", err)
-				return
-			}
-		default:
-			if err := encoder.Encode(Response{OK: false, Error: "unknown request type"}); err != nil {
-				log.Printf("encode response: %v", err)
-				return
+		// A request split over several lines (e.g. pretty-printed JSON) can't
+		// be resynchronized line by line, so report it once and hang up
+		// instead of answering every fragment with ERR_INVALID_JSON.
+		framingErr := isTruncatedJSON(line)
+		resp := Response{OK: false, Error: "request must be a single line of compact JSON", Code: ErrFraming}
+		if !framingErr {
+			resp = s.dispatch(conn, line, limiter)
+		}
+		if err := writeResponse(conn, resp); err != nil {
+			// The client went away before reading its reply; anything it
+			// sent after this request would go unanswered too.
+			if !isDisconnect(err) {
+				log.Printf("write response: %v", err)
 			}
+			return
+		}
+		if framingErr {
+			return
+		}
+		if events := s.subscription(conn); events != nil {
+			s.streamEvents(conn, events)
+			return
+		}
+	}
+
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		resp := Response{OK: false, Error: fmt.Sprintf("request line exceeds %d bytes", maxRequestLine), Code: ErrFraming}
+		if err := writeResponse(conn, resp); err != nil && !isDisconnect(err) {
+			log.Printf("write response: %v", err)
 		}
 	}
 }
 
-func (s *Server) handleSync(encoder *json.Encoder) {
-	if s.syncFunc == nil {
-		if err := encoder.Encode(Response{OK: false, Error: "sync not available"}); err != nil {
-			log.Printf("encode response: %v", err)
+// isTruncatedJSON reports whether line opens a JSON object or array that
+// doesn't close on the same line.
+func isTruncatedJSON(line []byte) bool {
+	trimmed := bytes.TrimSpace(line)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return false
+	}
+	var v json.RawMessage
+	err := json.Unmarshal(trimmed, &v)
+	var syntaxErr *json.SyntaxError
+	return errors.As(err, &syntaxErr) && syntaxErr.Offset >= int64(len(trimmed))
+}
+
+// dispatch decodes a single request line from conn and returns its
+// response, carrying the request's ID. limiter is conn's activity rate
+// limiter. A handler that panics is logged with its stack and answered
+// with ERR_INTERNAL; the connection stays open.
+func (s *Server) dispatch(conn net.Conn, line []byte, limiter *tokenBucket) (resp Response) {
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		return Response{OK: false, Error: "invalid json", Code: ErrInvalidJSON}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic handling %q request: %v\n%s", req.Type, r, debug.Stack())
+			resp = Response{ID: req.ID, OK: false, Error: "internal error", Code: ErrInternal}
 		}
-		return
+	}()
+	resp = s.route(conn, req, limiter)
+	resp.ID = req.ID
+	return resp
+}
+
+// route runs the handler for req.Type.
+func (s *Server) route(conn net.Conn, req Request, limiter *tokenBucket) Response {
+	switch req.Type {
+	case "activity":
+		return s.handleActivity(req.Data, limiter)
+	case "sync":
+		return s.handleSync()
+	case "sync_history":
+		return s.handleSyncHistory()
+	case "status":
+		return s.handleStatus(req.Data)
+	case "config":
+		return s.handleConfig()
+	case "stats":
+		return s.handleStats(req.Data)
+	case "session_start":
+		return s.handleSessionStart(conn, req.Data)
+	case "heartbeat":
+		return s.handleHeartbeat(conn, req.Data)
+	case "session_end":
+		return s.handleSessionEnd(conn)
+	case "subscribe":
+		return s.handleSubscribe(conn)
+	case "flush":
+		return s.handleFlush()
+	case "reload":
+		return s.handleReload(conn)
+	case "ping":
+		return Response{OK: true}
+	default:
+		return Response{OK: false, Error: "unknown request type", Code: ErrUnknownType}
+	}
+}
+
+// writeResponse writes resp as one newline-terminated JSON line.
+func writeResponse(w io.Writer, resp Response) error {
+	return writeLine(w, resp)
+}
+
+// writeLine marshals v and writes it plus its newline in a single Write,
+// so line-scanning clients never see a partial frame or two frames
+// interleaved. A writer that accepts only part of the line without an
+// error gets io.ErrShortWrite; the caller must then drop the connection,
+// since the stream can no longer be framed.
+func writeLine(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	n, err := w.Write(data)
+	if err == nil && n < len(data) {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+// isDisconnect reports whether err means the peer closed the connection.
+func isDisconnect(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, net.ErrClosed)
+}
+
+func (s *Server) handleSync() Response {
+	if s.syncFunc == nil {
+		return Response{OK: false, Error: "sync not available", Code: ErrSyncUnavailable}
 	}
 
 	if err := s.checkSyncRateLimit(); err != nil {
-		if encodeErr := encoder.Encode(Response{OK: false, Error: err.Error()}); encodeErr != nil {
-			log.Printf("encode response: %v", encodeErr)
-		}
-		return
+		return Response{OK: false, Error: err.Error(), Code: ErrRateLimited}
 	}
 
 	s.recordSyncRequest()
 
+	if err := s.flushBuffer(); err != nil {
+		log.Printf("flush write buffer before sync: %v", err)
+	}
 	if err := s.syncFunc(); err != nil {
-		if encodeErr := encoder.Encode(Response{OK: false, Error: err.Error()}); encodeErr != nil {
-			log.Printf("encode response: %v", encodeErr)
-		}
-		return
+		return Response{OK: false, Error: err.Error(), Code: ErrSyncFailed}
 	}
 
-	if err := encoder.Encode(Response{OK: true, Message: "sync complete"}); err != nil {
-		log.Printf("encode response: %v", err)
+	return Response{OK: true, Message: "sync complete"}
+}
+
+// handleSyncHistory reports the recent sync attempts, so a sync that keeps
+// failing in the background is visible without reading the logs.
+func (s *Server) handleSyncHistory() Response {
+	if s.syncHistory == nil {
+		return Response{OK: false, Error: "sync not available", Code: ErrSyncUnavailable}
 	}
+	return Response{OK: true, History: s.syncHistory()}
 }
 
 func (s *Server) checkSyncRateLimit() error {
@@ -227,46 +760,210 @@ func (s *Server) recordSyncRequest() {
 	s.syncRequests = append(s.syncRequests, time.Now())
 }
 
-func (s *Server) handleStatus(encoder *json.Encoder) {
-	stats, err := s.db.GetStats()
+// StatusData optionally narrows a status request to activities carrying Tag.
+type StatusData struct {
+	Tag string `json:"tag"`
+}
+
+func (s *Server) handleStatus(data json.RawMessage) Response {
+	var sd StatusData
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &sd); err != nil {
+			return Response{OK: false, Error: "invalid status data", Code: ErrInvalidJSON}
+		}
+	}
+
+	var stats *db.Stats
+	var err error
+	if sd.Tag != "" {
+		stats, err = s.db.GetStatsByTag(sd.Tag)
+	} else {
+		stats, err = s.db.GetStats()
+	}
 	if err != nil {
-		encoder.Encode(Response{OK: false, Error: err.Error()})
-		return
+		return Response{OK: false, Error: err.Error(), Code: ErrInternal}
 	}
-	encoder.Encode(Response{OK: true, Total: &stats.Total, Unsynced: &stats.Unsynced})
+	resp := Response{OK: true, Total: &stats.Total, Unsynced: &stats.Unsynced}
+
+	lastSync, err := s.db.LastSyncAt()
+	if err != nil {
+		return Response{OK: false, Error: err.Error(), Code: ErrInternal}
+	}
+	if !lastSync.IsZero() {
+		resp.LastSyncAt = lastSync.Format(time.RFC3339)
+	}
+	if s.syncWarning != nil {
+		resp.SyncWarning = s.syncWarning()
+	}
+	return resp
 }
 
-func (s *Server) handleActivity(data json.RawMessage, encoder *json.Encoder) {
-	var ad ActivityData
-	if err := json.Unmarshal(data, &ad); err != nil {
-		if encodeErr := encoder.Encode(Response{OK: false, Error: "invalid activity data"}); encodeErr != nil {
-			log.Printf("encode response: %v", encodeErr)
+// StatsData optionally bounds a stats request to activities that started
+// within [Since, Until). Both are RFC3339 timestamps. Alternatively Period
+// selects the current "day" or "week" (starting Monday) in the server's
+// timezone. ByDay adds per-day totals in that timezone.
+type StatsData struct {
+	Since  string `json:"since"`
+	Until  string `json:"until"`
+	Period string `json:"period"`
+	ByDay  bool   `json:"by_day"`
+}
+
+func (s *Server) handleStats(data json.RawMessage) Response {
+	var sd StatsData
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &sd); err != nil {
+			return Response{OK: false, Error: "invalid stats data", Code: ErrInvalidJSON}
 		}
-		return
 	}
 
-	startedAt, err := time.Parse(time.RFC3339, ad.StartedAt)
-	if err != nil {
-		if encodeErr := encoder.Encode(Response{OK: false, Error: "invalid started_at"}); encodeErr != nil {
-			log.Printf("encode response: %v", encodeErr)
+	var since, until time.Time
+	var err error
+	if sd.Since != "" {
+		if since, err = time.Parse(time.RFC3339, sd.Since); err != nil {
+			return Response{OK: false, Error: "invalid since", Code: ErrInvalidJSON}
+		}
+	}
+	if sd.Until != "" {
+		if until, err = time.Parse(time.RFC3339, sd.Until); err != nil {
+			return Response{OK: false, Error: "invalid until", Code: ErrInvalidJSON}
+		}
+	}
+	if sd.Period != "" {
+		if sd.Since != "" || sd.Until != "" {
+			return Response{OK: false, Error: "period can't be combined with since/until", Code: ErrInvalidJSON}
+		}
+		if since, until, err = periodRange(sd.Period, time.Now().In(s.loc)); err != nil {
+			return Response{OK: false, Error: err.Error(), Code: ErrInvalidJSON}
 		}
-		return
 	}
 
-	endedAt, err := time.Parse(time.RFC3339, ad.EndedAt)
+	editors, err := s.db.StatsByEditor(since, until)
+	if err != nil {
+		return Response{OK: false, Error: err.Error(), Code: ErrInternal}
+	}
+	filetypes, err := s.db.LinesByFiletype(since, until)
 	if err != nil {
-		if encodeErr := encoder.Encode(Response{OK: false, Error: "invalid ended_at"}); encodeErr != nil {
-			log.Printf("encode response: %v", encodeErr)
+		return Response{OK: false, Error: err.Error(), Code: ErrInternal}
+	}
+	resp := Response{OK: true, Editors: editors, Filetypes: filetypes, Rejected: s.rejected.snapshot()}
+	if sd.ByDay {
+		if resp.Days, err = s.db.StatsByDay(since, until, s.loc); err != nil {
+			return Response{OK: false, Error: err.Error(), Code: ErrInternal}
 		}
-		return
+	}
+	return resp
+}
+
+// periodRange returns the bounds of the day or week containing now, in
+// now's location.
+func periodRange(period string, now time.Time) (time.Time, time.Time, error) {
+	y, m, d := now.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	switch period {
+	case "day":
+		return day, day.AddDate(0, 0, 1), nil
+	case "week":
+		monday := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		return monday, monday.AddDate(0, 0, 7), nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid period %q: want \"day\" or \"week\"", period)
+	}
+}
+
+func (s *Server) handleConfig() Response {
+	s.settingsMu.RLock()
+	cfg := s.cfg
+	s.settingsMu.RUnlock()
+	if cfg == nil {
+		return Response{OK: false, Error: "config not available", Code: ErrInternal}
+	}
+
+	redacted := cfg.Redacted()
+	return Response{OK: true, Config: &redacted}
+}
+
+func (s *Server) handleActivity(data json.RawMessage, limiter *tokenBucket) Response {
+	if !limiter.allow(time.Now()) {
+		s.rejected.rateLimited.Add(1)
+		return Response{OK: false, Error: fmt.Sprintf("activity rate limit exceeded (%g per second)", limiter.rate), Code: ErrRateLimited}
+	}
+
+	var ad ActivityData
+	if err := json.Unmarshal(data, &ad); err != nil {
+		s.rejected.invalidJSON.Add(1)
+		return Response{OK: false, Error: "invalid activity data", Code: ErrInvalidActivity}
+	}
+
+	startedAt, err := parseTimestamp("started_at", ad.StartedAt)
+	if err != nil {
+		s.rejected.invalidTimestamp.Add(1)
+		return Response{OK: false, Error: err.Error(), Code: ErrInvalidActivity}
+	}
+
+	endedAt, err := parseTimestamp("ended_at", ad.EndedAt)
+	if err != nil {
+		s.rejected.invalidTimestamp.Add(1)
+		return Response{OK: false, Error: err.Error(), Code: ErrInvalidActivity}
+	}
+
+	s.settingsMu.RLock()
+	dropZero := s.dropZeroDuration
+	s.settingsMu.RUnlock()
+	if dropZero && !endedAt.After(startedAt) {
+		return Response{OK: true, Message: "zero-duration activity skipped"}
 	}
 
+	activity, err := s.newActivity(ad, startedAt, endedAt)
+	if err != nil {
+		s.rejected.invalidJSON.Add(1)
+		return Response{OK: false, Error: err.Error(), Code: ErrInvalidActivity}
+	}
+	return s.store(activity)
+}
+
+// newActivity builds the row for ad, applying the source, editor and
+// client UUID defaults and the machine override policy.
+func (s *Server) newActivity(ad ActivityData, startedAt, endedAt time.Time) (*db.Activity, error) {
+	metadata, err := normalizeMetadata(ad.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	instance, err := normalizeClientUUID(ad.ClientUUID)
+	if err != nil {
+		return nil, err
+	}
+	if ad.Keystrokes < 0 || ad.Edits < 0 {
+		return nil, fmt.Errorf("keystrokes and edits must not be negative")
+	}
+	if instance == "" {
+		instance = s.clientUUID
+	}
+
+	source := strings.TrimSpace(ad.Source)
+	switch source {
+	case "":
+		source = db.SourceEditor
+	case db.SourceEditor, db.SourceManual, db.SourceCLI:
+	default:
+		return nil, fmt.Errorf("source must be %q, %q or %q, got %q", db.SourceEditor, db.SourceManual, db.SourceCLI, source)
+	}
+	// blast.nvim doesn't send an editor; entries logged by hand have none.
 	editor := ad.Editor
-	if editor == "" {
+	if editor == "" && source == db.SourceEditor {
 		editor = "neovim"
 	}
 
-	activity := &db.Activity{
+	s.settingsMu.RLock()
+	allowOverride := s.allowMachineOverride
+	s.settingsMu.RUnlock()
+	machine := s.machine
+	if allowOverride && strings.TrimSpace(ad.Machine) != "" {
+		machine = strings.TrimSpace(ad.Machine)
+	}
+
+	return &db.Activity{
 		Project:          ad.Project,
 		GitRemote:        ad.GitRemote,
 		StartedAt:        startedAt,
@@ -276,20 +973,135 @@ func (s *Server) handleActivity(data json.RawMessage, encoder *json.Encoder) {
 		LinesAdded:       ad.LinesAdded,
 		LinesRemoved:     ad.LinesRemoved,
 		GitBranch:        ad.GitBranch,
+		GitCommit:        ad.GitCommit,
+		Tags:             normalizeTags(ad.Tags),
+		Metadata:         metadata,
 		ActionsPerMinute: ad.ActionsPerMinute,
 		WordsPerMinute:   ad.WordsPerMinute,
+		Keystrokes:       ad.Keystrokes,
+		Edits:            ad.Edits,
 		Editor:           editor,
-		Machine:          s.machine,
+		Source:           source,
+		InstanceUUID:     instance,
+		Machine:          machine,
+	}, nil
+}
+
+// store accepts activity, enforcing max_unsynced_rows, and hands it to the
+// merge window if set, or inserts it.
+func (s *Server) store(activity *db.Activity) Response {
+	if s.storageUnavailable() {
+		s.rejected.storageFull.Add(1)
+		return Response{OK: false, Error: "local storage is full or read-only", Code: ErrStorageFull}
+	}
+
+	maxQueue, overflow := s.unsyncedCap()
+	if maxQueue > 0 && overflow == RejectNew {
+		unsynced, err := s.db.UnsyncedCount()
+		if err != nil {
+			return Response{OK: false, Error: err.Error(), Code: ErrInternal}
+		}
+		// Buffered activities were acknowledged and will be stored.
+		if s.buffer != nil {
+			unsynced += int64(s.buffer.Pending())
+		}
+		if unsynced >= maxQueue {
+			s.rejected.queueFull.Add(1)
+			return Response{OK: false, Error: fmt.Sprintf("unsynced queue is full (%d activities)", unsynced), Code: ErrQueueFull}
+		}
+	}
+
+	if s.merger != nil {
+		s.merger.add(activity)
+		return Response{OK: true}
+	}
+	return s.insert(activity)
+}
+
+// insert writes activity through the write buffer, if set, or directly,
+// and notifies the syncer.
+func (s *Server) insert(activity *db.Activity) Response {
+	if s.buffer != nil {
+		s.buffer.Add(activity)
+		return Response{OK: true}
 	}
 
 	if err := s.db.InsertActivity(activity); err != nil {
-		if encodeErr := encoder.Encode(Response{OK: false, Error: err.Error()}); encodeErr != nil {
-			log.Printf("encode response: %v", encodeErr)
+		if db.IsStorageError(err) {
+			s.markStorageDown(err)
+			s.rejected.storageFull.Add(1)
+			return Response{OK: false, Error: "local storage is full or read-only", Code: ErrStorageFull}
+		}
+		return Response{OK: false, Error: err.Error(), Code: ErrInternal}
+	}
+	s.markStorageUp()
+	s.afterInsert(activity)
+
+	return Response{OK: true}
+}
+
+// afterInsert enforces max_unsynced_rows, publishes the stored activities
+// to subscribers and notifies the syncer once.
+func (s *Server) afterInsert(activities ...*db.Activity) {
+	if maxQueue, overflow := s.unsyncedCap(); maxQueue > 0 && overflow == DropOldest {
+		dropped, err := s.db.DropOldestUnsynced(maxQueue)
+		if err != nil {
+			log.Printf("enforce max_unsynced_rows: %v", err)
+		} else if dropped > 0 {
+			log.Printf("max_unsynced_rows reached: discarded %d oldest unsynced activities", dropped)
 		}
-		return
 	}
 
-	if err := encoder.Encode(Response{OK: true}); err != nil {
-		log.Printf("encode response: %v", err)
+	for _, a := range activities {
+		s.publish(a)
+	}
+	if s.onInsert != nil {
+		s.onInsert()
+	}
+}
+
+// normalizeMetadata checks that plugin metadata is a JSON object and
+// compacts it. Its contents are opaque to blastd.
+func normalizeMetadata(raw json.RawMessage) (json.RawMessage, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if raw[0] != '{' {
+		return nil, errors.New("metadata must be a JSON object")
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// normalizeClientUUID checks that a client-supplied client_uuid is a UUID
+// and returns it in canonical lowercase form. Empty stays empty.
+func normalizeClientUUID(id string) (string, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return "", nil
+	}
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return "", fmt.Errorf("invalid client_uuid: %w", err)
+	}
+	return parsed.String(), nil
+}
+
+// normalizeTags trims whitespace and drops empty and duplicate tags.
+func normalizeTags(tags []string) []string {
+	var out []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
 	}
+	return out
 }
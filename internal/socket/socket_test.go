@@ -2,14 +2,23 @@ package socket
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/taigrr/blastd/internal/config"
 	"github.com/taigrr/blastd/internal/db"
+	blastsync "github.com/taigrr/blastd/internal/sync"
 )
 
 func setupTestSocket(t *testing.T) (*Server, *db.DB) {
@@ -87,6 +96,186 @@ func TestPing(t *testing.T) {
 	}
 }
 
+func TestStartCreatesSocketDir(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := database.Close(); err != nil {
+			t.Fatalf("Close() error: %v", err)
+		}
+	})
+
+	sockPath := filepath.Join(t.TempDir(), "missing", "nested", "test.sock")
+	server := NewServer(sockPath, database, "test-machine")
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+
+	if resp := sendAndRecv(t, dial(t, server), map[string]any{"type": "ping"}); !resp.OK {
+		t.Errorf("ping failed: %+v", resp)
+	}
+	info, err := os.Stat(filepath.Dir(sockPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o700 {
+		t.Errorf("socket directory mode = %o, want 700 for a private socket", perm)
+	}
+}
+
+func TestStartSocketDirError(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := database.Close(); err != nil {
+			t.Fatalf("Close() error: %v", err)
+		}
+	})
+
+	// A regular file where the parent directory should be.
+	blocker := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocker, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sockPath := filepath.Join(blocker, "test.sock")
+	err = NewServer(sockPath, database, "test-machine").Start()
+	if err == nil || !strings.Contains(err.Error(), blocker) {
+		t.Errorf("Start() error = %v, want one mentioning %s", err, blocker)
+	}
+}
+
+func TestSocketPermissions(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := database.Close(); err != nil {
+			t.Fatalf("Close() error: %v", err)
+		}
+	})
+
+	sockDir := filepath.Join(t.TempDir(), "run")
+	sockPath := filepath.Join(sockDir, "test.sock")
+	server := NewServer(sockPath, database, "test-machine")
+	// Our own primary group is always a permitted chown target.
+	server.SetPermissions(0o660, os.Getgid())
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+
+	info, err := os.Stat(sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o660 {
+		t.Errorf("socket mode = %o, want 660", perm)
+	}
+
+	// The group must be able to reach the socket through the directory
+	// blastd created for it.
+	info, err = os.Stat(sockDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o710 {
+		t.Errorf("socket directory mode = %o, want 710", perm)
+	}
+}
+
+func TestStartRefusesLiveSocket(t *testing.T) {
+	first, _ := setupTestSocket(t)
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "second.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := database.Close(); err != nil {
+			t.Fatalf("Close() error: %v", err)
+		}
+	})
+
+	second := NewServer(first.path, database, "other-machine")
+	if err := second.Start(); !errors.Is(err, ErrSocketInUse) {
+		t.Fatalf("Start() on a live socket = %v, want ErrSocketInUse", err)
+	}
+
+	// The first daemon must still be reachable.
+	if resp := sendAndRecv(t, dial(t, first), map[string]string{"type": "ping"}); !resp.OK {
+		t.Errorf("ping after refused Start = %+v", resp)
+	}
+}
+
+func TestStartReplacesStaleSocket(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "test.sock")
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: sockPath, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leave the socket file behind, as a crashed daemon would.
+	stale.SetUnlinkOnClose(false)
+	if err := stale.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := database.Close(); err != nil {
+			t.Fatalf("Close() error: %v", err)
+		}
+	})
+
+	server := NewServer(sockPath, database, "test-machine")
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() over a stale socket error: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+}
+
+func TestAbstractSocket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract sockets are Linux-only")
+	}
+	database, err := db.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := database.Close(); err != nil {
+			t.Fatalf("Close() error: %v", err)
+		}
+	})
+
+	path := fmt.Sprintf("@blastd-test-%d", os.Getpid())
+	server := NewServer(path, database, "test-machine")
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+
+	if resp := sendAndRecv(t, dial(t, server), map[string]string{"type": "ping"}); !resp.OK {
+		t.Errorf("ping = %+v", resp)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("abstract socket left a file at %s (stat err %v)", path, err)
+	}
+
+	second := NewServer(path, database, "other-machine")
+	if err := second.Start(); !errors.Is(err, ErrSocketInUse) {
+		t.Errorf("second Start() = %v, want ErrSocketInUse", err)
+	}
+}
+
 func TestActivityInsertion(t *testing.T) {
 	server, database := setupTestSocket(t)
 	conn := dial(t, server)
@@ -114,57 +303,635 @@ func TestActivityInsertion(t *testing.T) {
 		t.Fatalf("activity: OK = false, error = %q", resp.Error)
 	}
 
-	activities, err := database.GetUnsyncedActivities(10)
-	if err != nil {
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 {
+		t.Fatalf("got %d activities, want 1", len(activities))
+	}
+
+	a := activities[0]
+	if a.Project != "blast" {
+		t.Errorf("Project = %q, want %q", a.Project, "blast")
+	}
+	if a.Machine != "test-machine" {
+		t.Errorf("Machine = %q, want %q", a.Machine, "test-machine")
+	}
+	if a.Editor != "neovim" {
+		t.Errorf("Editor = %q, want %q (default)", a.Editor, "neovim")
+	}
+}
+
+func TestActivityWithEditor(t *testing.T) {
+	server, database := setupTestSocket(t)
+	conn := dial(t, server)
+
+	now := time.Now().UTC()
+	activity := map[string]any{
+		"project":    "blast",
+		"started_at": now.Add(-5 * time.Minute).Format(time.RFC3339),
+		"ended_at":   now.Format(time.RFC3339),
+		"editor":     "vscode",
+	}
+
+	req := map[string]any{
+		"type": "activity",
+		"data": activity,
+	}
+
+	resp := sendAndRecv(t, conn, req)
+	if !resp.OK {
+		t.Fatalf("activity: OK = false, error = %q", resp.Error)
+	}
+
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 {
+		t.Fatalf("got %d activities, want 1", len(activities))
+	}
+	if activities[0].Editor != "vscode" {
+		t.Errorf("Editor = %q, want %q", activities[0].Editor, "vscode")
+	}
+}
+
+func TestActivitySource(t *testing.T) {
+	server, database := setupTestSocket(t)
+	conn := dial(t, server)
+
+	now := time.Now().UTC()
+	for i, source := range []string{"", "manual"} {
+		start := now.Add(time.Duration(i-2) * time.Minute)
+		resp := sendAndRecv(t, conn, map[string]any{
+			"type": "activity",
+			"data": map[string]any{
+				"project":    "blast",
+				"started_at": start.Format(time.RFC3339),
+				"ended_at":   start.Add(time.Minute).Format(time.RFC3339),
+				"source":     source,
+			},
+		})
+		if !resp.OK {
+			t.Fatalf("activity: OK = false, error = %q", resp.Error)
+		}
+	}
+
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 2 {
+		t.Fatalf("got %d activities, want 2", len(activities))
+	}
+	if got := activities[0]; got.Source != db.SourceEditor || got.Editor != "neovim" {
+		t.Errorf("default: Source = %q, Editor = %q; want %q, %q", got.Source, got.Editor, db.SourceEditor, "neovim")
+	}
+	if got := activities[1]; got.Source != db.SourceManual || got.Editor != "" {
+		t.Errorf("manual: Source = %q, Editor = %q; want %q, no editor", got.Source, got.Editor, db.SourceManual)
+	}
+
+	resp := sendAndRecv(t, conn, map[string]any{
+		"type": "activity",
+		"data": map[string]any{
+			"project":    "blast",
+			"started_at": now.Format(time.RFC3339),
+			"ended_at":   now.Add(time.Minute).Format(time.RFC3339),
+			"source":     "browser",
+		},
+	})
+	if resp.OK || resp.Code != ErrInvalidActivity {
+		t.Errorf("unknown source: got %+v, want ERR_INVALID_ACTIVITY", resp)
+	}
+}
+
+func TestActivityClientUUID(t *testing.T) {
+	server, database := setupTestSocket(t)
+	server.SetClientUUID("11111111-1111-4111-8111-111111111111")
+	conn := dial(t, server)
+
+	now := time.Now().UTC()
+	for i, id := range []string{"", "A5C3E0F2-4B1D-4E8A-9C7F-6D2B1A0E3F54", "not-a-uuid"} {
+		start := now.Add(time.Duration(i-3) * time.Minute)
+		resp := sendAndRecv(t, conn, map[string]any{
+			"type": "activity",
+			"data": map[string]any{
+				"project":     "blast",
+				"started_at":  start.Format(time.RFC3339),
+				"ended_at":    start.Add(time.Minute).Format(time.RFC3339),
+				"client_uuid": id,
+			},
+		})
+		if wantOK := id != "not-a-uuid"; resp.OK != wantOK {
+			t.Fatalf("client_uuid %q: OK = %v (%s), want %v", id, resp.OK, resp.Error, wantOK)
+		}
+	}
+
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 2 {
+		t.Fatalf("got %d activities, want 2", len(activities))
+	}
+	if got := activities[0].InstanceUUID; got != "11111111-1111-4111-8111-111111111111" {
+		t.Errorf("default InstanceUUID = %q, want the daemon's", got)
+	}
+	if got := activities[1].InstanceUUID; got != "a5c3e0f2-4b1d-4e8a-9c7f-6d2b1a0e3f54" {
+		t.Errorf("InstanceUUID = %q, want the client's, lowercased", got)
+	}
+	if activities[0].ClientID == activities[0].InstanceUUID {
+		t.Error("ClientID should stay unique per activity")
+	}
+}
+
+func TestActivityKeystrokesAndEdits(t *testing.T) {
+	server, database := setupTestSocket(t)
+	conn := dial(t, server)
+
+	now := time.Now().UTC()
+	activity := func(data map[string]any) Response {
+		data["project"] = "blast"
+		data["started_at"] = now.Add(-time.Minute).Format(time.RFC3339)
+		data["ended_at"] = now.Format(time.RFC3339)
+		return sendAndRecv(t, conn, map[string]any{"type": "activity", "data": data})
+	}
+	if resp := activity(map[string]any{"keystrokes": 412, "edits": 37}); !resp.OK {
+		t.Fatalf("activity with counts: %+v", resp)
+	}
+	if resp := activity(map[string]any{}); !resp.OK {
+		t.Fatalf("activity without counts: %+v", resp)
+	}
+	if resp := activity(map[string]any{"keystrokes": -1}); resp.OK || resp.Code != ErrInvalidActivity {
+		t.Errorf("negative keystrokes = %+v, want ERR_INVALID_ACTIVITY", resp)
+	}
+
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 2 {
+		t.Fatalf("got %d activities, want 2", len(activities))
+	}
+	if a := activities[0]; a.Keystrokes != 412 || a.Edits != 37 {
+		t.Errorf("Keystrokes, Edits = %d, %d, want 412, 37", a.Keystrokes, a.Edits)
+	}
+	if a := activities[1]; a.Keystrokes != 0 || a.Edits != 0 {
+		t.Errorf("defaults = %d, %d, want 0, 0", a.Keystrokes, a.Edits)
+	}
+}
+
+func TestWriteBuffer(t *testing.T) {
+	server, database := setupTestSocket(t)
+	server.SetWriteBuffer(time.Hour, 3)
+	var inserts atomic.Int32
+	server.SetActivityFunc(func() { inserts.Add(1) })
+	conn := dial(t, server)
+
+	send := func(i int) {
+		t.Helper()
+		start := time.Date(2024, 1, 1, 9, i, 0, 0, time.UTC)
+		resp := sendAndRecv(t, conn, map[string]any{
+			"type": "activity",
+			"data": map[string]any{"project": "p", "started_at": start.Format(time.RFC3339), "ended_at": start.Add(time.Minute).Format(time.RFC3339)},
+		})
+		if !resp.OK {
+			t.Fatalf("activity %d: %+v", i, resp)
+		}
+	}
+	stored := func() int64 {
+		t.Helper()
+		stats, err := database.GetStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats.Total
+	}
+
+	send(0)
+	send(1)
+	if n := stored(); n != 0 {
+		t.Fatalf("%d activities stored before a flush, want 0", n)
+	}
+	if resp := sendAndRecv(t, conn, Request{Type: "flush"}); !resp.OK {
+		t.Fatalf("flush: %+v", resp)
+	}
+	if n := stored(); n != 2 {
+		t.Fatalf("%d activities stored after flush, want 2", n)
+	}
+
+	// Reaching the count writes the batch from the request that filled it.
+	for i := 2; i < 5; i++ {
+		send(i)
+	}
+	if n := stored(); n != 5 {
+		t.Errorf("%d activities stored after a full batch, want 5", n)
+	}
+	if n := inserts.Load(); n != 2 {
+		t.Errorf("activity func called %d times, want once per batch", n)
+	}
+}
+
+func TestDropZeroDuration(t *testing.T) {
+	server, database := setupTestSocket(t)
+	conn := dial(t, server)
+
+	zero := map[string]any{
+		"type": "activity",
+		"data": map[string]any{"project": "p", "started_at": "2024-01-01T00:00:00Z", "ended_at": "2024-01-01T00:00:00Z"},
+	}
+	if resp := sendAndRecv(t, conn, zero); !resp.OK {
+		t.Fatalf("zero-duration activity rejected: %+v", resp)
+	}
+
+	server.SetDropZeroDuration(true)
+	if resp := sendAndRecv(t, conn, zero); !resp.OK || resp.Message == "" {
+		t.Fatalf("zero-duration activity with drop_zero_duration: got %+v, want an ok skip", resp)
+	}
+	if resp := sendAndRecv(t, conn, map[string]any{
+		"type": "activity",
+		"data": map[string]any{"project": "p", "started_at": "2024-01-01T00:00:00Z", "ended_at": "2024-01-01T00:00:01Z"},
+	}); !resp.OK {
+		t.Fatalf("one-second activity rejected: %+v", resp)
+	}
+
+	stats, err := database.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 2 {
+		t.Errorf("stored %d activities, want 2 (the dropped one skipped)", stats.Total)
+	}
+}
+
+func TestActivityMachineOverride(t *testing.T) {
+	send := func(t *testing.T, allow bool) string {
+		server, database := setupTestSocket(t)
+		server.SetAllowMachineOverride(allow)
+		resp := sendAndRecv(t, dial(t, server), map[string]any{
+			"type": "activity",
+			"data": map[string]any{
+				"project":    "p",
+				"started_at": "2024-01-01T00:00:00Z",
+				"ended_at":   "2024-01-01T00:05:00Z",
+				"machine":    "ci-runner-7",
+			},
+		})
+		if !resp.OK {
+			t.Fatalf("insert failed: %+v", resp)
+		}
+		activities, err := database.GetUnsyncedActivities(1)
+		if err != nil || len(activities) != 1 {
+			t.Fatalf("GetUnsyncedActivities() = %d, %v", len(activities), err)
+		}
+		return activities[0].Machine
+	}
+
+	if got := send(t, false); got != "test-machine" {
+		t.Errorf("override disabled: Machine = %q, want test-machine", got)
+	}
+	if got := send(t, true); got != "ci-runner-7" {
+		t.Errorf("override enabled: Machine = %q, want ci-runner-7", got)
+	}
+}
+
+func TestActivityGitFields(t *testing.T) {
+	server, database := setupTestSocket(t)
+	conn := dial(t, server)
+
+	now := time.Now().UTC()
+	activity := map[string]any{
+		"project":    "blast",
+		"started_at": now.Add(-5 * time.Minute).Format(time.RFC3339),
+		"ended_at":   now.Format(time.RFC3339),
+		"git_branch": "main",
+		"git_commit": "63af302e",
+	}
+
+	req := map[string]any{
+		"type": "activity",
+		"data": activity,
+	}
+
+	resp := sendAndRecv(t, conn, req)
+	if !resp.OK {
+		t.Fatalf("activity: OK = false, error = %q", resp.Error)
+	}
+
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 {
+		t.Fatalf("got %d activities, want 1", len(activities))
+	}
+	if activities[0].GitBranch != "main" {
+		t.Errorf("GitBranch = %q, want %q", activities[0].GitBranch, "main")
+	}
+	if activities[0].GitCommit != "63af302e" {
+		t.Errorf("GitCommit = %q, want %q", activities[0].GitCommit, "63af302e")
+	}
+}
+
+func TestActivityInvalidTimestamp(t *testing.T) {
+	server, _ := setupTestSocket(t)
+	conn := dial(t, server)
+
+	req := map[string]any{
+		"type": "activity",
+		"data": map[string]any{
+			"project":    "blast",
+			"started_at": "yesterday",
+			"ended_at":   time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+
+	resp := sendAndRecv(t, conn, req)
+	if resp.OK {
+		t.Fatal("expected OK = false for invalid started_at")
+	}
+	if resp.Code != ErrInvalidActivity {
+		t.Errorf("Code = %q, want %q", resp.Code, ErrInvalidActivity)
+	}
+	if !strings.HasPrefix(resp.Error, `invalid started_at "yesterday": expected RFC 3339`) {
+		t.Errorf("Error = %q, want it to name the field, value and format", resp.Error)
+	}
+}
+
+func TestActivityFuncCalledOnInsert(t *testing.T) {
+	server, _ := setupTestSocket(t)
+	called := make(chan struct{}, 1)
+	server.SetActivityFunc(func() { called <- struct{}{} })
+	conn := dial(t, server)
+
+	now := time.Now().UTC()
+	resp := sendAndRecv(t, conn, map[string]any{
+		"type": "activity",
+		"data": map[string]any{
+			"started_at": now.Add(-time.Minute).Format(time.RFC3339),
+			"ended_at":   now.Format(time.RFC3339),
+		},
+	})
+	if !resp.OK {
+		t.Fatalf("activity: OK = false, error = %q", resp.Error)
+	}
+
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Fatal("activity func was not called")
+	}
+}
+
+func TestClientDisconnectAfterSending(t *testing.T) {
+	server, database := setupTestSocket(t)
+
+	conn, err := net.DialTimeout("unix", server.path, 2*time.Second)
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	req := map[string]any{
+		"type": "activity",
+		"data": map[string]any{
+			"project":    "test",
+			"started_at": "2024-01-01T00:00:00Z",
+			"ended_at":   "2024-01-01T00:05:00Z",
+		},
+	}
+	line, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Send two requests and hang up without reading either reply.
+	if _, err := conn.Write(append(append(line, '\n'), append(line, '\n')...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		stats, err := database.GetStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Total >= 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("activity from disconnected client was not stored")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The server keeps serving other clients.
+	resp := sendAndRecv(t, dial(t, server), map[string]any{"type": "ping"})
+	if !resp.OK {
+		t.Errorf("ping after disconnect failed: %+v", resp)
+	}
+}
+
+func TestResponsesAreNewlineFramed(t *testing.T) {
+	server, _ := setupTestSocket(t)
+	conn := dial(t, server)
+
+	if _, err := conn.Write([]byte(`{"type":"ping"}` + "\n" + `{"type":"bogus"}` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	for _, wantOK := range []bool{true, false} {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("ReadBytes() error: %v", err)
+		}
+		var resp Response
+		if err := json.Unmarshal(line, &resp); err != nil {
+			t.Fatalf("unmarshal %q: %v", line, err)
+		}
+		if resp.OK != wantOK {
+			t.Errorf("response %q: ok = %v, want %v", line, resp.OK, wantOK)
+		}
+	}
+}
+
+// slowReader hands back at most a few bytes per Read, pausing between
+// reads, so the server's writes back up in the socket buffer.
+type slowReader struct {
+	r io.Reader
+}
+
+func (s slowReader) Read(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return s.r.Read(p[:min(len(p), 256)])
+}
+
+func TestLargeResponsesToSlowReader(t *testing.T) {
+	server, database := setupTestSocket(t)
+	conn := dial(t, server)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	const filetypes = 500
+	for i := range filetypes {
+		if err := database.InsertActivity(&db.Activity{
+			StartedAt:  start,
+			EndedAt:    start.Add(time.Minute),
+			Filetype:   fmt.Sprintf("filetype-%03d", i),
+			LinesAdded: i,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const requests = 5
+	req := `{"type":"stats","data":{"since":"2024-01-01T00:00:00Z","until":"2024-01-02T00:00:00Z"}}` + "\n"
+	if _, err := conn.Write([]byte(strings.Repeat(req, requests))); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(slowReader{conn})
+	for i := range requests {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+		var resp Response
+		if err := json.Unmarshal(line, &resp); err != nil {
+			t.Fatalf("response %d is not one JSON frame: %v", i, err)
+		}
+		if !resp.OK || len(resp.Filetypes) != filetypes {
+			t.Errorf("response %d: ok = %v, %d filetypes; want %d", i, resp.OK, len(resp.Filetypes), filetypes)
+		}
+	}
+}
+
+// shortWriter accepts half of every write without reporting an error.
+type shortWriter struct{}
+
+func (shortWriter) Write(p []byte) (int, error) { return len(p) / 2, nil }
+
+func TestWriteResponseShortWrite(t *testing.T) {
+	if err := writeResponse(shortWriter{}, Response{OK: true}); !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("writeResponse() error = %v, want io.ErrShortWrite", err)
+	}
+}
+
+func TestMultiObjectStream(t *testing.T) {
+	server, _ := setupTestSocket(t)
+	conn := dial(t, server)
+
+	stream := `{"type":"ping"}` + "\n" +
+		`{"type":"status"}` + "\n" +
+		"\n" +
+		`{"type":"activity","data":{"project":"p","started_at":"2024-01-01T00:00:00Z","ended_at":"2024-01-01T00:05:00Z"}}` + "\n"
+	if _, err := conn.Write([]byte(stream)); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := bufio.NewScanner(conn)
+	for i := range 3 {
+		if !scanner.Scan() {
+			t.Fatalf("missing response %d: %v", i, scanner.Err())
+		}
+		var resp Response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("unmarshal response %d: %v", i, err)
+		}
+		if !resp.OK {
+			t.Errorf("response %d not ok: %+v", i, resp)
+		}
+	}
+}
+
+func TestRequestIDsEchoed(t *testing.T) {
+	server, _ := setupTestSocket(t)
+	conn := dial(t, server)
+
+	stream := `{"id":1,"type":"ping"}` + "\n" +
+		`{"id":"b","type":"bogus"}` + "\n" +
+		`{"type":"ping"}` + "\n" +
+		`{"id":{"seq":3},"type":"activity","data":{"project":"p","started_at":"2024-01-01T00:00:00Z","ended_at":"2024-01-01T00:05:00Z"}}` + "\n" +
+		`{"id":4,"type":"status"}` + "\n"
+	if _, err := conn.Write([]byte(stream)); err != nil {
 		t.Fatal(err)
 	}
-	if len(activities) != 1 {
-		t.Fatalf("got %d activities, want 1", len(activities))
+	if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
 	}
 
-	a := activities[0]
-	if a.Project != "blast" {
-		t.Errorf("Project = %q, want %q", a.Project, "blast")
-	}
-	if a.Machine != "test-machine" {
-		t.Errorf("Machine = %q, want %q", a.Machine, "test-machine")
-	}
-	if a.Editor != "neovim" {
-		t.Errorf("Editor = %q, want %q (default)", a.Editor, "neovim")
+	scanner := bufio.NewScanner(conn)
+	for i, wantID := range []string{`1`, `"b"`, ``, `{"seq":3}`, `4`} {
+		if !scanner.Scan() {
+			t.Fatalf("missing response %d: %v", i, scanner.Err())
+		}
+		var resp Response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("unmarshal response %d: %v", i, err)
+		}
+		if string(resp.ID) != wantID {
+			t.Errorf("response %d id = %s, want %s", i, resp.ID, wantID)
+		}
+		if wantID == "" && bytes.Contains(scanner.Bytes(), []byte(`"id"`)) {
+			t.Errorf("response %d = %s, want no id for a request without one", i, scanner.Bytes())
+		}
 	}
 }
 
-func TestActivityWithEditor(t *testing.T) {
+func TestPrettyPrintedRequestRejected(t *testing.T) {
 	server, database := setupTestSocket(t)
 	conn := dial(t, server)
 
-	now := time.Now().UTC()
-	activity := map[string]any{
-		"project":    "blast",
-		"started_at": now.Add(-5 * time.Minute).Format(time.RFC3339),
-		"ended_at":   now.Format(time.RFC3339),
-		"editor":     "vscode",
-	}
-
-	req := map[string]any{
+	pretty, err := json.MarshalIndent(map[string]any{
 		"type": "activity",
-		"data": activity,
+		"data": map[string]any{
+			"project":    "p",
+			"started_at": "2024-01-01T00:00:00Z",
+			"ended_at":   "2024-01-01T00:05:00Z",
+		},
+	}, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(append(pretty, '\n')); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
 	}
 
-	resp := sendAndRecv(t, conn, req)
-	if !resp.OK {
-		t.Fatalf("activity: OK = false, error = %q", resp.Error)
+	scanner := bufio.NewScanner(conn)
+	if !scanner.Scan() {
+		t.Fatal("no response from server")
+	}
+	var resp Response
+	if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.OK || resp.Code != ErrFraming {
+		t.Errorf("expected ERR_FRAMING, got %+v", resp)
+	}
+	// The server hangs up rather than answering each fragment.
+	if scanner.Scan() {
+		t.Errorf("unexpected extra response: %s", scanner.Text())
 	}
 
-	activities, err := database.GetUnsyncedActivities(10)
+	stats, err := database.GetStats()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(activities) != 1 {
-		t.Fatalf("got %d activities, want 1", len(activities))
-	}
-	if activities[0].Editor != "vscode" {
-		t.Errorf("Editor = %q, want %q", activities[0].Editor, "vscode")
+	if stats.Total != 0 {
+		t.Errorf("expected no activity stored, got %d", stats.Total)
 	}
 }
 
@@ -179,6 +946,9 @@ func TestUnknownRequestType(t *testing.T) {
 	if resp.Error != "unknown request type" {
 		t.Errorf("Error = %q, want %q", resp.Error, "unknown request type")
 	}
+	if resp.Code != ErrUnknownType {
+		t.Errorf("Code = %q, want %q", resp.Code, ErrUnknownType)
+	}
 }
 
 func TestInvalidJSON(t *testing.T) {
@@ -204,6 +974,9 @@ func TestInvalidJSON(t *testing.T) {
 	if resp.OK {
 		t.Error("expected OK = false for invalid json")
 	}
+	if resp.Code != ErrInvalidJSON {
+		t.Errorf("Code = %q, want %q", resp.Code, ErrInvalidJSON)
+	}
 }
 
 func TestSyncSuccess(t *testing.T) {
@@ -236,6 +1009,54 @@ func TestSyncError(t *testing.T) {
 	if resp.Error != "no API token configured" {
 		t.Errorf("Error = %q, want %q", resp.Error, "no API token configured")
 	}
+	if resp.Code != ErrSyncFailed {
+		t.Errorf("Code = %q, want %q", resp.Code, ErrSyncFailed)
+	}
+}
+
+func TestSyncHistory(t *testing.T) {
+	server, _ := setupTestSocket(t)
+	conn := dial(t, server)
+
+	resp := sendAndRecv(t, conn, Request{Type: "sync_history"})
+	if resp.OK || resp.Code != ErrSyncUnavailable {
+		t.Errorf("sync_history without a syncer: OK = %v, Code = %q, want %q", resp.OK, resp.Code, ErrSyncUnavailable)
+	}
+
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	server.SetSyncHistoryFunc(func() []blastsync.Attempt {
+		return []blastsync.Attempt{
+			{Time: at, Synced: 4},
+			{Time: at.Add(time.Minute), Error: "server returned 503"},
+		}
+	})
+	resp = sendAndRecv(t, conn, Request{Type: "sync_history"})
+	if !resp.OK {
+		t.Fatalf("sync_history: OK = false, error = %q", resp.Error)
+	}
+	if len(resp.History) != 2 {
+		t.Fatalf("History has %d attempts, want 2", len(resp.History))
+	}
+	if !resp.History[0].Time.Equal(at) || resp.History[0].Synced != 4 {
+		t.Errorf("History[0] = %+v, want 4 synced at %s", resp.History[0], at)
+	}
+	if resp.History[1].Error != "server returned 503" {
+		t.Errorf("History[1].Error = %q, want %q", resp.History[1].Error, "server returned 503")
+	}
+}
+
+func TestHandlerPanicRecovered(t *testing.T) {
+	server, _ := setupTestSocket(t)
+	server.SetSyncFunc(func() error { panic("boom") })
+	conn := dial(t, server)
+
+	resp := sendAndRecv(t, conn, Request{ID: json.RawMessage(`9`), Type: "sync"})
+	if resp.OK || resp.Code != ErrInternal || resp.Error != "internal error" || string(resp.ID) != "9" {
+		t.Errorf("panicking request = %+v, want ERR_INTERNAL with its id", resp)
+	}
+	if resp := sendAndRecv(t, conn, Request{Type: "ping"}); !resp.OK {
+		t.Errorf("ping after a panic = %+v, want the connection still served", resp)
+	}
 }
 
 func TestSyncNoFunc(t *testing.T) {
@@ -249,6 +1070,9 @@ func TestSyncNoFunc(t *testing.T) {
 	if resp.Error != "sync not available" {
 		t.Errorf("Error = %q, want %q", resp.Error, "sync not available")
 	}
+	if resp.Code != ErrSyncUnavailable {
+		t.Errorf("Code = %q, want %q", resp.Code, ErrSyncUnavailable)
+	}
 }
 
 func TestStatus(t *testing.T) {
@@ -287,8 +1111,382 @@ func TestStatus(t *testing.T) {
 	if *resp.Total != 1 || *resp.Unsynced != 1 {
 		t.Errorf("after insert: total=%d unsynced=%d, want 1/1", *resp.Total, *resp.Unsynced)
 	}
+	if resp.LastSyncAt != "" {
+		t.Errorf("LastSyncAt = %q before any sync, want empty", resp.LastSyncAt)
+	}
+
+	synced := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := database.SetLastSyncAt(synced); err != nil {
+		t.Fatal(err)
+	}
+	resp = sendAndRecv(t, conn, Request{Type: "status"})
+	if resp.LastSyncAt != "2026-03-01T12:00:00Z" {
+		t.Errorf("LastSyncAt = %q, want %q", resp.LastSyncAt, "2026-03-01T12:00:00Z")
+	}
+}
+
+func TestStatusSyncWarning(t *testing.T) {
+	server, _ := setupTestSocket(t)
+	conn := dial(t, server)
+
+	resp := sendAndRecv(t, conn, Request{Type: "status"})
+	if resp.SyncWarning != nil {
+		t.Errorf("status without a syncer: SyncWarning = %+v, want nil", resp.SyncWarning)
+	}
+
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	server.SetSyncWarningFunc(func() *blastsync.Warning {
+		return &blastsync.Warning{Time: at, Message: "approaching quota"}
+	})
+	resp = sendAndRecv(t, conn, Request{Type: "status"})
+	if !resp.OK {
+		t.Fatalf("status: OK = false, error = %q", resp.Error)
+	}
+	if w := resp.SyncWarning; w == nil || w.Message != "approaching quota" || !w.Time.Equal(at) {
+		t.Errorf("SyncWarning = %+v, want approaching quota at %s", w, at)
+	}
+}
+
+func TestActivityMetadata(t *testing.T) {
+	server, database := setupTestSocket(t)
+	conn := dial(t, server)
+
+	activity := func(metadata any) map[string]any {
+		return map[string]any{
+			"type": "activity",
+			"data": map[string]any{
+				"project":    "p",
+				"started_at": "2024-01-01T00:00:00Z",
+				"ended_at":   "2024-01-01T00:01:00Z",
+				"metadata":   metadata,
+			},
+		}
+	}
+
+	if resp := sendAndRecv(t, conn, activity(map[string]any{"lsp": "gopls", "tests": map[string]int{"passed": 3}})); !resp.OK {
+		t.Fatalf("insert with metadata failed: %+v", resp)
+	}
+	for _, bad := range []any{[]string{"a"}, "text", 42} {
+		if resp := sendAndRecv(t, conn, activity(bad)); resp.OK || resp.Code != ErrInvalidActivity {
+			t.Errorf("metadata %v: got %+v, want ERR_INVALID_ACTIVITY", bad, resp)
+		}
+	}
+
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 {
+		t.Fatalf("stored %d activities, want 1", len(activities))
+	}
+	if got := string(activities[0].Metadata); got != `{"lsp":"gopls","tests":{"passed":3}}` {
+		t.Errorf("Metadata = %s", got)
+	}
+}
+
+func TestActivityTagsAndStatusFilter(t *testing.T) {
+	server, database := setupTestSocket(t)
+	conn := dial(t, server)
+
+	now := time.Now().UTC()
+	for _, tags := range [][]string{{" review ", "review", ""}, {"meeting"}} {
+		req := map[string]any{
+			"type": "activity",
+			"data": map[string]any{
+				"project":    "blast",
+				"started_at": now.Add(-5 * time.Minute).Format(time.RFC3339),
+				"ended_at":   now.Format(time.RFC3339),
+				"tags":       tags,
+			},
+		}
+		if resp := sendAndRecv(t, conn, req); !resp.OK {
+			t.Fatalf("activity: OK = false, error = %q", resp.Error)
+		}
+	}
+
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := activities[0].Tags; len(got) != 1 || got[0] != "review" {
+		t.Errorf("Tags = %v, want [review] (trimmed, deduped)", got)
+	}
+
+	resp := sendAndRecv(t, conn, map[string]any{
+		"type": "status",
+		"data": map[string]any{"tag": "review"},
+	})
+	if !resp.OK {
+		t.Fatalf("status: OK = false, error = %q", resp.Error)
+	}
+	if *resp.Total != 1 {
+		t.Errorf("status tag=review: total=%d, want 1", *resp.Total)
+	}
+}
+
+func TestStatsByEditorRequest(t *testing.T) {
+	server, _ := setupTestSocket(t)
+	conn := dial(t, server)
+
+	for _, editor := range []string{"", "vscode"} {
+		resp := sendAndRecv(t, conn, map[string]any{
+			"type": "activity",
+			"data": map[string]any{
+				"project":     "p",
+				"started_at":  "2024-01-01T00:00:00Z",
+				"ended_at":    "2024-01-01T00:01:00Z",
+				"editor":      editor,
+				"filetype":    "go",
+				"lines_added": 2,
+			},
+		})
+		if !resp.OK {
+			t.Fatalf("insert failed: %+v", resp)
+		}
+	}
+
+	resp := sendAndRecv(t, conn, map[string]any{
+		"type": "stats",
+		"data": map[string]any{"since": "2024-01-01T00:00:00Z", "until": "2024-01-02T00:00:00Z"},
+	})
+	if !resp.OK {
+		t.Fatalf("stats failed: %+v", resp)
+	}
+	if resp.Editors["neovim"].Activities != 1 || resp.Editors["vscode"].Seconds != 60 {
+		t.Errorf("unexpected editor stats: %+v", resp.Editors)
+	}
+	if resp.Filetypes["go"].LinesAdded != 4 {
+		t.Errorf("unexpected filetype stats: %+v", resp.Filetypes)
+	}
+
+	resp = sendAndRecv(t, conn, map[string]any{"type": "stats", "data": map[string]any{"since": "yesterday"}})
+	if resp.OK || resp.Code != ErrInvalidJSON {
+		t.Errorf("expected ERR_INVALID_JSON for bad since, got %+v", resp)
+	}
+}
+
+func TestPeriodRange(t *testing.T) {
+	zone := time.FixedZone("UTC-5", -5*3600)
+	// Thursday late evening local time, already Friday in UTC.
+	now := time.Date(2024, 1, 4, 22, 30, 0, 0, zone)
+
+	start, end, err := periodRange("day", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 1, 4, 0, 0, 0, 0, zone); !start.Equal(want) || !end.Equal(want.AddDate(0, 0, 1)) {
+		t.Errorf("day = [%v, %v), want [%v, +1d)", start, end, want)
+	}
+
+	start, end, err = periodRange("week", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 1, 1, 0, 0, 0, 0, zone); !start.Equal(want) || !end.Equal(want.AddDate(0, 0, 7)) {
+		t.Errorf("week = [%v, %v), want [%v, +7d)", start, end, want)
+	}
+
+	if _, _, err := periodRange("month", now); err == nil {
+		t.Error("expected error for unknown period")
+	}
+}
+
+func TestStatsPeriodRequest(t *testing.T) {
+	server, _ := setupTestSocket(t)
+	server.SetLocation(time.FixedZone("UTC+14", 14*3600))
+	conn := dial(t, server)
+
+	now := time.Now()
+	for _, start := range []time.Time{now.Add(-time.Minute), now.AddDate(0, 0, -8)} {
+		resp := sendAndRecv(t, conn, map[string]any{
+			"type": "activity",
+			"data": map[string]any{
+				"project":    "p",
+				"started_at": start.UTC().Format(time.RFC3339),
+				"ended_at":   start.Add(time.Minute).UTC().Format(time.RFC3339),
+				"editor":     "vscode",
+			},
+		})
+		if !resp.OK {
+			t.Fatalf("insert failed: %+v", resp)
+		}
+	}
+
+	resp := sendAndRecv(t, conn, map[string]any{"type": "stats", "data": map[string]any{"period": "week"}})
+	if !resp.OK {
+		t.Fatalf("stats failed: %+v", resp)
+	}
+	if got := resp.Editors["vscode"].Activities; got != 1 {
+		t.Errorf("week activities = %d, want 1", got)
+	}
+
+	resp = sendAndRecv(t, conn, map[string]any{
+		"type": "stats",
+		"data": map[string]any{"period": "day", "since": "2024-01-01T00:00:00Z"},
+	})
+	if resp.OK || resp.Code != ErrInvalidJSON {
+		t.Errorf("expected ERR_INVALID_JSON for period with since, got %+v", resp)
+	}
+}
+
+func TestRejectedCounters(t *testing.T) {
+	server, _ := setupTestSocket(t)
+	conn := dial(t, server)
+
+	for _, data := range []any{
+		"not an object",
+		map[string]any{"project": "p", "started_at": "yesterday", "ended_at": "2024-01-01T00:01:00Z"},
+		map[string]any{"project": "p", "started_at": "2024-01-01T00:00:00Z", "ended_at": "later"},
+	} {
+		if resp := sendAndRecv(t, conn, map[string]any{"type": "activity", "data": data}); resp.OK {
+			t.Fatalf("invalid activity %v accepted", data)
+		}
+	}
+
+	resp := sendAndRecv(t, conn, map[string]any{"type": "stats"})
+	if !resp.OK {
+		t.Fatalf("stats failed: %+v", resp)
+	}
+	want := map[string]int64{"invalid_json": 1, "invalid_timestamp": 2, "queue_full": 0}
+	for reason, n := range want {
+		if resp.Rejected[reason] != n {
+			t.Errorf("rejected[%s] = %d, want %d", reason, resp.Rejected[reason], n)
+		}
+	}
+}
+
+func TestActivityRateLimit(t *testing.T) {
+	server, database := setupTestSocket(t)
+	server.SetInsertRateLimit(0.001, 3)
+	conn := dial(t, server)
+
+	activity := map[string]any{
+		"type": "activity",
+		"data": map[string]any{"project": "p", "started_at": "2024-01-01T00:00:00Z", "ended_at": "2024-01-01T00:01:00Z"},
+	}
+	for i := range 5 {
+		resp := sendAndRecv(t, conn, activity)
+		if i < 3 && !resp.OK {
+			t.Fatalf("activity %d within the burst rejected: %+v", i, resp)
+		}
+		if i >= 3 && (resp.OK || resp.Code != ErrRateLimited) {
+			t.Fatalf("activity %d: got %+v, want ERR_RATE_LIMITED", i, resp)
+		}
+	}
+
+	// The limit is per connection.
+	if resp := sendAndRecv(t, dial(t, server), activity); !resp.OK {
+		t.Errorf("activity on a new connection rejected: %+v", resp)
+	}
+
+	stats, err := database.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 4 {
+		t.Errorf("stored %d activities, want 4", stats.Total)
+	}
+	resp := sendAndRecv(t, conn, map[string]any{"type": "stats"})
+	if resp.Rejected["rate_limited"] != 2 {
+		t.Errorf("rejected[rate_limited] = %d, want 2", resp.Rejected["rate_limited"])
+	}
+}
+
+func TestUnsyncedCap(t *testing.T) {
+	activity := func(minute int) map[string]any {
+		start := time.Date(2024, 1, 1, 0, minute, 0, 0, time.UTC)
+		return map[string]any{
+			"type": "activity",
+			"data": map[string]any{
+				"project":    "p",
+				"started_at": start.Format(time.RFC3339),
+				"ended_at":   start.Add(time.Minute).Format(time.RFC3339),
+			},
+		}
+	}
+
+	t.Run("drop oldest", func(t *testing.T) {
+		server, database := setupTestSocket(t)
+		server.SetUnsyncedCap(2, DropOldest)
+		conn := dial(t, server)
+
+		for i := range 3 {
+			if resp := sendAndRecv(t, conn, activity(i)); !resp.OK {
+				t.Fatalf("insert %d failed: %+v", i, resp)
+			}
+		}
+
+		remaining, err := database.GetUnsyncedActivities(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(remaining) != 2 || remaining[0].StartedAt.Minute() != 1 {
+			t.Errorf("expected the two newest activities to remain, got %d", len(remaining))
+		}
+	})
+
+	t.Run("reject new", func(t *testing.T) {
+		server, database := setupTestSocket(t)
+		server.SetUnsyncedCap(2, RejectNew)
+		conn := dial(t, server)
+
+		for i := range 2 {
+			if resp := sendAndRecv(t, conn, activity(i)); !resp.OK {
+				t.Fatalf("insert %d failed: %+v", i, resp)
+			}
+		}
+		resp := sendAndRecv(t, conn, activity(2))
+		if resp.OK || resp.Code != ErrQueueFull {
+			t.Errorf("expected ERR_QUEUE_FULL, got %+v", resp)
+		}
+
+		stats, err := database.GetStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Total != 2 {
+			t.Errorf("total = %d, want 2", stats.Total)
+		}
+	})
+
+	t.Run("reject new counts buffered", func(t *testing.T) {
+		server, _ := setupTestSocket(t)
+		server.SetWriteBuffer(time.Hour, 100)
+		server.SetUnsyncedCap(2, RejectNew)
+		conn := dial(t, server)
+
+		for i := range 2 {
+			if resp := sendAndRecv(t, conn, activity(i)); !resp.OK {
+				t.Fatalf("insert %d failed: %+v", i, resp)
+			}
+		}
+		resp := sendAndRecv(t, conn, activity(2))
+		if resp.OK || resp.Code != ErrQueueFull {
+			t.Errorf("expected ERR_QUEUE_FULL with 2 buffered, got %+v", resp)
+		}
+	})
+}
+
+func TestConfigRequest(t *testing.T) {
+	server, _ := setupTestSocket(t)
+	conn := dial(t, server)
+
+	resp := sendAndRecv(t, conn, map[string]any{"type": "config"})
+	if resp.OK || resp.Code != ErrInternal {
+		t.Errorf("expected ERR_INTERNAL without config, got %+v", resp)
+	}
 
-	_ = database // keep linter happy
+	server.SetConfig(&config.Config{ServerURL: "https://example.com", APIToken: "blast_secret", SyncBatchSize: 50})
+	resp = sendAndRecv(t, conn, map[string]any{"type": "config"})
+	if !resp.OK || resp.Config == nil {
+		t.Fatalf("expected config in response, got %+v", resp)
+	}
+	if resp.Config.APIToken != "REDACTED" {
+		t.Errorf("APIToken = %q, want REDACTED", resp.Config.APIToken)
+	}
+	if resp.Config.ServerURL != "https://example.com" || resp.Config.SyncBatchSize != 50 {
+		t.Errorf("unexpected config: %+v", resp.Config)
+	}
 }
 
 func TestSyncRateLimit(t *testing.T) {
@@ -313,4 +1511,7 @@ func TestSyncRateLimit(t *testing.T) {
 	if len(resp.Error) < 10 {
 		t.Errorf("expected descriptive rate limit error, got %q", resp.Error)
 	}
+	if resp.Code != ErrRateLimited {
+		t.Errorf("Code = %q, want %q", resp.Code, ErrRateLimited)
+	}
 }
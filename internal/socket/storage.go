@@ -0,0 +1,42 @@
+package socket
+
+import (
+	"log"
+	"time"
+)
+
+// storageRetryInterval is how often a degraded server lets one activity
+// through to test whether the database is writable again.
+const storageRetryInterval = 30 * time.Second
+
+// storageUnavailable reports whether an activity should be turned away
+// without touching the database because storage recently failed. Once per
+// storageRetryInterval it lets a single write through as a probe.
+func (s *Server) storageUnavailable() bool {
+	downAt := s.storageDownAt.Load()
+	if downAt == 0 {
+		return false
+	}
+	if time.Since(time.Unix(0, downAt)) < storageRetryInterval {
+		return true
+	}
+	// Only the caller that wins the swap probes; the rest keep failing
+	// fast until it reports back.
+	return !s.storageDownAt.CompareAndSwap(downAt, time.Now().UnixNano())
+}
+
+// markStorageDown enters degraded mode after a write failed because the
+// disk is full or read-only, logging once rather than per activity.
+func (s *Server) markStorageDown(err error) {
+	if s.storageDownAt.Swap(time.Now().UnixNano()) == 0 {
+		log.Printf("WARNING: cannot write to the database (disk full or read-only?): %v; "+
+			"rejecting activities with %s and retrying every %s", err, ErrStorageFull, storageRetryInterval)
+	}
+}
+
+// markStorageUp leaves degraded mode after a successful write.
+func (s *Server) markStorageUp() {
+	if s.storageDownAt.Swap(0) != 0 {
+		log.Println("database is writable again; accepting activities")
+	}
+}
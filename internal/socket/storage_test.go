@@ -0,0 +1,54 @@
+package socket
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStorageDegradedMode(t *testing.T) {
+	server, database := setupTestSocket(t)
+	conn := dial(t, server)
+
+	activity := func() Response {
+		now := time.Now().UTC()
+		return sendAndRecv(t, conn, map[string]any{
+			"type": "activity",
+			"data": map[string]any{
+				"project":    "blast",
+				"started_at": now.Add(-time.Minute).Format(time.RFC3339),
+				"ended_at":   now.Format(time.RFC3339),
+			},
+		})
+	}
+
+	// A write failed for lack of space: new activities fail fast.
+	server.markStorageDown(errors.New("database or disk is full"))
+	if resp := activity(); resp.OK || resp.Code != ErrStorageFull {
+		t.Fatalf("activity while degraded = %+v, want %s", resp, ErrStorageFull)
+	}
+	if n := server.rejected.storageFull.Load(); n != 1 {
+		t.Errorf("storage_full rejections = %d, want 1", n)
+	}
+
+	// After the retry interval one activity probes the database; it
+	// succeeds, so the server recovers.
+	server.storageDownAt.Store(time.Now().Add(-storageRetryInterval - time.Second).UnixNano())
+	if resp := activity(); !resp.OK {
+		t.Fatalf("probe activity = %+v, want OK", resp)
+	}
+	if server.storageDownAt.Load() != 0 {
+		t.Error("server still degraded after a successful write")
+	}
+	if resp := activity(); !resp.OK {
+		t.Errorf("activity after recovery = %+v", resp)
+	}
+
+	stats, err := database.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 2 {
+		t.Errorf("stored %d activities, want 2", stats.Total)
+	}
+}
@@ -0,0 +1,125 @@
+package socket
+
+import (
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/taigrr/blastd/internal/db"
+)
+
+// Event is streamed to subscribers, one JSON line per stored activity.
+type Event struct {
+	Type     string       `json:"type"`
+	ID       int64        `json:"id"`
+	ClientID string       `json:"client_id"`
+	Data     ActivityData `json:"data"`
+}
+
+// subscriberBuffer is how many events a slow subscriber may fall behind
+// before new events are dropped for it. Inserts never wait on subscribers.
+const subscriberBuffer = 64
+
+// handleSubscribe registers conn for activity events. handle switches the
+// connection to streaming once the OK response is written.
+func (s *Server) handleSubscribe(conn net.Conn) Response {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	if _, ok := s.subscribers[conn]; !ok {
+		s.subscribers[conn] = make(chan Event, subscriberBuffer)
+	}
+	return Response{OK: true}
+}
+
+// subscription returns conn's event channel, or nil if it hasn't
+// subscribed.
+func (s *Server) subscription(conn net.Conn) chan Event {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	return s.subscribers[conn]
+}
+
+// streamEvents writes events to a subscribed conn until the client hangs
+// up or the server stops. Subscribers only listen; anything they send is
+// discarded.
+func (s *Server) streamEvents(conn net.Conn, events chan Event) {
+	defer func() {
+		s.subMu.Lock()
+		delete(s.subscribers, conn)
+		s.subMu.Unlock()
+	}()
+
+	gone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-gone:
+			return
+		case ev := <-events:
+			if err := writeLine(conn, ev); err != nil {
+				if !isDisconnect(err) {
+					log.Printf("write event: %v", err)
+				}
+				return
+			}
+		}
+	}
+}
+
+// publish sends a stored activity to every subscriber and the event func
+// without blocking; a subscriber whose buffer is full misses the event.
+func (s *Server) publish(a *db.Activity) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	if len(s.subscribers) == 0 && s.onEvent == nil {
+		return
+	}
+
+	ev := newEvent(a)
+	for _, events := range s.subscribers {
+		select {
+		case events <- ev:
+		default:
+		}
+	}
+	if s.onEvent != nil {
+		s.onEvent(ev)
+	}
+}
+
+func newEvent(a *db.Activity) Event {
+	return Event{
+		Type:     "activity",
+		ID:       a.ID,
+		ClientID: a.ClientID,
+		Data: ActivityData{
+			Project:          a.Project,
+			GitRemote:        a.GitRemote,
+			StartedAt:        a.StartedAt.Format(time.RFC3339),
+			EndedAt:          a.EndedAt.Format(time.RFC3339),
+			Filename:         a.Filename,
+			Filetype:         a.Filetype,
+			LinesAdded:       a.LinesAdded,
+			LinesRemoved:     a.LinesRemoved,
+			GitBranch:        a.GitBranch,
+			GitCommit:        a.GitCommit,
+			Tags:             a.Tags,
+			Metadata:         a.Metadata,
+			ActionsPerMinute: a.ActionsPerMinute,
+			WordsPerMinute:   a.WordsPerMinute,
+			Keystrokes:       a.Keystrokes,
+			Edits:            a.Edits,
+			Editor:           a.Editor,
+			Source:           a.Source,
+			ClientUUID:       a.InstanceUUID,
+			Machine:          a.Machine,
+		},
+	}
+}
@@ -0,0 +1,102 @@
+package socket
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	server, _ := setupTestSocket(t)
+
+	subscribe := func() (net.Conn, *bufio.Reader) {
+		t.Helper()
+		conn, err := net.Dial("unix", server.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp := sendAndRecv(t, conn, map[string]string{"type": "subscribe"}); !resp.OK {
+			t.Fatalf("subscribe: %+v", resp)
+		}
+		return conn, bufio.NewReader(conn)
+	}
+	first, firstEvents := subscribe()
+	second, secondEvents := subscribe()
+	defer second.Close()
+
+	now := time.Now().UTC()
+	resp := sendAndRecv(t, dial(t, server), map[string]any{
+		"type": "activity",
+		"data": map[string]any{
+			"project":     "blast",
+			"started_at":  now.Add(-time.Minute).Format(time.RFC3339),
+			"ended_at":    now.Format(time.RFC3339),
+			"lines_added": 3,
+		},
+	})
+	if !resp.OK {
+		t.Fatalf("activity: %+v", resp)
+	}
+
+	for _, events := range []*bufio.Reader{firstEvents, secondEvents} {
+		line, err := events.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		var ev Event
+		if err := json.Unmarshal(line, &ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != "activity" || ev.Data.Project != "blast" || ev.Data.LinesAdded != 3 || ev.ClientID == "" {
+			t.Errorf("event = %+v", ev)
+		}
+	}
+
+	// Disconnecting unsubscribes.
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		server.subMu.Lock()
+		n := len(server.subscribers)
+		server.subMu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscribers after disconnect, want 1", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEventFunc(t *testing.T) {
+	server, _ := setupTestSocket(t)
+	events := make(chan Event, 1)
+	server.SetEventFunc(func(ev Event) { events <- ev })
+
+	now := time.Now().UTC()
+	resp := sendAndRecv(t, dial(t, server), map[string]any{
+		"type": "activity",
+		"data": map[string]any{
+			"project":    "blast",
+			"started_at": now.Add(-time.Minute).Format(time.RFC3339),
+			"ended_at":   now.Format(time.RFC3339),
+		},
+	})
+	if !resp.OK {
+		t.Fatalf("activity: %+v", resp)
+	}
+
+	select {
+	case ev := <-events:
+		if ev.Type != "activity" || ev.ID == 0 || ev.Data.Project != "blast" {
+			t.Errorf("event = %+v", ev)
+		}
+	default:
+		t.Fatal("event func was not called for the stored activity")
+	}
+}
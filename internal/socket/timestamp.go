@@ -0,0 +1,44 @@
+package socket
+
+import (
+	"fmt"
+	"time"
+)
+
+// timestampLayouts are the accepted started_at/ended_at formats, tried in
+// order. time.Parse takes fractional seconds after the seconds field in
+// any of them, so RFC 3339 with nanoseconds needs no layout of its own.
+// The last is the space-separated form RFC 3339 §5.6 allows.
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05Z07:00",
+}
+
+// offsetlessLayouts recognize a timestamp that is otherwise valid but has
+// no UTC offset, so the error can say what is missing.
+var offsetlessLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// expectedTimestamp is quoted in timestamp errors.
+const expectedTimestamp = "RFC 3339 with a UTC offset, e.g. 2006-01-02T15:04:05Z or 2006-01-02T15:04:05.000+02:00"
+
+// parseTimestamp parses the value of the named timestamp field. The error
+// names the field, quotes the value and gives the expected format.
+func parseTimestamp(field, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("missing %s: expected %s", field, expectedTimestamp)
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range offsetlessLayouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return time.Time{}, fmt.Errorf("invalid %s %q: no UTC offset; expected %s", field, value, expectedTimestamp)
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid %s %q: expected %s", field, value, expectedTimestamp)
+}
@@ -0,0 +1,64 @@
+package socket
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2024, 1, 2, 10, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value string
+		want  time.Time
+	}{
+		{"RFC3339 UTC", "2024-01-02T10:04:05Z", want},
+		{"RFC3339 offset", "2024-01-02T12:04:05+02:00", want},
+		{"RFC3339 negative offset", "2024-01-02T05:04:05-05:00", want},
+		{"RFC3339Nano", "2024-01-02T10:04:05.123456789Z", want.Add(123456789)},
+		{"milliseconds with offset", "2024-01-02T12:04:05.500+02:00", want.Add(500 * time.Millisecond)},
+		{"space separator", "2024-01-02 10:04:05Z", want},
+		{"space separator with offset", "2024-01-02 12:04:05+02:00", want},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTimestamp("started_at", tt.value)
+			if err != nil {
+				t.Fatalf("parseTimestamp(%q) error: %v", tt.value, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseTimestamp(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTimestampRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"empty", "", "missing ended_at: expected RFC 3339"},
+		{"no offset", "2024-01-02T10:04:05", `invalid ended_at "2024-01-02T10:04:05": no UTC offset`},
+		{"no offset with fraction", "2024-01-02T10:04:05.250", "no UTC offset"},
+		{"space separator without offset", "2024-01-02 10:04:05", "no UTC offset"},
+		{"date only", "2024-01-02", `invalid ended_at "2024-01-02": expected RFC 3339`},
+		{"unix seconds", "1704189845", `invalid ended_at "1704189845"`},
+		{"words", "yesterday", `invalid ended_at "yesterday"`},
+		{"RFC1123", "Tue, 02 Jan 2024 10:04:05 UTC", "expected RFC 3339"},
+		{"out of range", "2024-13-02T10:04:05Z", "expected RFC 3339"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTimestamp("ended_at", tt.value)
+			if err == nil {
+				t.Fatalf("parseTimestamp(%q) succeeded", tt.value)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseTimestamp(%q) error = %q, want it to contain %q", tt.value, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package sync
+
+import (
+	"errors"
+	"log"
+	"net/http"
+)
+
+// adaptiveGrowAfter is how many full passes in a row it takes to double
+// the adaptive batch size.
+const adaptiveGrowAfter = 3
+
+// SetAdaptiveBatch lets the batch size float between the one given to
+// NewSyncer and maximum while a backlog drains: it doubles after
+// adaptiveGrowAfter passes in a row that came back full, halves after a
+// failed pass, and falls back to the base size when the server answers
+// 413. A maximum no larger than the base size keeps batches fixed. Must be
+// called before Start.
+func (s *Syncer) SetAdaptiveBatch(maximum int) {
+	if maximum <= s.batchSize {
+		s.maxBatchSize = 0
+		s.adaptiveSize.Store(0)
+		return
+	}
+	s.maxBatchSize = maximum
+	s.adaptiveSize.Store(int64(s.batchSize))
+}
+
+// batch is how many activities go in one upload: the adaptive size when
+// SetAdaptiveBatch is on, otherwise the fixed batchSize.
+func (s *Syncer) batch() int {
+	if n := s.adaptiveSize.Load(); n > 0 {
+		return int(n)
+	}
+	return s.batchSize
+}
+
+// adaptBatch updates the adaptive batch size after a pass that returned
+// err, or that succeeded and did or didn't fill passSize. Callers hold
+// drainMu.
+func (s *Syncer) adaptBatch(full bool, err error) {
+	tooLarge := s.tooLarge.Swap(false)
+	if s.maxBatchSize == 0 {
+		return
+	}
+	size := s.batch()
+	next := size
+	switch {
+	case tooLarge:
+		next = s.batchSize
+	case err != nil:
+		next = max(size/2, s.batchSize)
+	case full:
+		s.fullPasses++
+		if s.fullPasses < adaptiveGrowAfter {
+			return
+		}
+		next = min(size*2, s.maxBatchSize)
+	}
+	s.fullPasses = 0
+	if next != size {
+		log.Printf("sync: batch size %d -> %d", size, next)
+		s.adaptiveSize.Store(int64(next))
+	}
+}
+
+// noteTooLarge remembers a 413 so the next adaptBatch drops to the base
+// size, even if splitting or isolating records let the pass succeed.
+func (s *Syncer) noteTooLarge(err error) {
+	var se *statusError
+	if errors.As(err, &se) && se.StatusCode == http.StatusRequestEntityTooLarge {
+		s.tooLarge.Store(true)
+	}
+}
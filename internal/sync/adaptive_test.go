@@ -0,0 +1,127 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	gosync "sync"
+	"testing"
+	"time"
+)
+
+// batchRecorder records the size of every upload and answers it with the
+// status fail returns, or acknowledges it when that is 0.
+type batchRecorder struct {
+	mu    gosync.Mutex
+	sizes []int
+	fail  func(call, size int) int
+}
+
+func (b *batchRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req syncRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b.mu.Lock()
+	b.sizes = append(b.sizes, len(req.Activities))
+	call := len(b.sizes)
+	b.mu.Unlock()
+
+	if b.fail != nil {
+		if status := b.fail(call, len(req.Activities)); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(syncResponse{Success: true, Count: len(req.Activities)})
+}
+
+func (b *batchRecorder) got() []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.sizes)
+}
+
+func TestAdaptiveBatchGrows(t *testing.T) {
+	rec := &batchRecorder{}
+	syncer, database := setupTestSyncer(t, rec)
+	syncer.batchSize = 2
+	syncer.SetAdaptiveBatch(8)
+	insertActivities(t, database, 40)
+
+	if n, err := syncer.Drain(0); err != nil || n != 40 {
+		t.Fatalf("Drain() = %d, %v; want 40, nil", n, err)
+	}
+	// Three full passes at each size before doubling, capped at 8; the
+	// short last pass ends the drain.
+	want := []int{2, 2, 2, 4, 4, 4, 8, 8, 6}
+	if got := rec.got(); !slices.Equal(got, want) {
+		t.Errorf("batch sizes = %v, want %v", got, want)
+	}
+}
+
+func TestAdaptiveBatchShrinksOnFailure(t *testing.T) {
+	rec := &batchRecorder{fail: func(call, _ int) int {
+		if call == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}}
+	syncer, database := setupTestSyncer(t, rec)
+	syncer.minBackoff = time.Millisecond
+	syncer.maxBackoff = 5 * time.Millisecond
+	syncer.batchSize = 2
+	syncer.SetAdaptiveBatch(8)
+	syncer.adaptiveSize.Store(8)
+	insertActivities(t, database, 10)
+
+	if n, err := syncer.Drain(1); err != nil || n != 10 {
+		t.Fatalf("Drain() = %d, %v; want 10, nil", n, err)
+	}
+	want := []int{8, 4, 4, 2}
+	if got := rec.got(); !slices.Equal(got, want) {
+		t.Errorf("batch sizes = %v, want %v", got, want)
+	}
+}
+
+func TestAdaptiveBatchResetsOnTooLarge(t *testing.T) {
+	rec := &batchRecorder{fail: func(_, size int) int {
+		if size > 2 {
+			return http.StatusRequestEntityTooLarge
+		}
+		return 0
+	}}
+	syncer, database := setupTestSyncer(t, rec)
+	syncer.minBackoff = time.Millisecond
+	syncer.maxBackoff = 5 * time.Millisecond
+	syncer.batchSize = 2
+	syncer.SetAdaptiveBatch(8)
+	syncer.adaptiveSize.Store(8)
+	insertActivities(t, database, 5)
+
+	if n, err := syncer.Drain(1); err != nil || n != 5 {
+		t.Fatalf("Drain() = %d, %v; want 5, nil", n, err)
+	}
+	// Straight back to the base size rather than halving to 4.
+	want := []int{5, 2, 2, 1}
+	if got := rec.got(); !slices.Equal(got, want) {
+		t.Errorf("batch sizes = %v, want %v", got, want)
+	}
+}
+
+func TestFixedBatch(t *testing.T) {
+	rec := &batchRecorder{}
+	syncer, database := setupTestSyncer(t, rec)
+	syncer.batchSize = 2
+	syncer.SetAdaptiveBatch(2)
+	insertActivities(t, database, 9)
+
+	if _, err := syncer.Drain(0); err != nil {
+		t.Fatalf("Drain() error: %v", err)
+	}
+	want := []int{2, 2, 2, 2, 1}
+	if got := rec.got(); !slices.Equal(got, want) {
+		t.Errorf("batch sizes = %v, want %v", got, want)
+	}
+}
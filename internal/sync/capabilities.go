@@ -0,0 +1,127 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+)
+
+// capabilities is the server's answer to GET /api/capabilities: the
+// optional activity fields it understands. A nil *capabilities means the
+// server didn't say, so everything is sent.
+type capabilities struct {
+	Fields []string `json:"fields"`
+}
+
+// supports reports whether the server accepts the optional payload field
+// with the given JSON name.
+func (c *capabilities) supports(field string) bool {
+	return c == nil || slices.Contains(c.Fields, field)
+}
+
+// strip clears optional fields the server doesn't accept. They are all
+// omitempty, so cleared fields are left out of the payload entirely.
+// durationSeconds is derived from startedAt and endedAt, so an older
+// server loses nothing without it.
+func (c *capabilities) strip(p *activityPayload) {
+	if c == nil {
+		return
+	}
+	if !c.supports("durationSeconds") {
+		p.DurationSeconds = nil
+	}
+	if !c.supports("gitRemote") {
+		p.GitRemote = ""
+	}
+	if !c.supports("gitBranch") {
+		p.GitBranch = ""
+	}
+	if !c.supports("gitCommit") {
+		p.GitCommit = ""
+	}
+	if !c.supports("tags") {
+		p.Tags = nil
+	}
+	if !c.supports("metadata") {
+		p.Metadata = nil
+	}
+	if !c.supports("actionsPerMinute") {
+		p.ActionsPerMinute = 0
+	}
+	if !c.supports("wordsPerMinute") {
+		p.WordsPerMinute = 0
+	}
+	if !c.supports("machine") {
+		p.Machine = ""
+	}
+	if !c.supports("source") {
+		p.Source = ""
+	}
+	if !c.supports("instanceUUID") {
+		p.InstanceUUID = ""
+	}
+	if !c.supports("keystrokes") {
+		p.Keystrokes = 0
+	}
+	if !c.supports("edits") {
+		p.Edits = 0
+	}
+	if !c.supports("tzOffset") {
+		p.TZOffset = nil
+	}
+	if !c.supports("timezone") {
+		p.Timezone = ""
+	}
+}
+
+// loadCapabilities asks the server which optional fields it accepts, once.
+// Servers that predate the endpoint answer 404, 405 or 501 and get every
+// field, as before. Anything else, e.g. a 401, 429 or 5xx, or a transport
+// error, sends every field this pass and probes again on the next.
+func (s *Syncer) loadCapabilities(ctx context.Context) {
+	if s.capsLoaded.Load() {
+		return
+	}
+
+	caps, err := s.fetchCapabilities(ctx)
+	if err != nil {
+		log.Printf("sync: capabilities probe failed, sending all fields: %v", err)
+		return
+	}
+	s.caps.Store(caps)
+	s.capsLoaded.Store(true)
+}
+
+func (s *Syncer) fetchCapabilities(ctx context.Context) (caps *capabilities, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.serverURL+"/api/capabilities", nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer func() {
+		if closeErr := closeBody(resp); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		// An older server without the endpoint.
+		return nil, nil
+	default:
+		return nil, &statusError{StatusCode: resp.StatusCode}
+	}
+
+	caps = &capabilities{}
+	if err := json.NewDecoder(resp.Body).Decode(caps); err != nil {
+		return nil, fmt.Errorf("decode capabilities: %w", err)
+	}
+	return caps, nil
+}
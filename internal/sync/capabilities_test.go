@@ -0,0 +1,155 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/taigrr/blastd/internal/db"
+)
+
+func TestSyncCapabilities(t *testing.T) {
+	var probes atomic.Int32
+	var got []map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/capabilities", func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		if err := json.NewEncoder(w).Encode(capabilities{Fields: []string{"gitRemote", "machine"}}); err != nil {
+			t.Errorf("Encode() error: %v", err)
+		}
+	})
+	mux.HandleFunc("POST /api/activities", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Activities []map[string]any `json:"activities"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Decode() error: %v", err)
+		}
+		got = append(got, req.Activities...)
+		if err := json.NewEncoder(w).Encode(syncResponse{Success: true, Count: len(req.Activities)}); err != nil {
+			t.Errorf("Encode() error: %v", err)
+		}
+	})
+
+	// setupTestSyncer's server hides the probe, so point at an unwrapped one.
+	syncer, database := setupTestSyncer(t, mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	syncer.serverURL = server.URL
+	for range 2 {
+		now := time.Now().UTC()
+		a := &db.Activity{
+			Project:      "blast",
+			GitRemote:    "git@github.com:taigrr/blast.git",
+			GitBranch:    "main",
+			GitCommit:    "abc123",
+			Tags:         []string{"work"},
+			Metadata:     json.RawMessage(`{"lsp":"gopls"}`),
+			StartedAt:    now.Add(-time.Minute),
+			EndedAt:      now,
+			Editor:       "neovim",
+			InstanceUUID: "0b5e1f62-7d4c-4f5e-9a3b-2c8d1e6f4a70",
+			Keystrokes:   120,
+			Edits:        7,
+			Machine:      "test",
+		}
+		if err := database.InsertActivity(a); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := syncer.syncBatch(); err != nil {
+			t.Fatalf("syncBatch() error: %v", err)
+		}
+	}
+
+	if probes.Load() != 1 {
+		t.Errorf("capabilities fetched %d times, want once", probes.Load())
+	}
+	if len(got) != 2 {
+		t.Fatalf("server got %d activities, want 2", len(got))
+	}
+	for _, field := range []string{"durationSeconds", "gitBranch", "gitCommit", "tags", "metadata", "source", "instanceUUID", "tzOffset", "timezone", "keystrokes", "edits"} {
+		if _, ok := got[0][field]; ok {
+			t.Errorf("unadvertised field %q was sent", field)
+		}
+	}
+	for _, field := range []string{"gitRemote", "machine", "project", "clientUUID"} {
+		if _, ok := got[0][field]; !ok {
+			t.Errorf("field %q missing from payload", field)
+		}
+	}
+}
+
+func TestStripDurationSeconds(t *testing.T) {
+	zero := 0.0
+	tests := []struct {
+		caps *capabilities
+		want bool
+	}{
+		{nil, true},
+		{&capabilities{Fields: []string{"durationSeconds"}}, true},
+		{&capabilities{Fields: []string{"gitRemote"}}, false},
+	}
+	for _, tt := range tests {
+		p := activityPayload{DurationSeconds: &zero}
+		tt.caps.strip(&p)
+		body, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(body, &fields); err != nil {
+			t.Fatal(err)
+		}
+		// A zero duration is still sent to servers that accept the field.
+		if _, sent := fields["durationSeconds"]; sent != tt.want {
+			t.Errorf("caps %v: durationSeconds sent = %v, want %v", tt.caps, sent, tt.want)
+		}
+	}
+}
+
+func TestCapabilitiesProbeStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		probes int32
+	}{
+		// No endpoint: remembered as a legacy server.
+		{http.StatusNotFound, 1},
+		{http.StatusMethodNotAllowed, 1},
+		{http.StatusNotImplemented, 1},
+		// Auth, rate limiting and server errors say nothing about the
+		// endpoint, so the next pass asks again.
+		{http.StatusUnauthorized, 2},
+		{http.StatusForbidden, 2},
+		{http.StatusTooManyRequests, 2},
+		{http.StatusServiceUnavailable, 2},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			var probes atomic.Int32
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /api/capabilities", func(w http.ResponseWriter, r *http.Request) {
+				probes.Add(1)
+				w.WriteHeader(tt.status)
+			})
+			mux.Handle("POST /api/activities", okHandler(t))
+
+			syncer, database := setupTestSyncer(t, mux)
+			server := httptest.NewServer(mux)
+			t.Cleanup(server.Close)
+			syncer.serverURL = server.URL
+
+			for range 2 {
+				insertActivities(t, database, 1)
+				if _, err := syncer.syncBatch(); err != nil {
+					t.Fatalf("syncBatch() error: %v", err)
+				}
+			}
+			if got := probes.Load(); got != tt.probes {
+				t.Errorf("capabilities probed %d times, want %d", got, tt.probes)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package sync
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// checkClockSkew compares the server's Date header with the local clock
+// and logs when they drift apart by more than the configured threshold,
+// which usually means NTP isn't running. It only warns again after the
+// clocks have agreed in between; timestamps are never adjusted.
+func (s *Syncer) checkClockSkew(header http.Header, now time.Time) {
+	if s.clockSkewThreshold <= 0 {
+		return
+	}
+	serverTime, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		return
+	}
+
+	skew := now.Sub(serverTime)
+	if skew.Abs() > s.clockSkewThreshold {
+		if s.clockSkewed.CompareAndSwap(false, true) {
+			direction := "ahead of"
+			if skew < 0 {
+				direction = "behind"
+			}
+			log.Printf("sync: WARNING: local clock is %s %s the server's; activity timestamps will be off (is NTP running?)",
+				skew.Abs().Round(time.Second), direction)
+		}
+		return
+	}
+	if s.clockSkewed.CompareAndSwap(true, false) {
+		log.Println("sync: local clock agrees with the server's again")
+	}
+}
@@ -0,0 +1,58 @@
+package sync
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCheckClockSkew(t *testing.T) {
+	syncer, _ := setupTestSyncer(t, okHandler(t))
+	syncer.SetClockSkewThreshold(5 * time.Minute)
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	date := func(d time.Duration) http.Header {
+		return http.Header{"Date": []string{now.Add(d).Format(http.TimeFormat)}}
+	}
+
+	syncer.checkClockSkew(date(time.Minute), now)
+	if syncer.clockSkewed.Load() {
+		t.Error("1m of skew flagged, want under the 5m threshold")
+	}
+	syncer.checkClockSkew(date(-time.Hour), now)
+	if !syncer.clockSkewed.Load() {
+		t.Error("1h of skew not flagged")
+	}
+	syncer.checkClockSkew(http.Header{}, now)
+	if !syncer.clockSkewed.Load() {
+		t.Error("missing Date header cleared the skew warning")
+	}
+	syncer.checkClockSkew(date(0), now)
+	if syncer.clockSkewed.Load() {
+		t.Error("skew still flagged after the clocks agree")
+	}
+
+	syncer.SetClockSkewThreshold(0)
+	syncer.checkClockSkew(date(time.Hour), now)
+	if syncer.clockSkewed.Load() {
+		t.Error("skew flagged with the check disabled")
+	}
+}
+
+func TestSyncDetectsClockSkew(t *testing.T) {
+	ok := okHandler(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(2*time.Hour).UTC().Format(http.TimeFormat))
+		ok(w, r)
+	})
+	syncer, database := setupTestSyncer(t, handler)
+	syncer.SetClockSkewThreshold(5 * time.Minute)
+	insertActivities(t, database, 1)
+
+	if _, err := syncer.syncBatch(); err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+	if !syncer.clockSkewed.Load() {
+		t.Error("2h of skew from the upload response was not flagged")
+	}
+}
@@ -0,0 +1,31 @@
+package sync
+
+import "fmt"
+
+// SetFailureNotifier makes the syncer call notify once after passes
+// consecutive sync passes have failed, and once more when a pass next
+// succeeds, so a long outage raises one alert rather than one per retry.
+// notify runs on its own goroutine and must be safe for concurrent use.
+func (s *Syncer) SetFailureNotifier(passes int, notify func(title, message string)) {
+	s.notifyAfter = max(passes, 1)
+	s.notifyFailure = notify
+}
+
+// trackFailures counts consecutive failed passes for the failure notifier.
+func (s *Syncer) trackFailures(n int, err error) {
+	if s.notifyFailure == nil {
+		return
+	}
+	if err != nil {
+		s.failures++
+		if s.failures == s.notifyAfter {
+			go s.notifyFailure("blastd sync failing",
+				fmt.Sprintf("%d sync attempts in a row failed; activities are kept locally. Last error: %v", s.failures, err))
+		}
+		return
+	}
+	if s.failures >= s.notifyAfter {
+		go s.notifyFailure("blastd sync recovered", fmt.Sprintf("Sync is working again (%d activities sent).", n))
+	}
+	s.failures = 0
+}
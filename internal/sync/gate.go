@@ -0,0 +1,55 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// gateTimeout bounds one run of the gate command; one that hangs counts as
+// a veto.
+const gateTimeout = 10 * time.Second
+
+// ErrSyncGated is returned by SyncNow and Drain when the gate command
+// vetoed syncing.
+var ErrSyncGated = errors.New("sync skipped: sync_gate_command exited non-zero")
+
+// SetGate sets a shell command run before every drain and shutdown flush.
+// A non-zero exit skips that sync, leaving activities queued for the next
+// one, e.g. to avoid syncing over a metered connection. Empty disables the
+// gate.
+func (s *Syncer) SetGate(command string) {
+	s.gateCommand = command
+}
+
+// gateOpen runs the gate command and reports whether syncing may go ahead.
+// It logs only when the answer changes, so a gate that stays closed for
+// hours doesn't fill the log.
+func (s *Syncer) gateOpen(ctx context.Context) bool {
+	if s.gateCommand == "" {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(ctx, gateTimeout)
+	defer cancel()
+
+	if err := shellCommand(ctx, s.gateCommand).Run(); err != nil {
+		if s.gateClosed.CompareAndSwap(false, true) {
+			log.Printf("sync: paused by sync_gate_command (%v); activities stay queued", err)
+		}
+		return false
+	}
+	if s.gateClosed.CompareAndSwap(true, false) {
+		log.Println("sync: sync_gate_command allows syncing again")
+	}
+	return true
+}
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
@@ -0,0 +1,43 @@
+package sync
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGate(t *testing.T) {
+	var uploads atomic.Int32
+	syncer, database := setupTestSyncer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploads.Add(1)
+		okHandler(t)(w, r)
+	}))
+	insertActivities(t, database, 2)
+
+	// The gate opens once the marker file exists.
+	marker := filepath.Join(t.TempDir(), "on-wifi")
+	syncer.SetGate("test -e " + marker)
+
+	if err := syncer.SyncNow(); !errors.Is(err, ErrSyncGated) {
+		t.Fatalf("SyncNow() with the gate closed = %v, want ErrSyncGated", err)
+	}
+	if _, err := syncer.Drain(0); !errors.Is(err, ErrSyncGated) {
+		t.Fatalf("Drain() with the gate closed = %v, want ErrSyncGated", err)
+	}
+	if uploads.Load() != 0 {
+		t.Fatalf("%d uploads with the gate closed, want 0", uploads.Load())
+	}
+
+	if err := os.WriteFile(marker, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncer.SyncNow(); err != nil {
+		t.Fatalf("SyncNow() with the gate open = %v", err)
+	}
+	if remaining, err := database.GetUnsyncedActivities(10); err != nil || len(remaining) != 0 {
+		t.Errorf("%d unsynced after an open gate (%v), want 0", len(remaining), err)
+	}
+}
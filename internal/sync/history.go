@@ -0,0 +1,45 @@
+package sync
+
+import (
+	"slices"
+	"time"
+)
+
+// historySize is how many recent sync attempts History keeps.
+const historySize = 20
+
+// Attempt is the outcome of one sync pass, kept so "why isn't my data
+// showing up" can be answered without reading logs.
+type Attempt struct {
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	Synced   int           `json:"synced"`
+	Error    string        `json:"error,omitempty"`
+	Warnings []string      `json:"warnings,omitempty"`
+}
+
+// record appends the result of a pass started at start, with the server
+// warnings noted during it, dropping the oldest attempt once historySize
+// is reached.
+func (s *Syncer) record(start time.Time, synced int, err error) {
+	a := Attempt{Time: start, Duration: time.Since(start), Synced: synced}
+	if err != nil {
+		a.Error = err.Error()
+	}
+
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	a.Warnings, s.passWarnings = s.passWarnings, nil
+	if len(s.history) == historySize {
+		s.history = slices.Delete(s.history, 0, 1)
+	}
+	s.history = append(s.history, a)
+}
+
+// History returns the most recent sync attempts, oldest first. It is safe
+// to call while the syncer is running.
+func (s *Syncer) History() []Attempt {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	return slices.Clone(s.history)
+}
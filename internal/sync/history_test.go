@@ -0,0 +1,60 @@
+package sync
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSyncHistory(t *testing.T) {
+	var fail atomic.Bool
+	ok := okHandler(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		ok(w, r)
+	})
+	syncer, database := setupTestSyncer(t, handler)
+
+	insertActivities(t, database, 3)
+	if _, err := syncer.syncBatch(); err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+	fail.Store(true)
+	insertActivities(t, database, 1)
+	if _, err := syncer.syncBatch(); err == nil {
+		t.Fatal("syncBatch() against a down server succeeded, want error")
+	}
+
+	history := syncer.History()
+	if len(history) != 2 {
+		t.Fatalf("History() has %d attempts, want 2", len(history))
+	}
+	if history[0].Synced != 3 || history[0].Error != "" {
+		t.Errorf("first attempt = %+v, want 3 synced without error", history[0])
+	}
+	if history[1].Synced != 0 || !strings.Contains(history[1].Error, "503") {
+		t.Errorf("second attempt = %+v, want a 503 error", history[1])
+	}
+	if history[1].Time.Before(history[0].Time) {
+		t.Error("History() is not oldest first")
+	}
+
+	// The ring keeps only the latest historySize attempts.
+	fail.Store(false)
+	for range historySize {
+		if _, err := syncer.syncBatch(); err != nil {
+			t.Fatalf("syncBatch() error: %v", err)
+		}
+	}
+	history = syncer.History()
+	if len(history) != historySize {
+		t.Fatalf("History() has %d attempts, want %d", len(history), historySize)
+	}
+	if history[0].Synced != 1 {
+		t.Errorf("oldest kept attempt = %+v, want the retry that synced 1", history[0])
+	}
+}
@@ -0,0 +1,157 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// oauthExpiryMargin is how long before its expiry a cached token is
+// replaced, so a request never leaves with a token about to lapse.
+const oauthExpiryMargin = 30 * time.Second
+
+// oauthSource fetches bearer tokens with the OAuth 2.0 client credentials
+// grant and caches them until shortly before they expire.
+type oauthSource struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+
+	mu     gosync.Mutex
+	token  string
+	expiry time.Time // zero when the server gave no expires_in
+}
+
+// tokenResponse is the token endpoint's success response (RFC 6749 §5.1).
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// SetOAuth makes the syncer authenticate with tokens from an OAuth 2.0
+// client credentials endpoint instead of the static API token. Tokens are
+// cached until shortly before expiry and refetched when the server answers
+// 401. An empty tokenURL keeps the static token.
+func (s *Syncer) SetOAuth(tokenURL, clientID, clientSecret string) {
+	if tokenURL == "" {
+		s.oauth = nil
+		return
+	}
+	s.oauth = &oauthSource{tokenURL: tokenURL, clientID: clientID, clientSecret: clientSecret}
+}
+
+// hasCredentials reports whether requests can be authenticated at all.
+func (s *Syncer) hasCredentials() bool {
+	return s.oauth != nil || s.apiToken != ""
+}
+
+// bearer returns the token to send, fetching a new one from the OAuth
+// endpoint when none is cached or the cached one is about to expire.
+func (s *Syncer) bearer(ctx context.Context) (string, error) {
+	if s.oauth == nil {
+		return s.apiToken, nil
+	}
+	o := s.oauth
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.token != "" && (o.expiry.IsZero() || time.Until(o.expiry) > oauthExpiryMargin) {
+		return o.token, nil
+	}
+	tok, err := s.fetchToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("fetch oauth token: %w", err)
+	}
+	o.token = tok.AccessToken
+	o.expiry = time.Time{}
+	if tok.ExpiresIn > 0 {
+		o.expiry = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	}
+	return o.token, nil
+}
+
+// invalidate drops the cached token if it is still the one that was
+// rejected, so concurrent workers hitting the same 401 fetch only once.
+func (o *oauthSource) invalidate(rejected string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.token == rejected {
+		o.token = ""
+	}
+}
+
+func (s *Syncer) fetchToken(ctx context.Context) (tok *tokenResponse, err error) {
+	o := s.oauth
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequestWithContext(ctx, "POST", o.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// RFC 6749 §2.3.1: credentials are form-encoded before Basic encoding.
+	req.SetBasicAuth(url.QueryEscape(o.clientID), url.QueryEscape(o.clientSecret))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer func() {
+		if closeErr := closeBody(resp); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{StatusCode: resp.StatusCode}
+	}
+	tok = &tokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(tok); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return nil, fmt.Errorf("response has no access_token")
+	}
+	if tok.TokenType != "" && !strings.EqualFold(tok.TokenType, "bearer") {
+		return nil, fmt.Errorf("unsupported token_type %q", tok.TokenType)
+	}
+	return tok, nil
+}
+
+// do sends an authenticated request. With OAuth, a 401 discards the cached
+// token and, when the body can be replayed, retries once with a fresh one.
+func (s *Syncer) do(req *http.Request) (*http.Response, error) {
+	token, err := s.bearer(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := s.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || s.oauth == nil {
+		return resp, err
+	}
+	s.oauth.invalidate(token)
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	if token, err = s.bearer(req.Context()); err != nil {
+		return resp, nil
+	}
+	closeBody(resp)
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return s.client.Do(retry)
+}
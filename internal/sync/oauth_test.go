@@ -0,0 +1,155 @@
+package sync
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// oauthServer issues tok-1, tok-2, ... and counts how many it has issued.
+func oauthServer(t *testing.T, expiresIn int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var issued atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "blastd" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		n := issued.Add(1)
+		if err := json.NewEncoder(w).Encode(tokenResponse{
+			AccessToken: fmt.Sprintf("tok-%d", n),
+			TokenType:   "Bearer",
+			ExpiresIn:   int64(expiresIn),
+		}); err != nil {
+			t.Errorf("Encode() error: %v", err)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &issued
+}
+
+// acceptOnly answers 401 unless the request carries the current token.
+func acceptOnly(t *testing.T, current *atomic.Value) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+current.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		okHandler(t)(w, r)
+	}
+}
+
+func TestOAuthTokenCached(t *testing.T) {
+	tokens, issued := oauthServer(t, 3600)
+	var current atomic.Value
+	current.Store("tok-1")
+
+	syncer, database := setupTestSyncer(t, acceptOnly(t, &current))
+	syncer.SetOAuth(tokens.URL, "blastd", "s3cret")
+
+	for range 3 {
+		insertActivities(t, database, 1)
+		if _, err := syncer.syncBatch(); err != nil {
+			t.Fatalf("syncBatch() error: %v", err)
+		}
+	}
+	if got := issued.Load(); got != 1 {
+		t.Errorf("issued %d tokens, want 1", got)
+	}
+}
+
+func TestOAuthRefreshOn401(t *testing.T) {
+	tokens, issued := oauthServer(t, 3600)
+	var current atomic.Value
+	current.Store("tok-1")
+
+	syncer, database := setupTestSyncer(t, acceptOnly(t, &current))
+	syncer.SetOAuth(tokens.URL, "blastd", "s3cret")
+
+	insertActivities(t, database, 1)
+	if _, err := syncer.syncBatch(); err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+
+	// The server revokes tok-1; the next upload is retried with tok-2.
+	current.Store("tok-2")
+	insertActivities(t, database, 1)
+	n, err := syncer.syncBatch()
+	if err != nil {
+		t.Fatalf("syncBatch() after revocation error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("synced %d, want 1", n)
+	}
+	if got := issued.Load(); got != 2 {
+		t.Errorf("issued %d tokens, want 2", got)
+	}
+}
+
+func TestOAuthRefreshOn401Streamed(t *testing.T) {
+	tokens, _ := oauthServer(t, 3600)
+	var lines atomic.Int32
+	syncer, database := setupTestSyncer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			lines.Add(1)
+		}
+		json.NewEncoder(w).Encode(syncResponse{Success: true})
+	}))
+	syncer.SetOAuth(tokens.URL, "blastd", "s3cret")
+	syncer.SetStream(true)
+
+	// tok-1 is refused; the streamed body is replayed with tok-2.
+	insertActivities(t, database, 3)
+	n, err := syncer.syncBatch()
+	if err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+	if n != 3 || lines.Load() != 3 {
+		t.Errorf("synced %d, server read %d lines; want 3 and 3", n, lines.Load())
+	}
+}
+
+func TestOAuthTokenExpiry(t *testing.T) {
+	// Inside oauthExpiryMargin, so every request needs a new token.
+	tokens, issued := oauthServer(t, 10)
+	syncer, database := setupTestSyncer(t, okHandler(t))
+	syncer.SetOAuth(tokens.URL, "blastd", "s3cret")
+
+	for range 2 {
+		insertActivities(t, database, 1)
+		if _, err := syncer.syncBatch(); err != nil {
+			t.Fatalf("syncBatch() error: %v", err)
+		}
+	}
+	// One for the capabilities probe, one per upload.
+	if got := issued.Load(); got != 3 {
+		t.Errorf("issued %d tokens, want 3", got)
+	}
+}
+
+func TestOAuthTokenEndpointFailure(t *testing.T) {
+	tokens, _ := oauthServer(t, 3600)
+	syncer, database := setupTestSyncer(t, okHandler(t))
+	syncer.SetOAuth(tokens.URL, "blastd", "wrong")
+
+	insertActivities(t, database, 1)
+	if _, err := syncer.syncBatch(); err == nil {
+		t.Fatal("syncBatch() succeeded with rejected client credentials")
+	}
+	if remaining, err := database.GetUnsyncedActivities(10); err != nil || len(remaining) != 1 {
+		t.Errorf("%d unsynced remaining (err %v), want 1", len(remaining), err)
+	}
+}
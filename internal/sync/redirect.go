@@ -0,0 +1,50 @@
+package sync
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// maxRedirects matches net/http's default limit.
+const maxRedirects = 10
+
+// redirectError is returned when the server redirects an upload in a way
+// that can't be followed without losing it: a 301, 302 or 303 turns a
+// POST into a GET without the body.
+type redirectError struct {
+	StatusCode int
+	Location   string
+	Reason     string
+}
+
+func (e *redirectError) Error() string {
+	return fmt.Sprintf("server redirected with status %d to %s, %s; set server_url to the new address", e.StatusCode, e.Location, e.Reason)
+}
+
+// checkRedirect is the HTTP client's redirect policy. A redirect that
+// keeps the method, which net/http only does with the body for 307 and
+// 308, is followed with a warning; one that would change it fails the
+// request instead of sending an empty upload.
+func (s *Syncer) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	status := 0
+	if req.Response != nil {
+		status = req.Response.StatusCode
+	}
+	if orig := via[0]; req.Method != orig.Method {
+		return &redirectError{
+			StatusCode: status,
+			Location:   req.URL.Redacted(),
+			Reason:     fmt.Sprintf("which would resend the %s as a %s without its body", orig.Method, req.Method),
+		}
+	}
+	from := via[len(via)-1].URL.Redacted()
+	to := req.URL.Redacted()
+	if _, seen := s.redirects.LoadOrStore(from+" "+to, true); !seen {
+		log.Printf("sync: WARNING: %s redirected with status %d to %s; set server_url to the new address", from, status, to)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package sync
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// redirectTo answers every upload with status, pointing at target's
+// upload endpoint.
+func redirectTo(target *httptest.Server, status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL+r.URL.Path, status)
+	}
+}
+
+func TestSyncFollowsMethodPreservingRedirect(t *testing.T) {
+	var auth atomic.Value
+	target := httptest.NewServer(noCapabilities(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth.Store(r.Header.Get("Authorization"))
+		okHandler(t)(w, r)
+	})))
+	t.Cleanup(target.Close)
+
+	for _, status := range []int{http.StatusTemporaryRedirect, http.StatusPermanentRedirect} {
+		syncer, database := setupTestSyncer(t, redirectTo(target, status))
+		insertActivities(t, database, 3)
+
+		n, err := syncer.syncBatch()
+		if err != nil {
+			t.Fatalf("%d: syncBatch() error: %v", status, err)
+		}
+		if n != 3 {
+			t.Errorf("%d: synced %d, want 3", status, n)
+		}
+		if got := auth.Load(); got != "Bearer test-token" {
+			t.Errorf("%d: redirected request Authorization = %v", status, got)
+		}
+		warned := 0
+		syncer.redirects.Range(func(key, _ any) bool {
+			if !strings.HasSuffix(key.(string), " "+target.URL+"/api/activities") {
+				t.Errorf("%d: warned about %q", status, key)
+			}
+			warned++
+			return true
+		})
+		if warned != 1 {
+			t.Errorf("%d: warned about %d redirects, want 1", status, warned)
+		}
+	}
+}
+
+func TestSyncRejectsRedirectThatDropsBody(t *testing.T) {
+	var hits atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	t.Cleanup(target.Close)
+
+	for _, status := range []int{http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther} {
+		syncer, database := setupTestSyncer(t, redirectTo(target, status))
+		insertActivities(t, database, 2)
+
+		_, err := syncer.syncBatch()
+		var re *redirectError
+		if !errors.As(err, &re) {
+			t.Fatalf("%d: syncBatch() error = %v, want a redirect error", status, err)
+		}
+		if re.StatusCode != status || !strings.Contains(err.Error(), "POST as a GET") {
+			t.Errorf("%d: error = %v", status, err)
+		}
+		if remaining, _ := database.GetUnsyncedActivities(100); len(remaining) != 2 {
+			t.Errorf("%d: %d unsynced remaining, want 2", status, len(remaining))
+		}
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("redirect target got %d requests, want 0", n)
+	}
+}
+
+func TestSyncStreamedRedirect(t *testing.T) {
+	var lines atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			lines.Add(1)
+		}
+		json.NewEncoder(w).Encode(syncResponse{Success: true})
+	}))
+	t.Cleanup(target.Close)
+
+	syncer, database := setupTestSyncer(t, redirectTo(target, http.StatusPermanentRedirect))
+	syncer.SetStream(true)
+	insertActivities(t, database, 2)
+
+	// The streamed body is encoded again for the new address.
+	n, err := syncer.syncBatch()
+	if err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+	if n != 2 || lines.Load() != 2 {
+		t.Errorf("synced %d, target read %d lines; want 2 and 2", n, lines.Load())
+	}
+}
@@ -2,238 +2,1085 @@ package sync
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"slices"
+	gosync "sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/taigrr/blastd/internal/db"
 )
 
 type Syncer struct {
-	db          *db.DB
-	serverURL   string
-	apiToken    string
-	interval    time.Duration
-	batchSize   int
-	metricsOnly bool
-	backoff     time.Duration
-	minBackoff  time.Duration
-	maxBackoff  time.Duration
-	done        chan struct{}
-	client      *http.Client
+	db                 *db.DB
+	serverURL          string
+	apiToken           string
+	oauth              *oauthSource
+	interval           time.Duration
+	debounce           time.Duration
+	startupDelay       time.Duration
+	activity           chan struct{}
+	batchSize          int
+	maxBatchSize       int          // adaptive batch cap; 0 keeps batchSize fixed
+	adaptiveSize       atomic.Int64 // current adaptive batch size; 0 when off
+	fullPasses         int          // full passes in a row; guarded by drainMu
+	tooLarge           atomic.Bool  // a 413 since the last adaptBatch
+	passFull           atomic.Bool  // the last pass claimed all it asked for, so more may be waiting
+	concurrency        int
+	coalesceGap        time.Duration
+	maxBytes           int
+	order              db.SyncOrder
+	metricsOnly        bool
+	clientInfo         *ClientInfo
+	stream             bool
+	deadLetterAfter    int
+	backoff            time.Duration
+	retryAt            time.Time
+	probe              bool
+	offlineRetry       time.Duration
+	offline            bool
+	offlineProbes      int // failed probes in a row; guarded by drainMu
+	minBackoff         time.Duration
+	maxBackoff         time.Duration
+	backoffFactor      float64
+	done               chan struct{}
+	finished           chan struct{}
+	started            atomic.Bool
+	caps               atomic.Pointer[capabilities]
+	capsLoaded         atomic.Bool
+	deletesUnsupported atomic.Bool
+	tombstoneMu        gosync.Mutex
+	tombstoneBackoff   time.Duration // guarded by tombstoneMu
+	tombstoneRetryAt   time.Time     // guarded by tombstoneMu
+	gateCommand        string
+	loc                *time.Location
+	gateClosed         atomic.Bool
+	notifyAfter        int
+	notifyFailure      func(title, message string)
+	failures           int // consecutive failed passes; guarded by drainMu
+	clockSkewThreshold time.Duration
+	clockSkewed        atomic.Bool
+	redirects          gosync.Map // "from to" URL pairs already warned about
+	drainMu            gosync.Mutex
+	historyMu          gosync.Mutex
+	history            []Attempt
+	passWarnings       []string // server warnings in the current pass; guarded by historyMu
+	lastWarning        *Warning // guarded by historyMu
+	ctx                context.Context
+	cancel             context.CancelFunc
+	client             *http.Client
+	transport          *http.Transport
+}
+
+// TLSOptions configures the sync client's TLS transport. Empty fields
+// leave the system defaults in place.
+type TLSOptions struct {
+	ClientCert         string
+	ClientKey          string
+	CACert             string
+	InsecureSkipVerify bool
 }
 
 type activityPayload struct {
-	ClientUUID       string  `json:"clientUUID"`
-	Project          string  `json:"project,omitempty"`
-	GitRemote        string  `json:"gitRemote,omitempty"`
-	StartedAt        string  `json:"startedAt"`
-	EndedAt          string  `json:"endedAt"`
-	Filename         string  `json:"filename,omitempty"`
-	Filetype         string  `json:"filetype,omitempty"`
-	LinesAdded       int     `json:"linesAdded"`
-	LinesRemoved     int     `json:"linesRemoved"`
-	GitBranch        string  `json:"gitBranch,omitempty"`
-	ActionsPerMinute float64 `json:"actionsPerMinute,omitempty"`
-	WordsPerMinute   float64 `json:"wordsPerMinute,omitempty"`
-	Editor           string  `json:"editor"`
-	Machine          string  `json:"machine,omitempty"`
+	ClientUUID       string          `json:"clientUUID"`
+	Project          string          `json:"project,omitempty"`
+	GitRemote        string          `json:"gitRemote,omitempty"`
+	StartedAt        string          `json:"startedAt"`
+	EndedAt          string          `json:"endedAt"`
+	DurationSeconds  *float64        `json:"durationSeconds,omitempty"`
+	Filename         string          `json:"filename,omitempty"`
+	Filetype         string          `json:"filetype,omitempty"`
+	LinesAdded       int             `json:"linesAdded"`
+	LinesRemoved     int             `json:"linesRemoved"`
+	GitBranch        string          `json:"gitBranch,omitempty"`
+	GitCommit        string          `json:"gitCommit,omitempty"`
+	Tags             []string        `json:"tags,omitempty"`
+	Metadata         json.RawMessage `json:"metadata,omitempty"`
+	ActionsPerMinute float64         `json:"actionsPerMinute,omitempty"`
+	WordsPerMinute   float64         `json:"wordsPerMinute,omitempty"`
+	Keystrokes       int             `json:"keystrokes,omitempty"`
+	Edits            int             `json:"edits,omitempty"`
+	Editor           string          `json:"editor"`
+	Source           string          `json:"source,omitempty"`
+	InstanceUUID     string          `json:"instanceUUID,omitempty"`
+	TZOffset         *int            `json:"tzOffset,omitempty"`
+	Timezone         string          `json:"timezone,omitempty"`
+	Machine          string          `json:"machine,omitempty"`
+}
+
+// ClientInfo describes this daemon. It is sent once per sync request rather
+// than repeated on every activity; servers that don't know it ignore it.
+type ClientInfo struct {
+	Version   string `json:"version,omitempty"`
+	Machine   string `json:"machine,omitempty"`
+	MachineID string `json:"machineId,omitempty"`
+	OS        string `json:"os,omitempty"`
+	Arch      string `json:"arch,omitempty"`
 }
 
 type syncRequest struct {
+	Client     *ClientInfo       `json:"client,omitempty"`
 	Activities []activityPayload `json:"activities"`
 }
 
+// syncResponse is the server's answer to an upload. Older servers send
+// only success and count, without the activities array; success alone
+// acknowledges the whole batch either way. Message and Warnings carry soft
+// issues, e.g. clamped activities or an approaching quota, that don't fail
+// the upload.
 type syncResponse struct {
 	Success    bool `json:"success"`
 	Count      int  `json:"count"`
 	Activities []struct {
 		ID string `json:"id"`
 	} `json:"activities"`
+	Message  serverMessages `json:"message"`
+	Warnings serverMessages `json:"warnings"`
 }
 
-const httpTimeout = 30 * time.Second
+const (
+	httpTimeout = 30 * time.Second
+	// shutdownFlushTimeout bounds the single sync attempt made on Stop so
+	// shutdown finishes well inside a typical init-system stop timeout.
+	shutdownFlushTimeout = 2 * time.Second
+
+	// probeTimeout bounds the pre-flight request to the server; offlineRetry
+	// is how long to wait before probing again while it's unreachable.
+	// After maxOfflineProbes failures in a row a real batch is tried anyway,
+	// so a probe that is wrong about the network can't stop syncing.
+	probeTimeout     = 3 * time.Second
+	offlineRetry     = 15 * time.Second
+	maxOfflineProbes = 4
+
+	// claimLease is how long a claimed row is reserved for this syncer
+	// before another one may take it over. It covers a full pass,
+	// including one-by-one retries after a rejected batch.
+	claimLease = 10 * time.Minute
+)
+
+// Keys in the db meta table holding the backoff state, so a restart doesn't
+// immediately retry a server that was known to be down.
+const (
+	metaBackoff = "sync_backoff"
+	metaRetryAt = "sync_retry_at"
+)
 
 func NewSyncer(database *db.DB, serverURL, apiToken string, intervalMinutes, batchSize int, metricsOnly bool) *Syncer {
-	return &Syncer{
-		db:          database,
-		serverURL:   serverURL,
-		apiToken:    apiToken,
-		interval:    time.Duration(intervalMinutes) * time.Minute,
-		batchSize:   batchSize,
-		metricsOnly: metricsOnly,
-		minBackoff:  30 * time.Second,
-		maxBackoff:  30 * time.Minute,
-		done:        make(chan struct{}),
-		client:      &http.Client{Timeout: httpTimeout},
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Syncer{
+		db:            database,
+		serverURL:     serverURL,
+		apiToken:      apiToken,
+		interval:      time.Duration(intervalMinutes) * time.Minute,
+		batchSize:     batchSize,
+		concurrency:   1,
+		order:         db.OldestFirst,
+		metricsOnly:   metricsOnly,
+		minBackoff:    30 * time.Second,
+		maxBackoff:    30 * time.Minute,
+		backoffFactor: 2,
+		offlineRetry:  offlineRetry,
+		loc:           time.Local,
+		activity:      make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		finished:      make(chan struct{}),
+		ctx:           ctx,
+		cancel:        cancel,
+		client:        &http.Client{Timeout: httpTimeout, Transport: transport},
+		transport:     transport,
 	}
+	s.client.CheckRedirect = s.checkRedirect
+	s.restoreBackoff()
+	return s
+}
+
+// SetLocation sets the timezone whose UTC offset (and IANA name, unless
+// it is the system's local zone) is sent with each activity, so the server
+// can bucket activities by the user's local day. Defaults to time.Local.
+func (s *Syncer) SetLocation(loc *time.Location) {
+	s.loc = loc
+}
+
+// SetOrder controls whether the oldest or newest unsynced activities are
+// sent first.
+func (s *Syncer) SetOrder(order db.SyncOrder) {
+	s.order = order
+}
+
+// SetStream switches uploads to newline-delimited JSON sent with chunked
+// transfer encoding, so large batches are never marshaled into one buffer.
+func (s *Syncer) SetStream(stream bool) {
+	s.stream = stream
+}
+
+// SetDeadLetterAfter enables poison-record handling: when the server rejects
+// a batch (400/413/422), activities are retried one by one and any activity
+// rejected n times is moved to the dead letter table. Zero disables it.
+func (s *Syncer) SetDeadLetterAfter(n int) {
+	s.deadLetterAfter = n
+}
+
+// SetClientInfo sets the batch-level client metadata sent with each sync.
+func (s *Syncer) SetClientInfo(info ClientInfo) {
+	s.clientInfo = &info
+}
+
+// SetDebounce enables adaptive syncing: after Notify reports new activity,
+// a sync runs within d instead of waiting for the next interval tick.
+// Zero disables it.
+func (s *Syncer) SetDebounce(d time.Duration) {
+	s.debounce = d
+}
+
+// SetConcurrency lets up to n batches be uploaded at once. Each pass reads
+// n batches' worth of unsynced rows and splits them, so workers never send
+// the same rows. Values below 1 are treated as 1.
+func (s *Syncer) SetConcurrency(n int) {
+	s.concurrency = max(n, 1)
+}
+
+// SetMaxBytes caps the size of one upload body. A batch whose encoded
+// payload would be larger is split in half, recursively, and the halves
+// are sent one after another. Zero disables the check.
+func (s *Syncer) SetMaxBytes(n int) {
+	s.maxBytes = n
+}
+
+// SetCoalesceGap makes each drain first merge runs of adjacent unsynced
+// activities no more than d apart (see db.Coalesce), so the server gets
+// fewer, longer rows. Zero disables it.
+func (s *Syncer) SetCoalesceGap(d time.Duration) {
+	s.coalesceGap = d
+}
+
+// SetClockSkewThreshold makes sync log a warning when the server's Date
+// header differs from the local clock by more than d. Zero disables the
+// check.
+func (s *Syncer) SetClockSkewThreshold(d time.Duration) {
+	s.clockSkewThreshold = d
+}
+
+// SetReachabilityCheck makes the syncer send a HEAD request to the server
+// before sending batches. While that fails it waits offlineRetry and probes
+// again instead of escalating the error backoff, so being offline doesn't
+// look like a failing server. After maxOfflineProbes failed probes it
+// tries a real batch, which backs off as usual if it fails too.
+func (s *Syncer) SetReachabilityCheck(enabled bool) {
+	s.probe = enabled
+}
+
+// SetStartupDelay makes Start wait d before its initial sync, e.g. to let
+// the network come up at boot. Stop interrupts the wait.
+func (s *Syncer) SetStartupDelay(d time.Duration) {
+	s.startupDelay = d
+}
+
+// SetKeepAlive tunes connection reuse between syncs: up to maxIdle idle
+// connections to the server are kept open for idleTimeout, so a sync that
+// follows within that window skips the TCP and TLS handshakes. Zero
+// idleTimeout keeps them until the server closes them.
+func (s *Syncer) SetKeepAlive(maxIdle int, idleTimeout time.Duration) {
+	s.transport.MaxIdleConnsPerHost = max(maxIdle, 1)
+	s.transport.IdleConnTimeout = idleTimeout
 }
 
+// SetBackoff tunes the retry delay after a failed sync: it starts at
+// minimum and is multiplied by factor after each further failure, up to
+// maximum. A backoff persisted by an earlier run is re-capped at maximum.
+func (s *Syncer) SetBackoff(minimum, maximum time.Duration, factor float64) {
+	s.minBackoff = minimum
+	s.maxBackoff = maximum
+	s.backoffFactor = factor
+	s.restoreBackoff()
+}
+
+// Notify tells the syncer a new activity was recorded. It never blocks.
+func (s *Syncer) Notify() {
+	select {
+	case s.activity <- struct{}{}:
+	default:
+	}
+}
+
+// SetTLS loads a client certificate and/or private CA into the sync
+// client's transport for servers that require mutual TLS. It can also
+// disable certificate verification for self-signed development servers.
+func (s *Syncer) SetTLS(opts TLSOptions) error {
+	if opts.ClientCert == "" && opts.ClientKey == "" && opts.CACert == "" && !opts.InsecureSkipVerify {
+		return nil
+	}
+	if (opts.ClientCert == "") != (opts.ClientKey == "") {
+		return fmt.Errorf("tls client cert and key must be set together")
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	if opts.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return fmt.Errorf("load tls client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.CACert != "" {
+		data, err := os.ReadFile(opts.CACert)
+		if err != nil {
+			return fmt.Errorf("read tls ca certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("parse tls ca certificate %s: no PEM certificates found", opts.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	s.transport.TLSClientConfig = tlsConfig
+	return nil
+}
+
+// Start drains the backlog, then syncs on the ticker and after activity
+// until Stop. Every wait along the way, including backoff in the initial
+// drain, also selects on Stop, so shutdown never waits out a backoff.
 func (s *Syncer) Start() {
+	s.started.Store(true)
+	defer close(s.finished)
+
+	if s.startupDelay > 0 {
+		select {
+		case <-s.done:
+			return
+		case <-time.After(s.startupDelay):
+		}
+	}
+
 	s.drainBacklog()
 
 	ticker := time.NewTicker(s.interval)
 	defer ticker.Stop()
 
+	// debounced is non-nil while a near-term sync is scheduled after new
+	// activity; the ticker remains the idle fallback.
+	var debounced <-chan time.Time
+
 	for {
 		select {
 		case <-s.done:
-			s.drainBacklog()
+			s.flush()
 			return
 		case <-ticker.C:
 			s.drainBacklog()
+		case <-s.activity:
+			if s.debounce > 0 && debounced == nil {
+				debounced = time.After(s.debounce)
+			}
+		case <-debounced:
+			debounced = nil
+			s.drainBacklog()
 		}
 	}
 }
 
+// Stop cancels any in-flight sync and, if Start is running, waits for its
+// final bounded flush to finish.
 func (s *Syncer) Stop() {
 	close(s.done)
+	s.cancel()
+	if s.started.Load() {
+		<-s.finished
+	}
 }
 
-func (s *Syncer) drainBacklog() {
-	if s.apiToken == "" {
-		log.Println("sync: no API token configured, skipping")
+// flush makes a single, time-boxed sync attempt on shutdown. Unlike
+// drainBacklog it never retries or backs off.
+func (s *Syncer) flush() {
+	if !s.hasCredentials() {
 		return
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownFlushTimeout)
+	defer cancel()
+	if !s.gateOpen(ctx) {
+		return
+	}
+
+	n, err := s.syncBatchContext(ctx)
+	if err != nil {
+		log.Printf("sync: final flush failed: %v", err)
+	}
+	s.recordLastSync(n)
+}
+
+// drainBacklog syncs until the backlog is empty, retrying failures with
+// backoff. Only one drain runs at a time: it returns false at once if
+// another (e.g. SyncNow while the ticker fired) is already in progress. A
+// closed gate (SetGate) skips the drain.
+func (s *Syncer) drainBacklog() bool {
+	if !s.drainMu.TryLock() {
+		return false
+	}
+	defer s.drainMu.Unlock()
+
+	if !s.hasCredentials() {
+		log.Println("sync: no API token configured, skipping")
+		return true
+	}
+
+	// Resume a backoff persisted before a restart.
+	if wait := time.Until(s.retryAt); wait > 0 {
+		log.Printf("sync: resuming backoff, next attempt in %s", wait.Round(time.Second))
+		select {
+		case <-s.done:
+			return true
+		case <-time.After(wait):
+		}
+	}
+
+	if !s.gateOpen(s.ctx) {
+		return true
+	}
+
+	s.coalesce()
+
+	probe := s.probe
 	for {
 		select {
 		case <-s.done:
-			return
+			return true
 		default:
 		}
 
+		if probe {
+			if err := s.checkReachable(); err != nil {
+				s.offlineProbes++
+				if !s.offline {
+					log.Printf("sync: server unreachable, waiting for network: %v", err)
+					s.offline = true
+				}
+				if s.offlineProbes < maxOfflineProbes {
+					select {
+					case <-s.done:
+						return true
+					case <-time.After(s.offlineRetry):
+						continue
+					}
+				}
+				log.Printf("sync: server unreachable after %d probes, trying a sync anyway", s.offlineProbes)
+			} else {
+				s.markOnline()
+			}
+			s.offlineProbes = 0
+			probe = false
+		}
+
 		n, err := s.syncBatch()
 		if err != nil {
+			// Stop cancelled the in-flight request; not a server failure.
+			if s.ctx.Err() != nil {
+				return true
+			}
+			probe = s.probe
+			s.adaptBatch(false, err)
 			s.increaseBackoff()
 			log.Printf("sync: error (retrying in %s): %v", s.backoff, err)
 
 			select {
 			case <-s.done:
-				return
+				return true
 			case <-time.After(s.backoff):
 				continue
 			}
 		}
 
 		s.resetBackoff()
+		s.markOnline()
 
-		if n < s.batchSize {
-			return
+		// A pass that isolated rejected rows syncs fewer than it claimed;
+		// keep going while it claimed a full pass and made progress.
+		full := s.passFull.Load()
+		s.adaptBatch(full, nil)
+		if !full || n == 0 {
+			return true
+		}
+	}
+}
+
+// Drain syncs until the backlog is empty, for one-shot runs without the
+// ticker. Failed batches are retried with the usual capped backoff, but at
+// most retries times in a row, so a down server ends the run with an error
+// instead of looping forever. It returns the number of activities sent.
+func (s *Syncer) Drain(retries int) (int, error) {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	if !s.hasCredentials() {
+		return 0, fmt.Errorf("no API token configured")
+	}
+	if s.retryAt.After(time.Now()) {
+		return 0, fmt.Errorf("backing off after earlier failures until %s", s.retryAt.Local().Format(time.DateTime))
+	}
+	if !s.gateOpen(s.ctx) {
+		return 0, ErrSyncGated
+	}
+
+	s.coalesce()
+
+	total, failures := 0, 0
+	for {
+		n, err := s.syncBatch()
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return total, s.ctx.Err()
+			}
+			failures++
+			if failures > retries {
+				return total, fmt.Errorf("giving up after %d attempts: %w", failures, err)
+			}
+			s.adaptBatch(false, err)
+			s.increaseBackoff()
+			log.Printf("sync: error (retrying in %s): %v", s.backoff, err)
+
+			select {
+			case <-s.done:
+				return total, context.Canceled
+			case <-time.After(s.backoff):
+				continue
+			}
+		}
+
+		s.resetBackoff()
+		failures = 0
+		total += n
+
+		full := s.passFull.Load()
+		s.adaptBatch(full, nil)
+		if !full || n == 0 {
+			return total, nil
 		}
 	}
 }
 
+// coalesce merges adjacent unsynced activities before a drain, when
+// enabled. A failure only costs the optimization, so it is logged.
+func (s *Syncer) coalesce() {
+	if s.coalesceGap <= 0 {
+		return
+	}
+	n, err := s.db.Coalesce(s.coalesceGap)
+	if err != nil {
+		log.Printf("sync: coalesce activities: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("sync: coalesced %d activities into adjacent sessions", n)
+	}
+}
+
+// checkReachable sends a HEAD request for the server URL through the
+// sync transport, so it goes through the same proxy as uploads. Any
+// response, whatever its status, means the server can be reached.
+func (s *Syncer) checkReachable() error {
+	ctx, cancel := context.WithTimeout(s.ctx, probeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.serverURL, nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		Transport: s.transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	return closeBody(resp)
+}
+
+// markOnline logs that the server answered again after it was found
+// unreachable.
+func (s *Syncer) markOnline() {
+	s.offlineProbes = 0
+	if s.offline {
+		log.Println("sync: server reachable again")
+		s.offline = false
+	}
+}
+
+// syncBatch runs one pass and records it in the history, unless Stop
+// cancelled it, and as the last sync time if anything was sent.
 func (s *Syncer) syncBatch() (int, error) {
-	activities, err := s.db.GetUnsyncedActivities(s.batchSize)
+	start := time.Now()
+	n, err := s.syncBatchContext(s.ctx)
+	if s.ctx.Err() == nil {
+		s.record(start, n, err)
+		s.trackFailures(n, err)
+	}
+	s.recordLastSync(n)
+	return n, err
+}
+
+// recordLastSync persists the time of a pass that synced n > 0
+// activities, even if part of it failed.
+func (s *Syncer) recordLastSync(n int) {
+	if n == 0 {
+		return
+	}
+	if err := s.db.SetLastSyncAt(time.Now()); err != nil {
+		log.Printf("sync: persist last sync time: %v", err)
+	}
+}
+
+// passSize is how many activities one syncBatch reads; a short pass means
+// the backlog is drained.
+func (s *Syncer) passSize() int {
+	return s.batch() * s.concurrency
+}
+
+// syncBatchContext reads up to passSize unsynced activities and uploads
+// them in batches of the current batch size, concurrently when configured.
+// It returns how many were synced and the first error, if any.
+func (s *Syncer) syncBatchContext(ctx context.Context) (int, error) {
+	s.loadCapabilities(ctx)
+	s.sendTombstones(ctx)
+
+	size := s.batch()
+	activities, err := s.db.ClaimUnsynced(size*s.concurrency, s.order, claimLease)
 	if err != nil {
-		return 0, fmt.Errorf("get unsynced activities: %w", err)
+		return 0, fmt.Errorf("claim unsynced activities: %w", err)
 	}
+	s.passFull.Store(len(activities) == size*s.concurrency)
 
 	if len(activities) == 0 {
 		return 0, nil
 	}
+	// Hand back whatever this pass didn't sync; synced rows are unaffected.
+	defer func() {
+		ids := make([]int64, len(activities))
+		for i, a := range activities {
+			ids[i] = a.ID
+		}
+		if err := s.db.ReleaseClaims(ids); err != nil {
+			log.Printf("sync: release claims: %v", err)
+		}
+	}()
+	if len(activities) <= size {
+		return s.sendBatch(ctx, activities)
+	}
+
+	var (
+		wg       gosync.WaitGroup
+		mu       gosync.Mutex
+		synced   int
+		firstErr error
+	)
+	for batch := range slices.Chunk(activities, size) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := s.sendBatch(ctx, batch)
+			mu.Lock()
+			defer mu.Unlock()
+			synced += n
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}()
+	}
+	wg.Wait()
+	return synced, firstErr
+}
+
+// sendBatch uploads one batch and marks it synced.
+func (s *Syncer) sendBatch(ctx context.Context, activities []*db.Activity) (int, error) {
+	if s.maxBytes > 0 && len(activities) > 1 {
+		size, err := s.payloadSize(activities)
+		if err != nil {
+			return 0, err
+		}
+		if size > s.maxBytes {
+			log.Printf("sync: %d activities encode to %d bytes (max_sync_bytes %d), splitting", len(activities), size, s.maxBytes)
+			half := len(activities) / 2
+			n, err := s.sendBatch(ctx, activities[:half])
+			if err != nil {
+				return n, err
+			}
+			m, err := s.sendBatch(ctx, activities[half:])
+			return n + m, err
+		}
+	}
 
 	log.Printf("sync: syncing %d activities", len(activities))
 
-	payloads := make([]activityPayload, len(activities))
-	for i, a := range activities {
-		project := a.Project
-		gitRemote := a.GitRemote
-		filename := a.Filename
-		if s.metricsOnly {
-			project = "private"
-			gitRemote = "private"
-			filename = ""
-		}
-		payloads[i] = activityPayload{
-			ClientUUID:       a.ClientID,
-			Project:          project,
-			GitRemote:        gitRemote,
-			StartedAt:        a.StartedAt.Format(time.RFC3339),
-			EndedAt:          a.EndedAt.Format(time.RFC3339),
-			Filename:         filename,
-			Filetype:         a.Filetype,
-			LinesAdded:       a.LinesAdded,
-			LinesRemoved:     a.LinesRemoved,
-			GitBranch:        a.GitBranch,
-			ActionsPerMinute: a.ActionsPerMinute,
-			WordsPerMinute:   a.WordsPerMinute,
-			Editor:           a.Editor,
-			Machine:          a.Machine,
-		}
-	}
-
-	body, err := json.Marshal(syncRequest{Activities: payloads})
+	err := s.upload(ctx, activities)
+	s.noteTooLarge(err)
+	if isRejection(err) && s.deadLetterAfter > 0 {
+		log.Printf("sync: %v; retrying activities individually to isolate bad records", err)
+		return s.syncIndividually(ctx, activities)
+	}
 	if err != nil {
-		return 0, fmt.Errorf("marshal request: %w", err)
+		return 0, err
 	}
 
-	req, err := http.NewRequest("POST", s.serverURL+"/api/activities", bytes.NewReader(body))
-	if err != nil {
-		return 0, fmt.Errorf("create request: %w", err)
+	ids := make([]int64, len(activities))
+	for i, a := range activities {
+		ids[i] = a.ID
+	}
+
+	if err := s.db.MarkSynced(ids); err != nil {
+		return 0, fmt.Errorf("mark as synced: %w", err)
+	}
+
+	log.Printf("sync: successfully synced %d activities", len(activities))
+	return len(activities), nil
+}
+
+// syncIndividually uploads activities one at a time after the server
+// rejected their batch. Activities the server still rejects have their
+// failure count bumped and are dead-lettered after deadLetterAfter attempts,
+// so one bad record can't wedge the backlog. Transport and server errors
+// abort the pass so the normal backoff applies.
+func (s *Syncer) syncIndividually(ctx context.Context, activities []*db.Activity) (int, error) {
+	synced, rejected := 0, 0
+	for _, a := range activities {
+		err := s.upload(ctx, []*db.Activity{a})
+		if isRejection(err) {
+			rejected++
+			dead, recordErr := s.db.RecordSyncFailure(a.ID, err.Error(), s.deadLetterAfter)
+			if recordErr != nil {
+				return synced, fmt.Errorf("record sync failure: %w", recordErr)
+			}
+			if dead {
+				log.Printf("sync: activity %d (%s) rejected %d times, moved to dead letter: %v", a.ID, a.ClientID, s.deadLetterAfter, err)
+			}
+			continue
+		}
+		if err != nil {
+			return synced, err
+		}
+		if err := s.db.MarkSynced([]int64{a.ID}); err != nil {
+			return synced, fmt.Errorf("mark as synced: %w", err)
+		}
+		synced++
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.apiToken)
+	log.Printf("sync: individually synced %d activities, %d rejected", synced, rejected)
+	if synced == 0 {
+		return 0, fmt.Errorf("server rejected %d activities", rejected)
+	}
+	return synced, nil
+}
+
+// maxDrain caps how much of a response body closeBody reads, so a server
+// that keeps sending can't stall a sync pass or shutdown.
+const maxDrain = 64 << 10
+
+// closeBody reads what is left of resp's body, up to maxDrain, before
+// closing it. A body closed early makes net/http drop the connection
+// instead of returning it to the idle pool, and the JSON decoder stops
+// short of EOF. A failed or cut-short drain only costs the reuse, so its
+// error is ignored.
+func closeBody(resp *http.Response) error {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrain))
+	return resp.Body.Close()
+}
+
+// statusError is returned when the server answers with a non-200 status.
+type statusError struct {
+	StatusCode int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("server returned status %d", e.StatusCode)
+}
+
+// isRejection reports whether err means the server refused the request's
+// content (as opposed to auth, rate limiting, or a server/transport failure).
+// 413 counts: once batches fit max_sync_bytes, only an oversized single
+// activity can still trigger it.
+func isRejection(err error) bool {
+	var se *statusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch se.StatusCode {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusRequestEntityTooLarge:
+		return true
+	}
+	return false
+}
 
-	resp, err := s.client.Do(req)
+// upload sends activities in a single request and checks the response.
+func (s *Syncer) upload(ctx context.Context, activities []*db.Activity) (err error) {
+	req, err := s.newRequest(ctx, activities)
 	if err != nil {
-		return 0, fmt.Errorf("request failed: %w", err)
+		return err
 	}
+	resp, err := s.do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	s.checkClockSkew(resp.Header, time.Now())
 	defer func() {
-		if closeErr := resp.Body.Close(); closeErr != nil && err == nil {
+		if closeErr := closeBody(resp); closeErr != nil && err == nil {
 			err = closeErr
 		}
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("server returned status %d", resp.StatusCode)
+		return &statusError{StatusCode: resp.StatusCode}
 	}
 
 	var syncResp syncResponse
 	if err := json.NewDecoder(resp.Body).Decode(&syncResp); err != nil {
-		return 0, fmt.Errorf("decode response: %w", err)
+		return fmt.Errorf("decode response: %w", err)
 	}
 
 	if !syncResp.Success {
-		return 0, fmt.Errorf("server returned success=false")
+		return fmt.Errorf("server returned success=false")
+	}
+	s.noteWarnings(append(syncResp.Message, syncResp.Warnings...))
+	if syncResp.Activities == nil && syncResp.Count != 0 && syncResp.Count != len(activities) {
+		// Without the array there is no way to tell which ones it kept
+		// (e.g. it dropped duplicates); re-sending would only duplicate the
+		// rest, so the batch still counts as synced.
+		log.Printf("sync: server acknowledged %d of %d activities", syncResp.Count, len(activities))
 	}
+	return nil
+}
 
-	ids := make([]int64, len(activities))
+// payloads converts stored activities to the wire format, scrubbing
+// identifying fields in metrics-only mode and dropping optional fields the
+// server hasn't advertised.
+func (s *Syncer) payloads(activities []*db.Activity) []activityPayload {
+	payloads := make([]activityPayload, len(activities))
 	for i, a := range activities {
-		ids[i] = a.ID
+		payloads[i] = s.payload(a)
 	}
+	return payloads
+}
 
-	if err := s.db.MarkSynced(ids); err != nil {
-		return 0, fmt.Errorf("mark as synced: %w", err)
+// payload converts one stored activity to the wire format; see payloads.
+func (s *Syncer) payload(a *db.Activity) activityPayload {
+	// time.Local has no IANA name to report; the offset still applies.
+	zone := s.loc.String()
+	if s.loc == time.Local {
+		zone = ""
+	}
+	project := a.Project
+	gitRemote := a.GitRemote
+	filename := a.Filename
+	gitCommit := a.GitCommit
+	metadata := a.Metadata
+	if s.metricsOnly {
+		project = "private"
+		gitRemote = "private"
+		filename = ""
+		gitCommit = ""
+		// Plugin metadata is opaque, so it may identify the work too.
+		metadata = nil
+	}
+	// The offset in effect when the activity started, so DST is right
+	// for backlogs that span a change.
+	_, offset := a.StartedAt.In(s.loc).Zone()
+	offset /= 60
+	p := activityPayload{
+		ClientUUID:       a.ClientID,
+		Project:          project,
+		GitRemote:        gitRemote,
+		StartedAt:        a.StartedAt.Format(time.RFC3339),
+		EndedAt:          a.EndedAt.Format(time.RFC3339),
+		DurationSeconds:  &a.DurationSeconds,
+		Filename:         filename,
+		Filetype:         a.Filetype,
+		LinesAdded:       a.LinesAdded,
+		LinesRemoved:     a.LinesRemoved,
+		GitBranch:        a.GitBranch,
+		GitCommit:        gitCommit,
+		Tags:             a.Tags,
+		Metadata:         metadata,
+		ActionsPerMinute: a.ActionsPerMinute,
+		WordsPerMinute:   a.WordsPerMinute,
+		Keystrokes:       a.Keystrokes,
+		Edits:            a.Edits,
+		Editor:           a.Editor,
+		Source:           a.Source,
+		InstanceUUID:     a.InstanceUUID,
+		TZOffset:         &offset,
+		Timezone:         zone,
+		Machine:          a.Machine,
 	}
+	s.caps.Load().strip(&p)
+	return p
+}
 
-	log.Printf("sync: successfully synced %d activities", len(activities))
-	return len(activities), nil
+// payloadSize returns how many bytes activities encode to in the upload
+// body, matching what newRequest sends.
+func (s *Syncer) payloadSize(activities []*db.Activity) (int, error) {
+	if !s.stream {
+		body, err := json.Marshal(syncRequest{Client: s.clientInfo, Activities: s.payloads(activities)})
+		if err != nil {
+			return 0, fmt.Errorf("marshal request: %w", err)
+		}
+		return len(body), nil
+	}
+
+	size := 0
+	for _, a := range activities {
+		line, err := json.Marshal(s.payload(a))
+		if err != nil {
+			return 0, fmt.Errorf("marshal activity: %w", err)
+		}
+		size += len(line) + 1
+	}
+	return size, nil
+}
+
+// newRequest builds the upload request: a single JSON document by default,
+// or one activity per line when streaming. Streamed uploads carry the client
+// info in the X-Blast-Client header since there is no envelope object.
+// Both bodies can be replayed (GetBody), so redirects and the OAuth retry
+// resend them.
+func (s *Syncer) newRequest(ctx context.Context, activities []*db.Activity) (*http.Request, error) {
+	url := s.serverURL + "/api/activities"
+
+	if !s.stream {
+		body, err := json.Marshal(syncRequest{Client: s.clientInfo, Activities: s.payloads(activities)})
+		if err != nil {
+			return nil, fmt.Errorf("marshal request: %w", err)
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	}
+
+	// An io.Reader body of unknown length is sent chunked.
+	body := s.streamBody(activities)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return s.streamBody(activities), nil
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.clientInfo != nil {
+		info, err := json.Marshal(s.clientInfo)
+		if err != nil {
+			body.Close()
+			return nil, fmt.Errorf("marshal client info: %w", err)
+		}
+		req.Header.Set("X-Blast-Client", string(info))
+	}
+	return req, nil
+}
+
+// streamBody encodes activities one line at a time as the reader consumes
+// them, so only the row being written is held in wire form. Closing the
+// reader early stops the encoder.
+func (s *Syncer) streamBody(activities []*db.Activity) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		enc := json.NewEncoder(pw)
+		for _, a := range activities {
+			if err := enc.Encode(s.payload(a)); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+	return pr
 }
 
 func (s *Syncer) increaseBackoff() {
 	if s.backoff == 0 {
 		s.backoff = s.minBackoff
 	} else {
-		s.backoff *= 2
-		if s.backoff > s.maxBackoff {
-			s.backoff = s.maxBackoff
-		}
+		s.backoff = min(time.Duration(float64(s.backoff)*s.backoffFactor), s.maxBackoff)
+	}
+	s.retryAt = time.Now().Add(s.backoff)
+
+	if err := s.db.SetMeta(metaBackoff, s.backoff.String()); err != nil {
+		log.Printf("sync: persist backoff: %v", err)
+	} else if err := s.db.SetMeta(metaRetryAt, s.retryAt.UTC().Format(time.RFC3339Nano)); err != nil {
+		log.Printf("sync: persist backoff: %v", err)
 	}
 }
 
 func (s *Syncer) resetBackoff() {
+	if s.backoff == 0 && s.retryAt.IsZero() {
+		return
+	}
 	s.backoff = 0
+	s.retryAt = time.Time{}
+	if err := s.db.DeleteMeta(metaBackoff, metaRetryAt); err != nil {
+		log.Printf("sync: clear persisted backoff: %v", err)
+	}
+}
+
+// restoreBackoff loads the backoff persisted by a previous run. Unreadable
+// state is ignored; the worst case is one early retry.
+func (s *Syncer) restoreBackoff() {
+	backoff, err := s.db.GetMeta(metaBackoff)
+	if err != nil || backoff == "" {
+		return
+	}
+	retryAt, err := s.db.GetMeta(metaRetryAt)
+	if err != nil {
+		return
+	}
+	d, err := time.ParseDuration(backoff)
+	if err != nil {
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, retryAt)
+	if err != nil {
+		return
+	}
+	s.backoff = min(d, s.maxBackoff)
+	// A retry time saved under a larger max, or before the clock jumped
+	// back, must not stall syncing for longer than the current max.
+	if latest := time.Now().Add(s.maxBackoff); t.After(latest) {
+		t = latest
+	}
+	s.retryAt = t
 }
 
+// ErrSyncInProgress is returned by SyncNow while another drain is running.
+var ErrSyncInProgress = errors.New("sync already in progress")
+
+// SyncNow drains the backlog immediately, unless a drain is already
+// running or the gate vetoes it.
 func (s *Syncer) SyncNow() error {
-	if s.apiToken == "" {
+	if !s.hasCredentials() {
 		return fmt.Errorf("no API token configured")
 	}
-	s.drainBacklog()
+	if !s.drainBacklog() {
+		return ErrSyncInProgress
+	}
+	if s.gateClosed.Load() {
+		return ErrSyncGated
+	}
 	return nil
 }
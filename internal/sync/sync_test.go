@@ -1,10 +1,28 @@
 package sync
 
 import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	gosync "sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -26,13 +44,28 @@ func setupTestSyncer(t *testing.T, handler http.Handler) (*Syncer, *db.DB) {
 		}
 	})
 
-	server := httptest.NewServer(handler)
+	server := httptest.NewServer(noCapabilities(handler))
 	t.Cleanup(server.Close)
 
 	syncer := NewSyncer(database, server.URL, "test-token", 60, 10, false)
 	return syncer, database
 }
 
+// noCapabilities answers the capabilities probe like a server that
+// predates it, and the reachability probe, so handler only sees uploads.
+func noCapabilities(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/capabilities" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodHead {
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
 func insertActivities(t *testing.T, database *db.DB, n int) {
 	t.Helper()
 	now := time.Now().UTC()
@@ -90,10 +123,37 @@ func TestSyncBatchSuccess(t *testing.T) {
 	if len(remaining) != 0 {
 		t.Errorf("%d unsynced remaining, want 0", len(remaining))
 	}
+
+	if last, err := database.LastSyncAt(); err != nil || time.Since(last) > time.Minute {
+		t.Errorf("LastSyncAt() = %v, %v; want just now", last, err)
+	}
+}
+
+func TestSyncBatchCountOnlyResponse(t *testing.T) {
+	for _, body := range []string{`{"success":true,"count":3}`, `{"success":true}`} {
+		t.Run(body, func(t *testing.T) {
+			syncer, database := setupTestSyncer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				_, _ = io.WriteString(w, body)
+			}))
+			insertActivities(t, database, 3)
+
+			n, err := syncer.syncBatch()
+			if err != nil {
+				t.Fatalf("syncBatch() error: %v", err)
+			}
+			if n != 3 {
+				t.Errorf("synced %d, want 3", n)
+			}
+			if remaining, err := database.GetUnsyncedActivities(100); err != nil || len(remaining) != 0 {
+				t.Errorf("%d unsynced remaining (%v), want 0", len(remaining), err)
+			}
+		})
+	}
 }
 
 func TestSyncBatchEmpty(t *testing.T) {
-	syncer, _ := setupTestSyncer(t, okHandler(t))
+	syncer, database := setupTestSyncer(t, okHandler(t))
 
 	n, err := syncer.syncBatch()
 	if err != nil {
@@ -102,6 +162,9 @@ func TestSyncBatchEmpty(t *testing.T) {
 	if n != 0 {
 		t.Errorf("synced %d, want 0", n)
 	}
+	if last, err := database.LastSyncAt(); err != nil || !last.IsZero() {
+		t.Errorf("LastSyncAt() = %v, %v; want unset after an empty pass", last, err)
+	}
 }
 
 func TestSyncBatchServerError(t *testing.T) {
@@ -124,6 +187,41 @@ func TestSyncBatchServerError(t *testing.T) {
 	if len(remaining) != 3 {
 		t.Errorf("%d unsynced remaining, want 3 (should not mark synced on error)", len(remaining))
 	}
+
+	// The failed pass must hand its claims back for the next attempt.
+	if again, err := database.ClaimUnsynced(100, db.OldestFirst, time.Hour); err != nil || len(again) != 3 {
+		t.Errorf("ClaimUnsynced() after failure = %d activities, %v; want 3", len(again), err)
+	}
+}
+
+func TestSyncSkipsClaimedActivities(t *testing.T) {
+	syncer, database := setupTestSyncer(t, okHandler(t))
+	insertActivities(t, database, 5)
+
+	// Another syncer (e.g. a --oneshot run) holds three of them.
+	claimed, err := database.ClaimUnsynced(3, db.OldestFirst, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := syncer.syncBatch()
+	if err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("synced %d, want only the 2 unclaimed activities", n)
+	}
+
+	ids := make([]int64, len(claimed))
+	for i, a := range claimed {
+		ids[i] = a.ID
+	}
+	if err := database.ReleaseClaims(ids); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := syncer.syncBatch(); err != nil || n != 3 {
+		t.Errorf("syncBatch() after release = %d, %v; want 3", n, err)
+	}
 }
 
 func TestSyncBatchServerFailure(t *testing.T) {
@@ -167,6 +265,194 @@ func TestDrainBacklogMultipleBatches(t *testing.T) {
 	}
 }
 
+func TestSyncConcurrency(t *testing.T) {
+	var (
+		inFlight, peak atomic.Int32
+		mu             gosync.Mutex
+		seen           = make(map[string]int)
+	)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		// Hold the request so the other workers' requests overlap it.
+		time.Sleep(50 * time.Millisecond)
+
+		var req syncRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Decode() error: %v", err)
+			return
+		}
+		mu.Lock()
+		for _, a := range req.Activities {
+			seen[a.ClientUUID]++
+		}
+		mu.Unlock()
+		if err := json.NewEncoder(w).Encode(syncResponse{Success: true, Count: len(req.Activities)}); err != nil {
+			t.Errorf("Encode() error: %v", err)
+		}
+	})
+
+	syncer, database := setupTestSyncer(t, handler)
+	syncer.batchSize = 2
+	syncer.SetConcurrency(3)
+	insertActivities(t, database, 11)
+
+	syncer.drainBacklog()
+
+	if remaining, err := database.GetUnsyncedActivities(100); err != nil || len(remaining) != 0 {
+		t.Fatalf("%d unsynced remaining (err %v), want 0", len(remaining), err)
+	}
+	if len(seen) != 11 {
+		t.Errorf("server saw %d distinct activities, want 11", len(seen))
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("activity %s sent %d times, want once", id, n)
+		}
+	}
+	if peak.Load() < 2 {
+		t.Errorf("peak concurrent requests = %d, want > 1", peak.Load())
+	}
+}
+
+func TestSyncSplitsOversizedBatch(t *testing.T) {
+	var (
+		mu    gosync.Mutex
+		sizes []int
+	)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("ReadAll() error: %v", err)
+			return
+		}
+		var req syncRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("Unmarshal() error: %v", err)
+			return
+		}
+		mu.Lock()
+		sizes = append(sizes, len(body))
+		mu.Unlock()
+		if err := json.NewEncoder(w).Encode(syncResponse{Success: true, Count: len(req.Activities)}); err != nil {
+			t.Errorf("Encode() error: %v", err)
+		}
+	})
+
+	syncer, database := setupTestSyncer(t, handler)
+	insertActivities(t, database, 10)
+
+	// Room for about three activities per request.
+	one, err := database.GetUnsyncedActivities(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit, err := syncer.payloadSize(one)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncer.SetMaxBytes(limit)
+
+	n, err := syncer.syncBatch()
+	if err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+	if n != 10 {
+		t.Errorf("synced %d, want 10", n)
+	}
+	if len(sizes) < 4 {
+		t.Errorf("sent %d requests, want the batch split into at least 4", len(sizes))
+	}
+	for _, size := range sizes {
+		if size > limit {
+			t.Errorf("request of %d bytes exceeds max %d", size, limit)
+		}
+	}
+}
+
+func TestSyncNewestFirst(t *testing.T) {
+	var first []activityPayload
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req syncRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("Decode() error: %v", err)
+		}
+		if first == nil {
+			first = req.Activities
+		}
+		if err := json.NewEncoder(w).Encode(syncResponse{Success: true, Count: len(req.Activities)}); err != nil {
+			t.Fatalf("Encode() error: %v", err)
+		}
+	})
+
+	syncer, database := setupTestSyncer(t, handler)
+	syncer.batchSize = 2
+	syncer.SetOrder(db.NewestFirst)
+	insertActivities(t, database, 5)
+
+	syncer.drainBacklog()
+
+	if len(first) != 2 {
+		t.Fatalf("first batch had %d activities, want 2", len(first))
+	}
+	if first[0].StartedAt <= first[1].StartedAt {
+		t.Errorf("first batch not newest-first: %s then %s", first[0].StartedAt, first[1].StartedAt)
+	}
+	remaining, err := database.GetUnsyncedActivities(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("%d unsynced remaining, want 0", len(remaining))
+	}
+}
+
+func TestSyncNowWhileDraining(t *testing.T) {
+	var requests atomic.Int32
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	ok := okHandler(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			close(entered)
+			<-release
+		}
+		ok(w, r)
+	})
+	syncer, database := setupTestSyncer(t, handler)
+	insertActivities(t, database, 3)
+
+	first := make(chan error, 1)
+	go func() { first <- syncer.SyncNow() }()
+	<-entered
+
+	// A second manual sync and a scheduled one both back off instead of
+	// starting another drain over the same rows.
+	if err := syncer.SyncNow(); !errors.Is(err, ErrSyncInProgress) {
+		t.Errorf("SyncNow() during a drain = %v, want ErrSyncInProgress", err)
+	}
+	if syncer.drainBacklog() {
+		t.Error("drainBacklog() ran concurrently with SyncNow")
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatalf("first SyncNow() error: %v", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("server saw %d uploads, want 1", got)
+	}
+	if err := syncer.SyncNow(); err != nil {
+		t.Errorf("SyncNow() after the drain finished: %v", err)
+	}
+}
+
 func TestDrainBacklogNoToken(t *testing.T) {
 	syncer, database := setupTestSyncer(t, okHandler(t))
 	syncer.apiToken = ""
@@ -203,6 +489,73 @@ func TestBackoffIncreases(t *testing.T) {
 	}
 }
 
+func TestBackoffConfigured(t *testing.T) {
+	syncer, _ := setupTestSyncer(t, okHandler(t))
+	syncer.SetBackoff(10*time.Second, 25*time.Second, 1.5)
+
+	for i, want := range []time.Duration{10 * time.Second, 15 * time.Second, 22500 * time.Millisecond, 25 * time.Second, 25 * time.Second} {
+		syncer.increaseBackoff()
+		if syncer.backoff != want {
+			t.Errorf("backoff %d = %s, want %s", i+1, syncer.backoff, want)
+		}
+	}
+}
+
+func TestFailureNotifier(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	syncer, database := setupTestSyncer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		okHandler(t)(w, r)
+	}))
+	notified := make(chan string, 10)
+	syncer.SetFailureNotifier(2, func(title, _ string) { notified <- title })
+	insertActivities(t, database, 1)
+
+	for range 4 {
+		if _, err := syncer.syncBatch(); err == nil {
+			t.Fatal("syncBatch() succeeded against a failing server")
+		}
+	}
+	if title := <-notified; title != "blastd sync failing" {
+		t.Errorf("notification = %q, want the failure", title)
+	}
+	failing.Store(false)
+	if _, err := syncer.syncBatch(); err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+	if title := <-notified; title != "blastd sync recovered" {
+		t.Errorf("notification = %q, want the recovery", title)
+	}
+	if _, err := syncer.syncBatch(); err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+	select {
+	case title := <-notified:
+		t.Errorf("unexpected notification %q; want one per failure streak", title)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRestoredRetryAtIsCapped(t *testing.T) {
+	syncer, database := setupTestSyncer(t, okHandler(t))
+	// A retry time far in the future, e.g. from a clock that was wrong.
+	if err := database.SetMeta(metaBackoff, "30m0s"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.SetMeta(metaRetryAt, time.Now().AddDate(1, 0, 0).UTC().Format(time.RFC3339Nano)); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := NewSyncer(database, syncer.serverURL, "test-token", 60, 10, false)
+	if wait := time.Until(restarted.retryAt); wait > restarted.maxBackoff {
+		t.Errorf("restored retryAt is %s away, want at most the %s max backoff", wait, restarted.maxBackoff)
+	}
+}
+
 func TestBackoffResets(t *testing.T) {
 	syncer, _ := setupTestSyncer(t, okHandler(t))
 
@@ -215,6 +568,45 @@ func TestBackoffResets(t *testing.T) {
 	}
 }
 
+func TestBackoffPersistsAcrossRestart(t *testing.T) {
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	syncer, database := setupTestSyncer(t, handler)
+	syncer.increaseBackoff()
+	syncer.increaseBackoff()
+
+	restarted := NewSyncer(database, syncer.serverURL, "test-token", 60, 10, false)
+	if restarted.backoff != 2*syncer.minBackoff {
+		t.Errorf("restored backoff = %s, want %s", restarted.backoff, 2*syncer.minBackoff)
+	}
+	if !restarted.retryAt.Equal(syncer.retryAt) {
+		t.Errorf("restored retryAt = %v, want %v", restarted.retryAt, syncer.retryAt)
+	}
+	if restarted.SetBackoff(time.Second, 45*time.Second, 2); restarted.backoff != 45*time.Second {
+		t.Errorf("restored backoff under a lower max = %s, want 45s", restarted.backoff)
+	}
+	if wait := time.Until(restarted.retryAt); wait > 45*time.Second || wait < 40*time.Second {
+		t.Errorf("restored retryAt under a lower max is %s away, want about 45s", wait)
+	}
+
+	// A restarted one-shot run must not contact the server early.
+	insertActivities(t, database, 1)
+	if _, err := restarted.Drain(0); err == nil {
+		t.Error("Drain() during persisted backoff succeeded, want error")
+	}
+	if got := calls.Load(); got != 0 {
+		t.Errorf("server saw %d requests during backoff, want 0", got)
+	}
+
+	restarted.resetBackoff()
+	if again := NewSyncer(database, syncer.serverURL, "test-token", 60, 10, false); again.backoff != 0 || !again.retryAt.IsZero() {
+		t.Errorf("backoff after reset = %s until %v, want cleared", again.backoff, again.retryAt)
+	}
+}
+
 func TestDrainBacklogRetriesOnError(t *testing.T) {
 	var callCount atomic.Int32
 
@@ -262,78 +654,676 @@ func TestDrainBacklogRetriesOnError(t *testing.T) {
 	}
 }
 
-func TestSyncPayloadFormat(t *testing.T) {
-	var receivedBody syncRequest
+func TestDrain(t *testing.T) {
+	syncer, database := setupTestSyncer(t, okHandler(t))
+	syncer.batchSize = 2
+	insertActivities(t, database, 5)
 
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("Authorization") != "Bearer test-token" {
-			t.Errorf("Authorization = %q, want %q", r.Header.Get("Authorization"), "Bearer test-token")
-		}
-		if r.Header.Get("Content-Type") != "application/json" {
-			t.Errorf("Content-Type = %q, want %q", r.Header.Get("Content-Type"), "application/json")
-		}
+	n, err := syncer.Drain(0)
+	if err != nil {
+		t.Fatalf("Drain() error: %v", err)
+	}
+	if n != 5 {
+		t.Errorf("Drain() sent %d, want 5", n)
+	}
+}
 
-		if err := json.NewDecoder(r.Body).Decode(&receivedBody); err != nil {
-			t.Fatalf("Decode() error: %v", err)
-		}
+func TestDrainCoalesces(t *testing.T) {
+	syncer, database := setupTestSyncer(t, okHandler(t))
+	syncer.SetCoalesceGap(time.Minute)
+	// Back-to-back activities on the same project merge into one.
+	insertActivities(t, database, 5)
 
-		resp := syncResponse{Success: true, Count: len(receivedBody.Activities)}
-		for range receivedBody.Activities {
-			resp.Activities = append(resp.Activities, struct {
-				ID string `json:"id"`
-			}{ID: "test-id"})
-		}
-		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			t.Fatalf("Encode() error: %v", err)
-		}
+	n, err := syncer.Drain(0)
+	if err != nil {
+		t.Fatalf("Drain() error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Drain() sent %d, want 1 coalesced activity", n)
+	}
+}
+
+func TestDrainGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
 	})
 
 	syncer, database := setupTestSyncer(t, handler)
+	syncer.minBackoff = time.Millisecond
+	syncer.maxBackoff = 5 * time.Millisecond
 	insertActivities(t, database, 1)
 
-	n, err := syncer.syncBatch()
-	if err != nil {
-		t.Fatalf("syncBatch() error: %v", err)
+	if _, err := syncer.Drain(2); err == nil {
+		t.Fatal("Drain() against a down server succeeded, want error")
 	}
-	if n != 1 {
-		t.Fatalf("synced %d, want 1", n)
+	if got := calls.Load(); got != 3 {
+		t.Errorf("server saw %d requests, want 3 (1 attempt + 2 retries)", got)
 	}
+}
 
-	if len(receivedBody.Activities) != 1 {
-		t.Fatalf("server received %d activities, want 1", len(receivedBody.Activities))
-	}
+func TestStopDuringBackoffIsPrompt(t *testing.T) {
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
 
-	a := receivedBody.Activities[0]
-	if a.Project != "blast" {
-		t.Errorf("Project = %q, want %q", a.Project, "blast")
+	syncer, database := setupTestSyncer(t, handler)
+	syncer.minBackoff = time.Hour
+	syncer.maxBackoff = time.Hour
+	insertActivities(t, database, 1)
+
+	go syncer.Start()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for calls.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
 	}
-	if a.Editor != "neovim" {
-		t.Errorf("Editor = %q, want %q", a.Editor, "neovim")
+	if calls.Load() == 0 {
+		t.Fatal("syncer never attempted a sync")
 	}
-	if a.Machine != "test" {
-		t.Errorf("Machine = %q, want %q", a.Machine, "test")
+
+	start := time.Now()
+	syncer.Stop()
+	if elapsed := time.Since(start); elapsed > shutdownFlushTimeout+time.Second {
+		t.Errorf("Stop took %s, want under %s", elapsed, shutdownFlushTimeout+time.Second)
 	}
-	if a.StartedAt == "" {
-		t.Error("StartedAt should not be empty")
+	// One attempt from the initial drain, one from the final flush.
+	if got := calls.Load(); got != 2 {
+		t.Errorf("server saw %d requests, want 2", got)
 	}
 }
 
-func TestSyncMetricsOnly(t *testing.T) {
-	var receivedBody syncRequest
-
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if err := json.NewDecoder(r.Body).Decode(&receivedBody); err != nil {
-			t.Fatalf("Decode() error: %v", err)
-		}
-		resp := syncResponse{Success: true, Count: len(receivedBody.Activities)}
-		for range receivedBody.Activities {
-			resp.Activities = append(resp.Activities, struct {
-				ID string `json:"id"`
-			}{ID: "test-id"})
-		}
-		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			t.Fatalf("Encode() error: %v", err)
-		}
+// TestStopDuringInitialDrainIsPrompt stops the syncer while the drain
+// Start runs before its ticker loop is parked at each kind of wait; Stop
+// must only have to wait for the bounded final flush.
+func TestStopDuringInitialDrainIsPrompt(t *testing.T) {
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	tests := []struct {
+		name  string
+		setup func(*Syncer)
+		bound time.Duration
+	}{
+		{
+			name: "persisted backoff",
+			setup: func(s *Syncer) {
+				s.backoff = time.Hour
+				s.retryAt = time.Now().Add(time.Hour)
+			},
+			bound: time.Second,
+		},
+		{
+			name: "failure backoff",
+			setup: func(s *Syncer) {
+				s.minBackoff = time.Hour
+				s.maxBackoff = time.Hour
+			},
+			bound: time.Second,
+		},
+		{
+			name: "offline retry",
+			setup: func(s *Syncer) {
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				s.serverURL = "http://" + listener.Addr().String()
+				if err := listener.Close(); err != nil {
+					t.Fatal(err)
+				}
+				s.SetReachabilityCheck(true)
+				s.offlineRetry = time.Hour
+			},
+			bound: time.Second,
+		},
+		{
+			// The final flush runs the gate again under its own timeout.
+			name:  "hanging gate",
+			setup: func(s *Syncer) { s.SetGate("sleep 30") },
+			bound: shutdownFlushTimeout + time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			syncer, database := setupTestSyncer(t, failing)
+			tt.setup(syncer)
+			insertActivities(t, database, 1)
+
+			go syncer.Start()
+			time.Sleep(100 * time.Millisecond)
+
+			start := time.Now()
+			syncer.Stop()
+			if elapsed := time.Since(start); elapsed > tt.bound {
+				t.Errorf("Stop took %s, want under %s", elapsed, tt.bound)
+			}
+		})
+	}
+}
+
+func TestReachabilityCheck(t *testing.T) {
+	syncer, database := setupTestSyncer(t, okHandler(t))
+	syncer.SetReachabilityCheck(true)
+	insertActivities(t, database, 1)
+
+	syncer.drainBacklog()
+	if remaining, err := database.GetUnsyncedActivities(10); err != nil || len(remaining) != 0 {
+		t.Fatalf("reachable server: %d unsynced remaining (err %v), want 0", len(remaining), err)
+	}
+
+	// Nothing listens on the unreachable address.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncer.serverURL = "http://" + listener.Addr().String()
+	if err := listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+	syncer.offlineRetry = time.Hour
+	insertActivities(t, database, 1)
+
+	drained := make(chan struct{})
+	go func() {
+		syncer.drainBacklog()
+		close(drained)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	syncer.Stop()
+	<-drained
+
+	if syncer.backoff != 0 {
+		t.Errorf("backoff while offline = %s, want 0", syncer.backoff)
+	}
+	if !syncer.offline {
+		t.Error("syncer not marked offline")
+	}
+}
+
+func TestReachabilityCheckFallsThrough(t *testing.T) {
+	syncer, database := setupTestSyncer(t, okHandler(t))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncer.serverURL = "http://" + listener.Addr().String()
+	if err := listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+	syncer.SetReachabilityCheck(true)
+	syncer.offlineRetry = time.Millisecond
+	syncer.minBackoff = time.Hour
+	insertActivities(t, database, 1)
+
+	drained := make(chan struct{})
+	go func() {
+		syncer.drainBacklog()
+		close(drained)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	syncer.Stop()
+	<-drained
+
+	// The failed probes gave way to a real attempt, which backed off.
+	if syncer.backoff != time.Hour {
+		t.Errorf("backoff after failed probes = %s, want %s", syncer.backoff, time.Hour)
+	}
+}
+
+func TestReachabilityCheckUsesProxy(t *testing.T) {
+	var probes atomic.Int32
+	upstream := noCapabilities(okHandler(t))
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			probes.Add(1)
+		}
+		upstream.ServeHTTP(w, r)
+	}))
+	t.Cleanup(proxy.Close)
+
+	syncer, database := setupTestSyncer(t, okHandler(t))
+	// The server host doesn't resolve; only the proxy can reach it.
+	syncer.serverURL = "http://blast.invalid"
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncer.transport.Proxy = http.ProxyURL(proxyURL)
+	syncer.SetReachabilityCheck(true)
+	syncer.offlineRetry = time.Hour
+	insertActivities(t, database, 1)
+
+	syncer.drainBacklog()
+	if probes.Load() != 1 {
+		t.Errorf("probes through the proxy = %d, want 1", probes.Load())
+	}
+	if remaining, err := database.GetUnsyncedActivities(10); err != nil || len(remaining) != 0 {
+		t.Errorf("%d unsynced remaining (err %v), want 0", len(remaining), err)
+	}
+}
+
+func TestStartupDelay(t *testing.T) {
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		okHandler(t)(w, r)
+	})
+
+	syncer, database := setupTestSyncer(t, handler)
+	syncer.SetStartupDelay(time.Hour)
+	insertActivities(t, database, 1)
+
+	go syncer.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	syncer.Stop()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Stop during startup delay took %s", elapsed)
+	}
+	if got := calls.Load(); got != 0 {
+		t.Errorf("server saw %d requests during startup delay, want 0", got)
+	}
+}
+
+func TestStopCancelsInFlightRequest(t *testing.T) {
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	syncer, database := setupTestSyncer(t, handler)
+	// Registered after the server so it runs first and unblocks handlers
+	// before httptest waits on them.
+	t.Cleanup(func() { close(release) })
+	insertActivities(t, database, 1)
+
+	go syncer.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	syncer.Stop()
+	if elapsed := time.Since(start); elapsed > shutdownFlushTimeout+time.Second {
+		t.Errorf("Stop took %s, want under %s", elapsed, shutdownFlushTimeout+time.Second)
+	}
+}
+
+func TestNotifyTriggersDebouncedSync(t *testing.T) {
+	syncer, database := setupTestSyncer(t, okHandler(t))
+	syncer.SetDebounce(50 * time.Millisecond)
+
+	go syncer.Start()
+	t.Cleanup(syncer.Stop)
+
+	// Let the initial (empty) drain finish before recording activity.
+	time.Sleep(50 * time.Millisecond)
+	insertActivities(t, database, 2)
+	syncer.Notify()
+	syncer.Notify() // coalesced with the first
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		stats, err := database.GetStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Unsynced == 0 {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("activities were not synced after Notify")
+}
+
+func TestSyncStream(t *testing.T) {
+	var received []activityPayload
+	var transferEncoding []string
+	var clientHeader string
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Content-Type"); got != "application/x-ndjson" {
+			t.Errorf("Content-Type = %q, want application/x-ndjson", got)
+		}
+		transferEncoding = r.TransferEncoding
+		clientHeader = r.Header.Get("X-Blast-Client")
+
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var p activityPayload
+			if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+				t.Errorf("line %q: %v", scanner.Text(), err)
+			}
+			received = append(received, p)
+		}
+
+		if err := json.NewEncoder(w).Encode(syncResponse{Success: true, Count: len(received)}); err != nil {
+			t.Errorf("Encode() error: %v", err)
+		}
+	})
+
+	syncer, database := setupTestSyncer(t, handler)
+	syncer.SetStream(true)
+	syncer.SetClientInfo(ClientInfo{Version: "v1.2.3"})
+	insertActivities(t, database, 3)
+
+	n, err := syncer.syncBatch()
+	if err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+	if n != 3 || len(received) != 3 {
+		t.Fatalf("synced %d, server received %d; want 3", n, len(received))
+	}
+	if len(transferEncoding) != 1 || transferEncoding[0] != "chunked" {
+		t.Errorf("TransferEncoding = %v, want [chunked]", transferEncoding)
+	}
+	if clientHeader != `{"version":"v1.2.3"}` {
+		t.Errorf("X-Blast-Client = %q", clientHeader)
+	}
+
+	stats, err := database.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Unsynced != 0 {
+		t.Errorf("expected all activities synced, %d unsynced", stats.Unsynced)
+	}
+}
+
+func TestPoisonActivityIsDeadLettered(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req syncRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Decode() error: %v", err)
+		}
+		for _, a := range req.Activities {
+			if a.Project == "bad" {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		if err := json.NewEncoder(w).Encode(syncResponse{Success: true, Count: len(req.Activities)}); err != nil {
+			t.Errorf("Encode() error: %v", err)
+		}
+	})
+
+	syncer, database := setupTestSyncer(t, handler)
+	syncer.SetDeadLetterAfter(2)
+	insertActivities(t, database, 2)
+	now := time.Now().UTC()
+	if err := database.InsertActivity(&db.Activity{Project: "bad", StartedAt: now, EndedAt: now.Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := syncer.syncBatch()
+	if err != nil {
+		t.Fatalf("first syncBatch() error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("first pass synced %d, want 2", n)
+	}
+
+	// Only the bad record is left; its second rejection dead-letters it.
+	if _, err := syncer.syncBatch(); err == nil {
+		t.Error("expected an error when every activity is rejected")
+	}
+
+	stats, err := database.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Unsynced != 0 {
+		t.Errorf("unsynced = %d, want 0", stats.Unsynced)
+	}
+	letters, err := database.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Activity.Project != "bad" {
+		t.Errorf("expected the bad activity in dead letter, got %d letters", len(letters))
+	}
+}
+
+func TestDrainContinuesAfterIsolatingRejects(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req syncRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Decode() error: %v", err)
+		}
+		for _, a := range req.Activities {
+			if a.Project == "bad" {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(syncResponse{Success: true, Count: len(req.Activities)})
+	})
+
+	syncer, database := setupTestSyncer(t, handler)
+	syncer.batchSize = 2
+	syncer.SetDeadLetterAfter(1)
+	// The oldest row poisons the first batch, which then syncs only one of
+	// its two rows.
+	old := time.Now().UTC().Add(-time.Hour)
+	if err := database.InsertActivity(&db.Activity{Project: "bad", StartedAt: old, EndedAt: old.Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	insertActivities(t, database, 4)
+
+	n, err := syncer.Drain(0)
+	if err != nil {
+		t.Fatalf("Drain() error: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("Drain() sent %d, want all 4 good activities in one drain", n)
+	}
+}
+
+func TestRejectionWithoutDeadLetterFailsBatch(t *testing.T) {
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	})
+
+	syncer, database := setupTestSyncer(t, handler)
+	insertActivities(t, database, 3)
+
+	if _, err := syncer.syncBatch(); err == nil {
+		t.Fatal("expected error")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("server saw %d requests, want 1 (no per-activity retries)", got)
+	}
+}
+
+func TestSyncPayloadFormat(t *testing.T) {
+	var receivedBody syncRequest
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			t.Errorf("Authorization = %q, want %q", r.Header.Get("Authorization"), "Bearer test-token")
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q, want %q", r.Header.Get("Content-Type"), "application/json")
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&receivedBody); err != nil {
+			t.Fatalf("Decode() error: %v", err)
+		}
+
+		resp := syncResponse{Success: true, Count: len(receivedBody.Activities)}
+		for range receivedBody.Activities {
+			resp.Activities = append(resp.Activities, struct {
+				ID string `json:"id"`
+			}{ID: "test-id"})
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatalf("Encode() error: %v", err)
+		}
+	})
+
+	syncer, database := setupTestSyncer(t, handler)
+	syncer.SetClientInfo(ClientInfo{Version: "v1.2.3", Machine: "test", MachineID: "3f1c9a62-machine", OS: "linux", Arch: "arm64"})
+	now := time.Now().UTC()
+	if err := database.InsertActivity(&db.Activity{
+		Project:   "blast",
+		StartedAt: now,
+		EndedAt:   now.Add(time.Minute),
+		GitBranch: "main",
+		GitCommit: "63af302e",
+		Tags:      []string{"review"},
+		Metadata:  json.RawMessage(`{"lsp":"gopls"}`),
+		Editor:    "neovim",
+		Machine:   "test",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := syncer.syncBatch()
+	if err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("synced %d, want 1", n)
+	}
+
+	if len(receivedBody.Activities) != 1 {
+		t.Fatalf("server received %d activities, want 1", len(receivedBody.Activities))
+	}
+
+	a := receivedBody.Activities[0]
+	if a.Project != "blast" {
+		t.Errorf("Project = %q, want %q", a.Project, "blast")
+	}
+	if string(a.Metadata) != `{"lsp":"gopls"}` {
+		t.Errorf("Metadata = %s, want %s", a.Metadata, `{"lsp":"gopls"}`)
+	}
+	if a.Editor != "neovim" {
+		t.Errorf("Editor = %q, want %q", a.Editor, "neovim")
+	}
+	if a.Machine != "test" {
+		t.Errorf("Machine = %q, want %q", a.Machine, "test")
+	}
+	if a.StartedAt == "" {
+		t.Error("StartedAt should not be empty")
+	}
+	if a.GitBranch != "main" {
+		t.Errorf("GitBranch = %q, want %q", a.GitBranch, "main")
+	}
+	if a.DurationSeconds == nil || *a.DurationSeconds != 60 {
+		t.Errorf("DurationSeconds = %v, want 60", a.DurationSeconds)
+	}
+	if a.GitCommit != "63af302e" {
+		t.Errorf("GitCommit = %q, want %q", a.GitCommit, "63af302e")
+	}
+	if len(a.Tags) != 1 || a.Tags[0] != "review" {
+		t.Errorf("Tags = %v, want [review]", a.Tags)
+	}
+
+	client := receivedBody.Client
+	if client == nil {
+		t.Fatal("expected batch-level client info")
+	}
+	if client.Version != "v1.2.3" || client.Machine != "test" || client.MachineID != "3f1c9a62-machine" || client.OS != "linux" || client.Arch != "arm64" {
+		t.Errorf("Client = %+v, want v1.2.3/test/3f1c9a62-machine/linux/arm64", *client)
+	}
+}
+
+func TestSyncPayloadKeystrokes(t *testing.T) {
+	var receivedBody syncRequest
+	syncer, database := setupTestSyncer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&receivedBody); err != nil {
+			t.Fatalf("Decode() error: %v", err)
+		}
+		_ = json.NewEncoder(w).Encode(syncResponse{Success: true})
+	}))
+
+	now := time.Now().UTC()
+	if err := database.InsertActivity(&db.Activity{Project: "blast", StartedAt: now.Add(-time.Minute), EndedAt: now, Keystrokes: 240, Edits: 9}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncer.syncBatch(); err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+	if len(receivedBody.Activities) != 1 {
+		t.Fatalf("server received %d activities, want 1", len(receivedBody.Activities))
+	}
+	if a := receivedBody.Activities[0]; a.Keystrokes != 240 || a.Edits != 9 {
+		t.Errorf("Keystrokes, Edits = %d, %d, want 240, 9", a.Keystrokes, a.Edits)
+	}
+}
+
+func TestSyncPayloadTimezone(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	var receivedBody syncRequest
+	syncer, database := setupTestSyncer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&receivedBody); err != nil {
+			t.Fatalf("Decode() error: %v", err)
+		}
+		_ = json.NewEncoder(w).Encode(syncResponse{Success: true})
+	}))
+	syncer.SetLocation(loc)
+
+	// 02:30 UTC is still the previous evening in New York, on either side
+	// of the DST change.
+	for _, start := range []time.Time{
+		time.Date(2024, 1, 10, 2, 30, 0, 0, time.UTC),
+		time.Date(2024, 7, 10, 2, 30, 0, 0, time.UTC),
+	} {
+		if err := database.InsertActivity(&db.Activity{Project: "blast", StartedAt: start, EndedAt: start.Add(time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := syncer.syncBatch(); err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+
+	if len(receivedBody.Activities) != 2 {
+		t.Fatalf("server received %d activities, want 2", len(receivedBody.Activities))
+	}
+	for i, want := range []int{-300, -240} {
+		a := receivedBody.Activities[i]
+		if a.TZOffset == nil || *a.TZOffset != want {
+			t.Errorf("activity %d: TZOffset = %v, want %d", i, a.TZOffset, want)
+		}
+		if a.Timezone != "America/New_York" {
+			t.Errorf("activity %d: Timezone = %q, want America/New_York", i, a.Timezone)
+		}
+		if a.StartedAt[len(a.StartedAt)-1] != 'Z' {
+			t.Errorf("activity %d: StartedAt = %q, want UTC", i, a.StartedAt)
+		}
+	}
+}
+
+func TestSyncMetricsOnly(t *testing.T) {
+	var receivedBody syncRequest
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&receivedBody); err != nil {
+			t.Fatalf("Decode() error: %v", err)
+		}
+		resp := syncResponse{Success: true, Count: len(receivedBody.Activities)}
+		for range receivedBody.Activities {
+			resp.Activities = append(resp.Activities, struct {
+				ID string `json:"id"`
+			}{ID: "test-id"})
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatalf("Encode() error: %v", err)
+		}
 	})
 
 	dbPath := filepath.Join(t.TempDir(), "test.db")
@@ -347,12 +1337,24 @@ func TestSyncMetricsOnly(t *testing.T) {
 		}
 	})
 
-	server := httptest.NewServer(handler)
+	server := httptest.NewServer(noCapabilities(handler))
 	t.Cleanup(server.Close)
 
 	syncer := NewSyncer(database, server.URL, "test-token", 60, 10, true)
 
-	insertActivities(t, database, 1)
+	now := time.Now().UTC()
+	if err := database.InsertActivity(&db.Activity{
+		Project:   "blast",
+		GitRemote: "git@github.com:taigrr/blast.git",
+		StartedAt: now,
+		EndedAt:   now.Add(time.Minute),
+		Filetype:  "go",
+		GitCommit: "63af302e",
+		Metadata:  json.RawMessage(`{"repo":"secret"}`),
+		Editor:    "neovim",
+	}); err != nil {
+		t.Fatal(err)
+	}
 
 	n, err := syncer.syncBatch()
 	if err != nil {
@@ -369,6 +1371,12 @@ func TestSyncMetricsOnly(t *testing.T) {
 	if a.GitRemote != "private" {
 		t.Errorf("GitRemote = %q, want %q", a.GitRemote, "private")
 	}
+	if a.GitCommit != "" {
+		t.Errorf("GitCommit = %q, want empty", a.GitCommit)
+	}
+	if a.Metadata != nil {
+		t.Errorf("Metadata = %s, want omitted", a.Metadata)
+	}
 	if a.Editor != "neovim" {
 		t.Errorf("Editor = %q, want %q (should still be sent)", a.Editor, "neovim")
 	}
@@ -376,3 +1384,216 @@ func TestSyncMetricsOnly(t *testing.T) {
 		t.Errorf("Filetype = %q, want %q (should still be sent)", a.Filetype, "go")
 	}
 }
+
+func writeTestKeyPair(t *testing.T) (certPath, keyPath string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "blastd-test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certPath = filepath.Join(dir, "client.crt")
+	keyPath = filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func TestSyncMutualTLS(t *testing.T) {
+	var sawClientCert atomic.Bool
+	ok := okHandler(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			sawClientCert.Store(true)
+		}
+		ok(w, r)
+	})
+
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	database, err := db.Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := database.Close(); err != nil {
+			t.Fatalf("Close() error: %v", err)
+		}
+	})
+
+	server := httptest.NewUnstartedServer(noCapabilities(handler))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	caPath := filepath.Join(t.TempDir(), "ca.crt")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caPath, caPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	certPath, keyPath := writeTestKeyPair(t)
+
+	syncer := NewSyncer(database, server.URL, "test-token", 60, 10, false)
+	if err := syncer.SetTLS(TLSOptions{ClientCert: certPath, ClientKey: keyPath, CACert: caPath}); err != nil {
+		t.Fatalf("SetTLS() error: %v", err)
+	}
+
+	insertActivities(t, database, 1)
+
+	n, err := syncer.syncBatch()
+	if err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("synced %d, want 1", n)
+	}
+	if !sawClientCert.Load() {
+		t.Error("server did not receive a client certificate")
+	}
+}
+
+func TestSyncInsecureSkipVerify(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	database, err := db.Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := database.Close(); err != nil {
+			t.Fatalf("Close() error: %v", err)
+		}
+	})
+
+	server := httptest.NewTLSServer(noCapabilities(okHandler(t)))
+	t.Cleanup(server.Close)
+
+	insertActivities(t, database, 1)
+
+	strict := NewSyncer(database, server.URL, "test-token", 60, 10, false)
+	if _, err := strict.syncBatch(); err == nil {
+		t.Fatal("expected certificate error against self-signed server")
+	}
+
+	insecure := NewSyncer(database, server.URL, "test-token", 60, 10, false)
+	if err := insecure.SetTLS(TLSOptions{InsecureSkipVerify: true}); err != nil {
+		t.Fatalf("SetTLS() error: %v", err)
+	}
+	n, err := insecure.syncBatch()
+	if err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("synced %d, want 1", n)
+	}
+}
+
+func TestSyncReusesConnections(t *testing.T) {
+	// The server pads each response after the JSON document, later than
+	// net/http waits when it drains a body by itself on Close, so the
+	// connection is only reused if the syncer reads the body to EOF.
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		okHandler(t)(w, r)
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(strings.Repeat(" ", 1<<10)))
+	})
+	syncer, database := setupTestSyncer(t, handler)
+	syncer.SetKeepAlive(4, time.Minute)
+	if syncer.transport.MaxIdleConnsPerHost != 4 || syncer.transport.IdleConnTimeout != time.Minute {
+		t.Errorf("transport idle settings = %d, %s; want 4, 1m", syncer.transport.MaxIdleConnsPerHost, syncer.transport.IdleConnTimeout)
+	}
+
+	var reused []bool
+	syncer.ctx = httptrace.WithClientTrace(syncer.ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { reused = append(reused, info.Reused) },
+	})
+
+	for range 3 {
+		insertActivities(t, database, 1)
+		if _, err := syncer.syncBatch(); err != nil {
+			t.Fatalf("syncBatch() error: %v", err)
+		}
+	}
+	// The capabilities probe opens the connection; every upload reuses it.
+	want := []bool{false, true, true, true}
+	if !slices.Equal(reused, want) {
+		t.Errorf("connection reused per request = %v, want %v", reused, want)
+	}
+}
+
+func TestCloseBodyLimitsDrain(t *testing.T) {
+	body := &endlessBody{}
+	if err := closeBody(&http.Response{Body: body}); err != nil {
+		t.Fatalf("closeBody() error: %v", err)
+	}
+	if body.read > maxDrain {
+		t.Errorf("closeBody() read %d bytes, want at most %d", body.read, maxDrain)
+	}
+	if !body.closed {
+		t.Error("closeBody() didn't close the body")
+	}
+}
+
+// endlessBody is a response body that never ends.
+type endlessBody struct {
+	read   int
+	closed bool
+}
+
+func (b *endlessBody) Read(p []byte) (int, error) {
+	b.read += len(p)
+	return len(p), nil
+}
+
+func (b *endlessBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestSetTLSErrors(t *testing.T) {
+	syncer, _ := setupTestSyncer(t, okHandler(t))
+	certPath, keyPath := writeTestKeyPair(t)
+
+	tests := []struct {
+		name string
+		opts TLSOptions
+	}{
+		{"cert without key", TLSOptions{ClientCert: certPath}},
+		{"key without cert", TLSOptions{ClientKey: keyPath}},
+		{"missing files", TLSOptions{ClientCert: "/nonexistent.crt", ClientKey: "/nonexistent.key"}},
+		{"swapped pair", TLSOptions{ClientCert: keyPath, ClientKey: certPath}},
+		{"ca not pem", TLSOptions{CACert: keyPath}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := syncer.SetTLS(tt.opts); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	if err := syncer.SetTLS(TLSOptions{}); err != nil {
+		t.Errorf("SetTLS(empty) error: %v", err)
+	}
+}
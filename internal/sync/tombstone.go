@@ -0,0 +1,123 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/taigrr/blastd/internal/db"
+)
+
+// sendTombstones asks the server to delete up to one pass worth of
+// activities deleted locally after they were synced, and purges each
+// tombstone the server confirms. A 404 means the server no longer has the
+// activity, which is what was wanted. A server without the endpoint (405 or
+// 501) is remembered for the daemon's lifetime; its tombstones stay queued
+// for a future server rather than failing every sync. Other failures are
+// logged and put tombstones on their own backoff, so a delete the server
+// keeps refusing never holds up uploads.
+func (s *Syncer) sendTombstones(ctx context.Context) {
+	if s.deletesUnsupported.Load() || !s.tombstonesDue() {
+		return
+	}
+	tombstones, err := s.db.Tombstones(s.passSize())
+	if err != nil {
+		log.Printf("sync: read tombstones: %v", err)
+		return
+	}
+	if len(tombstones) == 0 {
+		return
+	}
+
+	var (
+		purged []int64
+		failed int
+	)
+	defer func() {
+		if err := s.db.PurgeTombstones(purged); err != nil {
+			log.Printf("sync: purge tombstones: %v", err)
+		}
+		if len(purged) > 0 {
+			log.Printf("sync: deleted %d activities on the server", len(purged))
+		}
+		s.tombstonesDone(failed > 0)
+	}()
+	for _, t := range tombstones {
+		err := s.sendDelete(ctx, t)
+		var se *statusError
+		isStatus := errors.As(err, &se)
+		if isStatus && (se.StatusCode == http.StatusMethodNotAllowed || se.StatusCode == http.StatusNotImplemented) {
+			log.Printf("sync: server doesn't support deleting activities (%v); keeping %d deletes queued", err, len(tombstones)-len(purged))
+			s.deletesUnsupported.Store(true)
+			return
+		}
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			failed++
+			log.Printf("sync: delete activity %s: %v", t.ClientID, err)
+			// The server refused this one; the rest may still go through.
+			// Anything else (network, 5xx) would fail them all alike.
+			if isStatus && se.StatusCode >= 400 && se.StatusCode < 500 {
+				continue
+			}
+			return
+		}
+		purged = append(purged, t.ID)
+	}
+}
+
+// tombstonesDue reports whether the tombstone backoff has passed.
+func (s *Syncer) tombstonesDue() bool {
+	s.tombstoneMu.Lock()
+	defer s.tombstoneMu.Unlock()
+	return !time.Now().Before(s.tombstoneRetryAt)
+}
+
+// tombstonesDone grows the tombstone backoff after a pass with a failed
+// delete, like the upload backoff, and clears it after a clean one.
+func (s *Syncer) tombstonesDone(failed bool) {
+	s.tombstoneMu.Lock()
+	defer s.tombstoneMu.Unlock()
+	if !failed {
+		s.tombstoneBackoff = 0
+		s.tombstoneRetryAt = time.Time{}
+		return
+	}
+	if s.tombstoneBackoff == 0 {
+		s.tombstoneBackoff = s.minBackoff
+	} else {
+		s.tombstoneBackoff = min(time.Duration(float64(s.tombstoneBackoff)*s.backoffFactor), s.maxBackoff)
+	}
+	s.tombstoneRetryAt = time.Now().Add(s.tombstoneBackoff)
+	log.Printf("sync: retrying failed deletes in %s", s.tombstoneBackoff)
+}
+
+// sendDelete sends DELETE /api/activities/<clientUUID> for one tombstone.
+func (s *Syncer) sendDelete(ctx context.Context, t db.Tombstone) (err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.serverURL+"/api/activities/"+url.PathEscape(t.ClientID), nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer func() {
+		if closeErr := closeBody(resp); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return &statusError{StatusCode: resp.StatusCode}
+	}
+}
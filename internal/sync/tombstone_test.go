@@ -0,0 +1,178 @@
+package sync
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendTombstones(t *testing.T) {
+	var deleted []string
+	syncer, database := setupTestSyncer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			okHandler(t)(w, r)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/api/activities/"))
+		if len(deleted) == 2 {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	insertActivities(t, database, 3)
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncer.syncBatch(); err != nil {
+		t.Fatal(err)
+	}
+	if _, tombstoned, err := database.DeleteActivities([]int64{activities[0].ID, activities[1].ID}); err != nil || tombstoned != 2 {
+		t.Fatalf("DeleteActivities() = %d tombstoned, %v", tombstoned, err)
+	}
+
+	if _, err := syncer.syncBatch(); err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+	if len(deleted) != 2 {
+		t.Fatalf("server got %d deletes, want 2", len(deleted))
+	}
+	for _, id := range deleted {
+		if id != activities[0].ClientID && id != activities[1].ClientID {
+			t.Errorf("deleted %q, want one of the tombstoned client ids", id)
+		}
+	}
+	// A 404 counts as deleted, so both tombstones are gone.
+	if tombstones, err := database.Tombstones(10); err != nil || len(tombstones) != 0 {
+		t.Errorf("Tombstones() = %v, %v; want none left", tombstones, err)
+	}
+}
+
+func TestSendTombstonesUnsupported(t *testing.T) {
+	var deletes int
+	syncer, database := setupTestSyncer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			okHandler(t)(w, r)
+			return
+		}
+		deletes++
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+
+	insertActivities(t, database, 1)
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncer.syncBatch(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := database.DeleteActivities([]int64{activities[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if _, err := syncer.syncBatch(); err != nil {
+			t.Fatalf("syncBatch() error: %v", err)
+		}
+	}
+	if deletes != 1 {
+		t.Errorf("server got %d deletes, want 1 before the syncer gave up", deletes)
+	}
+	if tombstones, err := database.Tombstones(10); err != nil || len(tombstones) != 1 {
+		t.Errorf("Tombstones() = %v, %v; want the tombstone kept", tombstones, err)
+	}
+}
+
+func TestSendTombstonesFailureDoesNotBlockUploads(t *testing.T) {
+	var deletes []string
+	syncer, database := setupTestSyncer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			okHandler(t)(w, r)
+			return
+		}
+		deletes = append(deletes, strings.TrimPrefix(r.URL.Path, "/api/activities/"))
+		if len(deletes) == 1 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	syncer.minBackoff = time.Hour
+
+	insertActivities(t, database, 2)
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncer.syncBatch(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := database.DeleteActivities([]int64{activities[0].ID, activities[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The refused delete doesn't stop the next one or the upload.
+	insertActivities(t, database, 3)
+	if n, err := syncer.syncBatch(); err != nil || n != 3 {
+		t.Fatalf("syncBatch() = %d, %v; want 3 uploaded despite the refused delete", n, err)
+	}
+	if len(deletes) != 2 {
+		t.Fatalf("server got %d deletes, want 2", len(deletes))
+	}
+	tombstones, err := database.Tombstones(10)
+	if err != nil || len(tombstones) != 1 || tombstones[0].ClientID != deletes[0] {
+		t.Fatalf("Tombstones() = %v, %v; want only the refused one", tombstones, err)
+	}
+
+	// Deletes are now backing off; uploads are not.
+	insertActivities(t, database, 1)
+	if n, err := syncer.syncBatch(); err != nil || n != 1 {
+		t.Fatalf("syncBatch() = %d, %v; want 1", n, err)
+	}
+	if len(deletes) != 2 {
+		t.Errorf("server got %d deletes, want none during the backoff", len(deletes))
+	}
+}
+
+func TestSendTombstonesServerError(t *testing.T) {
+	var deletes int
+	syncer, database := setupTestSyncer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			okHandler(t)(w, r)
+			return
+		}
+		deletes++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	insertActivities(t, database, 2)
+	activities, err := database.GetUnsyncedActivities(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncer.syncBatch(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := database.DeleteActivities([]int64{activities[0].ID, activities[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	insertActivities(t, database, 1)
+	if n, err := syncer.syncBatch(); err != nil || n != 1 {
+		t.Fatalf("syncBatch() = %d, %v; want 1", n, err)
+	}
+	// A 5xx would fail every delete alike, so the rest wait for the backoff.
+	if deletes != 1 {
+		t.Errorf("server got %d deletes, want 1", deletes)
+	}
+	if tombstones, err := database.Tombstones(10); err != nil || len(tombstones) != 2 {
+		t.Errorf("Tombstones() = %v, %v; want both kept", tombstones, err)
+	}
+}
@@ -0,0 +1,74 @@
+package sync
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"time"
+)
+
+// Warning is the most recent soft issue the server reported alongside a
+// successful upload.
+type Warning struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// serverMessages decodes the optional message and warnings fields of a
+// sync response. A missing field, null, a string and an array of strings
+// are all accepted; anything else is ignored rather than failing an
+// upload the server accepted.
+type serverMessages []string
+
+func (m *serverMessages) UnmarshalJSON(data []byte) error {
+	*m = nil
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		if one != "" {
+			*m = serverMessages{one}
+		}
+		return nil
+	}
+	var many []any
+	if err := json.Unmarshal(data, &many); err != nil {
+		return nil
+	}
+	for _, v := range many {
+		if msg, ok := v.(string); ok && msg != "" {
+			*m = append(*m, msg)
+		}
+	}
+	return nil
+}
+
+// noteWarnings logs what the server said alongside a successful upload,
+// keeps it for the current pass's Attempt and remembers the last one for
+// LastWarning. A warning repeated by every batch of a pass is logged once.
+func (s *Syncer) noteWarnings(msgs []string) {
+	if len(msgs) == 0 {
+		return
+	}
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	for _, msg := range msgs {
+		if slices.Contains(s.passWarnings, msg) {
+			continue
+		}
+		s.passWarnings = append(s.passWarnings, msg)
+		log.Printf("sync: WARNING: server: %s", msg)
+	}
+	s.lastWarning = &Warning{Time: time.Now(), Message: msgs[len(msgs)-1]}
+}
+
+// LastWarning returns the most recent warning from the server since the
+// daemon started, or nil if there was none. It is safe to call while the
+// syncer is running.
+func (s *Syncer) LastWarning() *Warning {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+	if s.lastWarning == nil {
+		return nil
+	}
+	w := *s.lastWarning
+	return &w
+}
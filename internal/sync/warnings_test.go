@@ -0,0 +1,92 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+func TestServerMessagesTolerant(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{`{"success":true}`, nil},
+		{`{"success":true,"warnings":null,"message":null}`, nil},
+		{`{"success":true,"message":"3 activities clamped"}`, []string{"3 activities clamped"}},
+		{`{"success":true,"warnings":["approaching quota","",42,"clamped"]}`, []string{"approaching quota", "clamped"}},
+		{`{"success":true,"warnings":"approaching quota"}`, []string{"approaching quota"}},
+		{`{"success":true,"warnings":{"quota":0.9},"message":7}`, nil},
+	}
+	for _, tt := range tests {
+		var resp syncResponse
+		if err := json.Unmarshal([]byte(tt.body), &resp); err != nil {
+			t.Errorf("Unmarshal(%s) error: %v", tt.body, err)
+			continue
+		}
+		if !resp.Success {
+			t.Errorf("Unmarshal(%s) lost success", tt.body)
+		}
+		if got := append(resp.Message, resp.Warnings...); !slices.Equal(got, tt.want) {
+			t.Errorf("Unmarshal(%s) messages = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestSyncWarningsRecorded(t *testing.T) {
+	var quiet atomic.Bool
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if quiet.Load() {
+			okHandler(t)(w, r)
+			return
+		}
+		if _, err := w.Write([]byte(`{"success":true,"message":"2 activities clamped","warnings":["approaching quota"]}`)); err != nil {
+			t.Errorf("Write() error: %v", err)
+		}
+	})
+	syncer, database := setupTestSyncer(t, handler)
+	syncer.batchSize = 1
+	syncer.concurrency = 3
+
+	if w := syncer.LastWarning(); w != nil {
+		t.Fatalf("LastWarning() before any sync = %+v, want nil", w)
+	}
+
+	insertActivities(t, database, 3)
+	n, err := syncer.syncBatch()
+	if err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("synced %d, want 3", n)
+	}
+
+	// Three uploads repeating the same two messages make one pass.
+	history := syncer.History()
+	if len(history) != 1 {
+		t.Fatalf("%d attempts recorded, want 1", len(history))
+	}
+	if want := []string{"2 activities clamped", "approaching quota"}; !slices.Equal(history[0].Warnings, want) {
+		t.Errorf("Attempt.Warnings = %q, want %q", history[0].Warnings, want)
+	}
+	w := syncer.LastWarning()
+	if w == nil || w.Message != "approaching quota" || w.Time.IsZero() {
+		t.Errorf("LastWarning() = %+v, want approaching quota", w)
+	}
+
+	// A quiet pass records no warnings but keeps the last one.
+	quiet.Store(true)
+	insertActivities(t, database, 1)
+	if _, err := syncer.syncBatch(); err != nil {
+		t.Fatalf("syncBatch() error: %v", err)
+	}
+	history = syncer.History()
+	if got := history[len(history)-1].Warnings; got != nil {
+		t.Errorf("quiet pass Attempt.Warnings = %q, want none", got)
+	}
+	if w := syncer.LastWarning(); w == nil || w.Message != "approaching quota" {
+		t.Errorf("LastWarning() after a quiet pass = %+v, want approaching quota", w)
+	}
+}
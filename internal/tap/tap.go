@@ -0,0 +1,127 @@
+// Package tap writes stored activities to a stream, one JSON object per
+// line, so the daemon's output can be piped into jq or another tool.
+// Writes happen on a background goroutine: a slow reader never delays an
+// insert, it only misses events while it is behind.
+package tap
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// queueSize is how many events may wait to be written before new ones
+	// are dropped.
+	queueSize = 1024
+
+	// drainTimeout bounds how long Stop waits for queued events to be
+	// written, in case the reader has stopped reading.
+	drainTimeout = 2 * time.Second
+)
+
+// Tap writes each event to w as a JSON line from a single background
+// goroutine.
+type Tap struct {
+	w            io.Writer
+	queue        chan []byte
+	drainTimeout time.Duration
+	dropped      atomic.Int64
+	done         chan struct{}
+	finished     chan struct{}
+	started      atomic.Bool
+}
+
+func New(w io.Writer) *Tap {
+	return &Tap{
+		w:            w,
+		queue:        make(chan []byte, queueSize),
+		drainTimeout: drainTimeout,
+		done:         make(chan struct{}),
+		finished:     make(chan struct{}),
+	}
+}
+
+// Send queues v to be written and returns immediately. If the queue is
+// full the event is dropped.
+func (t *Tap) Send(v any) {
+	line, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("tap: marshal event: %v", err)
+		return
+	}
+	select {
+	case t.queue <- append(line, '\n'):
+	default:
+		if t.dropped.Add(1) == 1 {
+			log.Printf("tap: output is falling behind, dropping events")
+		}
+	}
+}
+
+// Start writes queued events until Stop is called, then writes whatever
+// is still queued. A write error, such as the reader closing the pipe,
+// stops the tap; later events are discarded.
+func (t *Tap) Start() {
+	t.started.Store(true)
+	defer close(t.finished)
+
+	for {
+		select {
+		case <-t.done:
+			for {
+				select {
+				case line := <-t.queue:
+					if !t.write(line) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		case line := <-t.queue:
+			if !t.write(line) {
+				t.discard()
+				return
+			}
+			if n := t.dropped.Swap(0); n > 0 {
+				log.Printf("tap: dropped %d events while output was behind", n)
+			}
+		}
+	}
+}
+
+// Stop ends Start, giving it up to drainTimeout to write queued events.
+func (t *Tap) Stop() {
+	close(t.done)
+	if !t.started.Load() {
+		return
+	}
+	select {
+	case <-t.finished:
+	case <-time.After(t.drainTimeout):
+		log.Printf("tap: output not accepting writes, abandoning %d queued events", len(t.queue))
+	}
+}
+
+func (t *Tap) write(line []byte) bool {
+	if _, err := t.w.Write(line); err != nil {
+		log.Printf("tap: write: %v; no further events will be written", err)
+		return false
+	}
+	return true
+}
+
+// discard empties the queue until Stop so Send never sees it full after
+// the output has failed.
+func (t *Tap) discard() {
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-t.queue:
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package tap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTapWritesJSONLines(t *testing.T) {
+	r, w := io.Pipe()
+	tp := New(w)
+	go tp.Start()
+	defer tp.Stop()
+
+	for i := range 3 {
+		tp.Send(map[string]any{"id": i})
+	}
+
+	scanner := bufio.NewScanner(r)
+	for i := range 3 {
+		if !scanner.Scan() {
+			t.Fatalf("line %d: %v", i, scanner.Err())
+		}
+		var ev map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatalf("line %d is not JSON: %v", i, err)
+		}
+		if ev["id"] != float64(i) {
+			t.Errorf("line %d has id %v", i, ev["id"])
+		}
+	}
+}
+
+func TestStopWritesQueuedEvents(t *testing.T) {
+	var lines collector
+	tp := New(&lines)
+	for i := range 3 {
+		tp.Send(map[string]any{"id": i})
+	}
+	go tp.Start()
+	for !tp.started.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	tp.Stop()
+
+	if got := len(lines.lines); got != 3 {
+		t.Errorf("wrote %d lines before Stop returned, want 3", got)
+	}
+}
+
+func TestSendDoesNotBlockOnStalledReader(t *testing.T) {
+	// Nothing reads the pipe, so the first write never completes.
+	_, w := io.Pipe()
+	tp := New(w)
+	tp.drainTimeout = 10 * time.Millisecond
+	go tp.Start()
+
+	sent := make(chan struct{})
+	go func() {
+		for i := range queueSize * 2 {
+			tp.Send(map[string]any{"id": i})
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send blocked on a stalled reader")
+	}
+	if tp.dropped.Load() == 0 {
+		t.Error("no events were dropped with a full queue")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		tp.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked on a stalled reader")
+	}
+}
+
+func TestWriteErrorStopsTap(t *testing.T) {
+	w := &failingWriter{}
+	tp := New(w)
+	go tp.Start()
+
+	// After the failed write the queue is emptied without writing, and
+	// Send never blocks.
+	for i := range queueSize * 2 {
+		tp.Send(map[string]any{"id": i})
+		if i == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	tp.Stop()
+
+	select {
+	case <-tp.finished:
+	default:
+		t.Fatal("Start still running after Stop")
+	}
+	if n := w.writes.Load(); n != 1 {
+		t.Errorf("failing writer got %d writes, want only the first", n)
+	}
+}
+
+// failingWriter fails every write and counts the attempts.
+type failingWriter struct{ writes atomic.Int32 }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes.Add(1)
+	return 0, io.ErrClosedPipe
+}
+
+type collector struct{ lines [][]byte }
+
+func (c *collector) Write(p []byte) (int, error) {
+	c.lines = append(c.lines, append([]byte(nil), p...))
+	return len(p), nil
+}
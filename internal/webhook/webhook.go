@@ -0,0 +1,155 @@
+// Package webhook forwards stored activities to a user-configured HTTP
+// endpoint, such as a local dashboard. Delivery is best effort: events are
+// queued without blocking the caller and dropped when the endpoint can't
+// keep up.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// queueSize is how many events may wait for delivery before new ones
+	// are dropped.
+	queueSize = 256
+
+	// attempts is how many times one event is POSTed before it is dropped;
+	// retryDelay is the pause before the first retry, doubled after each.
+	attempts   = 3
+	retryDelay = time.Second
+
+	// minInterval spaces out POSTs so a burst of activities doesn't flood
+	// a slow endpoint.
+	minInterval = 100 * time.Millisecond
+
+	httpTimeout = 5 * time.Second
+)
+
+// Forwarder POSTs each event to url as JSON from a single background
+// goroutine.
+type Forwarder struct {
+	url         string
+	client      *http.Client
+	queue       chan []byte
+	retryDelay  time.Duration
+	minInterval time.Duration
+	dropped     atomic.Int64
+	done        chan struct{}
+	finished    chan struct{}
+	started     atomic.Bool
+	ctx         context.Context
+	cancel      context.CancelFunc
+}
+
+func New(url string) *Forwarder {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Forwarder{
+		url:         url,
+		client:      &http.Client{Timeout: httpTimeout},
+		queue:       make(chan []byte, queueSize),
+		retryDelay:  retryDelay,
+		minInterval: minInterval,
+		done:        make(chan struct{}),
+		finished:    make(chan struct{}),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// Send queues v for delivery and returns immediately. If the queue is full
+// the event is dropped.
+func (f *Forwarder) Send(v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("webhook: marshal event: %v", err)
+		return
+	}
+	select {
+	case f.queue <- body:
+	default:
+		if f.dropped.Add(1) == 1 {
+			log.Printf("webhook: %s is falling behind, dropping events", f.url)
+		}
+	}
+}
+
+// Start delivers queued events until Stop is called. Events still queued
+// at that point are discarded.
+func (f *Forwarder) Start() {
+	f.started.Store(true)
+	defer close(f.finished)
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case body := <-f.queue:
+			f.deliver(body)
+			if n := f.dropped.Swap(0); n > 0 {
+				log.Printf("webhook: dropped %d events while %s was behind", n, f.url)
+			}
+			select {
+			case <-f.done:
+				return
+			case <-time.After(f.minInterval):
+			}
+		}
+	}
+}
+
+// Stop cancels any in-flight POST and, if Start is running, waits for it
+// to return.
+func (f *Forwarder) Stop() {
+	close(f.done)
+	f.cancel()
+	if f.started.Load() {
+		<-f.finished
+	}
+}
+
+// deliver POSTs body, retrying with backoff, and gives up after attempts
+// tries.
+func (f *Forwarder) deliver(body []byte) {
+	delay := f.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := f.post(body)
+		if err == nil || f.ctx.Err() != nil {
+			return
+		}
+		if attempt == attempts {
+			log.Printf("webhook: dropping event after %d attempts: %v", attempts, err)
+			return
+		}
+		select {
+		case <-f.done:
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (f *Forwarder) post(body []byte) error {
+	req, err := http.NewRequestWithContext(f.ctx, http.MethodPost, f.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %d", f.url, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestForwarder(t *testing.T, handler http.Handler) *Forwarder {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	f := New(server.URL)
+	f.retryDelay = time.Millisecond
+	f.minInterval = time.Millisecond
+	return f
+}
+
+func TestForwarderDelivers(t *testing.T) {
+	got := make(chan map[string]any, 3)
+	f := newTestForwarder(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		var ev map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("Decode() error: %v", err)
+		}
+		got <- ev
+	}))
+
+	for i := range 3 {
+		f.Send(map[string]any{"id": i})
+	}
+	go f.Start()
+	defer f.Stop()
+
+	for i := range 3 {
+		select {
+		case ev := <-got:
+			if ev["id"] != float64(i) {
+				t.Errorf("event %d has id %v", i, ev["id"])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+}
+
+func TestForwarderRetries(t *testing.T) {
+	var calls atomic.Int32
+	delivered := make(chan struct{})
+	f := newTestForwarder(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < attempts {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		close(delivered)
+	}))
+
+	f.Send(map[string]any{"id": 1})
+	go f.Start()
+	defer f.Stop()
+
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not redelivered after failures")
+	}
+	if got := calls.Load(); got != attempts {
+		t.Errorf("webhook saw %d requests, want %d", got, attempts)
+	}
+}
+
+func TestSendDropsWhenQueueFull(t *testing.T) {
+	f := New("http://127.0.0.1:0")
+
+	// Nothing drains the queue, so Send must drop rather than block.
+	for i := range queueSize + 10 {
+		f.Send(map[string]any{"id": i})
+	}
+	if got := f.dropped.Load(); got != 10 {
+		t.Errorf("dropped %d events, want 10", got)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/blastd/internal/db"
+	"github.com/taigrr/blastd/internal/socket"
+)
+
+func newLogCmd() *cobra.Command {
+	var (
+		project  string
+		filetype string
+		tags     []string
+		duration time.Duration
+		end      string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "log",
+		Short: "Record time spent outside the editor as a manual activity",
+		Long: "log submits an activity to the running daemon with source \"manual\", e.g. for code review in a " +
+			"browser. It is stored and synced like tracked time, but the server and stats can tell the two apart.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if duration <= 0 {
+				return fmt.Errorf("specify how long the activity lasted with --duration, e.g. --duration 45m")
+			}
+			endedAt := time.Now()
+			if end != "" {
+				t, err := parseTimeFlag(end)
+				if err != nil {
+					return fmt.Errorf("invalid --end: %w", err)
+				}
+				endedAt = t
+			}
+			startedAt := endedAt.Add(-duration)
+
+			data, err := json.Marshal(socket.ActivityData{
+				Project:   project,
+				Filetype:  filetype,
+				Tags:      tags,
+				StartedAt: startedAt.Format(time.RFC3339),
+				EndedAt:   endedAt.Format(time.RFC3339),
+				Source:    db.SourceManual,
+			})
+			if err != nil {
+				return err
+			}
+
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			resp, err := socketRequest(cfg.SocketPath, socket.Request{Type: "activity", Data: data})
+			if err != nil {
+				return err
+			}
+			if !resp.OK {
+				return fmt.Errorf("log: %s", resp.Error)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "logged %s from %s to %s\n", duration,
+				startedAt.Format(time.DateTime), endedAt.Format(time.DateTime))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&project, "project", "", "project the time was spent on")
+	cmd.Flags().StringVar(&filetype, "filetype", "", "filetype or kind of work, if any")
+	cmd.Flags().StringSliceVar(&tags, "tag", nil, "tag the activity (repeatable)")
+	cmd.Flags().DurationVar(&duration, "duration", 0, "how long the activity lasted, e.g. 45m or 1h30m")
+	cmd.Flags().StringVar(&end, "end", "", "when the activity ended (RFC 3339 or YYYY-MM-DD; default now)")
+	return cmd
+}
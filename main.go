@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime/debug"
 	"syscall"
 
@@ -12,12 +15,25 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/taigrr/blastd/internal/config"
 	"github.com/taigrr/blastd/internal/daemon"
+	"github.com/taigrr/blastd/internal/db"
+	"github.com/taigrr/blastd/internal/logfile"
 )
 
 // version is set at build time via ldflags by GoReleaser.
 // When empty (local builds), falls back to VCS info.
 var version string
 
+var (
+	configPath      string
+	socketPath      string
+	serverURL       string
+	daemonize       bool
+	pidFile         string
+	oneshot         bool
+	ignoreBadConfig bool
+	emitJSON        bool
+)
+
 func init() {
 	if version != "" {
 		return
@@ -64,6 +80,16 @@ func main() {
 		Long:  "blastd receives editor activity events over a Unix socket, caches them locally, and syncs to a remote Blast server.",
 		RunE:  run,
 	}
+	cmd.PersistentFlags().StringVar(&configPath, "config", "", "path to config file (default: $XDG_CONFIG_HOME/blastd/config.toml)")
+	cmd.PersistentFlags().StringVar(&socketPath, "socket", "", "socket path to listen on or dial (default: socket_path from config)")
+	cmd.PersistentFlags().StringVar(&serverURL, "server", "", "Blast server URL to sync with for this run (default: server_url from config)")
+	cmd.AddCommand(newResyncCmd(), newDeleteCmd(), newDeadLetterCmd(), newConfigCmd(), newPathsCmd(), newStatusCmd(), newSyncHistoryCmd(), newTodayCmd(), newLogCmd(), newTailCmd(), newBenchCmd(), newResetCmd(), newHeatmapCmd())
+
+	cmd.Flags().BoolVar(&daemonize, "daemonize", false, "detach into the background and log to a file")
+	cmd.Flags().BoolVar(&oneshot, "oneshot", false, "sync the unsynced backlog once and exit, without listening on the socket")
+	cmd.Flags().BoolVar(&ignoreBadConfig, "ignore-bad-config", false, "if the config file has a syntax error, log it and run with defaults and env vars")
+	cmd.Flags().BoolVar(&emitJSON, "emit-json", false, "also write every stored activity to stdout as a JSON line (logs stay on stderr)")
+	cmd.Flags().StringVar(&pidFile, "pid-file", "", "write the daemon PID to this file (default with --daemonize: <data_dir>/blastd.pid)")
 
 	if err := fang.Execute(
 		context.Background(),
@@ -74,15 +100,118 @@ func main() {
 	}
 }
 
+// loadConfig loads the config selected by --config and applies --socket
+// and --server.
+// With --ignore-bad-config, an unparsable config file is logged and
+// skipped.
+func loadConfig() (*config.Config, error) {
+	return loadConfigFallback(ignoreBadConfig)
+}
+
+// reloadConfig is loadConfig for a config reload, which fails on an
+// unparsable config file instead of falling back to defaults.
+func reloadConfig() (*config.Config, error) {
+	return loadConfigFallback(false)
+}
+
+func loadConfigFallback(fallback bool) (*config.Config, error) {
+	var cfg *config.Config
+	var err error
+	if fallback {
+		var parseErr error
+		cfg, parseErr, err = config.LoadFromFallback(configPath)
+		if parseErr != nil {
+			log.Printf("WARNING: %v; ignoring the config file and using defaults and env vars", parseErr)
+		}
+	} else {
+		cfg, err = config.LoadFrom(configPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if socketPath != "" {
+		if cfg.SocketPath, err = cfg.ExpandTokens("--socket", socketPath); err != nil {
+			return nil, err
+		}
+	}
+	if serverURL != "" {
+		u, err := url.Parse(serverURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("--server must be an http or https URL, got %q", serverURL)
+		}
+		if u.Scheme != "https" {
+			log.Printf("WARNING: --server %s is not https; the API token and activities are sent unencrypted", serverURL)
+		}
+		cfg.ServerURL = serverURL
+	}
+	return cfg, nil
+}
+
+// openDB opens the configured database for a CLI subcommand.
+func openDB(cfg *config.Config) (*db.DB, error) {
+	opts, err := daemon.DBOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return db.OpenWithOptions(cfg.DBPath, opts)
+}
+
 func run(cmd *cobra.Command, _ []string) error {
-	cfg, err := config.Load()
+	cfg, err := loadConfig()
 	if err != nil {
 		log.Fatalf("failed to load config: %v", err)
 	}
 
-	d, err := daemon.New(cfg)
+	if oneshot && daemonize {
+		return fmt.Errorf("--oneshot can't be combined with --daemonize")
+	}
+	if emitJSON && (daemonize || oneshot) {
+		return fmt.Errorf("--emit-json needs a foreground daemon; it can't be combined with --daemonize or --oneshot")
+	}
+
+	if daemonize && !isDaemonChild() {
+		logPath := cfg.LogFile
+		if logPath == "" {
+			logPath = filepath.Join(cfg.DataDir, "blastd.log")
+		}
+		pid, err := detach(logPath)
+		if err != nil {
+			log.Fatalf("failed to daemonize: %v", err)
+		}
+		fmt.Printf("blastd running in background (pid %d), logging to %s\n", pid, logPath)
+		return nil
+	}
+
+	if daemonize || pidFile != "" {
+		if pidFile == "" {
+			pidFile = filepath.Join(cfg.DataDir, "blastd.pid")
+		}
+		if err := writePIDFile(pidFile); err != nil {
+			log.Fatalf("failed to write pid file: %v", err)
+		}
+		defer func() {
+			if err := os.Remove(pidFile); err != nil && !os.IsNotExist(err) {
+				log.Printf("remove pid file: %v", err)
+			}
+		}()
+	}
+
+	var logFile *logfile.File
+	if cfg.LogFile != "" {
+		logFile, err = logfile.Open(cfg.LogFile)
+		if err != nil {
+			// Return rather than log.Fatalf so the pid file is removed.
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		log.SetOutput(logFile)
+	}
+
+	d, err := daemon.New(cfg, version)
 	if err != nil {
-		log.Fatalf("failed to create daemon: %v", err)
+		return fmt.Errorf("failed to create daemon: %w", err)
+	}
+	if emitJSON {
+		d.SetEventOutput(os.Stdout)
 	}
 
 	sigCh := make(chan os.Signal, 1)
@@ -94,8 +223,53 @@ func run(cmd *cobra.Command, _ []string) error {
 		d.Stop()
 	}()
 
+	reopenCh := make(chan os.Signal, 1)
+	if len(reopenSignals) > 0 {
+		signal.Notify(reopenCh, reopenSignals...)
+	}
+
+	go func() {
+		for range reopenCh {
+			// Logging to stderr/journald: nothing to reopen.
+			if logFile == nil {
+				continue
+			}
+			if err := logFile.Reopen(); err != nil {
+				log.Printf("reopen log file: %v", err)
+				continue
+			}
+			log.Printf("reopened log file %s", logFile.Path())
+		}
+	}()
+
+	d.SetConfigLoader(reloadConfig)
+	reloadCh := make(chan os.Signal, 1)
+	if len(reloadSignals) > 0 {
+		signal.Notify(reloadCh, reloadSignals...)
+	}
+
+	go func() {
+		for range reloadCh {
+			changed, err := d.Reload()
+			if err != nil {
+				log.Printf("reload config: %v", err)
+			} else if len(changed) == 0 {
+				log.Println("reload config: nothing changed")
+			}
+		}
+	}()
+
+	if oneshot {
+		err := d.Oneshot()
+		d.Stop()
+		if err != nil {
+			return fmt.Errorf("oneshot sync: %w", err)
+		}
+		return nil
+	}
+
 	if err := d.Run(); err != nil {
-		log.Fatalf("daemon error: %v", err)
+		return fmt.Errorf("daemon error: %w", err)
 	}
 
 	return nil
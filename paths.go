@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/blastd/internal/config"
+)
+
+func newPathsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "paths",
+		Short: "Print the resolved config, socket, and database paths",
+		Long: "paths prints the files blastd would use in the current environment, one key=value per line, " +
+			"after applying --config, --socket, env vars, and XDG defaults. config_found is false when no config " +
+			"file exists yet; config is then where `blastd config init` would write one.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+
+			path := configPath
+			if path == "" {
+				if path, err = config.DefaultPath(); err != nil {
+					return err
+				}
+			}
+			found := true
+			if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+				found = false
+			} else if err != nil {
+				return err
+			}
+
+			w := cmd.OutOrStdout()
+			fmt.Fprintf(w, "config=%s\n", path)
+			fmt.Fprintf(w, "config_found=%t\n", found)
+			fmt.Fprintf(w, "data_dir=%s\n", cfg.DataDir)
+			fmt.Fprintf(w, "socket=%s\n", cfg.SocketPath)
+			fmt.Fprintf(w, "db=%s\n", cfg.DBPath)
+			return nil
+		},
+	}
+}
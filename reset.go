@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/blastd/internal/config"
+	"github.com/taigrr/blastd/internal/db"
+	"github.com/taigrr/blastd/internal/socket"
+)
+
+func newResetCmd() *cobra.Command {
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "reset",
+		Short: "Delete all local data and start with an empty database",
+		Long: "reset deletes the database, its WAL/SHM sidecars, migration backups and quarantined copies, and the " +
+			"socket file, then creates an empty database. Activities that were not synced are lost; synced ones stay " +
+			"on the server. It refuses to run while a daemon is using the socket or the database, or the pid file " +
+			"names a running process, and asks for confirmation unless --yes is given.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if err := checkNoDaemon(cfg); err != nil {
+				return err
+			}
+
+			files, err := db.DataFiles(cfg.DBPath)
+			if err != nil {
+				return fmt.Errorf("list database files: %w", err)
+			}
+			if !config.IsAbstractSocket(cfg.SocketPath) {
+				if _, err := os.Lstat(cfg.SocketPath); err == nil {
+					files = append(files, cfg.SocketPath)
+				} else if !os.IsNotExist(err) {
+					return err
+				}
+			}
+
+			w := cmd.OutOrStdout()
+			if len(files) == 0 {
+				fmt.Fprintln(w, "no local data to delete")
+			} else {
+				fmt.Fprintln(w, "This permanently deletes:")
+				for _, f := range files {
+					fmt.Fprintf(w, "  %s\n", f)
+				}
+				fmt.Fprintln(w, "Activities that were not synced yet are lost.")
+				if !yes {
+					ok, err := confirm(cmd.InOrStdin(), w)
+					if err != nil {
+						return err
+					}
+					if !ok {
+						return fmt.Errorf("reset aborted; nothing was deleted")
+					}
+					// The prompt may have waited a while; a daemon started
+					// meanwhile must not lose its database underneath it.
+					if err := checkNoDaemon(cfg); err != nil {
+						return err
+					}
+				}
+			}
+
+			for _, f := range files {
+				if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("delete %s: %w", f, err)
+				}
+				fmt.Fprintf(w, "deleted %s\n", f)
+			}
+
+			database, err := openDB(cfg)
+			if err != nil {
+				return fmt.Errorf("create empty database: %w", err)
+			}
+			if err := database.Close(); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "created an empty database at %s\n", cfg.DBPath)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&yes, "yes", false, "delete without asking for confirmation")
+	return cmd
+}
+
+// checkNoDaemon fails if a daemon looks to be using cfg's database: one
+// answering on the socket, a connection holding the database open, or a
+// running process named by the default pid file.
+func checkNoDaemon(cfg *config.Config) error {
+	if err := socket.ProbeSocket(cfg.SocketPath); err != nil {
+		if errors.Is(err, socket.ErrSocketInUse) {
+			return fmt.Errorf("%w; stop the daemon before resetting", err)
+		}
+		return err
+	}
+	if err := db.CheckNotInUse(cfg.DBPath); err != nil {
+		if errors.Is(err, db.ErrInUse) {
+			return fmt.Errorf("%w; stop the daemon before resetting", err)
+		}
+		return fmt.Errorf("lock database: %w", err)
+	}
+
+	pidPath := filepath.Join(cfg.DataDir, "blastd.pid")
+	data, err := os.ReadFile(pidPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read pid file: %w", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err == nil && pid > 0 && processRunning(pid) {
+		return fmt.Errorf("blastd may be running (pid %d in %s); stop it before resetting, or remove the pid file if it is stale", pid, pidPath)
+	}
+	return nil
+}
+
+// confirm asks the user to type "yes". Anything else, including end of
+// input, declines.
+func confirm(r io.Reader, w io.Writer) (bool, error) {
+	fmt.Fprint(w, `Type "yes" to continue: `)
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("read confirmation: %w", err)
+	}
+	if err == io.EOF {
+		fmt.Fprintln(w)
+	}
+	return strings.TrimSpace(line) == "yes", nil
+}
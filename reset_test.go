@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/taigrr/blastd/internal/config"
+	"github.com/taigrr/blastd/internal/db"
+)
+
+func TestCheckNoDaemonDatabaseLocked(t *testing.T) {
+	dir := t.TempDir()
+	// The socket and pid file say nothing is running: the daemon was
+	// started with --socket elsewhere and no pid file.
+	cfg := &config.Config{
+		DataDir:    dir,
+		DBPath:     filepath.Join(dir, "blast.db"),
+		SocketPath: filepath.Join(dir, "blastd.sock"),
+	}
+	if err := checkNoDaemon(cfg); err != nil {
+		t.Fatalf("checkNoDaemon() with no database: %v", err)
+	}
+
+	database, err := db.Open(cfg.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.GetStats(); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkNoDaemon(cfg); !errors.Is(err, db.ErrInUse) {
+		t.Errorf("checkNoDaemon() with the database open = %v, want ErrInUse", err)
+	}
+
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkNoDaemon(cfg); err != nil {
+		t.Errorf("checkNoDaemon() after the daemon closed the database: %v", err)
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"yes\n", true},
+		{"  yes  \n", true},
+		{"yes", true},
+		{"y\n", false},
+		{"n\n", false},
+		{"no\n", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		got, err := confirm(strings.NewReader(tt.input), &out)
+		if err != nil {
+			t.Errorf("confirm(%q) error: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("confirm(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+		if !strings.HasPrefix(out.String(), `Type "yes" to continue: `) {
+			t.Errorf("confirm(%q) prompt = %q", tt.input, out.String())
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newResyncCmd() *cobra.Command {
+	var (
+		all          bool
+		yes          bool
+		since, until string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "resync",
+		Short: "Mark previously synced activities as unsynced so they are uploaded again",
+		Long: "resync flips synced activities back to unsynced so the next sync re-uploads them, " +
+			"e.g. after server-side data loss. This can send a large amount of data, so it requires --yes.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if !all && since == "" && until == "" {
+				return fmt.Errorf("specify --all or a time range with --since/--until")
+			}
+
+			start, err := parseTimeFlag(since)
+			if err != nil {
+				return fmt.Errorf("invalid --since: %w", err)
+			}
+			end, err := parseTimeFlag(until)
+			if err != nil {
+				return fmt.Errorf("invalid --until: %w", err)
+			}
+
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			database, err := openDB(cfg)
+			if err != nil {
+				return err
+			}
+			defer database.Close()
+
+			if !yes {
+				n, err := database.CountSynced(start, end)
+				if err != nil {
+					return err
+				}
+				return fmt.Errorf("this would re-upload %d activities; re-run with --yes to confirm", n)
+			}
+
+			n, err := database.MarkAllUnsynced(start, end)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "marked %d activities for re-sync\n", n)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", false, "re-sync every previously synced activity")
+	cmd.Flags().BoolVar(&yes, "yes", false, "confirm re-uploading the selected activities")
+	cmd.Flags().StringVar(&since, "since", "", "only activities started at or after this time (RFC 3339 or YYYY-MM-DD)")
+	cmd.Flags().StringVar(&until, "until", "", "only activities started before this time (RFC 3339 or YYYY-MM-DD)")
+	return cmd
+}
+
+// parseTimeFlag accepts RFC 3339 or a local YYYY-MM-DD date. An empty
+// value returns the zero time (unbounded).
+func parseTimeFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, value, time.Local)
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reopenSignals trigger a reopen of the log file after rotation.
+var reopenSignals = []os.Signal{syscall.SIGUSR1}
+
+// reloadSignals trigger a config reload.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
@@ -0,0 +1,12 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// reopenSignals is empty on Windows, which has no SIGUSR1.
+var reopenSignals []os.Signal
+
+// reloadSignals is empty on Windows, which has no SIGHUP; use the socket's
+// reload request instead.
+var reloadSignals []os.Signal
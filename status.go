@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/blastd/internal/output"
+	"github.com/taigrr/blastd/internal/socket"
+)
+
+func newStatusCmd() *cobra.Command {
+	var raw bool
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show stored and unsynced activity counts and the last sync time",
+		Long: "status asks the running daemon how many activities it has stored, how many are still " +
+			"waiting to be synced, and when activities last reached the server. The last sync time survives restarts. " +
+			"If the server attached a warning to an upload since the daemon started, the latest one is shown too.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			resp, err := socketRequest(cfg.SocketPath, socket.Request{Type: "status"})
+			if err != nil {
+				return err
+			}
+			if !resp.OK {
+				return fmt.Errorf("status: %s", resp.Error)
+			}
+
+			if raw {
+				return json.NewEncoder(cmd.OutOrStdout()).Encode(resp)
+			}
+			w := output.NewWriter(cmd.OutOrStdout(), os.Environ())
+			fmt.Fprintf(w, "%s  %d\n", output.Bold.Render("stored   "), deref(resp.Total))
+			fmt.Fprintf(w, "%s  %d\n", output.Bold.Render("unsynced "), deref(resp.Unsynced))
+			fmt.Fprintf(w, "%s  %s\n", output.Bold.Render("last sync"), formatLastSync(resp.LastSyncAt, time.Now()))
+			if warn := resp.SyncWarning; warn != nil {
+				ago := time.Since(warn.Time).Round(time.Second)
+				fmt.Fprintf(w, "%s  %s %s\n", output.Bold.Render("warning  "), warn.Message, output.Dim.Render(fmt.Sprintf("(%s ago)", ago)))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&raw, "json", false, "print the raw status response")
+	return cmd
+}
+
+func deref(n *int64) int64 {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
+
+// formatLastSync renders an RFC 3339 last sync time in local time with how
+// long ago it was.
+func formatLastSync(value string, now time.Time) string {
+	if value == "" {
+		return "never"
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return value
+	}
+	ago := now.Sub(t).Round(time.Second)
+	return fmt.Sprintf("%s (%s ago)", t.Local().Format(time.DateTime), ago)
+}
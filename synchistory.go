@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/blastd/internal/output"
+	"github.com/taigrr/blastd/internal/socket"
+	blastsync "github.com/taigrr/blastd/internal/sync"
+)
+
+func newSyncHistoryCmd() *cobra.Command {
+	var raw bool
+
+	cmd := &cobra.Command{
+		Use:   "sync-history",
+		Short: "Show the running daemon's recent sync attempts",
+		Long: "sync-history asks the daemon for its last sync attempts, oldest first, with how many " +
+			"activities each one sent or why it failed. Only attempts since the daemon started are kept.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			resp, err := socketRequest(cfg.SocketPath, socket.Request{Type: "sync_history"})
+			if err != nil {
+				return err
+			}
+			if !resp.OK {
+				return fmt.Errorf("sync-history: %s", resp.Error)
+			}
+
+			if raw {
+				return json.NewEncoder(cmd.OutOrStdout()).Encode(resp.History)
+			}
+			w := output.NewWriter(cmd.OutOrStdout(), os.Environ())
+			if len(resp.History) == 0 {
+				fmt.Fprintln(w, "no sync attempts since the daemon started")
+				return nil
+			}
+			for _, a := range resp.History {
+				fmt.Fprintln(w, formatAttempt(a))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&raw, "json", false, "print the attempts as a JSON array")
+	return cmd
+}
+
+// formatAttempt renders one sync attempt as a human-readable line.
+func formatAttempt(a blastsync.Attempt) string {
+	when := output.Dim.Render(a.Time.Local().Format(time.DateTime))
+	took := a.Duration.Round(time.Millisecond).String()
+	line := fmt.Sprintf("%s  %s  %s  sent %d", when, output.Added.Render("ok"), took, a.Synced)
+	if a.Error != "" {
+		line = fmt.Sprintf("%s  %s  %s  %s", when, output.Removed.Render("failed"), took, a.Error)
+	}
+	for _, warning := range a.Warnings {
+		line += "\n    warning: " + warning
+	}
+	return line
+}
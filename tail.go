@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/blastd/internal/output"
+	"github.com/taigrr/blastd/internal/socket"
+)
+
+func newTailCmd() *cobra.Command {
+	var raw bool
+
+	cmd := &cobra.Command{
+		Use:   "tail",
+		Short: "Print activities as the running daemon stores them",
+		Long: "tail subscribes to the daemon's socket and prints each activity as it is stored, " +
+			"which is handy when debugging an editor integration. It only reads; nothing is stored or synced differently.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+
+			conn, err := net.Dial("unix", cfg.SocketPath)
+			if err != nil {
+				return fmt.Errorf("connect to blastd at %s: %w", cfg.SocketPath, err)
+			}
+			defer conn.Close()
+
+			if _, err := conn.Write([]byte(`{"type":"subscribe"}` + "\n")); err != nil {
+				return fmt.Errorf("subscribe: %w", err)
+			}
+			reader := bufio.NewReader(conn)
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				return fmt.Errorf("subscribe: %w", err)
+			}
+			var resp socket.Response
+			if err := json.Unmarshal(line, &resp); err != nil {
+				return fmt.Errorf("subscribe: %w", err)
+			}
+			if !resp.OK {
+				return fmt.Errorf("subscribe: %s", resp.Error)
+			}
+
+			w := cmd.OutOrStdout()
+			if !raw {
+				w = output.NewWriter(w, os.Environ())
+			}
+			return tailEvents(reader, w, raw)
+		},
+	}
+
+	cmd.Flags().BoolVar(&raw, "json", false, "print each event as a raw JSON line")
+	return cmd
+}
+
+// tailEvents prints events from r until the daemon closes the connection.
+func tailEvents(r *bufio.Reader, w io.Writer, raw bool) error {
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return fmt.Errorf("daemon closed the connection")
+		}
+		if err != nil {
+			return err
+		}
+		if raw {
+			fmt.Fprint(w, string(line))
+			continue
+		}
+
+		var ev socket.Event
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return fmt.Errorf("decode event: %w", err)
+		}
+		fmt.Fprintln(w, formatEvent(ev))
+	}
+}
+
+// formatEvent renders an activity event as one human-readable line. The
+// styling is dropped by output.NewWriter when stdout isn't a terminal.
+func formatEvent(ev socket.Event) string {
+	a := ev.Data
+	start, _ := time.Parse(time.RFC3339, a.StartedAt)
+	end, _ := time.Parse(time.RFC3339, a.EndedAt)
+
+	fields := []string{
+		output.Dim.Render(start.Local().Format(time.TimeOnly)),
+		output.Bold.Render(a.Project),
+		a.Filetype,
+	}
+	if a.Filename != "" {
+		fields = append(fields, a.Filename)
+	}
+	fields = append(fields,
+		output.Added.Render(fmt.Sprintf("+%d", a.LinesAdded))+"/"+output.Removed.Render(fmt.Sprintf("-%d", a.LinesRemoved)),
+		end.Sub(start).String(),
+		a.Editor,
+	)
+	if len(a.Tags) > 0 {
+		fields = append(fields, "["+strings.Join(a.Tags, ",")+"]")
+	}
+	return strings.Join(fields, "  ")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/taigrr/blastd/internal/config"
+	"github.com/taigrr/blastd/internal/daemon"
+	"github.com/taigrr/blastd/internal/db"
+	"github.com/taigrr/blastd/internal/output"
+	"github.com/taigrr/blastd/internal/socket"
+)
+
+// dayTotals sums a day's activity across editors.
+type dayTotals struct {
+	Activities   int64   `json:"activities"`
+	Seconds      float64 `json:"seconds"`
+	LinesAdded   int64   `json:"lines_added"`
+	LinesRemoved int64   `json:"lines_removed"`
+}
+
+func newTodayCmd() *cobra.Command {
+	var raw bool
+
+	cmd := &cobra.Command{
+		Use:   "today",
+		Short: "Show total tracked time and lines changed today",
+		Long: "today asks the running daemon for today's stats (in the configured timezone). If the daemon " +
+			"isn't running it reads the database directly, read-only.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			y, m, d := time.Now().In(cfg.Location()).Date()
+			start := time.Date(y, m, d, 0, 0, 0, 0, cfg.Location())
+			end := start.AddDate(0, 0, 1)
+
+			editors, err := statsFromDaemon(cfg.SocketPath, start, end)
+			if daemonNotRunning(err) {
+				fmt.Fprintf(cmd.ErrOrStderr(), "blastd is not running; reading %s directly\n", cfg.DBPath)
+				editors, err = statsFromDB(cfg, start, end)
+			}
+			if err != nil {
+				return err
+			}
+
+			var totals dayTotals
+			for _, es := range editors {
+				totals.Activities += es.Activities
+				totals.Seconds += es.Seconds
+				totals.LinesAdded += es.LinesAdded
+				totals.LinesRemoved += es.LinesRemoved
+			}
+
+			if raw {
+				return json.NewEncoder(cmd.OutOrStdout()).Encode(totals)
+			}
+			w := output.NewWriter(cmd.OutOrStdout(), os.Environ())
+			fmt.Fprintf(w, "%s  %s\n", output.Bold.Render("today"), formatTracked(totals.Seconds))
+			fmt.Fprintf(w, "%s  %s %s\n", output.Bold.Render("lines"),
+				output.Added.Render(fmt.Sprintf("+%d", totals.LinesAdded)),
+				output.Removed.Render(fmt.Sprintf("-%d", totals.LinesRemoved)))
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&raw, "json", false, "print the totals as JSON")
+	return cmd
+}
+
+// statsFromDaemon sends a stats request for [start, end) to the daemon.
+func statsFromDaemon(socketPath string, start, end time.Time) (map[string]db.EditorStats, error) {
+	data, err := json.Marshal(socket.StatsData{Since: start.Format(time.RFC3339), Until: end.Format(time.RFC3339)})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := socketRequest(socketPath, socket.Request{Type: "stats", Data: data})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, fmt.Errorf("stats: %s", resp.Error)
+	}
+	return resp.Editors, nil
+}
+
+// statsFromDB reads [start, end) from the database without the daemon. A
+// missing database means nothing has been tracked yet.
+func statsFromDB(cfg *config.Config, start, end time.Time) (map[string]db.EditorStats, error) {
+	database, err := openReadOnlyDB(cfg)
+	if database == nil || err != nil {
+		return nil, err
+	}
+	defer database.Close()
+	return database.StatsByEditor(start, end)
+}
+
+// openReadOnlyDB opens the configured database read-only for a command
+// that works without the daemon. It returns nil and no error when the
+// database doesn't exist yet.
+func openReadOnlyDB(cfg *config.Config) (*db.DB, error) {
+	opts, err := daemon.DBOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts.ReadOnly = true
+	database, err := db.OpenWithOptions(cfg.DBPath, opts)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", cfg.DBPath, err)
+	}
+	return database, nil
+}
+
+// daemonNotRunning reports whether err means the daemon's socket couldn't
+// be dialed, so a command may fall back to reading the database.
+func daemonNotRunning(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// formatTracked renders seconds as hours and minutes, e.g. "3h 12m".
+func formatTracked(seconds float64) string {
+	minutes := int64(seconds) / 60
+	if minutes < 60 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh %dm", minutes/60, minutes%60)
+}